@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// auditFlags say where the changes made to the server are recorded. Every
+// command that connects has them.
+type auditFlags struct {
+	file    string
+	node    string
+	command string
+}
+
+var audit auditFlags
+
+func addAuditFlags(fs *flag.FlagSet) {
+	audit.command = fs.Name()
+	fs.StringVar(&audit.file, "audit-file", "", "Append every change made to the server to this file, as JSON lines")
+	fs.StringVar(&audit.node, "audit-node", "", "Record every change made to the server as a node below this path, such as /_audit")
+}
+
+// audit returns the Audit the flags ask for, nil if they ask for none.
+func (a *auditFlags) audit() *zksync.Audit {
+	if a.file == "" && a.node == "" {
+		return nil
+	}
+	return zksync.NewAudit(a.file, a.node, a.command)
+}
+
+func runHistory(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	since := fs.Duration("since", 0, "Only show changes made this long ago or later")
+	user := fs.String("user", "", "Only show changes made by this user")
+	if code, ok := parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if fs.NArg() > 1 || (audit.file == "") == (audit.node == "") {
+		slog.Error("Give either -audit-file or -audit-node to read")
+		fs.Usage()
+		return exitUsage
+	}
+	prefix := fs.Arg(0)
+
+	show := func(entries []zksync.AuditEntry) int {
+		var cutoff time.Time
+		if *since > 0 {
+			cutoff = time.Now().Add(-*since)
+		}
+		shown := []zksync.AuditEntry{}
+		for _, e := range entries {
+			if e.Time.Before(cutoff) || (*user != "" && e.User != *user) {
+				continue
+			}
+			if prefix != "" && e.Path != prefix && !strings.HasPrefix(e.Path, strings.TrimSuffix(prefix, "/")+"/") {
+				continue
+			}
+			shown = append(shown, e)
+		}
+		if structured() {
+			if err := writeStructured(shown); err != nil {
+				slog.Error("Could not write history", "err", err)
+				return exitError
+			}
+			return exitOK
+		}
+		for _, e := range shown {
+			line := fmt.Sprintf("%s %s@%s %-6s %s", e.Time.Local().Format(time.RFC3339), e.User, e.Host, e.Action, e.Path)
+			if e.OldVersion != nil {
+				line += fmt.Sprintf(" v%d", *e.OldVersion)
+			}
+			if e.SHA256 != "" {
+				line += fmt.Sprintf(" %d bytes sha256:%.12s", e.Size, e.SHA256)
+			}
+			if e.ACL != "" {
+				line += " " + e.ACL
+			}
+			if e.Command != "" {
+				line += " (" + e.Command + ")"
+			}
+			fmt.Println(line)
+		}
+		return exitOK
+	}
+
+	if audit.file != "" {
+		entries, err := zksync.ReadAuditLog(audit.file)
+		if err != nil {
+			slog.Error("Could not read audit log", "file", audit.file, "err", err)
+			return exitError
+		}
+		return show(entries)
+	}
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		entries, err := client.ReadAuditNode(audit.node)
+		if err != nil {
+			slog.Error("Could not read audit log", "node", audit.node, "err", err)
+			return exitCode(err)
+		}
+		return show(entries)
+	})
+}
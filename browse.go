@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/edevil/configurator/zksync"
+)
+
+// browser is the model behind the browse command: a listing of one remote
+// dir, with the data and stat of the file under the cursor below it.
+type browser struct {
+	ctx    context.Context
+	client *zksync.Client
+	tree   *treeFlags
+	opts   zksync.Options
+
+	dir     string
+	entries []zksync.Entry
+	cursor  int
+	offset  int // first entry shown
+
+	preview     []byte
+	previewStat *zksync.Stat
+
+	// confirm, if set, is run once the prompt is answered with y
+	confirm func() tea.Msg
+	prompt  string
+	status  string
+
+	width, height int
+}
+
+type listedMsg struct {
+	dir     string
+	entries []zksync.Entry
+	err     error
+}
+
+type previewMsg struct {
+	path string
+	data []byte
+	stat *zksync.Stat
+	err  error
+}
+
+type statusMsg string
+
+// editedMsg comes back once the editor started on file exits.
+type editedMsg struct {
+	path    string
+	file    string
+	old     []byte
+	version int64
+	err     error
+}
+
+func (b *browser) Init() tea.Cmd {
+	return b.list(b.dir)
+}
+
+func (b *browser) list(dir string) tea.Cmd {
+	return func() tea.Msg {
+		entries, err := b.client.ListTree(b.ctx, dir, false)
+		return listedMsg{dir: dir, entries: entries, err: err}
+	}
+}
+
+func (b *browser) selected() (zksync.Entry, string, bool) {
+	if b.cursor >= len(b.entries) {
+		return zksync.Entry{}, "", false
+	}
+	e := b.entries[b.cursor]
+	return e, path.Join(b.dir, e.Path), true
+}
+
+// showSelected fetches what the file under the cursor holds.
+func (b *browser) showSelected() tea.Cmd {
+	b.preview, b.previewStat = nil, nil
+	e, p, ok := b.selected()
+	if !ok || e.Dir() {
+		return nil
+	}
+	return func() tea.Msg {
+		data, stat, err := b.client.ReadNode(p)
+		return previewMsg{path: p, data: data, stat: stat, err: err}
+	}
+}
+
+// localPath maps a remote path under -server_prefix to where download
+// puts it under -local_prefix.
+func (b *browser) localPath(p string) (string, bool) {
+	if p == b.tree.serverPrefix {
+		return b.tree.localPrefix, true
+	}
+	rel := strings.TrimPrefix(p, strings.TrimSuffix(b.tree.serverPrefix, "/")+"/")
+	if rel == p {
+		return "", false
+	}
+	return filepath.Join(b.tree.localPrefix, filepath.FromSlash(rel)), true
+}
+
+func (b *browser) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		b.width, b.height = msg.Width, msg.Height
+	case listedMsg:
+		if msg.err != nil {
+			b.status = msg.err.Error()
+			return b, nil
+		}
+		if msg.dir != b.dir {
+			b.cursor, b.offset = 0, 0
+		}
+		b.dir, b.entries = msg.dir, msg.entries
+		if b.cursor >= len(b.entries) {
+			b.cursor = len(b.entries) - 1
+		}
+		if b.cursor < 0 {
+			b.cursor = 0
+		}
+		return b, b.showSelected()
+	case previewMsg:
+		if _, p, ok := b.selected(); ok && p == msg.path {
+			if msg.err != nil {
+				b.status = msg.err.Error()
+			} else {
+				b.preview, b.previewStat = msg.data, msg.stat
+			}
+		}
+	case statusMsg:
+		b.status = string(msg)
+		return b, b.list(b.dir)
+	case editedMsg:
+		return b, b.saveEdit(msg)
+	case tea.KeyMsg:
+		return b.key(msg)
+	}
+	return b, nil
+}
+
+func (b *browser) key(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if b.confirm != nil {
+		run := b.confirm
+		b.confirm, b.prompt = nil, ""
+		if msg.String() == "y" {
+			b.status = "Working..."
+			return b, func() tea.Msg { return run() }
+		}
+		b.status = "Cancelled"
+		return b, nil
+	}
+
+	switch msg.String() {
+	case "q", "ctrl+c":
+		return b, tea.Quit
+	case "up", "k":
+		if b.cursor > 0 {
+			b.cursor--
+			return b, b.showSelected()
+		}
+	case "down", "j":
+		if b.cursor < len(b.entries)-1 {
+			b.cursor++
+			return b, b.showSelected()
+		}
+	case "enter", "right", "l":
+		if e, p, ok := b.selected(); ok && e.Dir() {
+			b.status = ""
+			return b, b.list(p)
+		}
+	case "left", "h", "backspace":
+		if b.dir != "/" {
+			b.status = ""
+			return b, b.list(path.Dir(b.dir))
+		}
+	case "r":
+		return b, b.list(b.dir)
+	case "e":
+		return b, b.edit()
+	case "d", "u":
+		return b, b.transfer(msg.String() == "u")
+	}
+	return b, nil
+}
+
+// edit opens the file under the cursor in $EDITOR.
+func (b *browser) edit() tea.Cmd {
+	e, p, ok := b.selected()
+	if !ok || e.Dir() || b.previewStat == nil {
+		b.status = "Pick a file to edit"
+		return nil
+	}
+	tmp, err := ioutil.TempFile("", "configurator-*-"+path.Base(p))
+	if err == nil {
+		_, err = tmp.Write(b.preview)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		b.status = err.Error()
+		return nil
+	}
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	done := editedMsg{path: p, file: tmp.Name(), old: b.preview, version: b.previewStat.Version}
+	return tea.ExecProcess(exec.Command("/bin/sh", "-c", editor+` "$0"`, tmp.Name()), func(err error) tea.Msg {
+		done.err = err
+		return done
+	})
+}
+
+// saveEdit writes an edited file back, only over the version that was
+// edited.
+func (b *browser) saveEdit(msg editedMsg) tea.Cmd {
+	return func() tea.Msg {
+		defer os.Remove(msg.file)
+		if msg.err != nil {
+			return statusMsg("Editor failed: " + msg.err.Error())
+		}
+		data, err := ioutil.ReadFile(msg.file)
+		if err != nil {
+			return statusMsg(err.Error())
+		}
+		if string(data) == string(msg.old) {
+			return statusMsg("Unchanged")
+		}
+		res, err := b.client.Put(b.ctx, msg.path, data, msg.version, b.opts)
+		return b.resultStatus("Saved "+msg.path, res, err)
+	}
+}
+
+// transfer asks before downloading the subtree under the cursor into
+// -local_prefix, or uploading it from there.
+func (b *browser) transfer(upload bool) tea.Cmd {
+	_, p, ok := b.selected()
+	if !ok {
+		return nil
+	}
+	local, ok := b.localPath(p)
+	if !ok {
+		b.status = fmt.Sprintf("%s is not under -server_prefix %s", p, b.tree.serverPrefix)
+		return nil
+	}
+	if upload {
+		b.prompt = fmt.Sprintf("Upload %s to %s? (y/n)", local, p)
+		b.confirm = func() tea.Msg {
+			res, err := b.client.Upload(b.ctx, local, p, b.opts)
+			return b.resultStatus("Uploaded "+local, res, err)
+		}
+	} else {
+		b.prompt = fmt.Sprintf("Download %s to %s? (y/n)", p, local)
+		b.confirm = func() tea.Msg {
+			if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+				return statusMsg(err.Error())
+			}
+			res, err := b.client.Download(b.ctx, local, p, b.opts)
+			return b.resultStatus("Downloaded "+p, res, err)
+		}
+	}
+	return nil
+}
+
+func (b *browser) resultStatus(done string, res *zksync.Result, err error) statusMsg {
+	switch {
+	case err != nil:
+		return statusMsg(err.Error())
+	case b.opts.DryRun:
+		return statusMsg(fmt.Sprintf("Would make %d changes", len(res.Plan)))
+	case len(res.Failed) > 0:
+		return statusMsg(fmt.Sprintf("%d of %d changes failed: %v", len(res.Failed), len(res.Plan), res.Failed[0]))
+	case len(res.Plan) == 0:
+		return statusMsg("Nothing to do")
+	}
+	return statusMsg(fmt.Sprintf("%s, %d changes", done, len(res.Plan)))
+}
+
+func (b *browser) View() string {
+	if b.width == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	line := func(s string) {
+		if len(s) > b.width {
+			s = s[:b.width]
+		}
+		sb.WriteString(s + "\n")
+	}
+
+	line(b.dir)
+	// the listing gets half the screen, the preview the rest
+	rows := (b.height - 4) / 2
+	if rows < 1 {
+		rows = 1
+	}
+	if b.cursor < b.offset {
+		b.offset = b.cursor
+	} else if b.cursor >= b.offset+rows {
+		b.offset = b.cursor - rows + 1
+	}
+	for i := b.offset; i < b.offset+rows; i++ {
+		if i >= len(b.entries) {
+			line("")
+			continue
+		}
+		e := b.entries[i]
+		mark := "  "
+		if i == b.cursor {
+			mark = "> "
+		}
+		name := e.Path
+		if e.Dir() {
+			name += "/"
+		}
+		line(fmt.Sprintf("%s%-40s %10d", mark, name, e.Stat.DataLength))
+	}
+
+	previewRows := b.height - rows - 4
+	if st := b.previewStat; st != nil {
+		mtime := "-"
+		if !st.Mtime.IsZero() {
+			mtime = st.Mtime.Local().Format("2006-01-02 15:04:05")
+		}
+		line(fmt.Sprintf("-- version %d, %d bytes, %d chunks, modified %s", st.Version, st.DataLength, st.Chunks, mtime))
+		var lines []string
+		if utf8.Valid(b.preview) {
+			lines = strings.Split(strings.ReplaceAll(string(b.preview), "\t", "    "), "\n")
+		} else {
+			lines = []string{fmt.Sprintf("(%d bytes of binary data)", len(b.preview))}
+		}
+		for i := 0; i < previewRows; i++ {
+			if i < len(lines) {
+				line(lines[i])
+			} else {
+				line("")
+			}
+		}
+	} else {
+		line("--")
+		for i := 0; i < previewRows; i++ {
+			line("")
+		}
+	}
+
+	switch {
+	case b.prompt != "":
+		line(b.prompt)
+	case b.status != "":
+		line(b.status)
+	default:
+		line("enter/h/j/k/l: move  e: edit  d: download  u: upload  r: refresh  q: quit")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func runBrowse(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	tree := addTreeFlags(fs)
+	apply := addApplyFlags(fs)
+	if code, ok := parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	dir := tree.serverPrefix
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+	opts, err := apply.options(nil)
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		// anything logged would scribble over the screen, results are shown
+		// in the status line instead
+		defaultLogger := slog.Default()
+		slog.SetDefault(slog.New(slog.DiscardHandler))
+		defer slog.SetDefault(defaultLogger)
+
+		b := &browser{ctx: ctx, client: client, tree: tree, opts: opts, dir: dir}
+		if _, err := tea.NewProgram(b, tea.WithAltScreen(), tea.WithContext(ctx)).Run(); err != nil && !stopped(err) {
+			slog.Error("Browser failed", "err", err)
+			return exitError
+		}
+		return exitOK
+	})
+}
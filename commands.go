@@ -0,0 +1,1748 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// options builds the Options every tree operation shares. filters may be
+// nil for commands that work on whole trees.
+func (a *applyFlags) options(filters *filterFlags) (zksync.Options, error) {
+	opts := zksync.Options{DryRun: a.dryRun, Concurrency: a.concurrency, ChunkSize: a.chunkSize, MaxNodeSize: a.maxNodeSize, WarnOversize: a.warnSize, Compress: a.compress, EncodeBinary: a.encodeBin, PlainEmpty: a.plainEmpty, MinDeleteDepth: a.minDepth, ForceDelete: a.forceDelete}
+	if a.progress && !a.dryRun {
+		showProgress(&opts)
+	}
+	if a.trash {
+		opts.Trash = a.trashPolicy()
+	}
+	if a.history {
+		opts.History = a.historyPolicy()
+	}
+	if a.quotas {
+		opts.Quotas = a.quotaPolicy()
+	}
+	binary, err := zksync.ParseBinaryPolicy(a.binary)
+	if err != nil {
+		return opts, err
+	}
+	opts.Binary = binary
+	if filters != nil {
+		filter, err := filters.filter()
+		if err != nil {
+			return opts, err
+		}
+		opts.Filter = filter
+	}
+	return opts, nil
+}
+
+func (a *applyFlags) trashPolicy() *zksync.Trash {
+	return &zksync.Trash{Root: a.trashRoot, Keep: a.trashKeep}
+}
+
+func (a *applyFlags) historyPolicy() *zksync.History {
+	return &zksync.History{Root: a.historyRoot, Keep: a.historyKeep}
+}
+
+func (a *applyFlags) quotaPolicy() *zksync.Quotas {
+	return &zksync.Quotas{Node: a.quotaNode}
+}
+
+func runUpload(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	trees := addTreesFlags(fs)
+	apply := addApplyFlags(fs)
+	filters := addFilterFlags(fs)
+	acls := addACLFlags(fs)
+	templates := addTemplateFlags(fs)
+	normalize := addNormalizeFlags(fs)
+	secrets := addVaultFlags(fs)
+	symlinks := addSymlinksFlag(fs)
+	names := addNamesFlags(fs)
+	validate := addValidateFlags(fs)
+	properties := addPropertiesFlag(fs)
+	overlays := addOverlayFlags(fs)
+	clean := fs.Bool("clean", false, "Delete the remote tree before uploading?")
+	prune := fs.Bool("prune", false, "Delete remote nodes removed locally?")
+	perms := fs.Bool("perms", false, "Give files ACLs matching their modes instead of -acl, for download -perms to restore?")
+	release := fs.Bool("release", false, "Upload as a new release under -server_prefix/releases, then point -server_prefix/current at it?")
+	explode := fs.String("explode", "", "Upload this YAML or JSON file instead of -local_prefix, one node per key")
+	atomic := fs.Bool("atomic", false, "Apply all the changes in one transaction, so readers never see half of them?")
+	mirror := fs.Bool("mirror", false, "Make the remote tree an exact replica: upload, prune, set the ACLs of every node from -acl and -acl-file, then compare again, exiting with 8 if some nodes could not be reconciled?")
+	journal := addJournalFlags(fs)
+	hooks := addHookFlags(fs)
+	locks := addLockFlags(fs)
+	checkVersion := addCheckVersionFlag(fs)
+	confirm := addConfirmFlag(fs)
+	if code, ok := parseFlags(fs, args, false); !ok {
+		return code
+	}
+
+	opts, err := apply.options(filters)
+	if err == nil {
+		opts.ACLs, err = acls.policy()
+	}
+	if err == nil {
+		opts.Template, err = templates.template()
+	}
+	if err == nil {
+		opts.Normalize, err = normalize.normalizer()
+	}
+	if err == nil {
+		opts.Vault, err = secrets.vault()
+	}
+	if err == nil {
+		opts.Symlinks, err = zksync.ParseSymlinkPolicy(*symlinks)
+	}
+	if err == nil {
+		opts.Validator, err = validate.validator()
+	}
+	if err == nil {
+		opts.Properties, err = properties.properties()
+	}
+	var pairs []treePair
+	if err == nil {
+		pairs, err = trees.pairs()
+	}
+	if err == nil && *explode != "" && len(pairs) > 1 {
+		err = fmt.Errorf("-explode uploads to a single -server_prefix")
+	}
+	if err == nil && *explode != "" && (*perms || opts.Validator != nil || opts.Properties != nil) {
+		err = fmt.Errorf("-perms, -validate, -validator, -schemas and -properties do not apply to -explode")
+	}
+	if err == nil && *release && (*clean || *prune || *explode != "" || *atomic) {
+		err = fmt.Errorf("-release uploads a fresh tree, -clean, -prune, -explode and -atomic do not apply")
+	}
+	if err == nil && *atomic && *perms {
+		err = fmt.Errorf("-perms sets ACLs, which cannot be part of an -atomic transaction")
+	}
+	if err == nil && journal.resume && (*release || *explode != "") {
+		err = fmt.Errorf("-resume does not apply to -release and -explode")
+	}
+	if err == nil && *mirror && (*clean || *release || *explode != "") {
+		err = fmt.Errorf("-mirror does not go with -clean, -release and -explode")
+	}
+	if err == nil {
+		err = overlays.check(pairs)
+	}
+	if err == nil && len(overlays.dirs) > 0 && (*explode != "" || journal.resume) {
+		// the merged tree is a new one every run, there is nothing to resume
+		err = fmt.Errorf("-overlay does not go with -explode and -resume")
+	}
+	if err == nil {
+		opts.Names, err = names.mapping()
+	}
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	pairs, cleanUp, err := overlays.merge(pairs)
+	if err != nil {
+		slog.Error("Could not merge overlays", "err", err)
+		return exitError
+	}
+	defer cleanUp()
+	opts.CheckVersion = *checkVersion
+	opts.Clean = *clean
+	opts.Prune = *prune
+	opts.ModeACLs = *perms
+	opts.Atomic = *atomic
+	confirm.apply(&opts)
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		if *explode != "" {
+			t := treePair{localPrefix: *explode, serverPrefix: pairs[0].serverPrefix}
+			res, err := locks.around(ctx, client, t.serverPrefix, opts.DryRun, func() (*zksync.Result, error) {
+				return hooks.around(ctx, "upload", t, opts, func(opts zksync.Options) (*zksync.Result, error) {
+					return client.Explode(ctx, t.localPrefix, t.serverPrefix, opts)
+				})
+			})
+			return finish(res, err, opts.DryRun)
+		}
+		if *release {
+			// a release is only made current once it is all there, so there
+			// is never anything to resume
+			res, err := runTrees(pairs, func(t treePair) (*zksync.Result, error) {
+				return locks.around(ctx, client, t.serverPrefix, opts.DryRun, func() (*zksync.Result, error) {
+					return hooks.around(ctx, "upload", t, opts, func(opts zksync.Options) (*zksync.Result, error) {
+						return client.Release(ctx, t.localPrefix, t.serverPrefix, opts)
+					})
+				})
+			})
+			return finish(res, err, opts.DryRun)
+		}
+		res, err := journal.run(ctx, client, "upload", cfg, pairs, opts, hooks, locks, func(t treePair, opts zksync.Options) (*zksync.Result, error) {
+			if *mirror {
+				return client.Mirror(ctx, t.localPrefix, t.serverPrefix, opts)
+			}
+			return client.Upload(ctx, t.localPrefix, t.serverPrefix, opts)
+		})
+		return finish(res, err, opts.DryRun)
+	})
+}
+
+func runDownload(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	trees := addTreesFlags(fs)
+	apply := addApplyFlags(fs)
+	filters := addFilterFlags(fs)
+	comparePtr := fs.String("compare", string(zksync.CompareChecksum), "How to spot changed files: checksum, or mtime to trust equal mtimes")
+	prune := fs.Bool("prune", false, "Remove local files deleted remotely?")
+	perms := fs.Bool("perms", false, "Give files modes matching their ACLs, as set by upload -perms?")
+	ephemeral := addEphemeralFlag(fs)
+	names := addNamesFlags(fs)
+	redact := addRedactFlags(fs)
+	incremental := fs.Bool("incremental", false, "Only fetch files changed since the last download, as told by their mzxid, recorded in "+zksync.CursorFile+" at the root of -local_prefix? Zookeeper only")
+	metadata := fs.Bool("metadata", false, "Record the zxids, version, times and ACL of every node in "+zksync.MetadataFile+" at the root of -local_prefix, for upload -acl-metadata?")
+	secrets := addVaultFlags(fs)
+	properties := addPropertiesFlag(fs)
+	implode := fs.String("implode", "", "Download into this single YAML or JSON file instead of -local_prefix, a key per node")
+	journal := addJournalFlags(fs)
+	hooks := addHookFlags(fs)
+	confirm := addConfirmFlag(fs)
+	if code, ok := parseFlags(fs, args, false); !ok {
+		return code
+	}
+
+	opts, err := apply.options(filters)
+	if err == nil {
+		opts.Compare, err = zksync.ParseCompareMode(*comparePtr)
+	}
+	if err == nil {
+		opts.Vault, err = secrets.vault()
+	}
+	if err == nil {
+		opts.Redact, err = redact.redaction()
+	}
+	if err == nil {
+		opts.Properties, err = properties.properties()
+	}
+	var pairs []treePair
+	if err == nil {
+		pairs, err = trees.pairs()
+	}
+	if err == nil && *implode != "" && len(pairs) > 1 {
+		err = fmt.Errorf("-implode downloads from a single -server_prefix")
+	}
+	if err == nil && *implode != "" && (*prune || *perms || *metadata || *incremental || journal.resume || opts.Properties != nil) {
+		err = fmt.Errorf("-prune, -perms, -metadata, -incremental, -resume and -properties do not apply to -implode")
+	}
+	if err == nil && *incremental && opts.Properties != nil {
+		// a key changing leaves the mzxid of its dir as it is
+		err = fmt.Errorf("-incremental does not go with -properties")
+	}
+	if err == nil {
+		opts.Names, err = names.mapping()
+	}
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	opts.Prune = *prune
+	opts.ModeACLs = *perms
+	opts.Ephemeral = *ephemeral
+	opts.Metadata = *metadata
+	opts.Cursor = *incremental
+	confirm.apply(&opts)
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		if *implode != "" {
+			t := treePair{localPrefix: *implode, serverPrefix: pairs[0].serverPrefix}
+			res, err := hooks.around(ctx, "download", t, opts, func(opts zksync.Options) (*zksync.Result, error) {
+				return client.Implode(ctx, t.localPrefix, t.serverPrefix, opts)
+			})
+			return finish(res, err, opts.DryRun)
+		}
+		res, err := journal.run(ctx, client, "download", cfg, pairs, opts, hooks, nil, func(t treePair, opts zksync.Options) (*zksync.Result, error) {
+			return client.Download(ctx, t.localPrefix, t.serverPrefix, opts)
+		})
+		return finish(res, err, opts.DryRun)
+	})
+}
+
+func runSync(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	trees := addTreesFlags(fs)
+	apply := addApplyFlags(fs)
+	filters := addFilterFlags(fs)
+	symlinks := addSymlinksFlag(fs)
+	names := addNamesFlags(fs)
+	validate := addValidateFlags(fs)
+	properties := addPropertiesFlag(fs)
+	ephemeral := addEphemeralFlag(fs)
+	acls := addACLFlags(fs)
+	conflicts := addConflictFlags(fs)
+	threeWay := fs.Bool("three-way", false, "Copy only the side changed since the last sync, as recorded in "+zksync.StateFile+" at the local tree root, so that only files changed on both sides conflict?")
+	journal := addJournalFlags(fs)
+	hooks := addHookFlags(fs)
+	locks := addLockFlags(fs)
+	checkVersion := addCheckVersionFlag(fs)
+	confirm := addConfirmFlag(fs)
+	if code, ok := parseFlags(fs, args, false); !ok {
+		return code
+	}
+
+	opts, err := apply.options(filters)
+	if err == nil {
+		opts.Symlinks, err = zksync.ParseSymlinkPolicy(*symlinks)
+	}
+	if err == nil {
+		opts.Validator, err = validate.validator()
+	}
+	if err == nil {
+		opts.Properties, err = properties.properties()
+	}
+	if err == nil {
+		opts.ThreeWay = *threeWay
+		err = conflicts.apply(&opts)
+	}
+	if err == nil {
+		opts.ACLs, err = acls.policy()
+	}
+	var pairs []treePair
+	if err == nil {
+		pairs, err = trees.pairs()
+	}
+	if err == nil {
+		opts.Names, err = names.mapping()
+	}
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	opts.CheckVersion = *checkVersion
+	opts.Ephemeral = *ephemeral
+	confirm.apply(&opts)
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		res, err := journal.run(ctx, client, "sync", cfg, pairs, opts, hooks, locks, func(t treePair, opts zksync.Options) (*zksync.Result, error) {
+			return client.Sync(ctx, t.localPrefix, t.serverPrefix, opts)
+		})
+		return finish(res, err, opts.DryRun)
+	})
+}
+
+// runTrees runs f on every tree in turn, over the one session, adding up
+// the results. It stops at the first tree f fails to plan.
+func runTrees(pairs []treePair, f func(treePair) (*zksync.Result, error)) (*zksync.Result, error) {
+	total := &zksync.Result{}
+	for _, t := range pairs {
+		res, err := f(t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.serverPrefix, err)
+		}
+		total.Plan = append(total.Plan, res.Plan...)
+		total.Failed = append(total.Failed, res.Failed...)
+		for _, c := range res.Conflicts {
+			total.Conflicts = append(total.Conflicts, path.Join(t.serverPrefix, c))
+		}
+		for _, u := range res.Unreconciled {
+			total.Unreconciled = append(total.Unreconciled, path.Join(t.serverPrefix, u))
+		}
+	}
+	return total, nil
+}
+
+// diffTrees compares every tree in turn. With more than one, the paths of
+// the differences start with the server prefix they are under.
+func diffTrees(ctx context.Context, client *zksync.Client, pairs []treePair, opts zksync.Options) ([]zksync.Difference, error) {
+	var all []zksync.Difference
+	for _, t := range pairs {
+		diffs, err := client.Diff(ctx, t.localPrefix, t.serverPrefix, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range diffs {
+			if len(pairs) > 1 {
+				d.Path = path.Join(t.serverPrefix, d.Path)
+			}
+			all = append(all, d)
+		}
+	}
+	return all, nil
+}
+
+func runRm(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	apply := addApplyFlags(fs)
+	confirm := addConfirmFlag(fs)
+	if code, ok := parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	opts, err := apply.options(nil)
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	confirm.apply(&opts)
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		total := &zksync.Result{}
+		for _, p := range fs.Args() {
+			res, err := client.Delete(ctx, p, opts)
+			if err != nil {
+				return finish(nil, err, opts.DryRun)
+			}
+			total.Plan = append(total.Plan, res.Plan...)
+			total.Failed = append(total.Failed, res.Failed...)
+		}
+		return report(total, opts.DryRun)
+	})
+}
+
+func runDiff(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	trees := addTreesFlags(fs)
+	filters := addFilterFlags(fs)
+	templates := addTemplateFlags(fs)
+	normalize := addNormalizeFlags(fs)
+	secrets := addVaultFlags(fs)
+	ephemeral := addEphemeralFlag(fs)
+	symlinks := addSymlinksFlag(fs)
+	names := addNamesFlags(fs)
+	properties := addPropertiesFlag(fs)
+	overlays := addOverlayFlags(fs)
+	if code, ok := parseFlags(fs, args, false); !ok {
+		return code
+	}
+
+	var opts zksync.Options
+	filter, err := filters.filter()
+	if err == nil {
+		opts.Template, err = templates.template()
+	}
+	if err == nil {
+		opts.Normalize, err = normalize.normalizer()
+	}
+	if err == nil {
+		opts.Vault, err = secrets.vault()
+	}
+	if err == nil {
+		opts.Symlinks, err = zksync.ParseSymlinkPolicy(*symlinks)
+	}
+	if err == nil {
+		opts.Properties, err = properties.properties()
+	}
+	var pairs []treePair
+	if err == nil {
+		pairs, err = trees.pairs()
+	}
+	if err == nil {
+		err = overlays.check(pairs)
+	}
+	if err == nil {
+		opts.Names, err = names.mapping()
+	}
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	opts.Filter = filter
+	opts.Ephemeral = *ephemeral
+	pairs, cleanUp, err := overlays.merge(pairs)
+	if err != nil {
+		slog.Error("Could not merge overlays", "err", err)
+		return exitError
+	}
+	defer cleanUp()
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		diffs, err := diffTrees(ctx, client, pairs, opts)
+		if err != nil {
+			slog.Error("Could not compare", "err", err)
+			return exitCode(err)
+		}
+		if structured() {
+			err = writeStructured(zksync.NewVerifyReport(diffs))
+		} else {
+			err = zksync.WriteDiffs(os.Stdout, diffs)
+		}
+		if err != nil {
+			slog.Error("Could not write diffs", "err", err)
+			return exitError
+		}
+		if len(diffs) == 0 {
+			return exitNothingToDo
+		}
+		return exitOK
+	})
+}
+
+func runVerify(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	trees := addTreesFlags(fs)
+	filters := addFilterFlags(fs)
+	templates := addTemplateFlags(fs)
+	normalize := addNormalizeFlags(fs)
+	secrets := addVaultFlags(fs)
+	ephemeral := addEphemeralFlag(fs)
+	symlinks := addSymlinksFlag(fs)
+	names := addNamesFlags(fs)
+	properties := addPropertiesFlag(fs)
+	overlays := addOverlayFlags(fs)
+	if code, ok := parseFlags(fs, args, false); !ok {
+		return code
+	}
+
+	var opts zksync.Options
+	filter, err := filters.filter()
+	if err == nil {
+		opts.Template, err = templates.template()
+	}
+	if err == nil {
+		opts.Normalize, err = normalize.normalizer()
+	}
+	if err == nil {
+		opts.Vault, err = secrets.vault()
+	}
+	if err == nil {
+		opts.Symlinks, err = zksync.ParseSymlinkPolicy(*symlinks)
+	}
+	if err == nil {
+		opts.Properties, err = properties.properties()
+	}
+	var pairs []treePair
+	if err == nil {
+		pairs, err = trees.pairs()
+	}
+	if err == nil {
+		err = overlays.check(pairs)
+	}
+	if err == nil {
+		opts.Names, err = names.mapping()
+	}
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	opts.Filter = filter
+	opts.Ephemeral = *ephemeral
+	pairs, cleanUp, err := overlays.merge(pairs)
+	if err != nil {
+		slog.Error("Could not merge overlays", "err", err)
+		return exitError
+	}
+	defer cleanUp()
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		diffs, err := diffTrees(ctx, client, pairs, opts)
+		if err != nil {
+			slog.Error("Could not compare", "err", err)
+			return exitCode(err)
+		}
+		report := zksync.NewVerifyReport(diffs)
+		if structured() {
+			err = writeStructured(report)
+		} else {
+			for _, m := range report.Mismatches {
+				fmt.Printf("%-13s %s\n", m.Kind, m.Path)
+			}
+		}
+		if err != nil {
+			slog.Error("Could not write report", "err", err)
+			return exitError
+		}
+		if !report.Identical {
+			slog.Error("Trees differ", "mismatches", len(report.Mismatches))
+			return exitMismatch
+		}
+		slog.Info("Trees are identical")
+		return exitOK
+	})
+}
+
+func runValidate(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	serverPrefix := fs.String("server_prefix", "/discodev", "Server prefix for config")
+	filters := addFilterFlags(fs)
+	validate := addValidateFlags(fs)
+	if code, ok := parseFlags(fs, args, false); !ok {
+		return code
+	}
+
+	var opts zksync.Options
+	filter, err := filters.filter()
+	if err == nil {
+		opts.Validator, err = validate.validator()
+	}
+	if err == nil && opts.Validator == nil {
+		err = fmt.Errorf("nothing to check, give -validate, -validator or -schemas")
+	}
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	opts.Filter = filter
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		err := client.Validate(ctx, *serverPrefix, opts)
+		var ve *zksync.ValidationError
+		if !errors.As(err, &ve) {
+			if err != nil {
+				slog.Error("Could not validate", "path", *serverPrefix, "err", err)
+				return exitCode(err)
+			}
+			slog.Info("All files are valid")
+			return exitOK
+		}
+		for _, err := range ve.Errs {
+			slog.Error("Invalid", "err", err)
+		}
+		slog.Error("Files failed validation", "count", len(ve.Errs))
+		return exitMismatch
+	})
+}
+
+func runLs(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	recursive := fs.Bool("R", false, "List every descendant, not just the children")
+	long := fs.Bool("l", false, "Show the version, size and mtime of each node")
+	if code, ok := parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	p := "/"
+	if fs.NArg() == 1 {
+		p = fs.Arg(0)
+	}
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		entries, err := client.ListTree(ctx, p, *recursive)
+		if err != nil {
+			slog.Error("Could not list", "path", p, "err", err)
+			return exitCode(err)
+		}
+		if structured() {
+			if err := writeStructured(lsReport(entries)); err != nil {
+				slog.Error("Could not write listing", "err", err)
+				return exitError
+			}
+			return exitOK
+		}
+		for _, e := range entries {
+			name := e.Path
+			if e.Dir() {
+				name += "/"
+			}
+			if !*long {
+				fmt.Println(name)
+				continue
+			}
+			mtime := "-"
+			if !e.Stat.Mtime.IsZero() {
+				mtime = e.Stat.Mtime.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%8d %10d %19s %s\n", e.Stat.Version, e.Stat.DataLength, mtime, name)
+		}
+		return exitOK
+	})
+}
+
+// lsEntry is a node as ls -output json and yaml show it.
+type lsEntry struct {
+	Path    string `json:"path" yaml:"path"`
+	Dir     bool   `json:"dir" yaml:"dir"`
+	Version int64  `json:"version" yaml:"version"`
+	Size    int    `json:"size" yaml:"size"`
+	Mtime   string `json:"mtime,omitempty" yaml:"mtime,omitempty"`
+}
+
+func lsReport(entries []zksync.Entry) []lsEntry {
+	out := make([]lsEntry, len(entries))
+	for i, e := range entries {
+		out[i] = lsEntry{Path: e.Path, Dir: e.Dir(), Version: e.Stat.Version, Size: e.Stat.DataLength}
+		if !e.Stat.Mtime.IsZero() {
+			out[i].Mtime = e.Stat.Mtime.UTC().Format(time.RFC3339)
+		}
+	}
+	return out
+}
+
+func runCat(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	if code, ok := parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		data, _, err := client.ReadNode(fs.Arg(0))
+		if err != nil {
+			slog.Error("Could not read", "err", err)
+			return exitCode(err)
+		}
+		if _, err := os.Stdout.Write(data); err != nil {
+			slog.Error("Could not write", "err", err)
+			return exitError
+		}
+		return exitOK
+	})
+}
+
+func runPut(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	apply := addApplyFlags(fs)
+	file := fs.String("f", "-", "File to write to the node, - for stdin")
+	version := fs.Int64("version", -1, "Only write if the node is at this version, as ls -l shows, -1 for any")
+	if code, ok := parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	var data []byte
+	var err error
+	if *file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(*file)
+	}
+	if err != nil {
+		slog.Error("Could not read", "file", *file, "err", err)
+		return exitError
+	}
+
+	opts, err := apply.options(nil)
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		res, err := client.Put(ctx, fs.Arg(0), data, *version, opts)
+		return finish(res, err, opts.DryRun)
+	})
+}
+
+func runGet(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	key := fs.String("key", "", "Dotted key, such as db.pool.size, of the JSON or YAML document in the file to print the value of, numbers indexing into lists and \\. standing for a dot in a key; the whole file if empty")
+	if code, ok := parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		var data []byte
+		var err error
+		if *key == "" {
+			data, _, err = client.ReadNode(fs.Arg(0))
+		} else if data, err = client.GetKey(fs.Arg(0), *key); err == nil && !bytes.HasSuffix(data, []byte("\n")) {
+			data = append(data, '\n')
+		}
+		if err != nil {
+			slog.Error("Could not read", "err", err)
+			return exitCode(err)
+		}
+		if _, err := os.Stdout.Write(data); err != nil {
+			slog.Error("Could not write", "err", err)
+			return exitError
+		}
+		return exitOK
+	})
+}
+
+func runSet(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	apply := addApplyFlags(fs)
+	key := fs.String("key", "", "Dotted key, such as db.pool.size, of the JSON or YAML document in the file to set, numbers indexing into lists and \\. standing for a dot in a key")
+	asString := fs.Bool("string", false, "Set the value as a string, even if it reads as a number, a bool or a map?")
+	version := fs.Int64("version", -1, "Only write if the node is at this version, as ls -l shows, -1 for any")
+	if code, ok := parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if fs.NArg() != 2 || *key == "" {
+		fs.Usage()
+		return exitUsage
+	}
+
+	opts, err := apply.options(nil)
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		res, err := client.SetKey(ctx, fs.Arg(0), *key, fs.Arg(1), *asString, *version, opts)
+		return finish(res, err, opts.DryRun)
+	})
+}
+
+func runApplyPatch(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	apply := addApplyFlags(fs)
+	patchArg := fs.String("patch", "", "The patch itself, instead of -f")
+	file := fs.String("f", "-", "File holding the patch, - for stdin")
+	kind := fs.String("type", "", "Patch type: json for an RFC 6902 list of operations, merge for an RFC 7386 merge patch; json for a list and merge otherwise if empty")
+	version := fs.Int64("version", -1, "Only patch the node if it is at this version, as ls -l shows, -1 for any")
+	if code, ok := parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	patch := []byte(*patchArg)
+	var err error
+	switch {
+	case *patchArg != "":
+	case *file == "-":
+		patch, err = ioutil.ReadAll(os.Stdin)
+	default:
+		patch, err = ioutil.ReadFile(*file)
+	}
+	if err != nil {
+		slog.Error("Could not read", "file", *file, "err", err)
+		return exitError
+	}
+	patchKind := zksync.MergePatch
+	if *kind != "" {
+		patchKind, err = zksync.ParsePatchKind(*kind)
+	} else if bytes.HasPrefix(bytes.TrimSpace(patch), []byte("[")) {
+		patchKind = zksync.JSONPatch
+	}
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+
+	opts, err := apply.options(nil)
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		res, err := client.PatchNode(ctx, fs.Arg(0), patch, patchKind, *version, opts)
+		return finish(res, err, opts.DryRun)
+	})
+}
+
+func runWatch(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	tree := addTreeFlags(fs)
+	apply := addApplyFlags(fs)
+	filters := addFilterFlags(fs)
+	acls := addACLFlags(fs)
+	templates := addTemplateFlags(fs)
+	normalize := addNormalizeFlags(fs)
+	secrets := addVaultFlags(fs)
+	symlinks := addSymlinksFlag(fs)
+	names := addNamesFlags(fs)
+	validate := addValidateFlags(fs)
+	properties := addPropertiesFlag(fs)
+	ephemeral := addEphemeralFlag(fs)
+	upload := fs.Bool("upload", false, "Upload local changes instead of mirroring remote ones?")
+	debounce := fs.Duration("debounce", zksync.DefaultDebounce, "Quiet period before uploading local changes")
+	comparePtr := fs.String("compare", string(zksync.CompareChecksum), "How the initial download spots changed files: checksum or mtime")
+	notify := addNotifyFlags(fs)
+	daemon := addDaemonFlags(fs)
+	hooks := addWebhookFlags(fs)
+	if code, ok := parseFlags(fs, args, false); !ok {
+		return code
+	}
+
+	opts, err := apply.options(filters)
+	if err == nil {
+		opts.Compare, err = zksync.ParseCompareMode(*comparePtr)
+	}
+	if err == nil {
+		opts.ACLs, err = acls.policy()
+	}
+	if err == nil {
+		opts.Template, err = templates.template()
+	}
+	if err == nil {
+		opts.Normalize, err = normalize.normalizer()
+	}
+	if err == nil {
+		opts.Vault, err = secrets.vault()
+	}
+	if err == nil {
+		opts.Symlinks, err = zksync.ParseSymlinkPolicy(*symlinks)
+	}
+	if err == nil {
+		opts.Validator, err = validate.validator()
+	}
+	if err == nil {
+		opts.Properties, err = properties.properties()
+	}
+	if err == nil && (opts.Template != nil || opts.Validator != nil) && !*upload {
+		err = fmt.Errorf("-template, -validate, -validator and -schemas only apply to watch -upload")
+	}
+	if err == nil && hooks.enabled() && *upload {
+		err = fmt.Errorf("-webhook reports remote changes, it does not apply to watch -upload")
+	}
+	if err == nil {
+		err = notify.check()
+	}
+	if err == nil {
+		opts.Names, err = names.mapping()
+	}
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	opts.Debounce = *debounce
+	opts.Ephemeral = *ephemeral
+	if notify.enabled() {
+		opts.OnApplied = notify.applied
+	}
+	if hooks.enabled() {
+		opts.OnApplied = hooks.observe(opts.OnApplied)
+	}
+	if daemon.enabled() {
+		if err := daemon.serve(); err != nil {
+			slog.Error("Could not serve HTTP", "addr", daemon.addr, "err", err)
+			return exitError
+		}
+		opts.OnApplied = daemon.observe(opts.OnApplied)
+		cfg.OnSessionEvent = daemon.sessionEvent
+	}
+	sigs := newDaemonSignals()
+	opts.Resync = sigs.resync()
+	opts.OnApplied = sigs.observe(opts.OnApplied)
+	cfg.OnSessionEvent = sigs.sessionEvent(cfg.OnSessionEvent)
+	sd := newSystemd(daemon.health.grace)
+	if sd.enabled() {
+		opts.OnApplied = sd.observe(opts.OnApplied)
+		cfg.OnSessionEvent = sd.sessionEvent(cfg.OnSessionEvent)
+	}
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		go sigs.run(ctx)
+		if sd.enabled() {
+			go sd.run(ctx)
+		}
+		if notify.enabled() {
+			go notify.run(ctx)
+		}
+		if hooks.enabled() {
+			hooks.watch(ctx, tree.localPrefix, tree.serverPrefix, opts.Names)
+		}
+		if *upload {
+			err = client.WatchLocal(ctx, tree.localPrefix, tree.serverPrefix, opts)
+		} else {
+			err = client.Watch(ctx, tree.localPrefix, tree.serverPrefix, opts)
+		}
+		if err != nil {
+			slog.Error("Watch failed", "err", err)
+			return exitCode(err)
+		}
+		return exitOK
+	})
+}
+
+func runReplicate(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	destCfg := addConnectFlags(fs, "dest-", "Destination: ")
+	serverPrefix := fs.String("server_prefix", "/discodev", "Server prefix of the tree to copy")
+	destPrefix := fs.String("dest_prefix", "", "Where to copy the tree to on the destination, -server_prefix if not given")
+	apply := addApplyFlags(fs)
+	filters := addFilterFlags(fs)
+	acls := addACLFlags(fs)
+	prune := fs.Bool("prune", false, "Delete destination nodes removed from the source?")
+	atomic := fs.Bool("atomic", false, "Apply all the changes in one transaction, so readers never see half of them?")
+	watch := fs.Bool("watch", false, "Keep copying changes as they happen until stopped?")
+	notify := addNotifyFlags(fs)
+	daemon := addDaemonFlags(fs)
+	confirm := addConfirmFlag(fs)
+	if code, ok := parseFlags(fs, args, false); !ok {
+		return code
+	}
+
+	opts, err := apply.options(filters)
+	if err == nil {
+		opts.ACLs, err = acls.policy()
+	}
+	if err == nil && *watch && *atomic {
+		err = fmt.Errorf("-atomic does not apply to -watch")
+	}
+	if err == nil && !*watch && (notify.enabled() || daemon.enabled()) {
+		err = fmt.Errorf("-notify-* and -http-addr only apply to -watch")
+	}
+	if err == nil {
+		err = notify.check()
+	}
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	if *destPrefix == "" {
+		*destPrefix = *serverPrefix
+	}
+	opts.Prune = *prune
+	opts.Atomic = *atomic
+	if !*watch {
+		// there is no one to ask while it keeps copying
+		confirm.apply(&opts)
+	}
+	if notify.enabled() {
+		opts.OnApplied = notify.applied
+	}
+	if daemon.enabled() {
+		if err := daemon.serve(); err != nil {
+			slog.Error("Could not serve HTTP", "addr", daemon.addr, "err", err)
+			return exitError
+		}
+		opts.OnApplied = daemon.observe(opts.OnApplied)
+		destCfg.OnSessionEvent = daemon.sessionEvent
+	}
+	var sigs *daemonSignals
+	if *watch {
+		sigs = newDaemonSignals()
+		opts.Resync = sigs.resync()
+		opts.OnApplied = sigs.observe(opts.OnApplied)
+		destCfg.OnSessionEvent = sigs.sessionEvent(destCfg.OnSessionEvent)
+	}
+	sd := newSystemd(daemon.health.grace)
+	if *watch && sd.enabled() {
+		opts.OnApplied = sd.observe(opts.OnApplied)
+		destCfg.OnSessionEvent = sd.sessionEvent(destCfg.OnSessionEvent)
+	}
+
+	return withClient(cfg, func(ctx context.Context, src *zksync.Client) int {
+		if err := ensemble.ensure(destCfg); err != nil {
+			slog.Error("Not connecting to the destination", "servers", destCfg.Servers, "err", err)
+			return exitCode(err)
+		}
+		b, err := zksync.Open(*destCfg)
+		if err != nil {
+			slog.Error("Could not connect to the destination", "servers", destCfg.Servers, "err", err)
+			return exitCode(err)
+		}
+		defer b.Close()
+		dest := zksync.New(b)
+		dest.Encryption = src.Encryption
+		// only the destination is written to
+		dest.Audit, src.Audit = src.Audit, nil
+
+		if !*watch {
+			res, err := dest.Replicate(ctx, src, *serverPrefix, *destPrefix, opts)
+			return finish(res, err, opts.DryRun)
+		}
+		go sigs.run(ctx)
+		if sd.enabled() {
+			go sd.run(ctx)
+		}
+		if notify.enabled() {
+			go notify.run(ctx)
+		}
+		if err := dest.ReplicateWatch(ctx, src, *serverPrefix, *destPrefix, opts); err != nil {
+			slog.Error("Replication failed", "err", err)
+			return exitCode(err)
+		}
+		return exitOK
+	})
+}
+
+func runACL(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	// for sync
+	serverPrefix := fs.String("server_prefix", "/discodev", "Server prefix for config")
+	apply := addApplyFlags(fs)
+	filters := addFilterFlags(fs)
+	acls := addACLFlags(fs)
+	action, args, code, ok := splitAction(fs, args)
+	if !ok {
+		return code
+	}
+
+	switch action {
+	case "get":
+		if code, ok := parseFlags(fs, args, true); !ok {
+			return code
+		}
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return exitUsage
+		}
+		return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+			ab, ok := client.Backend.(zksync.ACLBackend)
+			if !ok {
+				slog.Error("Could not read ACL", "err", zksync.ErrUnsupported)
+				return exitError
+			}
+			acl, err := ab.GetACL(fs.Arg(0))
+			if err != nil {
+				slog.Error("Could not read ACL", "path", fs.Arg(0), "err", err)
+				return exitCode(err)
+			}
+			for _, a := range acl {
+				fmt.Println(a)
+			}
+			return exitOK
+		})
+	case "sync":
+		if code, ok := parseFlags(fs, args, false); !ok {
+			return code
+		}
+
+		opts, err := apply.options(filters)
+		if err == nil {
+			opts.ACLs, err = acls.policy()
+		}
+		if err == nil && opts.ACLs == nil {
+			err = fmt.Errorf("acl sync needs -acl or -acl-file")
+		}
+		if err != nil {
+			slog.Error("Invalid flags", "err", err)
+			return exitUsage
+		}
+		return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+			res, err := client.SyncACLs(ctx, *serverPrefix, opts)
+			return finish(res, err, opts.DryRun)
+		})
+	}
+	slog.Error("Unknown acl action", "action", action)
+	fs.Usage()
+	return exitUsage
+}
+
+func runK8s(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	serverPrefix := fs.String("server_prefix", "/discodev", "Server prefix for config")
+	filters := addFilterFlags(fs)
+	kube := zksync.KubeConfig{}
+	fs.StringVar(&kube.Name, "name", "", "Name of the ConfigMap and Secret, the last part of -server_prefix if empty")
+	fs.StringVar(&kube.Namespace, "namespace", "", "Namespace of the ConfigMap and Secret, kubectl's current one if empty")
+	var secrets stringList
+	fs.Var(&secrets, "secret", "Put files matching this glob, or regexp when prefixed with re:, in a Secret instead; repeatable")
+	if code, ok := parseFlags(fs, args, false); !ok {
+		return code
+	}
+	filter, err := filters.filter()
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	kube.Secrets = secrets
+	if kube.Name == "" {
+		kube.Name = path.Base(*serverPrefix)
+	}
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		files, err := client.ReadTree(ctx, *serverPrefix, zksync.Options{Filter: filter})
+		if err != nil {
+			slog.Error("Could not read tree", "path", *serverPrefix, "err", err)
+			return exitCode(err)
+		}
+		manifests, err := zksync.KubeManifests(files, kube)
+		if err != nil {
+			slog.Error("Could not render manifests", "err", err)
+			return exitError
+		}
+		os.Stdout.Write(manifests)
+		return exitOK
+	})
+}
+
+func runReleases(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	serverPrefix := fs.String("server_prefix", "/discodev", "Server prefix for config")
+	if code, ok := parseFlags(fs, args, false); !ok {
+		return code
+	}
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		releases, current, err := client.Releases(*serverPrefix)
+		if err != nil {
+			slog.Error("Could not list releases", "err", err)
+			return exitCode(err)
+		}
+		if structured() {
+			names := make([]string, len(releases))
+			for i, r := range releases {
+				names[i] = path.Base(r)
+			}
+			out := struct {
+				Releases []string `json:"releases" yaml:"releases"`
+				Current  string   `json:"current,omitempty" yaml:"current,omitempty"`
+			}{Releases: names}
+			if current != "" {
+				out.Current = path.Base(current)
+			}
+			if err := writeStructured(out); err != nil {
+				slog.Error("Could not write releases", "err", err)
+				return exitError
+			}
+			return exitOK
+		}
+		for _, r := range releases {
+			marker := " "
+			if r == current {
+				marker = "*"
+			}
+			fmt.Println(marker, path.Base(r))
+		}
+		return exitOK
+	})
+}
+
+func runRollback(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	serverPrefix := fs.String("server_prefix", "/discodev", "Server prefix for config")
+	apply := addApplyFlags(fs)
+	if code, ok := parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	opts, err := apply.options(nil)
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		res, err := client.Rollback(ctx, *serverPrefix, fs.Arg(0), opts)
+		return finish(res, err, opts.DryRun)
+	})
+}
+
+func runDeploy(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	serverPrefix := fs.String("server_prefix", "/discodev", "Server prefix for config")
+	repo := fs.String("repo", ".", "Git repository to deploy from, a path or a URL")
+	ref := fs.String("ref", "HEAD", "Branch, tag or commit to deploy")
+	dir := fs.String("dir", "", "Subdir of the repository holding the tree, the whole of it if not given")
+	apply := addApplyFlags(fs)
+	filters := addFilterFlags(fs)
+	acls := addACLFlags(fs)
+	templates := addTemplateFlags(fs)
+	normalize := addNormalizeFlags(fs)
+	secrets := addVaultFlags(fs)
+	symlinks := addSymlinksFlag(fs)
+	validate := addValidateFlags(fs)
+	prune := fs.Bool("prune", false, "Delete remote nodes not in the tree deployed?")
+	hooks := addHookFlags(fs)
+	locks := addLockFlags(fs)
+	checkVersion := addCheckVersionFlag(fs)
+	confirm := addConfirmFlag(fs)
+	if code, ok := parseFlags(fs, args, false); !ok {
+		return code
+	}
+
+	opts, err := apply.options(filters)
+	if err == nil {
+		opts.ACLs, err = acls.policy()
+	}
+	if err == nil {
+		opts.Template, err = templates.template()
+	}
+	if err == nil {
+		opts.Normalize, err = normalize.normalizer()
+	}
+	if err == nil {
+		opts.Vault, err = secrets.vault()
+	}
+	if err == nil {
+		opts.Symlinks, err = zksync.ParseSymlinkPolicy(*symlinks)
+	}
+	if err == nil {
+		opts.Validator, err = validate.validator()
+	}
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	opts.CheckVersion = *checkVersion
+	opts.Prune = *prune
+	confirm.apply(&opts)
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		t := treePair{localPrefix: *repo, serverPrefix: *serverPrefix}
+		res, err := locks.around(ctx, client, t.serverPrefix, opts.DryRun, func() (*zksync.Result, error) {
+			return hooks.around(ctx, "deploy", t, opts, func(opts zksync.Options) (*zksync.Result, error) {
+				res, d, err := client.Deploy(ctx, *repo, *ref, *dir, *serverPrefix, opts)
+				if err == nil && !opts.DryRun && len(res.Failed) == 0 {
+					slog.Info("Deployed", "path", *serverPrefix, "commit", d.Commit)
+				}
+				return res, err
+			})
+		})
+		return finish(res, err, opts.DryRun)
+	})
+}
+
+func runStatus(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	serverPrefix := fs.String("server_prefix", "/discodev", "Server prefix for config")
+	if code, ok := parseFlags(fs, args, false); !ok {
+		return code
+	}
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		d, err := client.Deployed(*serverPrefix)
+		if err != nil {
+			slog.Error("Could not read deployment", "path", *serverPrefix, "err", err)
+			return exitCode(err)
+		}
+		if structured() {
+			if err := writeStructured(d); err != nil {
+				slog.Error("Could not write deployment", "err", err)
+				return exitError
+			}
+			return exitOK
+		}
+		if d == nil {
+			fmt.Printf("%s: nothing deployed from git\n", *serverPrefix)
+			return exitOK
+		}
+		from := d.Repo
+		if d.Dir != "" {
+			from += " " + d.Dir
+		}
+		fmt.Printf("%s: %s (%s) from %s\n", *serverPrefix, d.Commit, d.Ref, from)
+		fmt.Printf("deployed %s", d.Time.Local().Format(time.RFC1123))
+		if d.User != "" {
+			fmt.Printf(" by %s", d.User)
+		}
+		fmt.Println()
+		return exitOK
+	})
+}
+
+func runBackup(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	serverPrefix := fs.String("server_prefix", "/discodev", "Server prefix for config")
+	filters := addFilterFlags(fs)
+	if code, ok := parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	filter, err := filters.filter()
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		snapshot, err := client.Backup(ctx, *serverPrefix, zksync.Options{Filter: filter})
+		if err != nil {
+			slog.Error("Could not back up", "path", *serverPrefix, "err", err)
+			return exitCode(err)
+		}
+		if err := snapshot.Save(fs.Arg(0)); err != nil {
+			slog.Error("Could not save backup", "err", err)
+			return exitError
+		}
+		slog.Info("Backed up", "path", *serverPrefix, "nodes", len(snapshot.Nodes), "file", fs.Arg(0))
+		return exitOK
+	})
+}
+
+func runRestore(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	serverPrefix := fs.String("server_prefix", "", "Where to restore to, the path the backup was taken from if empty")
+	apply := addApplyFlags(fs)
+	filters := addFilterFlags(fs)
+	prune := fs.Bool("prune", false, "Delete nodes that were not in the backup?")
+	confirm := addConfirmFlag(fs)
+	if code, ok := parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	opts, err := apply.options(filters)
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	opts.Prune = *prune
+	confirm.apply(&opts)
+
+	snapshot, err := zksync.LoadSnapshot(fs.Arg(0))
+	if err != nil {
+		slog.Error("Could not load backup", "err", err)
+		return exitError
+	}
+	target := *serverPrefix
+	if target == "" {
+		target = snapshot.Root
+	}
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		res, err := client.Restore(ctx, snapshot, target, opts)
+		return finish(res, err, opts.DryRun)
+	})
+}
+
+// finish reports the outcome of an operation that may not have got as far
+// as planning.
+func finish(res *zksync.Result, err error, dryRun bool) int {
+	if err != nil {
+		slog.Error("Nothing was changed", "err", err)
+		if structured() {
+			writeStructured(zksync.Report{DryRun: dryRun, Error: err.Error(), Changes: []zksync.OpReport{}})
+		}
+		return exitCode(err)
+	}
+	return report(res, dryRun)
+}
+
+// report logs the outcome of an operation and picks the exit code for it.
+func report(res *zksync.Result, dryRun bool) int {
+	rep := res.Report(dryRun)
+	if structured() {
+		if err := writeStructured(rep); err != nil {
+			slog.Error("Could not write report", "err", err)
+		}
+	}
+	for _, p := range res.Conflicts {
+		slog.Warn("Changed on both sides, left alone", "path", p)
+	}
+	if dryRun {
+		if !structured() {
+			res.Plan.Print(os.Stdout)
+		}
+		return exitOK
+	}
+	for _, p := range res.Unreconciled {
+		slog.Error("Could not make the remote node match the local tree", "path", p)
+	}
+	if len(res.Plan) == 0 && len(res.Conflicts) == 0 && len(res.Unreconciled) == 0 {
+		slog.Info("Nothing to do")
+		return exitNothingToDo
+	}
+
+	if len(res.Failed) > 0 {
+		for _, err := range res.Failed {
+			var opErr *zksync.OpError
+			if stopped(err) && errors.As(err, &opErr) {
+				slog.Warn("Not applied", "op", strings.TrimSpace(opErr.Op.String()))
+				continue
+			}
+			slog.Error("Change failed", "err", err)
+		}
+		if rep.NotApplied > 0 {
+			slog.Error("Stopped before finishing", summary(rep)...)
+			return exitStopped
+		}
+		slog.Error("Some changes failed", summary(rep)...)
+		if rep.Applied == 0 {
+			return exitCode(res.Failed[0])
+		}
+		return exitPartial
+	}
+	if len(res.Unreconciled) > 0 {
+		slog.Error("Done, but the remote tree still differs", append(summary(rep), "unreconciled", len(res.Unreconciled))...)
+		return exitMismatch
+	}
+	if len(res.Conflicts) > 0 {
+		slog.Warn("Done, with conflicts left", append(summary(rep), "conflicts", len(res.Conflicts))...)
+		return exitConflict
+	}
+
+	slog.Info("All done", summary(rep)...)
+	return exitOK
+}
+
+// summary is what a run did, counted up for the last line it logs.
+func summary(rep zksync.Report) []any {
+	attrs := []any{"applied", rep.Applied, "created", rep.Created, "updated", rep.Updated, "deleted", rep.Deleted}
+	if rep.Failed > 0 {
+		attrs = append(attrs, "failed", rep.Failed)
+	}
+	if rep.NotApplied > 0 {
+		attrs = append(attrs, "not_applied", rep.NotApplied)
+	}
+	return append(attrs, "bytes", rep.Bytes, "took", time.Since(started).Round(time.Millisecond))
+}
+
+func runVersions(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	root := fs.String("history-root", zksync.DefaultHistoryRoot, "Where the history is kept")
+	if code, ok := parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		versions, err := client.NodeHistory(&zksync.History{Root: *root}, fs.Arg(0))
+		if err != nil {
+			slog.Error("Could not list the history", "path", fs.Arg(0), "err", err)
+			return exitCode(err)
+		}
+		if structured() {
+			if versions == nil {
+				versions = []zksync.HistoryVersion{}
+			}
+			if err := writeStructured(versions); err != nil {
+				slog.Error("Could not write the history", "err", err)
+				return exitError
+			}
+			return exitOK
+		}
+		for _, v := range versions {
+			saved := "-"
+			if !v.Saved.IsZero() {
+				saved = v.Saved.Local().Format(time.RFC3339)
+			}
+			fmt.Printf("%d  %s  %d bytes\n", v.Version, saved, v.Size)
+		}
+		return exitOK
+	})
+}
+
+func runRevert(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	apply := addApplyFlags(fs)
+	to := fs.Int64("to", -1, "Version to put back, as the versions command lists them")
+	if code, ok := parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if fs.NArg() != 1 || *to < 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	opts, err := apply.options(nil)
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		res, err := client.Revert(ctx, apply.historyPolicy(), fs.Arg(0), *to, opts)
+		return finish(res, err, opts.DryRun)
+	})
+}
+
+func runNamespace(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	apply := addApplyFlags(fs)
+	confirm := addConfirmFlag(fs)
+	root := fs.String("namespace-root", zksync.DefaultNamespaceRoot, "Where namespaces are registered")
+	prefix := fs.String("prefix", "", "Path the namespace gets, /<name> if empty")
+	owner := fs.String("owner", "", "Team or person the namespace is for, recorded with it")
+	acl := fs.String("acl", "", "ACL for the prefix node, e.g. world:anyone:r,digest:team:hash:crwda")
+	maxNodes := fs.Int64("max-nodes", 0, "Most nodes the prefix may hold, itself included, 0 for no limit; a ZooKeeper quota on ZooKeeper")
+	maxBytes := fs.Int64("max-bytes", 0, "Most bytes of data the prefix may hold, 0 for no limit; a ZooKeeper quota on ZooKeeper")
+	action, args, code, ok := splitAction(fs, args)
+	if !ok {
+		return code
+	}
+	if code, ok = parseFlags(fs, args, true); !ok {
+		return code
+	}
+	want := map[string]int{"create": 1, "list": 0, "delete": 1}
+	n, ok := want[action]
+	if !ok || fs.NArg() != n {
+		fs.Usage()
+		return exitUsage
+	}
+	opts, err := apply.options(nil)
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	// for the budget, and so that budgets of the trees below which
+	// namespaces are made hold
+	opts.Quotas = apply.quotaPolicy()
+	confirm.apply(&opts)
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		switch action {
+		case "create":
+			ns := zksync.Namespace{Name: fs.Arg(0), Prefix: *prefix, Owner: *owner, ACL: *acl, MaxNodes: *maxNodes, MaxBytes: *maxBytes}
+			if ns.Prefix == "" {
+				ns.Prefix = path.Join("/", ns.Name)
+			}
+			res, err := client.CreateNamespace(ctx, *root, ns, opts)
+			return finish(res, err, opts.DryRun)
+		case "delete":
+			res, err := client.DeleteNamespace(ctx, *root, fs.Arg(0), opts)
+			return finish(res, err, opts.DryRun)
+		}
+		namespaces, err := client.Namespaces(*root)
+		if err != nil {
+			slog.Error("Could not list the namespaces", "err", err)
+			return exitCode(err)
+		}
+		if structured() {
+			if namespaces == nil {
+				namespaces = []zksync.Namespace{}
+			}
+			if err := writeStructured(namespaces); err != nil {
+				slog.Error("Could not write the namespaces", "err", err)
+				return exitError
+			}
+			return exitOK
+		}
+		for _, ns := range namespaces {
+			limit := func(n int64) string {
+				if n == 0 {
+					return "-"
+				}
+				return fmt.Sprint(n)
+			}
+			owner := ns.Owner
+			if owner == "" {
+				owner = "-"
+			}
+			fmt.Printf("%s  %s  %s  nodes:%s bytes:%s\n", ns.Name, ns.Prefix, owner, limit(ns.MaxNodes), limit(ns.MaxBytes))
+		}
+		return exitOK
+	})
+}
+
+func runQuota(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	apply := addApplyFlags(fs)
+	maxNodes := fs.Int64("max-nodes", 0, "Most nodes the prefix may hold, itself included, 0 for no limit")
+	maxBytes := fs.Int64("max-bytes", 0, "Most bytes of data the prefix may hold, as stored, 0 for no limit")
+	action, args, code, ok := splitAction(fs, args)
+	if !ok {
+		return code
+	}
+	if code, ok = parseFlags(fs, args, true); !ok {
+		return code
+	}
+	want := map[string]int{"list": 0, "set": 1, "delete": 1}
+	n, ok := want[action]
+	if !ok || fs.NArg() != n || (action == "set" && *maxNodes <= 0 && *maxBytes <= 0) {
+		fs.Usage()
+		return exitUsage
+	}
+	opts, err := apply.options(nil)
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	quotas := apply.quotaPolicy()
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		switch action {
+		case "set":
+			res, err := client.SetBudget(ctx, quotas, zksync.Budget{Prefix: fs.Arg(0), MaxNodes: *maxNodes, MaxBytes: *maxBytes}, opts)
+			return finish(res, err, opts.DryRun)
+		case "delete":
+			res, err := client.SetBudget(ctx, quotas, zksync.Budget{Prefix: fs.Arg(0)}, opts)
+			return finish(res, err, opts.DryRun)
+		}
+		usage, err := client.QuotaUsage(ctx, quotas)
+		if err != nil {
+			slog.Error("Could not count usage", "err", err)
+			return exitCode(err)
+		}
+		if structured() {
+			if usage == nil {
+				usage = []zksync.QuotaUsage{}
+			}
+			if err := writeStructured(usage); err != nil {
+				slog.Error("Could not write the usage", "err", err)
+				return exitError
+			}
+			return exitOK
+		}
+		for _, u := range usage {
+			limit := func(used, max int64) string {
+				if max == 0 {
+					return fmt.Sprint(used)
+				}
+				s := fmt.Sprintf("%d/%d", used, max)
+				if used > max {
+					s += " OVER"
+				}
+				return s
+			}
+			fmt.Printf("%s  nodes:%s bytes:%s\n", u.Prefix, limit(u.Nodes, u.MaxNodes), limit(u.Bytes, u.MaxBytes))
+		}
+		return exitOK
+	})
+}
+
+func runTrash(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	apply := addApplyFlags(fs)
+	confirm := addConfirmFlag(fs)
+	action, args, code, ok := splitAction(fs, args)
+	if !ok {
+		return code
+	}
+	if code, ok = parseFlags(fs, args, true); !ok {
+		return code
+	}
+	want := map[string][2]int{"list": {0, 0}, "restore": {1, 2}, "purge": {0, 0}}
+	n, ok := want[action]
+	if !ok || fs.NArg() < n[0] || fs.NArg() > n[1] {
+		fs.Usage()
+		return exitUsage
+	}
+	opts, err := apply.options(nil)
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	opts.Trash = nil
+	confirm.apply(&opts)
+	trash := apply.trashPolicy()
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		switch action {
+		case "restore":
+			target := "/"
+			if fs.NArg() == 2 {
+				target = fs.Arg(1)
+			}
+			res, err := client.RestoreTrash(ctx, trash, fs.Arg(0), target, opts)
+			return finish(res, err, opts.DryRun)
+		case "purge":
+			res, err := client.PurgeTrash(ctx, trash, opts)
+			return finish(res, err, opts.DryRun)
+		}
+		entries, err := client.TrashEntries(trash)
+		if err != nil {
+			slog.Error("Could not list the trash", "err", err)
+			return exitCode(err)
+		}
+		if structured() {
+			if entries == nil {
+				entries = []zksync.TrashEntry{}
+			}
+			if err := writeStructured(entries); err != nil {
+				slog.Error("Could not write the trash entries", "err", err)
+				return exitError
+			}
+			return exitOK
+		}
+		for _, e := range entries {
+			fmt.Printf("%s  %s ago\n", e.Name, time.Since(e.Deleted).Round(time.Second))
+		}
+		return exitOK
+	})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// completeCommand is run by the completion scripts, with the words typed
+// so far after configurator, to get the candidates for the last one.
+const completeCommand = "__complete"
+
+// remotePathCommands take remote paths as arguments.
+var remotePathCommands = map[string]bool{"ls": true, "cat": true, "put": true, "rm": true, "browse": true}
+
+var completionScripts = map[string]string{
+	"bash": `_configurator() {
+    local IFS=$'\n'
+    COMPREPLY=($(configurator ` + completeCommand + ` "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+    if [[ ${#COMPREPLY[@]} -eq 1 && ${COMPREPLY[0]} == */ ]]; then
+        compopt -o nospace
+    fi
+}
+complete -o default -F _configurator configurator
+`,
+	"zsh": `#compdef configurator
+_configurator() {
+    local -a candidates dirs
+    candidates=("${(@f)$(configurator ` + completeCommand + ` "${words[2,CURRENT][@]}" 2>/dev/null)}")
+    candidates=(${candidates:#})
+    if (( ${#candidates} == 0 )); then
+        _files
+        return
+    fi
+    dirs=(${(M)candidates:#*/})
+    compadd -S '' -- $dirs
+    compadd -- ${candidates:#*/}
+}
+compdef _configurator configurator
+`,
+	"fish": `function __configurator_complete
+    set -l words (commandline -opc) (commandline -ct)
+    configurator ` + completeCommand + ` $words[2..-1] 2>/dev/null
+end
+complete -c configurator -a '(__configurator_complete)'
+`,
+}
+
+func runCompletion(fs *flag.FlagSet, args []string) int {
+	if code, ok := parseFlags(fs, args, true); !ok {
+		return code
+	}
+	script, ok := completionScripts[fs.Arg(0)]
+	if fs.NArg() != 1 || !ok {
+		fs.Usage()
+		return exitUsage
+	}
+	fmt.Print(script)
+	return exitOK
+}
+
+// complete prints the candidates for the last of words: command names,
+// flag names, or remote paths read from the server the words point at.
+func complete(words []string) int {
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur := words[len(words)-1]
+	if len(words) == 1 {
+		for _, cmd := range commands {
+			if strings.HasPrefix(cmd.name, cur) {
+				fmt.Println(cmd.name)
+			}
+		}
+		return exitOK
+	}
+
+	cmd := findCommand(words[0])
+	if cmd == nil {
+		return exitOK
+	}
+	// running a command with -h registers its flags and does nothing else
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	addCommonFlags(fs)
+	cmd.run(fs, []string{"-h"})
+
+	// bash splits -flag=value into three words, and then only wants the
+	// value completed
+	var joined []string
+	split := false
+	for i := 0; i < len(words); i++ {
+		if words[i] == "=" && len(joined) > 0 {
+			joined[len(joined)-1] += "="
+			if i+1 < len(words) {
+				i++
+				joined[len(joined)-1] += words[i]
+			}
+			split = i == len(words)-1
+			continue
+		}
+		split = false
+		joined = append(joined, words[i])
+	}
+	words, cur = joined, joined[len(joined)-1]
+	valuePrefix := ""
+	prev := words[len(words)-2]
+	if name, value, ok := strings.Cut(cur, "="); ok && strings.HasPrefix(name, "-") {
+		prev, cur = name, value
+		if !split {
+			valuePrefix = name + "="
+		}
+	}
+	prevFlag := strings.TrimLeft(prev, "-")
+
+	switch {
+	case strings.HasPrefix(prev, "-") && prevFlag == "server_prefix":
+	case strings.HasPrefix(prev, "-") && fs.Lookup(prevFlag) != nil && !isBoolFlag(fs.Lookup(prevFlag)):
+		// the value of some other flag, left to the shell
+		return exitOK
+	case strings.HasPrefix(cur, "-"):
+		fs.VisitAll(func(f *flag.Flag) {
+			if strings.HasPrefix("-"+f.Name, cur) {
+				fmt.Println("-" + f.Name)
+			}
+		})
+		return exitOK
+	case !remotePathCommands[cmd.name]:
+		return exitOK
+	}
+
+	// the connection flags typed so far, and the profile, say where to look
+	fs.Parse(words[1 : len(words)-1])
+	conn := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	cfg := connectFlags(conn)
+	fs.Visit(func(f *flag.Flag) {
+		if conn.Lookup(f.Name) != nil {
+			conn.Set(f.Name, f.Value.String())
+		}
+	})
+	if applyProfile(conn) != nil {
+		return exitOK
+	}
+	cfg.Retry = zksync.RetryPolicy{}
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		for _, p := range remotePaths(ctx, client, cur) {
+			fmt.Println(valuePrefix + p)
+		}
+		return exitOK
+	})
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// remotePaths returns the remote paths starting with prefix, down to the
+// next slash, dirs ending in one.
+func remotePaths(ctx context.Context, client *zksync.Client, prefix string) []string {
+	if prefix == "" {
+		prefix = "/"
+	}
+	if !strings.HasPrefix(prefix, "/") {
+		return nil
+	}
+	dir, base := path.Split(prefix)
+	entries, err := client.ListTree(ctx, path.Clean(dir), false)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Path, base) {
+			continue
+		}
+		p := dir + e.Path
+		if e.Dir() {
+			p += "/"
+		}
+		paths = append(paths, p)
+	}
+	return paths
+}
@@ -3,223 +3,109 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
-	"path"
-	"path/filepath"
 	"strings"
-	"time"
-
-	"github.com/samuel/go-zookeeper/zk"
 )
 
-const nodeMode = 0744
-
-func doDelete(c *zk.Conn, serverPrefix *string) {
-	children, stat, err := c.Children(*serverPrefix)
-	if err != nil {
-		if err == zk.ErrNoNode {
-			log.Printf("Path %s not there\n", *serverPrefix)
-			return
-		}
-		panic(err)
-	}
-
-	for _, child := range children {
-		fullpath := path.Join(*serverPrefix, child)
-		doDelete(c, &fullpath)
-	}
+// command is one of the configurator subcommands, each with its own flags.
+type command struct {
+	name    string
+	args    string // positional arguments, for the usage line
+	summary string
+	run     func(fs *flag.FlagSet, args []string) int
+}
 
-	log.Printf("Will delete %s\n", *serverPrefix)
-	c.Delete(*serverPrefix, stat.Version)
+var commands = []*command{
+	{name: "upload", summary: "Copy the local tree to the server", run: runUpload},
+	{name: "download", summary: "Copy the server tree to disk", run: runDownload},
+	{name: "sync", summary: "Transfer whatever differs in both directions", run: runSync},
+	{name: "diff", summary: "Show how the local tree differs from the server's", run: runDiff},
+	{name: "verify", summary: "Check the local and server trees are identical, listing what differs and exiting with 8 if not", run: runVerify},
+	{name: "drift", summary: "Keep checking the server trees against -local_prefix, or -ref of the git repository -repo, reporting drift in the log, -http-addr metrics and -webhook posts", run: runDrift},
+	{name: "validate", summary: "Check the files under -server_prefix with -validate, -validator and -schemas, listing those failing and exiting with 8 if any does", run: runValidate},
+	{name: "watch", summary: "Keep mirroring remote changes to disk, or local ones to the server with -upload, telling a process with -notify-*", run: runWatch},
+	{name: "serve", summary: "Serve a REST API at -listen running uploads, downloads, syncs and diffs of the trees given, and streaming their remote changes", run: runServe},
+	{name: "render", args: "[template:dest...]", summary: "Write files from Go templates reading the tree under -server_prefix, or from the confd template resources in -confdir, for programs that cannot read it themselves, rendering them again as it changes with -watch", run: runRender},
+	{name: "replicate", summary: "Copy the tree under -server_prefix to the servers -dest-servers, keeping it in step with -watch", run: runReplicate},
+	{name: "ls", args: "[path]", summary: "List the children of a remote path, with -R all its descendants, and with -l their versions, sizes and mtimes", run: runLs},
+	{name: "browse", args: "[path]", summary: "Browse the remote tree from -server_prefix, editing files and downloading or uploading subtrees", run: runBrowse},
+	{name: "cat", args: "path", summary: "Print the data of a remote file", run: runCat},
+	{name: "put", args: "path", summary: "Write stdin, or the file -f, to a remote file, with -version only over that version", run: runPut},
+	{name: "get", args: "path", summary: "Print the value of the dotted key -key of the JSON or YAML document in a remote file, or all of the file without -key", run: runGet},
+	{name: "set", args: "path value", summary: "Set the dotted key -key of the JSON or YAML document in a remote file, leaving the rest as it is and writing it back only over the version read", run: runSet},
+	{name: "apply-patch", args: "path", summary: "Apply an RFC 6902 JSON patch or RFC 7386 merge patch, given with -patch, -f or on stdin, to the JSON document in a remote file, writing it back only over the version patched", run: runApplyPatch},
+	{name: "acl", args: "get path | sync", summary: "Show the ACL of a node, or give every node under -server_prefix the ACL -acl and -acl-file say", run: runACL},
+	{name: "k8s", summary: "Print the tree under -server_prefix as a Kubernetes ConfigMap, and a Secret with -secret, for kubectl apply -f -", run: runK8s},
+	{name: "releases", summary: "List the releases uploaded under -server_prefix with upload -release, marking the current one", run: runReleases},
+	{name: "rollback", args: "[release]", summary: "Point -server_prefix/current back at the release before the current one, or at the one given", run: runRollback},
+	{name: "deploy", summary: "Upload the tree at -ref of the git repository -repo, recording the commit deployed", run: runDeploy},
+	{name: "status", summary: "Show which git commit was last deployed to -server_prefix", run: runStatus},
+	{name: "history", args: "[path]", summary: "Show the changes recorded with -audit-file or -audit-node, those under path if given", run: runHistory},
+	{name: "backup", args: "file", summary: "Save the tree under -server_prefix, with ACLs, to a JSON file, gzipped if its name ends in .gz", run: runBackup},
+	{name: "restore", args: "file", summary: "Recreate a tree saved by backup where it was taken from, or under -server_prefix", run: runRestore},
+	{name: "export", summary: "Print the tree under -server_prefix as one JSON or YAML document, dirs as maps and files as their data, base64 encoded if binary, for reviewing what is deployed, or as CSV path,value rows for spreadsheets", run: runExport},
+	{name: "import", args: "file", summary: "Upload a document written by export, or a CSV file of path,value rows, - for stdin, to -server_prefix", run: runImport},
+	{name: "completion", args: "bash|zsh|fish", summary: "Print a shell completion script, completing remote paths from the server", run: runCompletion},
+	{name: "rm", args: "path...", summary: "Delete remote paths and everything below them", run: runRm},
+	{name: "versions", args: "path", summary: "List the versions of a remote file -history runs kept before overwriting or deleting it, for revert", run: runVersions},
+	{name: "revert", args: "path", summary: "Put back the version -to of a remote file from the history, over what it holds now", run: runRevert},
+	{name: "namespace", args: "create name | list | delete name", summary: "Set aside a prefix for a team, with its ACL, node and byte limits and a registration below -namespace-root, list the namespaces, or delete one and all it holds", run: runNamespace},
+	{name: "quota", args: "list | set prefix | delete prefix", summary: "Show what the trees with a budget hold against it, or set or delete the -max-nodes and -max-bytes budget of a prefix, which -quotas runs keep to", run: runQuota},
+	{name: "trash", args: "list | restore entry [path] | purge", summary: "List what -trash runs moved to the trash, put an entry back, all of it or what is below path, or purge entries older than -trash-keep", run: runTrash},
+	{name: "selftest", summary: "Check connecting, writing, uploading and downloading, ACLs, watches and deleting work, under a scratch path below -server_prefix removed after", run: runSelftest},
 }
 
-func ensureRemotePath(c *zk.Conn, serverPrefix *string) {
-	if *serverPrefix == "/" {
-		return
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: configurator <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", cmd.name, cmd.summary)
 	}
+	fmt.Fprintf(os.Stderr, "\nRun 'configurator <command> -h' for the flags of a command.\n")
+}
 
-	dir := path.Dir(*serverPrefix)
-	ensureRemotePath(c, &dir)
-	if _, err := c.Create(*serverPrefix, nil, 0, zk.AuthACL(zk.PermAll)); err != nil {
-		if err == zk.ErrNodeExists {
-			log.Printf("Dir already created: %s\n", *serverPrefix)
-		} else {
-			panic(err)
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
 		}
 	}
+	return nil
 }
 
-func doUpload(c *zk.Conn, serverPrefix *string, localPrefix *string) {
-	// iterate local dir
-	absLocal, err := filepath.Abs(*localPrefix)
-	if err != nil {
-		panic(err)
+func run() int {
+	if len(os.Args) < 2 {
+		usage()
+		return exitUsage
 	}
-
-	ensureRemotePath(c, serverPrefix)
-
-	visitFunc := func(visitedPath string, fInfo os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if !fInfo.Mode().IsRegular() && !fInfo.IsDir() {
-			log.Printf("Node is not a regular file: %s\n", visitedPath)
-			return err
-		}
-
-		remotePath := path.Join(*serverPrefix, visitedPath[len(absLocal):])
-
-		// upload files
-		var fData []byte
-		if fInfo.IsDir() {
-			fData = []byte{}
-		} else {
-			data, err := ioutil.ReadFile(visitedPath)
-			if err != nil {
-				panic(err)
-			} else {
-				fData = data
-			}
+	name, args := os.Args[1], os.Args[2:]
+	switch name {
+	case completeCommand:
+		return complete(args)
+	case "help", "-h", "-help", "--help":
+		if len(args) == 0 || findCommand(args[0]) == nil {
+			usage()
+			return exitOK
 		}
-
-		if _, err := c.Create(remotePath, fData, 0, zk.AuthACL(zk.PermAll)); err != nil {
-			if err == zk.ErrNodeExists {
-				if fInfo.IsDir() {
-					log.Printf("Dir already there: %s\n", remotePath)
-				} else {
-					_, fStat, err := c.Exists(remotePath)
-					if err != nil {
-						panic(err)
-					} else if fStat.NumChildren > 0 {
-						panic("Remote path is a dir when a file is expected: " + remotePath)
-					}
-
-					if _, err := c.Set(remotePath, fData, fStat.Version); err != nil {
-						panic(err)
-					}
-					log.Printf("Overwrote %s -> %s\n", visitedPath, remotePath)
-				}
-			} else {
-				panic(err)
-			}
-		} else {
-			log.Printf("Copied %s -> %s\n", visitedPath, remotePath)
-		}
-
-		return err
-	}
-	if err := filepath.Walk(absLocal, visitFunc); err != nil {
-		panic(err)
+		name, args = args[0], []string{"-h"}
 	}
-}
 
-func doDownload(c *zk.Conn, serverPrefix *string, localPrefix *string) {
-	// iterate remote dir
-	fData, stat, err := c.Get(*serverPrefix)
-	if err != nil {
-		if err == zk.ErrNoNode {
-			log.Fatalf("Path %s not there\n", *serverPrefix)
-		}
-		panic(err)
+	cmd := findCommand(name)
+	if cmd == nil {
+		slog.Error("Unknown command", "command", name)
+		usage()
+		return exitUsage
 	}
 
-	if stat.DataLength == 0 {
-		// create dir
-		if err := os.Mkdir(*localPrefix, nodeMode); err != nil {
-			if os.IsExist(err) {
-				log.Printf("Local dir already present: %s\n", *localPrefix)
-			} else {
-				panic(err)
-			}
-		} else {
-			log.Printf("Created local dir: %s\n", *localPrefix)
-		}
-
-		// iterate children
-		if stat.NumChildren > 0 {
-			children, _, err := c.Children(*serverPrefix)
-			if err != nil {
-				panic(err)
-			}
-
-			for _, child := range children {
-				fullpath := path.Join(*serverPrefix, child)
-				fulllocalpath := path.Join(*localPrefix, child)
-				doDownload(c, &fullpath, &fulllocalpath)
-			}
-
-		}
-	} else {
-		// check local file
-		mtime := time.Unix(stat.Mtime/1000, 0)
-		log.Printf("Remote file was modified on: %s\n", mtime)
-
-		fInfo, err := os.Stat(*localPrefix)
-		if err != nil {
-			if os.IsNotExist(err) {
-				log.Printf("Local file does not exist\n")
-			} else {
-				panic(err)
-			}
-		} else {
-			log.Printf("Local file was modified on: %s\n", fInfo.ModTime())
-			if mtime == fInfo.ModTime() {
-				log.Printf("Files are the same")
-				return
-			} else if mtime.Before(fInfo.ModTime()) {
-				fmt.Printf("Remote file is older than local file: %s\n", *localPrefix)
-				return
-			} else {
-				log.Printf("Remote file is newer, will overwrite")
-			}
-		}
-
-		// create file
-		if err := ioutil.WriteFile(*localPrefix, fData, 0644); err != nil {
-			panic(err)
-		}
-		if err := os.Chtimes(*localPrefix, mtime, mtime); err != nil {
-			panic(err)
-		}
-
-		fmt.Printf("Downloaded file: %s\n", *localPrefix)
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s\n\n%s.\n\nFlags:\n", strings.TrimSpace("configurator "+cmd.name+" [flags] "+cmd.args), cmd.summary)
+		fs.PrintDefaults()
 	}
+	addCommonFlags(fs)
+	return cmd.run(fs, args)
 }
 
 func main() {
-	serversPtr := flag.String("servers", "localhost", "Zookeeper server list")
-	authPtr := flag.String("auth", "", "Auth infomation sent to server")
-	serverPrefix := flag.String("server_prefix", "/discodev", "Server prefix for config")
-	localPrefix := flag.String("local_prefix", "/", "Local prefix for config")
-	isUpload := flag.Bool("upload", false, "Upload config to server?")
-	isDelete := flag.Bool("delete", false, "Clean remote before upload?")
-
-	flag.Parse()
-
-	c, _, err := zk.Connect(strings.Split(*serversPtr, ","), 5*time.Second)
-	if err != nil {
-		panic(err)
-	}
-	defer c.Close()
-
-	if *authPtr != "" {
-		err = c.AddAuth("digest", []byte(*authPtr))
-		if err != nil {
-			panic(err)
-		}
-	}
-
-	if *isUpload {
-		if *isDelete {
-			doDelete(c, serverPrefix)
-		}
-		doUpload(c, serverPrefix, localPrefix)
-	} else {
-		doDownload(c, serverPrefix, localPrefix)
-	}
-
-	log.Println("All done")
+	os.Exit(run())
 }
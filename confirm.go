@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// errDeclined is how a run ends when its deletions are not confirmed.
+var errDeclined = errors.New("deletions not confirmed, nothing changed")
+
+// confirmShown is how many of the deleted subtrees the prompt lists.
+const confirmShown = 10
+
+// confirmFlag says whether runs may delete without asking first.
+type confirmFlag struct {
+	yes bool
+}
+
+// addConfirmFlag registers -yes, for one-off commands that may delete.
+func addConfirmFlag(fs *flag.FlagSet) *confirmFlag {
+	c := &confirmFlag{}
+	fs.BoolVar(&c.yes, "yes", false, "Delete without asking for confirmation first? Needed to delete anything when not on a terminal")
+	return c
+}
+
+// apply has opts ask on the terminal before applying a plan that deletes
+// remote nodes or removes local files, listing what would go. Without a
+// terminal to ask on, such plans are refused unless -yes was given. Dry
+// runs never ask, as they apply nothing.
+func (c *confirmFlag) apply(opts *zksync.Options) {
+	if c.yes || opts.DryRun {
+		return
+	}
+	trashed := opts.Trash != nil
+	next := opts.BeforeApply
+	opts.BeforeApply = func(p zksync.Plan) error {
+		if err := confirmDeletions(p, trashed); err != nil {
+			return err
+		}
+		if next != nil {
+			return next(p)
+		}
+		return nil
+	}
+}
+
+// confirmDeletions asks whether to go ahead with the deletions of p, if it
+// has any.
+func confirmDeletions(p zksync.Plan, trashed bool) error {
+	remote, local := deletedRoots(p)
+	if len(remote) == 0 && len(local) == 0 {
+		return nil
+	}
+	var what []string
+	if n := countDeleted(remote); n > 0 {
+		verb := "delete"
+		if trashed {
+			verb = "move to the trash"
+		}
+		what = append(what, fmt.Sprintf("%s %d nodes on the server", verb, n))
+	}
+	if n := countDeleted(local); n > 0 {
+		what = append(what, fmt.Sprintf("remove %d local files and dirs", n))
+	}
+	summary := "This would " + strings.Join(what, " and ")
+	if !interactive() {
+		return fmt.Errorf("%s, confirm with -yes: %w", summary, errDeclined)
+	}
+	return promptDeletions(bufio.NewReader(os.Stdin), os.Stderr, summary, remote, local)
+}
+
+func promptDeletions(in *bufio.Reader, out io.Writer, summary string, remote, local map[string]int) error {
+	fmt.Fprintf(out, "%s:\n", summary)
+	shown := 0
+	for _, roots := range []map[string]int{remote, local} {
+		sorted := make([]string, 0, len(roots))
+		for r := range roots {
+			sorted = append(sorted, r)
+		}
+		sort.Strings(sorted)
+		for _, r := range sorted {
+			if shown == confirmShown {
+				break
+			}
+			shown++
+			if below := roots[r] - 1; below > 0 {
+				fmt.Fprintf(out, "  %s (and %d below)\n", r, below)
+			} else {
+				fmt.Fprintf(out, "  %s\n", r)
+			}
+		}
+	}
+	if more := len(remote) + len(local) - shown; more > 0 {
+		fmt.Fprintf(out, "  and %d more\n", more)
+	}
+	fmt.Fprint(out, "Go ahead? [y/N] ")
+	answer, err := in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errDeclined
+}
+
+// deletedRoots returns the topmost remote and local paths p deletes, each
+// with how many paths go with it, itself included.
+func deletedRoots(p zksync.Plan) (remote, local map[string]int) {
+	remoteSet, localSet := make(map[string]bool), make(map[string]bool)
+	for _, o := range p {
+		switch o.Kind {
+		case zksync.OpDelete:
+			remoteSet[o.Target] = true
+		case zksync.OpRemove:
+			localSet[filepath.ToSlash(filepath.Clean(o.Target))] = true
+		}
+	}
+	for target := range remoteSet {
+		if zksync.IsChunk(target) && !remoteSet[path.Dir(target)] {
+			// what is left of a large file made smaller, not a deletion
+			delete(remoteSet, target)
+		}
+	}
+	return rootsOf(remoteSet), rootsOf(localSet)
+}
+
+func rootsOf(set map[string]bool) map[string]int {
+	roots := make(map[string]int)
+	for p := range set {
+		root := p
+		for dir := path.Dir(p); ; dir = path.Dir(dir) {
+			if set[dir] {
+				root = dir
+			}
+			if dir == "/" || dir == "." {
+				break
+			}
+		}
+		roots[root]++
+	}
+	return roots
+}
+
+func countDeleted(roots map[string]int) int {
+	n := 0
+	for _, c := range roots {
+		n += c
+	}
+	return n
+}
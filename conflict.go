@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// errQuit is how a sync ends when told to quit at a conflict.
+var errQuit = errors.New("quit at conflict prompt, nothing changed")
+
+// conflictFlags say how syncs settle files changed on both sides.
+type conflictFlags struct {
+	policy string
+}
+
+func addConflictFlags(fs *flag.FlagSet) *conflictFlags {
+	c := &conflictFlags{}
+	fs.StringVar(&c.policy, "on-conflict", "", "How to settle files changed on both sides: prompt, newest-wins, local-wins, remote-wins or skip; if empty, prompt for -three-way syncs on a terminal, skip for other -three-way syncs and newest-wins otherwise")
+	fs.StringVar(&c.policy, "conflict", "", "Same as -on-conflict")
+	return c
+}
+
+// apply has opts settle conflicts as the flags say, prompting on the
+// terminal for every one if asked to. Dry runs do not prompt, listing the
+// conflicts instead.
+func (c *conflictFlags) apply(opts *zksync.Options) error {
+	prompt := c.policy == "prompt" || c.policy == "" && opts.ThreeWay && interactive()
+	if !prompt {
+		var err error
+		opts.Policy, err = zksync.ParseConflictPolicy(c.policy)
+		return err
+	}
+	if !interactive() {
+		return errors.New("-on-conflict prompt needs a terminal")
+	}
+	if opts.DryRun {
+		opts.Policy = zksync.Skip
+		return nil
+	}
+	pr := &prompter{in: bufio.NewReader(os.Stdin), out: os.Stderr}
+	opts.Resolve = pr.resolve
+	return nil
+}
+
+// interactive reports whether someone is at a terminal to answer prompts.
+func interactive() bool {
+	for _, f := range []*os.File{os.Stdin, os.Stderr} {
+		fi, err := f.Stat()
+		if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// prompter asks how to settle each conflict, the way git add -p asks about
+// each hunk.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func (pr *prompter) resolve(d zksync.Difference) (zksync.Resolution, error) {
+	what, local, remote, choices := "changed on both sides", "keep the local file", "keep the remote file", "l,r,d,e,s,q,?"
+	switch d.Kind {
+	case zksync.LocalOnly:
+		what, local, remote, choices = "changed locally, deleted remotely", "upload it again", "remove it locally", "l,r,s,q,?"
+	case zksync.RemoteOnly:
+		what, local, remote, choices = "changed remotely, deleted locally", "delete it remotely", "download it again", "l,r,s,q,?"
+	}
+	fmt.Fprintf(pr.out, "%s: %s\n", d.Path, what)
+	for {
+		fmt.Fprintf(pr.out, "Settle %s [%s]? ", d.Path, choices)
+		answer, err := pr.in.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return zksync.Resolution{}, errQuit
+			}
+			return zksync.Resolution{}, err
+		}
+		switch strings.TrimSpace(answer) {
+		case "l":
+			return zksync.Resolution{Policy: zksync.LocalWins}, nil
+		case "r":
+			return zksync.Resolution{Policy: zksync.RemoteWins}, nil
+		case "s":
+			return zksync.Resolution{Policy: zksync.Skip}, nil
+		case "q":
+			return zksync.Resolution{}, errQuit
+		case "d":
+			if d.Kind == zksync.Modified {
+				if err := zksync.WriteUnified(pr.out, d.RemotePath, d.LocalPath, d.RemoteData, d.LocalData); err != nil {
+					return zksync.Resolution{}, err
+				}
+				continue
+			}
+		case "e":
+			if d.Kind == zksync.Modified {
+				merged, err := pr.edit(d)
+				if err != nil {
+					fmt.Fprintf(pr.out, "Could not edit: %v\n", err)
+				} else if merged != nil {
+					return zksync.Resolution{Merged: merged}, nil
+				}
+				continue
+			}
+		}
+		fmt.Fprintf(pr.out, "l - %s\nr - %s\n", local, remote)
+		if d.Kind == zksync.Modified {
+			fmt.Fprintf(pr.out, "d - show the diff from remote to local\ne - edit the two merged, and write that to both sides\n")
+		}
+		fmt.Fprintf(pr.out, "s - leave both as they are\nq - quit, changing nothing\n? - print this help\n")
+	}
+}
+
+// edit opens both sides of d, merged with conflict markers, in $EDITOR,
+// returning what was saved, or nil if markers were left in.
+func (pr *prompter) edit(d zksync.Difference) ([]byte, error) {
+	tmp, err := ioutil.TempFile("", "configurator-merge-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(zksync.MarkConflicts("local", "remote", d.LocalData, d.RemoteData))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command("/bin/sh", "-c", editor+` "$0"`, tmp.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	merged, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	for _, marker := range []string{"<<<<<<< ", "\n=======\n", ">>>>>>> "} {
+		if bytes.Contains(merged, []byte(marker)) {
+			fmt.Fprintf(pr.out, "Conflict markers left in, edit again or pick a side\n")
+			return nil, nil
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// redacted stands in for credentials in what is logged.
+const redacted = "REDACTED"
+
+// addAuthFlags registers the ways to give cfg its credentials other than
+// -auth, which leaves them in ps output and shell history: a file, the OS
+// keychain or $CONFIGURATOR_AUTH, $CONFIGURATOR_DEST_AUTH for the dest-
+// flags. The file and keychain are read as the flags are parsed.
+func addAuthFlags(fs *flag.FlagSet, cfg *zksync.BackendConfig, prefix, what string) {
+	env := "CONFIGURATOR_" + strings.ToUpper(strings.ReplaceAll(prefix, "-", "_")) + "AUTH"
+	fs.StringVar(&cfg.Auth, prefix+"auth", "", what+"Auth information sent to server; visible to other users in ps, prefer -"+prefix+"auth-file or $"+env)
+	cfg.Auth = os.Getenv(env) // not a default, for -help not to print it
+	fs.Var(authFile{cfg}, prefix+"auth-file", what+"File holding the auth information, instead of -"+prefix+"auth")
+	fs.Var(authKeychain{cfg}, prefix+"auth-keychain", what+"Service[/account] of the auth information in the OS keychain, the macOS Keychain or the Secret Service through secret-tool, instead of -"+prefix+"auth")
+	fs.Var(extraAuth{cfg}, prefix+"add-auth", what+"More Zookeeper credentials for the session as scheme:credential, such as digest:user:password, ip:10.0.0.1, x509:CN=me, or sasl[:principal] to turn on -"+prefix+"sasl; repeatable")
+}
+
+// extraAuth is a flag adding credentials for a scheme of their own to a
+// BackendConfig.
+type extraAuth struct {
+	cfg *zksync.BackendConfig
+}
+
+func (e extraAuth) String() string {
+	return ""
+}
+
+func (e extraAuth) Set(s string) error {
+	a, err := zksync.ParseAuthEntry(s)
+	if err != nil {
+		return err
+	}
+	e.cfg.ExtraAuth = append(e.cfg.ExtraAuth, a)
+	return nil
+}
+
+// authFile is a flag setting the credentials of a BackendConfig to what
+// the file it names holds.
+type authFile struct {
+	cfg *zksync.BackendConfig
+}
+
+func (f authFile) String() string {
+	return ""
+}
+
+func (f authFile) Set(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	auth := strings.TrimSpace(string(data))
+	if auth == "" {
+		return fmt.Errorf("%s is empty", file)
+	}
+	f.cfg.Auth = auth
+	return nil
+}
+
+// authKeychain is a flag setting the credentials of a BackendConfig to
+// what the OS keychain holds for the service, and account, it names.
+type authKeychain struct {
+	cfg *zksync.BackendConfig
+}
+
+func (k authKeychain) String() string {
+	return ""
+}
+
+func (k authKeychain) Set(spec string) error {
+	auth, err := keychainLookup(spec)
+	if err != nil {
+		return err
+	}
+	k.cfg.Auth = auth
+	return nil
+}
+
+// keychainLookup returns the secret the OS keychain holds for spec,
+// service[/account].
+func keychainLookup(spec string) (string, error) {
+	service, account, _ := strings.Cut(spec, "/")
+	if service == "" {
+		return "", fmt.Errorf("want service[/account]")
+	}
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		args := []string{"find-generic-password", "-s", service, "-w"}
+		if account != "" {
+			args = append(args, "-a", account)
+		}
+		cmd = exec.Command("security", args...)
+	case "linux", "freebsd", "openbsd", "netbsd":
+		args := []string{"lookup", "service", service}
+		if account != "" {
+			args = append(args, "account", account)
+		}
+		cmd = exec.Command("secret-tool", args...)
+	default:
+		return "", fmt.Errorf("no keychain support on %s", runtime.GOOS)
+	}
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("reading %s from the keychain: %w", spec, err)
+	}
+	auth := strings.TrimRight(string(out), "\r\n")
+	if auth == "" {
+		return "", fmt.Errorf("no secret for %s in the keychain", spec)
+	}
+	return auth, nil
+}
+
+// redactArgs returns args with the values of flags holding credentials
+// replaced, for logging.
+func redactArgs(args []string) []string {
+	out := append([]string(nil), args...)
+	for i := 0; i < len(out); i++ {
+		if out[i] == "--" || !strings.HasPrefix(out[i], "-") {
+			continue
+		}
+		name, _, hasValue := strings.Cut(strings.TrimLeft(out[i], "-"), "=")
+		if !secretFlag(name) {
+			continue
+		}
+		if hasValue {
+			out[i] = out[i][:strings.Index(out[i], "=")+1] + redacted
+		} else if i+1 < len(out) {
+			i++
+			out[i] = redacted
+		}
+	}
+	return out
+}
+
+// secretFlag reports whether the flag called name holds credentials, as
+// -auth, -add-auth, -dest-auth, -token and -webhook-secret do. The -secret
+// of k8s is a pattern.
+func secretFlag(name string) bool {
+	return name == "auth" || name == "token" ||
+		strings.HasSuffix(name, "-auth") || strings.HasSuffix(name, "-token") || strings.HasSuffix(name, "-secret")
+}
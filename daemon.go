@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// daemon serves metrics and health checks over HTTP while watching.
+type daemon struct {
+	addr    string
+	metrics *metrics
+	health  *health
+}
+
+func addDaemonFlags(fs *flag.FlagSet) *daemon {
+	d := &daemon{health: &health{}}
+	fs.StringVar(&d.addr, "http-addr", "", "Serve /metrics, /healthz and /readyz at this address, e.g. :9100")
+	fs.DurationVar(&d.health.grace, "health-grace", time.Minute, "How long the ZooKeeper session may be lost before /healthz fails")
+	return d
+}
+
+func (d *daemon) enabled() bool {
+	return d.addr != ""
+}
+
+// serve starts serving. The listener is opened before returning so that a
+// bad address is reported up front.
+func (d *daemon) serve() error {
+	d.metrics = newMetrics()
+	ln, err := net.Listen("tcp", d.addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", d.metrics.handler())
+	mux.HandleFunc("/healthz", d.health.live)
+	mux.HandleFunc("/readyz", d.health.ready)
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			slog.Error("HTTP server failed", "err", err)
+		}
+	}()
+	return nil
+}
+
+// observe returns a watch hook feeding both metrics and health, then next.
+func (d *daemon) observe(next func(zksync.Plan, []error, time.Duration)) func(zksync.Plan, []error, time.Duration) {
+	return d.metrics.observe(d.health.observe(next))
+}
+
+func (d *daemon) sessionEvent(state string) {
+	d.metrics.sessionEvent(state)
+	d.health.sessionEvent(state)
+}
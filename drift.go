@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// driftChecker compares remote trees with their source of truth, a local
+// tree or a git ref, telling whoever is listening when they drift apart.
+type driftChecker struct {
+	client   *zksync.Client
+	pairs    []treePair
+	repo     string
+	ref      string
+	dir      string
+	opts     zksync.Options
+	daemon   *daemon
+	webhooks *webhooks
+	systemd  *systemd
+
+	last map[string]string // the drift each tree last had, as driftKey says
+}
+
+func runDrift(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	trees := addTreesFlags(fs)
+	repo := fs.String("repo", "", "Git repository holding the source of truth, a path or a URL, instead of -local_prefix")
+	ref := fs.String("ref", "HEAD", "Branch, tag or commit of -repo to compare with, fetched again for every check")
+	dir := fs.String("dir", "", "Subdir of -repo holding the tree, the whole of it if not given")
+	filters := addFilterFlags(fs)
+	templates := addTemplateFlags(fs)
+	normalize := addNormalizeFlags(fs)
+	secrets := addVaultFlags(fs)
+	ephemeral := addEphemeralFlag(fs)
+	symlinks := addSymlinksFlag(fs)
+	names := addNamesFlags(fs)
+	properties := addPropertiesFlag(fs)
+	every := fs.Duration("every", 5*time.Minute, "How often to check for drift")
+	once := fs.Bool("once", false, "Check once and exit, with 8 if a tree drifted, instead of checking -every so often?")
+	daemon := addDaemonFlags(fs)
+	hooks := addWebhookFlags(fs)
+	if code, ok := parseFlags(fs, args, false); !ok {
+		return code
+	}
+
+	var opts zksync.Options
+	filter, err := filters.filter()
+	if err == nil {
+		opts.Template, err = templates.template()
+	}
+	if err == nil {
+		opts.Normalize, err = normalize.normalizer()
+	}
+	if err == nil {
+		opts.Vault, err = secrets.vault()
+	}
+	if err == nil {
+		opts.Symlinks, err = zksync.ParseSymlinkPolicy(*symlinks)
+	}
+	if err == nil {
+		opts.Properties, err = properties.properties()
+	}
+	var pairs []treePair
+	if err == nil {
+		pairs, err = trees.pairs()
+	}
+	if err == nil && *repo != "" && len(pairs) > 1 {
+		err = fmt.Errorf("-repo holds the source of truth of a single -server_prefix")
+	}
+	if err == nil && *every <= 0 && !*once {
+		err = fmt.Errorf("-every must be positive")
+	}
+	if err == nil {
+		opts.Names, err = names.mapping()
+	}
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	opts.Filter = filter
+	opts.Ephemeral = *ephemeral
+	if daemon.enabled() {
+		if err := daemon.serve(); err != nil {
+			slog.Error("Could not serve HTTP", "addr", daemon.addr, "err", err)
+			return exitError
+		}
+		daemon.metrics.enableDrift()
+		cfg.OnSessionEvent = daemon.sessionEvent
+	}
+
+	var sigs *daemonSignals
+	if !*once {
+		sigs = newDaemonSignals()
+		cfg.OnSessionEvent = sigs.sessionEvent(cfg.OnSessionEvent)
+	}
+	sd := newSystemd(daemon.health.grace)
+	if !*once && sd.enabled() {
+		cfg.OnSessionEvent = sd.sessionEvent(cfg.OnSessionEvent)
+	}
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		var resync <-chan struct{}
+		if sigs != nil {
+			resync = sigs.resync()
+			go sigs.run(ctx)
+		}
+		if !*once && sd.enabled() {
+			go sd.run(ctx)
+		}
+		d := &driftChecker{client: client, pairs: pairs, repo: *repo, ref: *ref, dir: *dir, opts: opts, last: make(map[string]string)}
+		if daemon.enabled() {
+			d.daemon = daemon
+		}
+		if !*once && sd.enabled() {
+			d.systemd = sd
+		}
+		if hooks.enabled() {
+			d.webhooks = hooks
+			go hooks.run(ctx)
+		}
+		for {
+			drifted, err := d.check(ctx)
+			if *once {
+				switch {
+				case err != nil:
+					return exitCode(err)
+				case drifted:
+					return exitMismatch
+				}
+				return exitOK
+			}
+			select {
+			case <-ctx.Done():
+				if drifted {
+					return exitMismatch
+				}
+				return exitOK
+			case <-time.After(*every):
+			case <-resync:
+				slog.Info("Checking at once")
+			}
+		}
+	})
+}
+
+// check compares every tree once, reporting whether any has drifted, and
+// the first error of those that could not be compared.
+func (d *driftChecker) check(ctx context.Context) (drifted bool, err error) {
+	var errs []error
+	for _, t := range d.pairs {
+		source := t.localPrefix
+		var diffs []zksync.Difference
+		var treeErr error
+		if d.repo != "" {
+			var commit string
+			diffs, commit, treeErr = d.client.DiffGit(ctx, d.repo, d.ref, d.dir, t.serverPrefix, d.opts)
+			source = d.repo + "@" + commit
+		} else {
+			diffs, treeErr = d.client.Diff(ctx, t.localPrefix, t.serverPrefix, d.opts)
+		}
+		if treeErr != nil {
+			if ctx.Err() == nil {
+				slog.Error("Could not check for drift", "tree", t.serverPrefix, "err", treeErr)
+			}
+			errs = append(errs, treeErr)
+			if d.daemon != nil {
+				d.daemon.metrics.observeDrift(t.serverPrefix, 0, treeErr)
+			}
+			continue
+		}
+
+		report := zksync.NewVerifyReport(diffs)
+		if report.Identical {
+			slog.Info("No drift", "tree", t.serverPrefix, "source", source)
+		} else {
+			drifted = true
+			slog.Warn("Remote tree drifted", "tree", t.serverPrefix, "source", source, "mismatches", len(report.Mismatches))
+			for _, m := range report.Mismatches {
+				slog.Warn("Drift", "tree", t.serverPrefix, "kind", m.Kind, "path", m.Path)
+			}
+		}
+		if d.daemon != nil {
+			d.daemon.metrics.observeDrift(t.serverPrefix, len(report.Mismatches), nil)
+		}
+		d.notify(t.serverPrefix, source, report)
+	}
+	if d.daemon != nil {
+		d.daemon.health.observe(nil)(nil, errs, 0)
+	}
+	if d.systemd != nil {
+		d.systemd.observe(nil)(nil, errs, 0)
+	}
+	if len(errs) > 0 {
+		err = errs[0]
+	}
+	return drifted, err
+}
+
+// notify posts the drift of tree to the webhooks when it is not what it was
+// at the last check, so that drift left alone is only reported once. A tree
+// found clean at the first check is not reported.
+func (d *driftChecker) notify(tree, source string, report zksync.VerifyReport) {
+	key := driftKey(report)
+	last, seen := d.last[tree]
+	d.last[tree] = key
+	if d.webhooks == nil || key == last || !seen && report.Identical {
+		return
+	}
+	if report.Identical {
+		d.webhooks.post("drift-resolved", fmt.Sprintf("%s: drift resolved, back in line with %s", tree, source), []webhookChange{})
+		return
+	}
+	changes := make([]webhookChange, len(report.Mismatches))
+	for i, m := range report.Mismatches {
+		changes[i] = webhookChange{Path: path.Join(tree, m.Path), Event: m.Kind, OldSHA256: m.LocalSHA256, NewSHA256: m.RemoteSHA256}
+	}
+	d.webhooks.post("drift", fmt.Sprintf("%s: %d paths drifted from %s", tree, len(changes), source), changes)
+}
+
+// driftKey tells apart one drift from another: the same paths differing in
+// the same way.
+func driftKey(report zksync.VerifyReport) string {
+	var b strings.Builder
+	for _, m := range report.Mismatches {
+		fmt.Fprintf(&b, "%s %s %s %s\n", m.Kind, m.Path, m.LocalSHA256, m.RemoteSHA256)
+	}
+	return b.String()
+}
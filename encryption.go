@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// encryptionFlags say which files to store encrypted, and where the key
+// comes from. Every command that connects has them.
+type encryptionFlags struct {
+	patterns   stringList
+	keyFile    string
+	keyEnv     string
+	keyCommand string
+}
+
+var encryption encryptionFlags
+
+func addEncryptionFlags(fs *flag.FlagSet) {
+	fs.Var(&encryption.patterns, "encrypt", "Encrypt files whose remote path matches this glob, or regexp when prefixed with re:; repeatable")
+	fs.StringVar(&encryption.keyFile, "encryption-key-file", "", "File holding the base64 AES key to encrypt and decrypt files with")
+	fs.StringVar(&encryption.keyCommand, "encryption-key-command", "", "Shell command printing the base64 AES key, such as a KMS decrypt call")
+	fs.StringVar(&encryption.keyEnv, "encryption-key-env", "CONFIGURATOR_ENCRYPTION_KEY", "Environment variable holding the base64 AES key, when no other source is given")
+}
+
+// encryption returns the Encryption the flags ask for, nil if there is no
+// key to encrypt or decrypt with.
+func (e *encryptionFlags) encryption() (*zksync.Encryption, error) {
+	var encoded string
+	switch {
+	case e.keyFile != "" && e.keyCommand != "":
+		return nil, fmt.Errorf("-encryption-key-file and -encryption-key-command are exclusive")
+	case e.keyFile != "":
+		data, err := ioutil.ReadFile(e.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(data)
+	case e.keyCommand != "":
+		cmd := exec.Command("/bin/sh", "-c", e.keyCommand)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("running -encryption-key-command: %w", err)
+		}
+		encoded = string(out)
+	default:
+		encoded = os.Getenv(e.keyEnv)
+	}
+
+	if encoded == "" {
+		if len(e.patterns) > 0 {
+			return nil, fmt.Errorf("-encrypt needs a key, from -encryption-key-file, -encryption-key-command or $%s", e.keyEnv)
+		}
+		return nil, nil
+	}
+	key, err := zksync.ParseKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return zksync.NewEncryption(key, e.patterns)
+}
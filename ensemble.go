@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// ensembleFlags say whether to check the ZooKeeper ensemble is healthy
+// before connecting to it. Every command that connects has them, so that a
+// profile can turn the check on for all of them.
+type ensembleFlags struct {
+	check     bool
+	skip      bool
+	adminPort int
+	timeout   time.Duration
+}
+
+var ensemble ensembleFlags
+
+func addEnsembleFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&ensemble.check, "health-check", false, "Ask every Zookeeper server how it is before connecting, refusing to go on if one is down, out of sync or there is no single leader?")
+	fs.BoolVar(&ensemble.skip, "skip-health-check", false, "Go on without -health-check, whatever the profile says?")
+	fs.IntVar(&ensemble.adminPort, "health-admin-port", 0, "Ask the AdminServer on this port, instead of sending ruok and mntr to the client port")
+	fs.DurationVar(&ensemble.timeout, "health-timeout", 5*time.Second, "How long every server has to answer -health-check")
+}
+
+// ensure checks the ensemble cfg points at is healthy, if asked to,
+// returning an error wrapping zksync.ErrDegraded if not.
+func (e *ensembleFlags) ensure(cfg *zksync.BackendConfig) error {
+	if !e.check || e.skip {
+		return nil
+	}
+	if cfg.Kind != "zookeeper" {
+		return fmt.Errorf("-health-check only applies to zookeeper")
+	}
+	health, err := zksync.CheckEnsemble(context.Background(), cfg.Servers, zksync.HealthCheck{AdminPort: e.adminPort, Timeout: e.timeout})
+	if err != nil {
+		return err
+	}
+	for _, s := range health {
+		slog.Debug("Server health", "server", s.Server, "state", s.State, "learners", s.Learners, "synced", s.Synced, "err", s.Error)
+	}
+	if err := zksync.EnsembleDegraded(health); err != nil {
+		return fmt.Errorf("%w, -skip-health-check to go on anyway", err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// Exit codes, so scripts can tell failures apart.
+const (
+	exitOK          = 0
+	exitError       = 1  // the run failed before anything was changed
+	exitUsage       = 2  // same code the flag package uses
+	exitConnection  = 3  // the ensemble could not be reached
+	exitAuth        = 4  // authentication or ACL failure
+	exitPartial     = 5  // some changes were applied, others failed
+	exitNothingToDo = 6  // local and remote were already in sync
+	exitStopped     = 7  // interrupted or timed out before finishing
+	exitMismatch    = 8  // verify found the trees differ
+	exitLocked      = 9  // another run held the lock past -lock-timeout
+	exitChanged     = 10 // -check-version found nodes changed since they were read
+	exitConflict    = 11 // sync left files changed on both sides alone
+	exitReadOnly    = 12 // -read-only refused to make changes
+	exitDegraded    = 13 // -health-check found the ensemble degraded
+	exitOverQuota   = 14 // -quotas refused a run going over a budget
+)
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, zksync.ErrNoAuth):
+		return exitAuth
+	case errors.Is(err, zksync.ErrNoSession):
+		return exitConnection
+	case errors.Is(err, zksync.ErrReadOnly):
+		return exitReadOnly
+	case errors.Is(err, zksync.ErrDegraded):
+		return exitDegraded
+	case errors.Is(err, zksync.ErrLocked):
+		return exitLocked
+	case errors.As(err, new(*zksync.ChangedError)):
+		return exitChanged
+	case errors.Is(err, zksync.ErrOverQuota):
+		return exitOverQuota
+	case stopped(err):
+		return exitStopped
+	}
+	return exitError
+}
+
+// stopped reports whether err is down to a signal or -timeout.
+func stopped(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
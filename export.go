@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+func runExport(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	serverPrefix := fs.String("server_prefix", "/discodev", "Server prefix for config")
+	filters := addFilterFlags(fs)
+	ephemeral := addEphemeralFlag(fs)
+	format := fs.String("format", "yaml", "Document format: json, yaml, or csv for path,value rows")
+	out := fs.String("o", "-", "File to write the document to, - for stdout")
+	if code, ok := parseFlags(fs, args, false); !ok {
+		return code
+	}
+	filter, err := filters.filter()
+	var docFormat zksync.DocFormat
+	if err == nil {
+		docFormat, err = zksync.ParseDocFormat(*format)
+	}
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		doc, err := client.Export(ctx, *serverPrefix, docFormat, zksync.Options{Filter: filter, Ephemeral: *ephemeral})
+		if err != nil {
+			slog.Error("Could not export", "path", *serverPrefix, "err", err)
+			return exitCode(err)
+		}
+		if *out == "-" {
+			_, err = os.Stdout.Write(doc)
+		} else {
+			err = ioutil.WriteFile(*out, doc, 0644)
+		}
+		if err != nil {
+			slog.Error("Could not write", "file", *out, "err", err)
+			return exitError
+		}
+		return exitOK
+	})
+}
+
+func runImport(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	serverPrefix := fs.String("server_prefix", "/discodev", "Server prefix for config")
+	apply := addApplyFlags(fs)
+	filters := addFilterFlags(fs)
+	acls := addACLFlags(fs)
+	clean := fs.Bool("clean", false, "Delete the remote tree before importing?")
+	prune := fs.Bool("prune", false, "Delete remote nodes with no key in the document?")
+	format := fs.String("format", "", "Document format: json, yaml or csv, from the file name if empty, yaml for stdin")
+	confirm := addConfirmFlag(fs)
+	if code, ok := parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	file := fs.Arg(0)
+	opts, err := apply.options(filters)
+	if err == nil {
+		opts.ACLs, err = acls.policy()
+	}
+	var docFormat zksync.DocFormat
+	if err == nil {
+		docFormat, err = importFormat(*format, file)
+	}
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	opts.Clean = *clean
+	opts.Prune = *prune
+	confirm.apply(&opts)
+
+	var src []byte
+	if file == "-" {
+		src, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		src, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		slog.Error("Could not read", "file", file, "err", err)
+		return exitError
+	}
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		res, err := client.Import(ctx, src, docFormat, file, *serverPrefix, opts)
+		return finish(res, err, opts.DryRun)
+	})
+}
+
+// importFormat returns the format given, or the one the extension of file
+// names.
+func importFormat(format, file string) (zksync.DocFormat, error) {
+	if format != "" {
+		return zksync.ParseDocFormat(format)
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		return zksync.FormatJSON, nil
+	case ".csv":
+		return zksync.FormatCSV, nil
+	}
+	return zksync.FormatYAML, nil
+}
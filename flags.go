@@ -0,0 +1,621 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// stringList is a flag that can be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// connectFlags registers the flags saying which server to talk to, and
+// how files on it are encrypted.
+func connectFlags(fs *flag.FlagSet) *zksync.BackendConfig {
+	addEncryptionFlags(fs)
+	addAuditFlags(fs)
+	addEnsembleFlags(fs)
+	return addConnectFlags(fs, "", "")
+}
+
+// addConnectFlags registers the connection flags with their names starting
+// with prefix and their descriptions with what, for commands talking to
+// more than one server.
+func addConnectFlags(fs *flag.FlagSet, prefix, what string) *zksync.BackendConfig {
+	cfg := &zksync.BackendConfig{}
+	fs.StringVar(&cfg.Servers, prefix+"servers", "localhost", what+"Zookeeper server list, with an optional /chroot every path is below, e.g. zk1:2181,zk2:2181/apps/myapp, srv:<name> for the targets of a DNS SRV record, or exhibitor:<host:port> or an http(s) URL for the servers an Exhibitor or JSON list gives")
+	fs.DurationVar(&cfg.DiscoveryRefresh, prefix+"discovery-refresh", 5*time.Minute, what+"How old the lookup of srv:, exhibitor: or URL servers can get before reconnecting looks them up again")
+	addAuthFlags(fs, cfg, prefix, what)
+	fs.StringVar(&cfg.Kind, prefix+"backend", "zookeeper", what+"Config store: zookeeper, etcd or consul")
+	fs.StringVar(&cfg.Datacenter, prefix+"datacenter", "", what+"Consul datacenter, defaults to the agent's")
+	fs.BoolVar(&cfg.TLS.Enabled, prefix+"tls", false, what+"Connect over TLS?")
+	fs.StringVar(&cfg.TLS.CertFile, prefix+"tls-cert", "", what+"Client certificate file for TLS, implies -tls")
+	fs.StringVar(&cfg.TLS.KeyFile, prefix+"tls-key", "", what+"Client key file for TLS, implies -tls")
+	fs.StringVar(&cfg.TLS.CAFile, prefix+"tls-ca", "", what+"CA file to verify the server with instead of the system CAs, implies -tls")
+	fs.BoolVar(&cfg.SASL.Enabled, prefix+"sasl", false, what+"Authenticate to Zookeeper with Kerberos, using the ticket cache unless -sasl-keytab is given?")
+	fs.StringVar(&cfg.SASL.Principal, prefix+"sasl-principal", "", what+"Kerberos principal to log in as with -sasl-keytab")
+	fs.StringVar(&cfg.SASL.Keytab, prefix+"sasl-keytab", "", what+"Keytab file to log in with, implies -sasl")
+	fs.StringVar(&cfg.SASL.CCache, prefix+"sasl-ccache", "", what+"Kerberos ticket cache, defaults to $KRB5CCNAME")
+	fs.StringVar(&cfg.SASL.KRB5Conf, prefix+"krb5-conf", "", what+"Kerberos configuration, defaults to $KRB5_CONFIG or /etc/krb5.conf")
+	fs.StringVar(&cfg.SASL.Service, prefix+"sasl-service", "zookeeper", what+"Service name in the Zookeeper server principals")
+	fs.IntVar(&cfg.Retry.Attempts, prefix+"attempts", 5, what+"How many times to try Zookeeper operations that fail on a lost connection or session")
+	fs.DurationVar(&cfg.Retry.Backoff, prefix+"retry-backoff", 100*time.Millisecond, what+"Wait before the first retry, doubled for every one after it")
+	fs.DurationVar(&cfg.Retry.MaxBackoff, prefix+"retry-max-backoff", 10*time.Second, what+"Longest wait between retries")
+	fs.Float64Var(&cfg.RateLimit.OpsPerSec, prefix+"max-ops-per-sec", 0, what+"Send at most this many Zookeeper operations a second, 0 for no limit")
+	fs.IntVar(&cfg.RateLimit.BytesPerSec, prefix+"max-bytes-per-sec", 0, what+"Read and write at most this many bytes of node data a second, 0 for no limit")
+	fs.BoolVar(&cfg.ReadOnly, prefix+"read-only", false, what+"Refuse to change anything on the server, whatever the command?")
+	fs.BoolVar(&cfg.ReadOnlySession, prefix+"read-only-session", false, what+"Also connect to Zookeeper servers cut off from the quorum, which only serve reads? Implies -read-only")
+	return cfg
+}
+
+// treeFlags are the roots of the two trees being synced.
+type treeFlags struct {
+	serverPrefix string
+	localPrefix  string
+}
+
+func addTreeFlags(fs *flag.FlagSet) *treeFlags {
+	t := &treeFlags{}
+	fs.StringVar(&t.serverPrefix, "server_prefix", "/discodev", "Server prefix for config")
+	fs.StringVar(&t.localPrefix, "local_prefix", "/", "Local prefix for config")
+	return t
+}
+
+// treesFlags say which trees a command working on several at once, over
+// one session, is to sync.
+type treesFlags struct {
+	serverPrefixes stringList
+	localPrefixes  stringList
+	mapFile        string
+}
+
+// treePair is a local dir and the remote path it is synced with.
+type treePair struct {
+	localPrefix  string
+	serverPrefix string
+}
+
+func addTreesFlags(fs *flag.FlagSet) *treesFlags {
+	t := &treesFlags{}
+	fs.Var(&t.serverPrefixes, "server_prefix", "Server prefix for config, /discodev if not given; repeat, with a -local_prefix each, for several trees")
+	fs.Var(&t.localPrefixes, "local_prefix", "Local prefix for config, / if not given; repeat, with a -server_prefix each, for several trees")
+	fs.StringVar(&t.mapFile, "map", "", "File of local_prefix server_prefix pairs, one per line, instead of the prefix flags")
+	return t
+}
+
+// pairs returns the trees to sync, in the order given.
+func (t *treesFlags) pairs() ([]treePair, error) {
+	if t.mapFile != "" {
+		if len(t.serverPrefixes) > 0 || len(t.localPrefixes) > 0 {
+			return nil, fmt.Errorf("-map replaces -server_prefix and -local_prefix")
+		}
+		return readTreeMap(t.mapFile)
+	}
+
+	servers, locals := []string(t.serverPrefixes), []string(t.localPrefixes)
+	if len(servers) == 0 {
+		servers = []string{"/discodev"}
+	}
+	if len(locals) == 0 {
+		locals = []string{"/"}
+	}
+	if len(servers) != len(locals) {
+		return nil, fmt.Errorf("%d -server_prefix for %d -local_prefix, they go in pairs", len(servers), len(locals))
+	}
+	pairs := make([]treePair, len(servers))
+	for i := range servers {
+		pairs[i] = treePair{localPrefix: locals[i], serverPrefix: servers[i]}
+	}
+	return pairs, nil
+}
+
+// readTreeMap reads a -map file, where blank lines and those starting with
+// # are skipped.
+func readTreeMap(file string) ([]treePair, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var pairs []treePair
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want a local dir and a server path", file, i+1)
+		}
+		pairs = append(pairs, treePair{localPrefix: fields[0], serverPrefix: fields[1]})
+	}
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("%s maps no trees", file)
+	}
+	return pairs, nil
+}
+
+// applyFlags tune how changes are applied.
+type applyFlags struct {
+	dryRun      bool
+	concurrency int
+	chunkSize   int
+	maxNodeSize int
+	warnSize    bool
+	compress    bool
+	binary      string
+	encodeBin   bool
+	plainEmpty  bool
+	progress    bool
+	trash       bool
+	trashRoot   string
+	trashKeep   time.Duration
+	history     bool
+	historyRoot string
+	historyKeep int
+	quotas      bool
+	quotaNode   string
+	minDepth    int
+	forceDelete bool
+}
+
+func addApplyFlags(fs *flag.FlagSet) *applyFlags {
+	a := &applyFlags{}
+	fs.BoolVar(&a.dryRun, "dry-run", false, "Only print the changes that would be made?")
+	fs.IntVar(&a.concurrency, "concurrency", 1, "How many changes to apply at once")
+	fs.BoolVar(&a.compress, "compress", false, "Gzip files before uploading them? Downloads decompress them either way")
+	fs.StringVar(&a.binary, "binary-policy", string(zksync.BinaryAllow), "What to do with binary local files: allow them, skip them, or error")
+	fs.BoolVar(&a.encodeBin, "encode-binary", false, "Store binary files base64 encoded, behind a marker, for readers expecting text? Downloads decode them either way")
+	fs.BoolVar(&a.plainEmpty, "plain-empty", false, "Store empty files as nodes with no data rather than as a short marker, for readers of the nodes that do not expect it? They then come back down as dirs")
+	fs.IntVar(&a.chunkSize, "chunk-size", zksync.DefaultChunkSize, "Split files larger than this many bytes across chunk nodes, 0 to never split")
+	fs.IntVar(&a.maxNodeSize, "max-node-size", zksync.DefaultMaxNodeSize, "Refuse to write nodes with more data than this many bytes, listing them all before changing anything, 0 for no limit")
+	fs.BoolVar(&a.warnSize, "warn-oversize", false, "Only warn about nodes over -max-node-size, leaving it to the server to refuse them?")
+	fs.BoolVar(&a.progress, "progress", false, "Show the changes and bytes done so far, and an ETA, while applying?")
+	fs.BoolVar(&a.trash, "trash", false, "Move deleted nodes to the trash, for the trash command to restore, instead of deleting them for good?")
+	fs.StringVar(&a.trashRoot, "trash-root", zksync.DefaultTrashRoot, "Where the trash is kept")
+	fs.DurationVar(&a.trashKeep, "trash-keep", 7*24*time.Hour, "How long the trash keeps what was deleted, 0 for until purged")
+	fs.BoolVar(&a.history, "history", false, "Keep the version of every file overwritten or deleted, for the revert command to put back?")
+	fs.StringVar(&a.historyRoot, "history-root", zksync.DefaultHistoryRoot, "Where the history is kept")
+	fs.IntVar(&a.historyKeep, "history-keep", 10, "How many versions of a file the history keeps, 0 for all")
+	fs.BoolVar(&a.quotas, "quotas", false, "Refuse runs that would take a tree over its budget, as the quota command sets them?")
+	fs.StringVar(&a.quotaNode, "quota-node", zksync.DefaultQuotaNode, "Node holding the budgets")
+	fs.IntVar(&a.minDepth, "min-delete-depth", 1, "Refuse to delete or prune paths with fewer names than this, 1 only protecting the root")
+	fs.BoolVar(&a.forceDelete, "force-delete", false, "Delete and prune even the root, /zookeeper and paths shallower than -min-delete-depth?")
+	return a
+}
+
+// filterFlags pick the paths an operation works on.
+type filterFlags struct {
+	includes stringList
+	excludes stringList
+}
+
+func addFilterFlags(fs *flag.FlagSet) *filterFlags {
+	f := &filterFlags{}
+	fs.Var(&f.includes, "include", "Only sync files matching this glob, or regexp when prefixed with re:; repeatable")
+	fs.Var(&f.excludes, "exclude", "Skip paths matching this glob, or regexp when prefixed with re:; repeatable")
+	return f
+}
+
+func (f *filterFlags) filter() (*zksync.Filter, error) {
+	return zksync.NewFilter(f.includes, f.excludes)
+}
+
+// addCheckVersionFlag registers -check-version, for commands writing to the
+// server.
+func addCheckVersionFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("check-version", false, "Check no node to be written changed since it was read before writing any, and stop at the first one changed while writing?")
+}
+
+// addSymlinksFlag registers -symlinks, for commands walking the local tree.
+func addSymlinksFlag(fs *flag.FlagSet) *string {
+	return fs.String("symlinks", string(zksync.SymlinksSkip), "What to do with local symlinks: skip them, follow them to upload what they point at, or error")
+}
+
+// namesFlags say how local file names map to node names.
+type namesFlags struct {
+	escape   bool
+	local    string
+	foldCase bool
+	exts     stringList
+}
+
+// addNamesFlags registers the flags mapping names, for commands working on
+// local trees.
+func addNamesFlags(fs *flag.FlagSet) *namesFlags {
+	n := &namesFlags{}
+	fs.BoolVar(&n.escape, "escape-names", false, "Escape the characters node names cannot hold in local names, and those in -escape-chars in node names, as %XX, so that any name round-trips?")
+	fs.StringVar(&n.local, "escape-chars", zksync.DefaultLocalChars, "Characters local file names cannot hold, escaped by -escape-names")
+	fs.BoolVar(&n.foldCase, "fold-case", runtime.GOOS == "windows" || runtime.GOOS == "darwin", "Refuse nodes whose names differ only in case, as the same local file on case-insensitive file systems?")
+	fs.Var(&n.exts, "strip-ext", "Extension local files have and their nodes do not, e.g. .json, or pattern=.ext for the files matching a pattern, e.g. 'conf/*=.yaml'; repeatable, the first matching a node deciding")
+	return n
+}
+
+// mapping returns the name mapping the flags ask for, nil for none.
+func (n *namesFlags) mapping() (*zksync.NameMap, error) {
+	if !n.escape && !n.foldCase && len(n.exts) == 0 {
+		return nil, nil
+	}
+	m := &zksync.NameMap{Escape: n.escape, Local: n.local, FoldCase: n.foldCase}
+	for _, s := range n.exts {
+		rule, err := zksync.ParseExtRule(s)
+		if err != nil {
+			return nil, err
+		}
+		m.Exts = append(m.Exts, rule)
+	}
+	return m, nil
+}
+
+// addEphemeralFlag registers -ephemeral, for commands copying remote trees
+// to disk.
+func addEphemeralFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("ephemeral", false, "Copy ephemeral nodes, such as service registrations, which are skipped otherwise?")
+}
+
+// aclFlags say which ACLs nodes should have.
+type aclFlags struct {
+	acl      string
+	manifest string
+	metadata string
+}
+
+func addACLFlags(fs *flag.FlagSet) *aclFlags {
+	a := &aclFlags{}
+	fs.StringVar(&a.acl, "acl", "", "ACL for created nodes, e.g. world:anyone:r,digest:user:hash:crwda")
+	fs.StringVar(&a.manifest, "acl-file", "", "File of pattern and ACL pairs, overriding -acl for the paths they match")
+	fs.StringVar(&a.metadata, "acl-metadata", "", "Metadata file written by download -metadata, giving every node it lists the ACL it had, over -acl and -acl-file")
+	return a
+}
+
+// policy returns the ACLs asked for, or nil for the backend default.
+func (a *aclFlags) policy() (*zksync.ACLPolicy, error) {
+	if a.acl == "" && a.manifest == "" && a.metadata == "" {
+		return nil, nil
+	}
+	p := &zksync.ACLPolicy{}
+	if a.acl != "" {
+		acl, err := zksync.ParseACL(a.acl)
+		if err != nil {
+			return nil, err
+		}
+		p.Default = acl
+	}
+	if a.manifest != "" {
+		if err := p.LoadACLManifest(a.manifest); err != nil {
+			return nil, err
+		}
+	}
+	if a.metadata != "" {
+		if err := p.LoadMetadata(a.metadata); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// redactFlags say which files downloads keep the data of out.
+type redactFlags struct {
+	patterns    stringList
+	placeholder string
+	skip        bool
+}
+
+func addRedactFlags(fs *flag.FlagSet) *redactFlags {
+	r := &redactFlags{}
+	fs.Var(&r.patterns, "redact", "Write files whose path matches this glob, or regexp when prefixed with re:, with -redact-placeholder instead of their data, and have uploads skip them; repeatable")
+	fs.StringVar(&r.placeholder, "redact-placeholder", zksync.DefaultPlaceholder, "What redacted files hold instead of their data")
+	fs.BoolVar(&r.skip, "redact-skip", false, "Leave redacted files out instead of writing the placeholder?")
+	return r
+}
+
+// redaction returns the Redaction asked for, nil if none.
+func (r *redactFlags) redaction() (*zksync.Redaction, error) {
+	if len(r.patterns) == 0 {
+		return nil, nil
+	}
+	return zksync.NewRedaction(r.patterns, r.placeholder+"\n", r.skip)
+}
+
+// templateFlags say how to render files before uploading them.
+type templateFlags struct {
+	syntax string
+	sets   stringList
+	values string
+}
+
+func addTemplateFlags(fs *flag.FlagSet) *templateFlags {
+	t := &templateFlags{}
+	fs.StringVar(&t.syntax, "template", "", "Render files before uploading: go for text/template, env for ${VAR} expansion")
+	fs.Var(&t.sets, "set", "Template value as key=value, dotted keys reaching into maps; repeatable")
+	fs.StringVar(&t.values, "values", "", "YAML or JSON file of template values, overridden by -set")
+	return t
+}
+
+// template returns the template asked for, or nil to upload files as they
+// are.
+func (t *templateFlags) template() (*zksync.Template, error) {
+	if t.syntax == "" {
+		if len(t.sets) > 0 || t.values != "" {
+			return nil, fmt.Errorf("-set and -values need -template")
+		}
+		return nil, nil
+	}
+	syntax, err := zksync.ParseTemplateSyntax(t.syntax)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &zksync.Template{Syntax: syntax}
+	for _, kv := range t.sets {
+		if err := tmpl.Set(kv); err != nil {
+			return nil, err
+		}
+	}
+	if t.values != "" {
+		if err := tmpl.LoadValues(t.values); err != nil {
+			return nil, err
+		}
+	}
+	return tmpl, nil
+}
+
+// normalizeFlags say how to normalize files before uploading them.
+type normalizeFlags struct {
+	lineEndings bool
+	utf8        bool
+	skip        stringList
+}
+
+func addNormalizeFlags(fs *flag.FlagSet) *normalizeFlags {
+	n := &normalizeFlags{}
+	fs.BoolVar(&n.lineEndings, "normalize-eol", false, "Turn CRLF line endings into LF before uploading or diffing text files?")
+	fs.BoolVar(&n.utf8, "require-utf8", false, "Refuse to upload files that are not valid UTF-8?")
+	fs.Var(&n.skip, "normalize-skip", "Leave files matching this glob, or regexp when prefixed with re:, as they are despite -normalize-eol and -require-utf8; repeatable")
+	return n
+}
+
+// normalizer returns the Normalizer asked for, or nil to upload files as
+// they are.
+func (n *normalizeFlags) normalizer() (*zksync.Normalizer, error) {
+	if !n.lineEndings && !n.utf8 {
+		if len(n.skip) > 0 {
+			return nil, fmt.Errorf("-normalize-skip needs -normalize-eol or -require-utf8")
+		}
+		return nil, nil
+	}
+	return zksync.NewNormalizer(n.lineEndings, n.utf8, n.skip)
+}
+
+// propertiesFlag picks the Java properties files stored a node per key.
+type propertiesFlag struct {
+	patterns stringList
+}
+
+func addPropertiesFlag(fs *flag.FlagSet) *propertiesFlag {
+	pr := &propertiesFlag{}
+	fs.Var(&pr.patterns, "properties", "Java properties files matching this glob, or regexp when prefixed with re:, are stored as a dir holding a node per key, which downloads join back and comparisons compare key by key; repeatable")
+	return pr
+}
+
+// properties returns the Properties asked for, nil if none.
+func (pr *propertiesFlag) properties() (*zksync.Properties, error) {
+	if len(pr.patterns) == 0 {
+		return nil, nil
+	}
+	return zksync.NewProperties(pr.patterns)
+}
+
+// overlayFlags lay trees over -local_prefix before it is uploaded or
+// compared.
+type overlayFlags struct {
+	dirs stringList
+}
+
+func addOverlayFlags(fs *flag.FlagSet) *overlayFlags {
+	o := &overlayFlags{}
+	fs.Var(&o.dirs, "overlay", "Lay this tree over -local_prefix, its files replacing those at the same paths, or patching them with a JSON merge patch if named after them plus "+zksync.PatchSuffix+"; repeatable, later ones laid over earlier ones")
+	return o
+}
+
+// check says whether the overlays can go with pairs.
+func (o *overlayFlags) check(pairs []treePair) error {
+	if len(o.dirs) > 0 && len(pairs) > 1 {
+		return fmt.Errorf("-overlay applies to a single -local_prefix")
+	}
+	return nil
+}
+
+// merge returns pairs with the local tree replaced by a temporary one, the
+// overlays laid over it, and a func removing it. Without overlays, pairs
+// are returned as they are.
+func (o *overlayFlags) merge(pairs []treePair) ([]treePair, func(), error) {
+	if len(o.dirs) == 0 {
+		return pairs, func() {}, nil
+	}
+	tmp, err := ioutil.TempDir("", "configurator-overlay-")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanUp := func() { os.RemoveAll(tmp) }
+	if err := zksync.MergeOverlays(pairs[0].localPrefix, o.dirs, tmp); err != nil {
+		cleanUp()
+		return nil, nil, err
+	}
+	slog.Debug("Merged overlays", "base", pairs[0].localPrefix, "overlays", []string(o.dirs), "tree", tmp)
+	return []treePair{{localPrefix: tmp, serverPrefix: pairs[0].serverPrefix}}, cleanUp, nil
+}
+
+// validateFlags say how to check files before uploading them.
+type validateFlags struct {
+	syntax     bool
+	validators stringList
+	schemas    string
+}
+
+func addValidateFlags(fs *flag.FlagSet) *validateFlags {
+	v := &validateFlags{}
+	fs.BoolVar(&v.syntax, "validate", false, "Check the syntax of .json, .yaml, .yml, .toml and .xml files, uploading nothing if one is malformed?")
+	fs.Var(&v.validators, "validator", "Check files matching a glob with a command as pattern=command, getting the file on stdin; repeatable")
+	fs.StringVar(&v.schemas, "schemas", "", "File of pattern and JSON Schema file pairs, checking the files matching each pattern against its schema")
+	return v
+}
+
+// validator returns the checks asked for, or nil to upload files unchecked.
+func (v *validateFlags) validator() (*zksync.Validator, error) {
+	if !v.syntax && len(v.validators) == 0 && v.schemas == "" {
+		return nil, nil
+	}
+	val := &zksync.Validator{Syntax: v.syntax}
+	for _, spec := range v.validators {
+		if err := val.AddCommand(spec); err != nil {
+			return nil, err
+		}
+	}
+	if v.schemas != "" {
+		if err := val.LoadSchemaManifest(v.schemas); err != nil {
+			return nil, err
+		}
+	}
+	return val, nil
+}
+
+// logFlags say what gets logged, and how. Every command has them.
+type logFlags struct {
+	level  string
+	format string
+}
+
+var logging logFlags
+
+// timeout bounds how long a command runs, 0 for no bound.
+var timeout time.Duration
+
+// addCommonFlags registers the flags every command has.
+func addCommonFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&timeout, "timeout", 0, "Give up after this long, reporting what was and was not done; 0 for no limit")
+	fs.StringVar(&logging.level, "log-level", "info", "Least severe messages to log: debug, info, warn or error")
+	fs.StringVar(&logging.format, "log-format", "text", "Log format: text, or json for log pipelines")
+	fs.StringVar(&outputFormat, "output", "text", "Result format on stdout: text, or json or yaml for a report of every change")
+	fs.StringVar(&profiles.file, "config", defaultConfigFile(), "YAML file of named profiles giving flag values")
+	fs.StringVar(&profiles.name, "profile", "", "Profile in -config to take flag values from, overridden by flags given; its default profile if empty")
+}
+
+// setup makes the default slog logger log as the flags say, to stderr.
+func (l *logFlags) setup() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(l.level)); err != nil {
+		return fmt.Errorf("bad log level %q, want debug, info, warn or error", l.level)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch l.format {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		return fmt.Errorf("bad log format %q, want text or json", l.format)
+	}
+	return nil
+}
+
+// parseFlags parses the flags of a command, which takes no positional
+// arguments unless withArgs is set. The exit code is only meaningful when
+// ok is false.
+func parseFlags(fs *flag.FlagSet, args []string, withArgs bool) (code int, ok bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	if err := applyProfile(fs); err != nil {
+		slog.Error("Invalid profile", "err", err)
+		return exitUsage, false
+	}
+	if err := logging.setup(); err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage, false
+	}
+	if err := checkOutputFormat(); err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage, false
+	}
+	slog.Debug("Running", "command", fs.Name(), "args", strings.Join(redactArgs(args), " "))
+	if !withArgs && fs.NArg() > 0 {
+		slog.Error("Unexpected arguments", "args", strings.Join(redactArgs(fs.Args()), " "))
+		fs.Usage()
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// splitAction takes the action of a command with several out of args,
+// after any flags given before it, leaving those parsed and returning the
+// args that follow it for parseFlags. -h prints the usage as it does for
+// other commands.
+func splitAction(fs *flag.FlagSet, args []string) (action string, rest []string, code int, ok bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return "", nil, exitOK, false
+		}
+		return "", nil, exitUsage, false
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return "", nil, exitUsage, false
+	}
+	return fs.Arg(0), fs.Args()[1:], exitOK, true
+}
+
+// withClient connects to the server cfg points at and runs f with a context
+// that is cancelled on SIGINT or SIGTERM, or after -timeout.
+func withClient(cfg *zksync.BackendConfig, f func(ctx context.Context, client *zksync.Client) int) int {
+	enc, err := encryption.encryption()
+	if err != nil {
+		slog.Error("Invalid encryption key", "err", err)
+		return exitUsage
+	}
+	if err := ensemble.ensure(cfg); err != nil {
+		slog.Error("Not connecting", "servers", cfg.Servers, "err", err)
+		return exitCode(err)
+	}
+	b, err := zksync.Open(*cfg)
+	if err != nil {
+		slog.Error("Could not connect", "servers", cfg.Servers, "err", err)
+		return exitCode(err)
+	}
+	defer b.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	client := zksync.New(b)
+	client.Encryption = enc
+	client.Audit = audit.audit()
+	defer client.Audit.Close()
+	return f(ctx, client)
+}
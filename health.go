@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// health tracks what /healthz and /readyz report: whether the session is
+// there, and how the last sync went.
+type health struct {
+	// grace is how long the session may be gone before the process is
+	// reported unhealthy, to ride out the reconnects the client does on its
+	// own.
+	grace time.Duration
+
+	mu       sync.Mutex
+	session  string    // the last ZooKeeper state, empty for other backends
+	lostAt   time.Time // when the session was lost, zero while there is one
+	synced   bool      // the initial sync has been done
+	failed   bool      // the last batch had errors
+	lastSync time.Time // when a batch last went through without errors
+}
+
+// healthStatus is the body of /healthz and /readyz.
+type healthStatus struct {
+	OK           bool    `json:"ok"`
+	Reason       string  `json:"reason,omitempty"`
+	Session      string  `json:"session,omitempty"`
+	LastSync     string  `json:"last_sync,omitempty"`
+	LastSyncAge  float64 `json:"last_sync_age_seconds,omitempty"`
+	LastSyncFail bool    `json:"last_sync_failed"`
+}
+
+func (h *health) sessionEvent(state string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.session = state
+	switch {
+	case state == "StateHasSession":
+		h.lostAt = time.Time{}
+	case h.lostAt.IsZero():
+		h.lostAt = time.Now()
+	}
+}
+
+// observe returns a watch hook recording how every batch went before
+// handing it on to next, if not nil.
+func (h *health) observe(next func(zksync.Plan, []error, time.Duration)) func(zksync.Plan, []error, time.Duration) {
+	return func(applied zksync.Plan, errs []error, took time.Duration) {
+		h.mu.Lock()
+		h.synced = true
+		h.failed = len(errs) > 0
+		if !h.failed {
+			h.lastSync = time.Now()
+		}
+		h.mu.Unlock()
+		if next != nil {
+			next(applied, errs, took)
+		}
+	}
+}
+
+func (h *health) status() healthStatus {
+	st := healthStatus{Session: h.session, LastSyncFail: h.failed}
+	if !h.lastSync.IsZero() {
+		st.LastSync = h.lastSync.UTC().Format(time.RFC3339)
+		st.LastSyncAge = time.Since(h.lastSync).Seconds()
+	}
+	return st
+}
+
+// dead returns why the process is no longer alive, empty while it is: the
+// session has been gone for longer than grace. h.mu must be held.
+func (h *health) dead() string {
+	if !h.lostAt.IsZero() && time.Since(h.lostAt) > h.grace {
+		return "no session since " + h.lostAt.UTC().Format(time.RFC3339)
+	}
+	return ""
+}
+
+// live fails once the session has been gone for longer than grace.
+func (h *health) live(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	st := h.status()
+	st.Reason = h.dead()
+	h.mu.Unlock()
+	writeHealth(w, st)
+}
+
+// ready fails until the initial sync is done, while there is no session
+// and while the last batch of changes has errors.
+func (h *health) ready(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	st := h.status()
+	switch {
+	case !h.synced:
+		st.Reason = "initial sync not done"
+	case !h.lostAt.IsZero():
+		st.Reason = "no session"
+	case h.failed:
+		st.Reason = "last sync failed"
+	}
+	h.mu.Unlock()
+	writeHealth(w, st)
+}
+
+func writeHealth(w http.ResponseWriter, st healthStatus) {
+	st.OK = st.Reason == ""
+	w.Header().Set("Content-Type", "application/json")
+	if !st.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(st)
+}
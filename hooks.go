@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// hookFlags are shell commands run around the changes a command makes to
+// each tree, with the changes described in their environment.
+type hookFlags struct {
+	pre  string
+	post string
+}
+
+func addHookFlags(fs *flag.FlagSet) *hookFlags {
+	h := &hookFlags{}
+	fs.StringVar(&h.pre, "pre-hook", "", "Shell command run once the changes to a tree are planned and before any is made; the run stops if it fails")
+	fs.StringVar(&h.post, "post-hook", "", "Shell command run after the changes to a tree are made")
+	return h
+}
+
+// around has do make the changes of command to t, with the hooks run
+// around them. Neither runs when there is nothing to change, nor on dry
+// runs. A failing post-hook is only logged, as the changes were made.
+func (h *hookFlags) around(ctx context.Context, command string, t treePair, opts zksync.Options, do func(zksync.Options) (*zksync.Result, error)) (*zksync.Result, error) {
+	if h.pre != "" {
+		earlier := opts.BeforeApply
+		opts.BeforeApply = func(p zksync.Plan) error {
+			if earlier != nil {
+				if err := earlier(p); err != nil {
+					return err
+				}
+			}
+			// nothing has failed yet, so the counts are those planned
+			rep := (&zksync.Result{Plan: p}).Report(false)
+			if err := h.run(ctx, "pre-hook", h.pre, command, t, rep); err != nil {
+				return fmt.Errorf("pre-hook: %w", err)
+			}
+			return nil
+		}
+	}
+	res, err := do(opts)
+	if err != nil || h.post == "" || opts.DryRun || len(res.Plan) == 0 {
+		return res, err
+	}
+	if err := h.run(ctx, "post-hook", h.post, command, t, res.Report(false)); err != nil {
+		slog.Error("Post-hook failed", "command", h.post, "err", err)
+	}
+	return res, nil
+}
+
+// run runs the hook command, with the changes of rep that went through, or
+// are about to, listed in the file CONFIGURATOR_CHANGES names, one
+// "action path" per line.
+func (h *hookFlags) run(ctx context.Context, name, hook, command string, t treePair, rep zksync.Report) error {
+	changes, err := ioutil.TempFile("", "configurator-changes-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(changes.Name())
+	for _, c := range rep.Changes {
+		if c.Result == zksync.OutcomeApplied {
+			fmt.Fprintf(changes, "%s %s\n", c.Action, c.Path)
+		}
+	}
+	if err := changes.Close(); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", hook)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	cmd.Env = append(os.Environ(),
+		"CONFIGURATOR_HOOK="+name,
+		"CONFIGURATOR_COMMAND="+command,
+		"CONFIGURATOR_LOCAL_PREFIX="+t.localPrefix,
+		"CONFIGURATOR_SERVER_PREFIX="+t.serverPrefix,
+		"CONFIGURATOR_CHANGES="+changes.Name(),
+		"CONFIGURATOR_APPLIED="+strconv.Itoa(rep.Applied),
+		"CONFIGURATOR_CREATED="+strconv.Itoa(rep.Created),
+		"CONFIGURATOR_UPDATED="+strconv.Itoa(rep.Updated),
+		"CONFIGURATOR_DELETED="+strconv.Itoa(rep.Deleted),
+		"CONFIGURATOR_FAILED="+strconv.Itoa(rep.Failed+rep.NotApplied),
+	)
+	slog.Debug("Running hook", "hook", name, "command", hook)
+	return cmd.Run()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// journalFlags say where a command records how far it got, and whether to
+// carry on from there.
+type journalFlags struct {
+	resume bool
+	path   string
+}
+
+func addJournalFlags(fs *flag.FlagSet) *journalFlags {
+	j := &journalFlags{}
+	fs.BoolVar(&j.resume, "resume", false, "Carry on from where the last run of this command on the same trees stopped, without walking the trees again?")
+	fs.StringVar(&j.path, "journal", "", "File recording the changes made so far, for -resume; one per command and -servers in the user cache dir if empty")
+	return j
+}
+
+// open opens the journal of command run against cfg.
+func (j *journalFlags) open(command string, cfg *zksync.BackendConfig) (*zksync.Journal, error) {
+	p := j.path
+	if p == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(dir, "configurator")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256([]byte(command + " " + cfg.Kind + " " + cfg.Servers))
+		p = filepath.Join(dir, "journal-"+hex.EncodeToString(sum[:8]))
+	}
+	return zksync.OpenJournal(p, j.resume)
+}
+
+// run is runTrees recording every tree in the journal of command, or
+// resuming the trees the journal has a plan for with -resume, with hooks
+// run around each. The journal is removed once everything went through.
+// Dry runs are not recorded.
+func (j *journalFlags) run(ctx context.Context, client *zksync.Client, command string, cfg *zksync.BackendConfig, pairs []treePair, opts zksync.Options, hooks *hookFlags, locks *lockFlags, f func(t treePair, opts zksync.Options) (*zksync.Result, error)) (*zksync.Result, error) {
+	if opts.DryRun {
+		return runTrees(pairs, func(t treePair) (*zksync.Result, error) {
+			return f(t, opts)
+		})
+	}
+	journal, err := j.open(command, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("opening journal: %w", err)
+	}
+	res, err := runTrees(pairs, func(t treePair) (*zksync.Result, error) {
+		treeOpts := opts
+		treeOpts.Journal = journal.For(t.localPrefix + " " + t.serverPrefix)
+		return locks.around(ctx, client, t.serverPrefix, false, func() (*zksync.Result, error) {
+			return hooks.around(ctx, command, t, treeOpts, func(opts zksync.Options) (*zksync.Result, error) {
+				if j.resume {
+					if _, ok := opts.Journal.Pending(); ok {
+						slog.Info("Resuming", "local_prefix", t.localPrefix, "server_prefix", t.serverPrefix)
+						return client.Resume(ctx, opts.Journal, opts)
+					}
+					slog.Info("Nothing to resume, starting afresh", "local_prefix", t.localPrefix, "server_prefix", t.serverPrefix)
+				}
+				return f(t, opts)
+			})
+		})
+	})
+	if err == nil && len(res.Failed) == 0 {
+		if err := journal.Remove(); err != nil {
+			slog.Warn("Could not remove journal", "err", err)
+		}
+	} else {
+		journal.Close()
+	}
+	return res, err
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// lockFlags say how long to wait for other runs changing the same tree.
+type lockFlags struct {
+	timeout time.Duration
+	force   bool
+}
+
+func addLockFlags(fs *flag.FlagSet) *lockFlags {
+	l := &lockFlags{}
+	fs.DurationVar(&l.timeout, "lock-timeout", time.Minute, "How long to wait for another run changing the same tree to finish")
+	fs.BoolVar(&l.force, "force", false, "Change the tree without taking its lock, even if another run holds it?")
+	return l
+}
+
+// around runs do with the lock on the tree at serverPrefix held, so that no
+// other run changes it meanwhile. Dry runs change nothing and do not lock.
+func (l *lockFlags) around(ctx context.Context, client *zksync.Client, serverPrefix string, dryRun bool, do func() (*zksync.Result, error)) (*zksync.Result, error) {
+	if l == nil || l.force || dryRun {
+		return do()
+	}
+	lockCtx, cancel := context.WithTimeout(ctx, l.timeout)
+	unlock, err := client.Lock(lockCtx, serverPrefix)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return do()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/edevil/configurator/zksync"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics are served on /metrics while watching, to alert on a sync that
+// is stuck or failing.
+type metrics struct {
+	reg      *prometheus.Registry
+	ops      *prometheus.CounterVec
+	bytes    prometheus.Counter
+	errors   prometheus.Counter
+	sessions *prometheus.CounterVec
+	latency  prometheus.Histogram
+	lastSync prometheus.Gauge
+
+	// set by enableDrift
+	drift       *prometheus.GaugeVec
+	driftChecks *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{reg: prometheus.NewRegistry()}
+	m.ops = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "configurator_ops_applied_total",
+		Help: "Changes applied, by kind.",
+	}, []string{"kind"})
+	m.bytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "configurator_bytes_transferred_total",
+		Help: "Bytes of file data written, locally or remotely.",
+	})
+	m.errors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "configurator_errors_total",
+		Help: "Changes that failed to be planned or applied.",
+	})
+	m.sessions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "configurator_session_events_total",
+		Help: "ZooKeeper connection and session state changes, by new state.",
+	}, []string{"state"})
+	m.latency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "configurator_sync_duration_seconds",
+		Help:    "Time taken to apply each batch of changes.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+	})
+	m.lastSync = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "configurator_last_sync_timestamp_seconds",
+		Help: "When a batch of changes was last applied without errors.",
+	})
+	m.reg.MustRegister(m.ops, m.bytes, m.errors, m.sessions, m.latency, m.lastSync,
+		prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{})
+}
+
+// sessionEvent counts ZooKeeper state changes.
+func (m *metrics) sessionEvent(state string) {
+	m.sessions.WithLabelValues(state).Inc()
+}
+
+// observe returns a watch hook recording every batch of changes before
+// handing it on to next, if not nil.
+func (m *metrics) observe(next func(zksync.Plan, []error, time.Duration)) func(zksync.Plan, []error, time.Duration) {
+	return func(applied zksync.Plan, errs []error, took time.Duration) {
+		for _, o := range applied {
+			m.ops.WithLabelValues(o.Kind.String()).Inc()
+			m.bytes.Add(float64(len(o.Data)))
+		}
+		m.errors.Add(float64(len(errs)))
+		if took > 0 && len(applied)+len(errs) > 0 {
+			m.latency.Observe(took.Seconds())
+		}
+		if len(errs) == 0 {
+			m.lastSync.SetToCurrentTime()
+		}
+		if next != nil {
+			next(applied, errs, took)
+		}
+	}
+}
+
+// enableDrift adds the metrics of drift checks to those served.
+func (m *metrics) enableDrift() {
+	m.drift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "configurator_drift_paths",
+		Help: "Paths where the remote tree differs from its source of truth at the last check, by tree.",
+	}, []string{"tree"})
+	m.driftChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "configurator_drift_checks_total",
+		Help: "Drift checks run, by tree and result: clean, drift or error.",
+	}, []string{"tree", "result"})
+	m.reg.MustRegister(m.drift, m.driftChecks)
+}
+
+// observeDrift records a drift check of tree finding paths differing, or
+// failing with err.
+func (m *metrics) observeDrift(tree string, paths int, err error) {
+	switch {
+	case err != nil:
+		m.driftChecks.WithLabelValues(tree, "error").Inc()
+		return
+	case paths > 0:
+		m.driftChecks.WithLabelValues(tree, "drift").Inc()
+	default:
+		m.driftChecks.WithLabelValues(tree, "clean").Inc()
+	}
+	m.drift.WithLabelValues(tree).Set(float64(paths))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// signals are those -notify-signal can send, more being added where the
+// platform has them.
+var signals = map[string]os.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+}
+
+// notifier lets the process using the files know they changed, by
+// signalling it or running a command, once changes have settled for a
+// while.
+type notifier struct {
+	pid     int
+	pidFile string
+	signal  string
+	command string
+	delay   time.Duration
+	changed chan struct{}
+}
+
+func addNotifyFlags(fs *flag.FlagSet) *notifier {
+	n := &notifier{changed: make(chan struct{}, 1)}
+	fs.IntVar(&n.pid, "notify-pid", 0, "Signal this process after files change")
+	fs.StringVar(&n.pidFile, "notify-pidfile", "", "Signal the process whose pid is in this file after files change, read each time")
+	fs.StringVar(&n.signal, "notify-signal", "HUP", "Signal to send: HUP, INT, QUIT, TERM, or USR1 and USR2 on Unix")
+	fs.StringVar(&n.command, "notify-exec", "", "Shell command to run after files change, e.g. 'nginx -s reload'")
+	fs.DurationVar(&n.delay, "notify-delay", time.Second, "Quiet period after a change before notifying, so bursts notify once")
+	return n
+}
+
+func (n *notifier) enabled() bool {
+	return n.pid != 0 || n.pidFile != "" || n.command != ""
+}
+
+func (n *notifier) check() error {
+	if _, ok := signals[n.signalName()]; !ok {
+		return fmt.Errorf("unknown signal %s", n.signal)
+	}
+	return nil
+}
+
+func (n *notifier) signalName() string {
+	return strings.TrimPrefix(strings.ToUpper(n.signal), "SIG")
+}
+
+// applied is given to the watch, to hear of every change applied.
+func (n *notifier) applied(p zksync.Plan, _ []error, _ time.Duration) {
+	if len(p) == 0 {
+		return
+	}
+	select {
+	case n.changed <- struct{}{}:
+	default:
+	}
+}
+
+// run notifies after changes until ctx is done.
+func (n *notifier) run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.changed:
+			timer.Reset(n.delay)
+		case <-timer.C:
+			n.notify()
+		}
+	}
+}
+
+func (n *notifier) notify() {
+	pid := n.pid
+	if n.pidFile != "" {
+		data, err := ioutil.ReadFile(n.pidFile)
+		if err == nil {
+			pid, err = strconv.Atoi(strings.TrimSpace(string(data)))
+		}
+		if err != nil {
+			slog.Error("Could not read pid file", "file", n.pidFile, "err", err)
+			pid = 0
+		}
+	}
+	if pid != 0 {
+		proc, err := os.FindProcess(pid)
+		if err == nil {
+			err = proc.Signal(signals[n.signalName()])
+		}
+		if err != nil {
+			slog.Error("Could not signal", "pid", pid, "err", err)
+		} else {
+			slog.Info("Signalled", "pid", pid, "signal", n.signal)
+		}
+	}
+
+	if n.command != "" {
+		cmd := exec.Command("/bin/sh", "-c", n.command)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			slog.Error("Notify command failed", "command", n.command, "err", err)
+		} else {
+			slog.Info("Ran notify command", "command", n.command)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// outputFormat is how results are written to stdout: text for people,
+// json or yaml for programs.
+var outputFormat string
+
+func checkOutputFormat() error {
+	switch outputFormat {
+	case "text", "json", "yaml":
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q, want text, json or yaml", outputFormat)
+}
+
+// structured reports whether results are to be written for programs.
+func structured() bool {
+	return outputFormat != "text"
+}
+
+// writeStructured writes v to stdout as -output says.
+func writeStructured(v interface{}) error {
+	if outputFormat == "yaml" {
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
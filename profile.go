@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// profiles holds what -config and -profile say.
+var profiles struct {
+	file string
+	name string
+}
+
+// profileFile is the layout of the config file: named profiles, each
+// giving flag values by flag name.
+type profileFile struct {
+	// Default is the profile used when -profile is not given.
+	Default  string                             `yaml:"default"`
+	Profiles map[string]map[string]profileValue `yaml:"profiles"`
+}
+
+// profileValue is the value of a flag, a list for repeatable flags.
+type profileValue []string
+
+func (v *profileValue) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.SequenceNode {
+		return n.Decode((*[]string)(v))
+	}
+	var s string
+	if err := n.Decode(&s); err != nil {
+		return err
+	}
+	*v = profileValue{s}
+	return nil
+}
+
+func defaultConfigFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".configurator.yaml")
+}
+
+// applyProfile sets the flags of fs the chosen profile has values for,
+// unless they were given on the command line. Flags the command does not
+// have are skipped, as profiles are shared by all commands.
+func applyProfile(fs *flag.FlagSet) error {
+	file := profiles.file
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) && file == defaultConfigFile() && profiles.name == "" {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	var pf profileFile
+	if err := yaml.Unmarshal(data, &pf); err != nil {
+		return fmt.Errorf("parsing %s: %w", file, err)
+	}
+
+	name := profiles.name
+	if name == "" {
+		name = pf.Default
+	}
+	if name == "" {
+		return nil
+	}
+	profile, ok := pf.Profiles[name]
+	if !ok {
+		return fmt.Errorf("no profile %s in %s", name, file)
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	keys := make([]string, 0, len(profile))
+	for key := range profile {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if given[key] || fs.Lookup(key) == nil {
+			continue
+		}
+		for _, value := range profile[key] {
+			if err := fs.Set(key, value); err != nil {
+				return fmt.Errorf("profile %s: -%s: %w", name, key, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// started is when the command started running, for the summary it ends
+// with.
+var started = time.Now()
+
+// progressInterval is how often progress is logged when stderr is not a
+// terminal that a single line can be redrawn on.
+const progressInterval = 5 * time.Second
+
+// progressBar shows how far applying a plan has got on stderr, as a line
+// redrawn in place on a terminal and as a log line every so often
+// otherwise.
+type progressBar struct {
+	tty bool
+
+	mu    sync.Mutex
+	start time.Time
+	shown time.Time
+	total int
+	line  string // on screen, below whatever was logged
+}
+
+func newProgressBar() *progressBar {
+	fi, err := os.Stderr.Stat()
+	return &progressBar{tty: err == nil && fi.Mode()&os.ModeCharDevice != 0}
+}
+
+func (b *progressBar) update(p zksync.Progress) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if p.Done == 1 || p.Total != b.total {
+		// a new plan, as several trees or watched batches each have one
+		b.start, b.total = now, p.Total
+	}
+	last := p.Done == p.Total
+	interval := progressInterval
+	if b.tty {
+		interval = 100 * time.Millisecond
+	}
+	if !last && now.Sub(b.shown) < interval {
+		return
+	}
+	b.shown = now
+
+	eta := "-"
+	if elapsed := now.Sub(b.start); !last {
+		eta = (elapsed * time.Duration(p.Total-p.Done) / time.Duration(p.Done)).Round(time.Second).String()
+	}
+	if !b.tty {
+		slog.Info("Progress", "done", p.Done, "total", p.Total, "bytes", p.Bytes, "total_bytes", p.TotalBytes, "eta", eta)
+		return
+	}
+	b.line = fmt.Sprintf("%d/%d changes  %s/%s  ETA %s", p.Done, p.Total, formatBytes(p.Bytes), formatBytes(p.TotalBytes), eta)
+	fmt.Fprint(os.Stderr, "\r\033[K"+b.line)
+	if last {
+		fmt.Fprintln(os.Stderr)
+		b.line = ""
+	}
+}
+
+// progressHandler keeps log lines from being written over the progress
+// line, clearing it before each one and drawing it again after.
+type progressHandler struct {
+	slog.Handler
+	bar *progressBar
+}
+
+func (h progressHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.bar.mu.Lock()
+	defer h.bar.mu.Unlock()
+	if h.bar.line != "" {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+	err := h.Handler.Handle(ctx, r)
+	if h.bar.line != "" {
+		fmt.Fprint(os.Stderr, h.bar.line)
+	}
+	return err
+}
+
+func (h progressHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return progressHandler{h.Handler.WithAttrs(attrs), h.bar}
+}
+
+func (h progressHandler) WithGroup(name string) slog.Handler {
+	return progressHandler{h.Handler.WithGroup(name), h.bar}
+}
+
+// showProgress has opts report progress on stderr.
+func showProgress(opts *zksync.Options) {
+	bar := newProgressBar()
+	if bar.tty {
+		slog.SetDefault(slog.New(progressHandler{slog.Default().Handler(), bar}))
+	}
+	opts.OnProgress = bar.update
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
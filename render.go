@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+func runRender(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	serverPrefix := fs.String("server_prefix", "/discodev", "Server prefix of the tree the templates read, that the prefixes of -confdir resources are below")
+	confdir := fs.String("confdir", "", "Render the template resources of confd in the conf.d dir of this one, with their templates in its templates dir, instead of the targets given")
+	mode := fs.String("mode", "0644", "Permissions of the files written, in octal")
+	dryRun := fs.Bool("dry-run", false, "Only print the files that would be written?")
+	filters := addFilterFlags(fs)
+	secrets := addVaultFlags(fs)
+	watch := fs.Bool("watch", false, "Keep rendering the files again as the tree changes until stopped?")
+	notify := addNotifyFlags(fs)
+	daemon := addDaemonFlags(fs)
+	if code, ok := parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if (fs.NArg() == 0) == (*confdir == "") {
+		fs.Usage()
+		return exitUsage
+	}
+
+	var opts zksync.Options
+	groups, err := renderTargets(fs.Args(), *confdir, *mode, *serverPrefix)
+	if err == nil {
+		opts.Filter, err = filters.filter()
+	}
+	if err == nil {
+		opts.Vault, err = secrets.vault()
+	}
+	if err == nil && !*watch && (notify.enabled() || daemon.enabled()) {
+		err = fmt.Errorf("-notify-* and -http-addr only apply to -watch")
+	}
+	if err == nil {
+		err = notify.check()
+	}
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	opts.DryRun = *dryRun
+	if notify.enabled() {
+		opts.OnApplied = notify.applied
+	}
+	if daemon.enabled() {
+		if err := daemon.serve(); err != nil {
+			slog.Error("Could not serve HTTP", "addr", daemon.addr, "err", err)
+			return exitError
+		}
+		opts.OnApplied = daemon.observe(opts.OnApplied)
+		cfg.OnSessionEvent = daemon.sessionEvent
+	}
+	var sigs *daemonSignals
+	if *watch {
+		sigs = newDaemonSignals()
+		opts.OnApplied = sigs.observe(opts.OnApplied)
+		cfg.OnSessionEvent = sigs.sessionEvent(cfg.OnSessionEvent)
+	}
+	sd := newSystemd(daemon.health.grace)
+	if *watch && sd.enabled() {
+		opts.OnApplied = sd.observe(opts.OnApplied)
+		cfg.OnSessionEvent = sd.sessionEvent(cfg.OnSessionEvent)
+	}
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		var pairs []treePair
+		for prefix := range groups {
+			pairs = append(pairs, treePair{serverPrefix: prefix})
+		}
+		if !*watch {
+			res, err := runTrees(pairs, func(t treePair) (*zksync.Result, error) {
+				return client.Render(ctx, t.serverPrefix, groups[t.serverPrefix], opts)
+			})
+			return finish(res, err, opts.DryRun)
+		}
+
+		go sigs.run(ctx)
+		if sd.enabled() {
+			go sd.run(ctx)
+		}
+		if notify.enabled() {
+			go notify.run(ctx)
+		}
+		// every prefix is watched on its own, the first to fail stopping
+		// them all
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		errs := make(chan error, len(pairs))
+		var wg sync.WaitGroup
+		for _, t := range pairs {
+			wg.Add(1)
+			opts := opts
+			opts.Resync = sigs.resync()
+			go func(prefix string) {
+				defer wg.Done()
+				if err := client.RenderWatch(ctx, prefix, groups[prefix], opts); err != nil {
+					errs <- fmt.Errorf("%s: %w", prefix, err)
+					cancel()
+				}
+			}(t.serverPrefix)
+		}
+		wg.Wait()
+		close(errs)
+		if err := <-errs; err != nil {
+			slog.Error("Render failed", "err", err)
+			return exitCode(err)
+		}
+		return exitOK
+	})
+}
+
+// renderTargets returns the targets to render by the prefix of the tree
+// they read: those given as template:dest below serverPrefix, or the
+// template resources in confdir below serverPrefix and their own prefixes.
+func renderTargets(args []string, confdir, mode, serverPrefix string) (map[string][]zksync.RenderTarget, error) {
+	groups := make(map[string][]zksync.RenderTarget)
+	if confdir != "" {
+		resources, err := zksync.LoadConfdResources(confdir)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range resources {
+			t, err := r.Target()
+			if err != nil {
+				return nil, err
+			}
+			if r.UID != nil || r.GID != nil {
+				slog.Warn("Ignoring uid and gid, files are owned by whoever renders them", "file", r.File)
+			}
+			prefix := path.Join(serverPrefix, r.Prefix)
+			groups[prefix] = append(groups[prefix], t)
+		}
+		return groups, nil
+	}
+
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bad -mode %q: %w", mode, err)
+	}
+	for _, arg := range args {
+		t, err := zksync.ParseRenderTarget(arg)
+		if err != nil {
+			return nil, err
+		}
+		t.Mode = os.FileMode(perm)
+		groups[serverPrefix] = append(groups[serverPrefix], t)
+	}
+	return groups, nil
+}
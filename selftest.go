@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// selftestStep is the outcome of one check of selftest.
+type selftestStep struct {
+	Name    string `json:"name" yaml:"name"`
+	OK      bool   `json:"ok" yaml:"ok"`
+	Skipped string `json:"skipped,omitempty" yaml:"skipped,omitempty"`
+	Error   string `json:"error,omitempty" yaml:"error,omitempty"`
+	Took    string `json:"took" yaml:"took"`
+}
+
+// selftest runs checks against a scratch subtree, stopping at the first
+// failing one, as the later ones build on it.
+type selftest struct {
+	ctx     context.Context
+	client  *zksync.Client
+	scratch string
+	acl     []zksync.ACL
+	steps   []selftestStep
+	failed  error
+}
+
+func runSelftest(fs *flag.FlagSet, args []string) int {
+	start := time.Now()
+	cfg := connectFlags(fs)
+	prefix := fs.String("server_prefix", "/configurator-selftest", "Where to make the scratch subtree checks write to, removed once done")
+	aclFlag := fs.String("acl", "world:anyone:cdrwa", "ACL to give a scratch node and read back, e.g. digest:user:hash:crwda, checking the session can still read it")
+	watchTimeout := fs.Duration("watch-timeout", 5*time.Second, "How long to wait for a watch to report a change")
+	if code, ok := parseFlags(fs, args, false); !ok {
+		return code
+	}
+	acl, err := zksync.ParseACL(*aclFlag)
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	host, _ := os.Hostname()
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		t := &selftest{ctx: ctx, client: client, acl: acl, scratch: path.Join(*prefix, fmt.Sprintf("%s-%d", host, os.Getpid()))}
+		t.steps = append(t.steps, selftestStep{Name: "connect", OK: true, Took: time.Since(start).Round(time.Millisecond).String()})
+		defer t.cleanUp()
+
+		t.step("write", t.write)
+		t.step("upload", t.roundTrip)
+		t.step("acl", t.checkACL)
+		t.step("watch", func() (string, error) { return t.watch(*watchTimeout) })
+		t.step("delete", t.delete)
+		return t.report()
+	})
+}
+
+// step runs check unless an earlier one failed. check returns why it was
+// skipped, if it was.
+func (t *selftest) step(name string, check func() (string, error)) {
+	if t.failed != nil {
+		return
+	}
+	start := time.Now()
+	skipped, err := check()
+	s := selftestStep{Name: name, OK: err == nil, Skipped: skipped, Took: time.Since(start).Round(time.Millisecond).String()}
+	if err != nil {
+		s.Error = err.Error()
+		t.failed = err
+	}
+	t.steps = append(t.steps, s)
+}
+
+// write writes a node, reads it back, then overwrites it at the version
+// read.
+func (t *selftest) write() (string, error) {
+	p := path.Join(t.scratch, "node")
+	data := []byte("configurator selftest " + time.Now().UTC().Format(time.RFC3339) + "\n")
+	if err := failedOrNil(t.client.Put(t.ctx, p, data, -1, zksync.Options{})); err != nil {
+		return "", err
+	}
+	got, stat, err := t.client.ReadNode(p)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(got, data) {
+		return "", fmt.Errorf("%s reads back %q, not %q", p, got, data)
+	}
+	return "", failedOrNil(t.client.Put(t.ctx, p, append(data, "again\n"...), stat.Version, zksync.Options{}))
+}
+
+// roundTrip uploads a small tree, downloads it elsewhere and compares.
+func (t *selftest) roundTrip() (string, error) {
+	dir, err := ioutil.TempDir("", "configurator-selftest-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	up, down := filepath.Join(dir, "up"), filepath.Join(dir, "down")
+	files := map[string]string{"a.conf": "a = 1\n", "sub/b.conf": "b = 2\n", "sub/deeper/c.json": "{\"c\": 3}\n"}
+	for name, data := range files {
+		file := filepath.Join(up, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+			return "", err
+		}
+	}
+	remote := path.Join(t.scratch, "tree")
+	if err := failedOrNil(t.client.Upload(t.ctx, up, remote, zksync.Options{})); err != nil {
+		return "", fmt.Errorf("uploading: %w", err)
+	}
+	if err := failedOrNil(t.client.Download(t.ctx, down, remote, zksync.Options{})); err != nil {
+		return "", fmt.Errorf("downloading: %w", err)
+	}
+	for name, data := range files {
+		got, err := ioutil.ReadFile(filepath.Join(down, filepath.FromSlash(name)))
+		if err != nil {
+			return "", fmt.Errorf("downloading: %w", err)
+		}
+		if string(got) != data {
+			return "", fmt.Errorf("%s downloads as %q, not %q", name, got, data)
+		}
+	}
+	diffs, err := t.client.Diff(t.ctx, up, remote, zksync.Options{})
+	if err != nil {
+		return "", err
+	}
+	if len(diffs) > 0 {
+		return "", fmt.Errorf("%s still differs after uploading", diffs[0].Path)
+	}
+	return "", nil
+}
+
+// checkACL gives a node the ACL asked for and reads it back, and the node
+// with it, to tell whether the session's auth lets it read what it writes.
+func (t *selftest) checkACL() (string, error) {
+	ab, ok := t.client.Backend.(zksync.ACLBackend)
+	if !ok {
+		return "the backend has no ACLs", nil
+	}
+	p := path.Join(t.scratch, "acl")
+	if err := ab.CreateWithACL(p, []byte("acl\n"), t.acl); err != nil {
+		return "", fmt.Errorf("creating %s: %w", p, err)
+	}
+	got, err := ab.GetACL(p)
+	if err != nil {
+		return "", fmt.Errorf("reading the ACL of %s: %w", p, err)
+	}
+	want := make([]string, len(t.acl))
+	auth := false
+	for i, a := range t.acl {
+		want[i] = a.String()
+		// the server turns auth entries into whoever the session is
+		auth = auth || a.Scheme == "auth"
+	}
+	have := make([]string, len(got))
+	for i, a := range got {
+		have[i] = a.String()
+	}
+	if !auth && strings.Join(have, ",") != strings.Join(want, ",") {
+		return "", fmt.Errorf("%s has the ACL %s, not %s", p, strings.Join(have, ","), strings.Join(want, ","))
+	}
+	if _, _, err := t.client.ReadNode(p); err != nil {
+		return "", fmt.Errorf("the ACL keeps this session from reading: %w", err)
+	}
+	return "", nil
+}
+
+// watch changes a node while watching it.
+func (t *selftest) watch(timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(t.ctx, timeout)
+	defer cancel()
+	events, err := t.client.Backend.Watch(ctx, t.scratch)
+	if errors.Is(err, zksync.ErrUnsupported) {
+		return "the backend cannot watch", nil
+	} else if err != nil {
+		return "", err
+	}
+	p := path.Join(t.scratch, "watched")
+	if err := failedOrNil(t.client.Put(t.ctx, p, []byte("watched\n"), -1, zksync.Options{})); err != nil {
+		return "", err
+	}
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return "", fmt.Errorf("the watch ended with no event for %s", p)
+			}
+			if e.Err != nil {
+				return "", e.Err
+			}
+			if e.Path == p {
+				return "", nil
+			}
+		case <-ctx.Done():
+			return "", fmt.Errorf("no event for %s within %s", p, timeout)
+		}
+	}
+}
+
+// delete removes the scratch subtree and checks it is gone.
+func (t *selftest) delete() (string, error) {
+	if err := failedOrNil(t.client.Delete(t.ctx, t.scratch, zksync.Options{})); err != nil {
+		return "", err
+	}
+	if _, _, err := t.client.Backend.Get(t.scratch); !errors.Is(err, zksync.ErrNoNode) {
+		return "", fmt.Errorf("%s is still there after deleting it", t.scratch)
+	}
+	t.scratch = ""
+	return "", nil
+}
+
+// cleanUp removes what is left of the scratch subtree after a failure.
+func (t *selftest) cleanUp() {
+	if t.scratch == "" {
+		return
+	}
+	if err := failedOrNil(t.client.Delete(context.Background(), t.scratch, zksync.Options{})); err != nil {
+		slog.Warn("Could not remove the scratch subtree", "path", t.scratch, "err", err)
+	}
+}
+
+func (t *selftest) report() int {
+	if structured() {
+		if err := writeStructured(t.steps); err != nil {
+			slog.Error("Could not write the results", "err", err)
+			return exitError
+		}
+	} else {
+		for _, s := range t.steps {
+			switch {
+			case s.Skipped != "":
+				fmt.Printf("skip  %-8s %s\n", s.Name, s.Skipped)
+			case s.OK:
+				fmt.Printf("ok    %-8s %s\n", s.Name, s.Took)
+			default:
+				fmt.Printf("FAIL  %-8s %s\n", s.Name, s.Error)
+			}
+		}
+	}
+	if t.failed != nil {
+		return exitCode(t.failed)
+	}
+	return exitOK
+}
+
+// failedOrNil turns a run where some changes failed into the first error.
+func failedOrNil(res *zksync.Result, err error) error {
+	if err == nil && len(res.Failed) > 0 {
+		err = res.Failed[0]
+	}
+	return err
+}
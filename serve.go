@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// apiRecent is how many finished runs /v1/status lists.
+const apiRecent = 50
+
+// apiRequest is the JSON body of the operation endpoints, every field
+// optional.
+type apiRequest struct {
+	// Tree is the -server_prefix of the tree to work on, which may be left
+	// out when serving a single tree.
+	Tree       string `json:"tree"`
+	DryRun     bool   `json:"dry_run"`
+	Prune      bool   `json:"prune"`
+	ThreeWay   bool   `json:"three_way"`
+	OnConflict string `json:"on_conflict"`
+}
+
+// apiRun is an operation started through the API, as /v1/status lists it.
+type apiRun struct {
+	ID       int            `json:"id"`
+	Command  string         `json:"command"`
+	Tree     string         `json:"tree"`
+	DryRun   bool           `json:"dry_run"`
+	Started  time.Time      `json:"started"`
+	Finished *time.Time     `json:"finished,omitempty"`
+	Report   *zksync.Report `json:"report,omitempty"`
+}
+
+// apiStatus is the body of /v1/status.
+type apiStatus struct {
+	Running []*apiRun `json:"running"`
+	Recent  []*apiRun `json:"recent"`
+}
+
+// apiTree is a tree the API works on, as /v1/trees lists it.
+type apiTree struct {
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+}
+
+// apiServer runs operations on the trees it was started with for whoever
+// asks over HTTP.
+type apiServer struct {
+	ctx    context.Context
+	client *zksync.Client
+	pairs  []treePair
+	opts   zksync.Options
+	hooks  *hookFlags
+	locks  *lockFlags
+	token  string
+
+	mu      sync.Mutex
+	nextID  int
+	running map[int]*apiRun
+	recent  []*apiRun
+}
+
+func runServe(fs *flag.FlagSet, args []string) int {
+	cfg := connectFlags(fs)
+	trees := addTreesFlags(fs)
+	apply := addApplyFlags(fs)
+	filters := addFilterFlags(fs)
+	symlinks := addSymlinksFlag(fs)
+	validate := addValidateFlags(fs)
+	ephemeral := addEphemeralFlag(fs)
+	acls := addACLFlags(fs)
+	hooks := addHookFlags(fs)
+	locks := addLockFlags(fs)
+	checkVersion := addCheckVersionFlag(fs)
+	listen := fs.String("listen", "localhost:9101", "Address to serve the API at")
+	token := fs.String("token", "", "Bearer token every request must carry, $CONFIGURATOR_API_TOKEN if not given; none needed if empty")
+	*token = os.Getenv("CONFIGURATOR_API_TOKEN") // not a default, for -help not to print it
+	if code, ok := parseFlags(fs, args, false); !ok {
+		return code
+	}
+
+	opts, err := apply.options(filters)
+	if err == nil {
+		opts.Symlinks, err = zksync.ParseSymlinkPolicy(*symlinks)
+	}
+	if err == nil {
+		opts.Validator, err = validate.validator()
+	}
+	if err == nil {
+		opts.ACLs, err = acls.policy()
+	}
+	var pairs []treePair
+	if err == nil {
+		pairs, err = trees.pairs()
+	}
+	if err != nil {
+		slog.Error("Invalid flags", "err", err)
+		return exitUsage
+	}
+	opts.CheckVersion = *checkVersion
+	opts.Ephemeral = *ephemeral
+
+	return withClient(cfg, func(ctx context.Context, client *zksync.Client) int {
+		s := &apiServer{ctx: ctx, client: client, pairs: pairs, opts: opts, hooks: hooks, locks: locks, token: *token, running: make(map[int]*apiRun)}
+		ln, err := net.Listen("tcp", *listen)
+		if err != nil {
+			slog.Error("Could not listen", "addr", *listen, "err", err)
+			return exitUsage
+		}
+		if s.token == "" {
+			slog.Warn("No -token, anyone reaching the API can change the trees", "addr", ln.Addr().String())
+		}
+		srv := &http.Server{Handler: s.handler()}
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			srv.Shutdown(shutdownCtx)
+		}()
+		slog.Info("Serving API", "addr", ln.Addr().String(), "trees", len(pairs))
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			slog.Error("API server failed", "err", err)
+			return exitError
+		}
+		return exitOK
+	})
+}
+
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/trees", s.trees)
+	mux.HandleFunc("/v1/status", s.status)
+	mux.HandleFunc("/v1/diff", s.diff)
+	mux.HandleFunc("/v1/events", s.events)
+	mux.HandleFunc("/v1/upload", s.operation("upload", func(ctx context.Context, t treePair, opts zksync.Options) (*zksync.Result, error) {
+		return s.client.Upload(ctx, t.localPrefix, t.serverPrefix, opts)
+	}))
+	mux.HandleFunc("/v1/download", s.operation("download", func(ctx context.Context, t treePair, opts zksync.Options) (*zksync.Result, error) {
+		return s.client.Download(ctx, t.localPrefix, t.serverPrefix, opts)
+	}))
+	mux.HandleFunc("/v1/sync", s.operation("sync", func(ctx context.Context, t treePair, opts zksync.Options) (*zksync.Result, error) {
+		return s.client.Sync(ctx, t.localPrefix, t.serverPrefix, opts)
+	}))
+	return s.authorize(mux)
+}
+
+// authorize turns away requests without the -token.
+func (s *apiServer) authorize(next http.Handler) http.Handler {
+	want := []byte("Bearer " + s.token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeAPIError(w, http.StatusUnauthorized, errors.New("missing or wrong bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tree finds the tree served at serverPrefix, or the only one if empty.
+func (s *apiServer) tree(serverPrefix string) (treePair, error) {
+	if serverPrefix == "" && len(s.pairs) == 1 {
+		return s.pairs[0], nil
+	}
+	for _, t := range s.pairs {
+		if t.serverPrefix == serverPrefix {
+			return t, nil
+		}
+	}
+	if serverPrefix == "" {
+		return treePair{}, errors.New("serving several trees, say which")
+	}
+	return treePair{}, fmt.Errorf("not serving %s", serverPrefix)
+}
+
+func (s *apiServer) trees(w http.ResponseWriter, r *http.Request) {
+	trees := make([]apiTree, len(s.pairs))
+	for i, t := range s.pairs {
+		trees[i] = apiTree{Local: t.localPrefix, Remote: t.serverPrefix}
+	}
+	writeJSON(w, http.StatusOK, trees)
+}
+
+func (s *apiServer) status(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	st := apiStatus{Running: []*apiRun{}, Recent: append([]*apiRun{}, s.recent...)}
+	for _, run := range s.running {
+		st.Running = append(st.Running, run)
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, st)
+}
+
+// operation returns the handler of a POST running command on a tree, which
+// answers with the report of what it did once it is done. Runs are not cut
+// short when the caller goes away, only when the server is stopped.
+func (s *apiServer) operation(command string, f func(context.Context, treePair, zksync.Options) (*zksync.Result, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+			return
+		}
+		var req apiRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeAPIError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		t, err := s.tree(req.Tree)
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, err)
+			return
+		}
+		opts := s.opts
+		opts.DryRun = opts.DryRun || req.DryRun
+		opts.Prune = req.Prune
+		if command == "sync" {
+			opts.ThreeWay = req.ThreeWay
+			if opts.Policy, err = zksync.ParseConflictPolicy(req.OnConflict); err != nil {
+				writeAPIError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		run := s.start(command, t, opts.DryRun)
+		res, err := s.locks.around(s.ctx, s.client, t.serverPrefix, opts.DryRun, func() (*zksync.Result, error) {
+			return s.hooks.around(s.ctx, command, t, opts, func(opts zksync.Options) (*zksync.Result, error) {
+				return f(s.ctx, t, opts)
+			})
+		})
+		var rep zksync.Report
+		code := http.StatusOK
+		switch {
+		case err != nil:
+			rep = zksync.Report{DryRun: opts.DryRun, Error: err.Error(), Changes: []zksync.OpReport{}}
+			code = apiStatusCode(err)
+		default:
+			rep = res.Report(opts.DryRun)
+			if len(res.Failed) > 0 {
+				code = http.StatusInternalServerError
+			} else if len(res.Conflicts) > 0 {
+				code = http.StatusConflict
+			}
+		}
+		s.finish(run, rep)
+		writeJSON(w, code, rep)
+	}
+}
+
+func (s *apiServer) start(command string, t treePair, dryRun bool) *apiRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	run := &apiRun{ID: s.nextID, Command: command, Tree: t.serverPrefix, DryRun: dryRun, Started: time.Now().UTC()}
+	s.running[run.ID] = run
+	slog.Info("API run started", "id", run.ID, "command", command, "tree", t.serverPrefix, "dry_run", dryRun)
+	return run
+}
+
+func (s *apiServer) finish(run *apiRun, rep zksync.Report) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	run.Finished, run.Report = &now, &rep
+	delete(s.running, run.ID)
+	s.recent = append(s.recent, run)
+	if len(s.recent) > apiRecent {
+		s.recent = s.recent[len(s.recent)-apiRecent:]
+	}
+	slog.Info("API run finished", "id", run.ID, "applied", rep.Applied, "failed", rep.Failed, "err", rep.Error)
+}
+
+// diff answers with how the tree given by the tree query parameter differs
+// from the server's.
+func (s *apiServer) diff(w http.ResponseWriter, r *http.Request) {
+	t, err := s.tree(r.URL.Query().Get("tree"))
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, err)
+		return
+	}
+	diffs, err := s.client.Diff(r.Context(), t.localPrefix, t.serverPrefix, s.opts)
+	if err != nil {
+		writeAPIError(w, apiStatusCode(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, zksync.NewVerifyReport(diffs))
+}
+
+// apiEvent is a line of /v1/events.
+type apiEvent struct {
+	Type  string `json:"type,omitempty"`
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// events streams the changes to the remote tree given by the tree query
+// parameter as JSON lines, until the caller goes away or the watch breaks.
+func (s *apiServer) events(w http.ResponseWriter, r *http.Request) {
+	t, err := s.tree(r.URL.Query().Get("tree"))
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, err)
+		return
+	}
+	events, err := s.client.Backend.Watch(r.Context(), t.serverPrefix)
+	if err != nil {
+		code := apiStatusCode(err)
+		if errors.Is(err, zksync.ErrUnsupported) {
+			code = http.StatusNotImplemented
+		}
+		writeAPIError(w, code, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for ev := range events {
+		line := apiEvent{Type: ev.Type.String(), Path: ev.Path}
+		if ev.Err != nil {
+			line = apiEvent{Error: ev.Err.Error()}
+		}
+		if err := enc.Encode(line); err != nil || ev.Err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// apiStatusCode is the HTTP status for an operation that failed with err,
+// as exitCode is the exit code.
+func apiStatusCode(err error) int {
+	switch exitCode(err) {
+	case exitAuth:
+		return http.StatusForbidden
+	case exitConnection:
+		return http.StatusBadGateway
+	case exitLocked, exitChanged:
+		return http.StatusConflict
+	case exitStopped:
+		return http.StatusServiceUnavailable
+	}
+	var ve *zksync.ValidationError
+	if errors.As(err, &ve) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// apiError is the body of a request that failed before running anything.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeAPIError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, apiError{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// statsSignal has daemons log what they have done so far, nil where the
+// platform has no SIGUSR1.
+var statsSignal os.Signal
+
+// daemonSignals has long running commands act on signals as other daemons
+// do: SIGHUP syncs everything again at once, catching up with whatever
+// the watch missed, and SIGUSR1 logs what has been done since starting.
+type daemonSignals struct {
+	ch      chan os.Signal
+	mu      sync.Mutex
+	resyncs []chan struct{}
+	stats   daemonStats
+}
+
+// daemonStats are what a daemon has done since it started.
+type daemonStats struct {
+	started  time.Time
+	batches  int
+	ops      map[string]int // by kind
+	bytes    int
+	errors   int
+	resyncs  int
+	lastSync time.Time // when a batch last went through without errors
+	lastErr  error
+	session  string // the last ZooKeeper state, empty for other backends
+}
+
+// newDaemonSignals starts catching the signals, so that SIGHUP no longer
+// ends the process, for run to act on them.
+func newDaemonSignals() *daemonSignals {
+	s := &daemonSignals{ch: make(chan os.Signal, 1), stats: daemonStats{started: time.Now(), ops: make(map[string]int)}}
+	sigs := []os.Signal{syscall.SIGHUP}
+	if statsSignal != nil {
+		sigs = append(sigs, statsSignal)
+	}
+	signal.Notify(s.ch, sigs...)
+	return s
+}
+
+// resync returns a channel for Options.Resync that every SIGHUP sends to.
+func (s *daemonSignals) resync() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	s.mu.Lock()
+	s.resyncs = append(s.resyncs, ch)
+	s.mu.Unlock()
+	return ch
+}
+
+// observe returns a watch hook counting every batch of changes before
+// handing it on to next, if not nil.
+func (s *daemonSignals) observe(next func(zksync.Plan, []error, time.Duration)) func(zksync.Plan, []error, time.Duration) {
+	return func(applied zksync.Plan, errs []error, took time.Duration) {
+		s.mu.Lock()
+		s.stats.batches++
+		for _, o := range applied {
+			s.stats.ops[o.Kind.String()]++
+			s.stats.bytes += len(o.Data)
+		}
+		s.stats.errors += len(errs)
+		if len(errs) > 0 {
+			s.stats.lastErr = errs[len(errs)-1]
+		} else {
+			s.stats.lastSync = time.Now()
+		}
+		s.mu.Unlock()
+		if next != nil {
+			next(applied, errs, took)
+		}
+	}
+}
+
+// sessionEvent returns a session hook recording the state before handing
+// it on to next, if not nil.
+func (s *daemonSignals) sessionEvent(next func(string)) func(string) {
+	return func(state string) {
+		s.mu.Lock()
+		s.stats.session = state
+		s.mu.Unlock()
+		if next != nil {
+			next(state)
+		}
+	}
+}
+
+// run acts on the signals until ctx is done.
+func (s *daemonSignals) run(ctx context.Context) {
+	defer signal.Stop(s.ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-s.ch:
+			if sig != syscall.SIGHUP {
+				s.logStats()
+				continue
+			}
+			slog.Info("Resyncing everything", "signal", sig)
+			s.mu.Lock()
+			s.stats.resyncs++
+			for _, ch := range s.resyncs {
+				select {
+				case ch <- struct{}{}:
+				default:
+					// one is already on its way
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *daemonSignals) logStats() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kinds := make([]string, 0, len(s.stats.ops))
+	applied := 0
+	for kind, n := range s.stats.ops {
+		kinds = append(kinds, fmt.Sprintf("%s=%d", kind, n))
+		applied += n
+	}
+	sort.Strings(kinds)
+	attrs := []any{
+		"uptime", time.Since(s.stats.started).Round(time.Second),
+		"batches", s.stats.batches,
+		"applied", applied,
+		"ops", strings.Join(kinds, " "),
+		"bytes", s.stats.bytes,
+		"errors", s.stats.errors,
+		"resyncs", s.stats.resyncs,
+	}
+	if !s.stats.lastSync.IsZero() {
+		attrs = append(attrs, "last_sync", s.stats.lastSync.Format(time.RFC3339), "last_sync_age", time.Since(s.stats.lastSync).Round(time.Second))
+	}
+	if s.stats.lastErr != nil {
+		attrs = append(attrs, "last_error", s.stats.lastErr)
+	}
+	if s.stats.session != "" {
+		attrs = append(attrs, "session", s.stats.session)
+	}
+	slog.Info("Sync stats", attrs...)
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+func init() {
+	statsSignal = syscall.SIGUSR1
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// systemd tells systemd how the daemon is doing over $NOTIFY_SOCKET, as
+// sd_notify does, so that units can be Type=notify: READY=1 once the
+// first sync has gone through, and WATCHDOG=1 pings while the process is
+// alive as /healthz has it, for WatchdogSec= to restart it when they stop.
+type systemd struct {
+	socket   string
+	watchdog time.Duration // zero if the unit has no watchdog
+	health   *health
+	ready    bool
+}
+
+// newSystemd reads what systemd passed in the environment, and drops it so
+// that hooks and notify commands do not take it for theirs. grace is how
+// long the session may be gone before pings stop.
+func newSystemd(grace time.Duration) *systemd {
+	s := &systemd{socket: os.Getenv("NOTIFY_SOCKET"), health: &health{grace: grace}}
+	if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 {
+		pid := os.Getenv("WATCHDOG_PID")
+		if pid == "" || pid == strconv.Itoa(os.Getpid()) {
+			s.watchdog = time.Duration(usec) * time.Microsecond
+		}
+	}
+	os.Unsetenv("NOTIFY_SOCKET")
+	os.Unsetenv("WATCHDOG_USEC")
+	os.Unsetenv("WATCHDOG_PID")
+	return s
+}
+
+func (s *systemd) enabled() bool {
+	return s.socket != ""
+}
+
+// notify sends state, such as READY=1, to systemd.
+func (s *systemd) notify(state string) {
+	conn, err := net.Dial("unixgram", s.socket)
+	if err == nil {
+		_, err = conn.Write([]byte(state))
+		conn.Close()
+	}
+	if err != nil {
+		slog.Warn("Could not notify systemd", "socket", s.socket, "state", state, "err", err)
+	}
+}
+
+// observe returns a watch hook telling systemd the daemon is ready after
+// the first batch without errors, before handing it on to next, if not
+// nil.
+func (s *systemd) observe(next func(zksync.Plan, []error, time.Duration)) func(zksync.Plan, []error, time.Duration) {
+	return s.health.observe(func(applied zksync.Plan, errs []error, took time.Duration) {
+		s.health.mu.Lock()
+		ready := !s.ready && len(errs) == 0
+		s.ready = s.ready || ready
+		s.health.mu.Unlock()
+		if ready {
+			s.notify("READY=1\nSTATUS=Synced")
+		}
+		if next != nil {
+			next(applied, errs, took)
+		}
+	})
+}
+
+// sessionEvent returns a session hook recording the state before handing
+// it on to next, if not nil.
+func (s *systemd) sessionEvent(next func(string)) func(string) {
+	return func(state string) {
+		s.health.sessionEvent(state)
+		if next != nil {
+			next(state)
+		}
+	}
+}
+
+// run pings the watchdog twice every interval while the process is alive,
+// until ctx is done, and then tells systemd it is stopping.
+func (s *systemd) run(ctx context.Context) {
+	var tick <-chan time.Time
+	if s.watchdog > 0 {
+		ticker := time.NewTicker(s.watchdog / 2)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			s.notify("STOPPING=1")
+			return
+		case <-tick:
+			s.health.mu.Lock()
+			dead := s.health.dead()
+			s.health.mu.Unlock()
+			if dead != "" {
+				slog.Warn("Not pinging the systemd watchdog", "reason", dead)
+				continue
+			}
+			s.notify("WATCHDOG=1")
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// vaultFlags say whether, and when, references to HashiCorp Vault secrets
+// are resolved. The server and token come from the environment, as for the
+// vault CLI.
+type vaultFlags struct {
+	when      string
+	addr      string
+	namespace string
+}
+
+func addVaultFlags(fs *flag.FlagSet) *vaultFlags {
+	v := &vaultFlags{}
+	fs.StringVar(&v.when, "vault", "", "Resolve vault:path#key references to Vault secrets: upload in the files uploaded, download in those downloaded, keeping them off the server")
+	fs.StringVar(&v.addr, "vault-addr", os.Getenv("VAULT_ADDR"), "Address of the Vault server")
+	fs.StringVar(&v.namespace, "vault-namespace", os.Getenv("VAULT_NAMESPACE"), "Vault Enterprise namespace")
+	return v
+}
+
+// vault returns the Vault the flags ask for, or nil to leave references as
+// they are. The token is $VAULT_TOKEN, or the one vault login saved.
+func (v *vaultFlags) vault() (*zksync.Vault, error) {
+	var onDownload bool
+	switch v.when {
+	case "":
+		return nil, nil
+	case "upload":
+	case "download":
+		onDownload = true
+	default:
+		return nil, fmt.Errorf("bad -vault %q, want upload or download", v.when)
+	}
+	if v.addr == "" {
+		return nil, fmt.Errorf("-vault needs -vault-addr or $VAULT_ADDR")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadFile(filepath.Join(home, ".vault-token"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" {
+		return nil, fmt.Errorf("-vault needs a token, from $VAULT_TOKEN or vault login")
+	}
+	return &zksync.Vault{Addr: v.addr, Token: token, Namespace: v.namespace, OnDownload: onDownload}, nil
+}
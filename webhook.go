@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edevil/configurator/zksync"
+)
+
+// webhookSignature is the header carrying the HMAC-SHA256 of the body,
+// keyed with -webhook-secret, as sha256=<hex>.
+const webhookSignature = "X-Configurator-Signature"
+
+// webhookChange is one change in a webhook payload.
+type webhookChange struct {
+	Path      string `json:"path"`
+	Event     string `json:"event"`
+	OldSHA256 string `json:"old_sha256,omitempty"`
+	NewSHA256 string `json:"new_sha256,omitempty"`
+}
+
+// webhookPayload is what is posted for every batch of changes mirrored,
+// of kind changes, and by drift when drift is found, or resolved. Text
+// sums it up, for chat webhooks that only show that.
+type webhookPayload struct {
+	Kind    string          `json:"kind"`
+	Text    string          `json:"text"`
+	Host    string          `json:"host"`
+	Time    string          `json:"time"`
+	Changes []webhookChange `json:"changes"`
+}
+
+// webhooks post the remote changes a watch mirrors, or drift, to every URL
+// given, retrying those that fail, without holding the watch up.
+type webhooks struct {
+	urls    stringList
+	secret  string
+	retries int
+	timeout time.Duration
+
+	serverPrefix string
+	mu           sync.Mutex
+	hashes       map[string]string // of every file, by remote path
+	queue        chan webhookPayload
+}
+
+func addWebhookFlags(fs *flag.FlagSet) *webhooks {
+	h := &webhooks{queue: make(chan webhookPayload, 100)}
+	fs.Var(&h.urls, "webhook", "URL to POST JSON to for every batch of remote changes watch mirrors, or drift found; repeatable")
+	fs.StringVar(&h.secret, "webhook-secret", "", "Key to sign webhook bodies with, in the "+webhookSignature+" header as sha256=<HMAC-SHA256 hex>; $CONFIGURATOR_WEBHOOK_SECRET if not given, unsigned if empty")
+	h.secret = os.Getenv("CONFIGURATOR_WEBHOOK_SECRET") // not a default, for -help not to print it
+	fs.IntVar(&h.retries, "webhook-retries", 3, "How many times to retry a webhook that fails")
+	fs.DurationVar(&h.timeout, "webhook-timeout", 10*time.Second, "How long to wait for a webhook to answer")
+	return h
+}
+
+func (h *webhooks) enabled() bool {
+	return len(h.urls) > 0
+}
+
+// watch hashes the files under localPrefix, the mirror of serverPrefix
+// with names mapped by names, so that changes can say what they replaced,
+// then posts batches until ctx is done.
+func (h *webhooks) watch(ctx context.Context, localPrefix, serverPrefix string, names *zksync.NameMap) {
+	h.serverPrefix = serverPrefix
+	h.hashes = make(map[string]string)
+	filepath.Walk(localPrefix, func(p string, fInfo os.FileInfo, err error) error {
+		if err != nil || !fInfo.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(localPrefix, p)
+		if err != nil {
+			return nil
+		}
+		if data, err := ioutil.ReadFile(p); err == nil {
+			h.hashes[path.Join(serverPrefix, names.RemoteFile(filepath.ToSlash(rel)))] = sha256Hex(data)
+		}
+		return nil
+	})
+	go h.run(ctx)
+}
+
+// observe returns a watch hook posting every batch of changes before
+// handing it on to next, if not nil.
+func (h *webhooks) observe(next func(zksync.Plan, []error, time.Duration)) func(zksync.Plan, []error, time.Duration) {
+	return func(applied zksync.Plan, errs []error, took time.Duration) {
+		h.applied(applied)
+		if next != nil {
+			next(applied, errs, took)
+		}
+	}
+}
+
+func (h *webhooks) applied(p zksync.Plan) {
+	var changes []webhookChange
+	h.mu.Lock()
+	for _, o := range p {
+		if o.Dir {
+			continue
+		}
+		c := webhookChange{Path: o.Source, OldSHA256: h.hashes[o.Source]}
+		switch o.Kind {
+		case zksync.OpWrite, zksync.OpOverwrite:
+			c.Event, c.NewSHA256 = "changed", sha256Hex(o.Data)
+			if c.OldSHA256 == "" {
+				c.Event = "created"
+			}
+			h.hashes[o.Source] = c.NewSHA256
+		case zksync.OpRemove:
+			c.Event = "deleted"
+			for p := range h.hashes {
+				if p == o.Source || strings.HasPrefix(p, o.Source+"/") {
+					delete(h.hashes, p)
+				}
+			}
+		default:
+			continue
+		}
+		changes = append(changes, c)
+	}
+	h.mu.Unlock()
+	if len(changes) == 0 {
+		return
+	}
+
+	text := fmt.Sprintf("%s: %s %s", h.serverPrefix, changes[0].Path, changes[0].Event)
+	if len(changes) > 1 {
+		text = fmt.Sprintf("%s: %d files changed", h.serverPrefix, len(changes))
+	}
+	h.post("changes", text, changes)
+}
+
+// post queues a payload for run to send, with the host added to text.
+func (h *webhooks) post(kind, text string, changes []webhookChange) {
+	host, _ := os.Hostname()
+	payload := webhookPayload{Kind: kind, Text: text + " on " + host, Host: host, Time: time.Now().UTC().Format(time.RFC3339), Changes: changes}
+	select {
+	case h.queue <- payload:
+	default:
+		slog.Error("Webhooks falling behind, dropping a batch", "changes", len(changes))
+	}
+}
+
+// run sends what is posted until ctx is done.
+func (h *webhooks) run(ctx context.Context) {
+	client := &http.Client{Timeout: h.timeout}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case payload := <-h.queue:
+			body, err := json.Marshal(payload)
+			if err != nil {
+				slog.Error("Could not encode webhook", "err", err)
+				continue
+			}
+			for _, url := range h.urls {
+				h.deliver(ctx, client, url, body)
+			}
+		}
+	}
+}
+
+// deliver sends body to url, retrying with a backoff from one second while
+// it fails in a way that may pass.
+func (h *webhooks) deliver(ctx context.Context, client *http.Client, url string, body []byte) {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		retry, err := h.send(ctx, client, url, body)
+		if err == nil {
+			slog.Debug("Posted webhook", "url", url)
+			return
+		}
+		if !retry || attempt >= h.retries {
+			slog.Error("Webhook failed", "url", url, "err", err, "attempts", attempt+1)
+			return
+		}
+		slog.Warn("Retrying webhook", "url", url, "err", err, "wait", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (h *webhooks) send(ctx context.Context, client *http.Client, url string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.secret != "" {
+		mac := hmac.New(sha256.New, []byte(h.secret))
+		mac.Write(body)
+		req.Header.Set(webhookSignature, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		// client errors other than throttling will fail again
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("got %s", resp.Status)
+	}
+	return false, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
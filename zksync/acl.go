@@ -0,0 +1,220 @@
+package zksync
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Permission bits, with the values ZooKeeper uses.
+const (
+	PermRead   = 1 << iota // r
+	PermWrite              // w
+	PermCreate             // c
+	PermDelete             // d
+	PermAdmin              // a
+)
+
+var permLetters = []struct {
+	perm   int32
+	letter byte
+}{{PermCreate, 'c'}, {PermRead, 'r'}, {PermWrite, 'w'}, {PermDelete, 'd'}, {PermAdmin, 'a'}}
+
+// ACL is one access control entry, such as world:anyone:r.
+type ACL struct {
+	Scheme string
+	ID     string
+	Perms  int32
+}
+
+func (a ACL) String() string {
+	var perms []byte
+	for _, p := range permLetters {
+		if a.Perms&p.perm != 0 {
+			perms = append(perms, p.letter)
+		}
+	}
+	return a.Scheme + ":" + a.ID + ":" + string(perms)
+}
+
+// ParseACL parses a comma separated list of scheme:id:perms entries, perms
+// being letters out of crwda. The id may itself hold colons, as digest ids
+// do.
+func ParseACL(s string) ([]ACL, error) {
+	var acl []ACL
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		first, last := strings.Index(entry, ":"), strings.LastIndex(entry, ":")
+		if first < 0 || first == last {
+			return nil, fmt.Errorf("bad ACL %q, want scheme:id:perms", entry)
+		}
+
+		a := ACL{Scheme: entry[:first], ID: entry[first+1 : last]}
+	letters:
+		for _, letter := range []byte(entry[last+1:]) {
+			for _, p := range permLetters {
+				if p.letter == letter {
+					a.Perms |= p.perm
+					continue letters
+				}
+			}
+			return nil, fmt.Errorf("bad permission %q in ACL %q", letter, entry)
+		}
+		acl = append(acl, a)
+	}
+	return acl, nil
+}
+
+func formatACL(acl []ACL) string {
+	entries := make([]string, len(acl))
+	for i, a := range acl {
+		entries[i] = a.String()
+	}
+	return strings.Join(entries, ",")
+}
+
+// sameACL reports whether a and b hold the same entries, in any order.
+func sameACL(a, b []ACL) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	as, bs := make([]string, len(a)), make([]string, len(b))
+	for i := range a {
+		as[i], bs[i] = a[i].String(), b[i].String()
+	}
+	sort.Strings(as)
+	sort.Strings(bs)
+	for i := range as {
+		if as[i] != bs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ACLBackend is implemented by backends with per node access control.
+type ACLBackend interface {
+	// CreateWithACL is Create, giving the new node acl.
+	CreateWithACL(p string, data []byte, acl []ACL) error
+	GetACL(p string) ([]ACL, error)
+	SetACL(p string, acl []ACL) error
+}
+
+// ACLPolicy says which ACL each node of a tree gets.
+type ACLPolicy struct {
+	// Default applies where no rule matches. Nodes get whatever the backend
+	// gives them by default if it is empty too.
+	Default []ACL
+	rules   []aclRule
+	exact   map[string][]ACL // by path, from LoadMetadata
+}
+
+type aclRule struct {
+	pattern pattern
+	acl     []ACL
+}
+
+// LoadACLManifest reads ACL rules from a file, one per line:
+//
+//	# pattern       acl
+//	*               world:anyone:r,digest:admin:xyz=:crwda
+//	secrets         digest:admin:xyz=:crwda
+//
+// Patterns are matched like Filter patterns against paths relative to the
+// tree root, a rule for a dir applying to everything below it. The last
+// matching rule wins.
+func (p *ACLPolicy) LoadACLManifest(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: want a pattern and an ACL", file, line)
+		}
+		patterns, err := compilePatterns(fields[:1])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+		acl, err := ParseACL(fields[1])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+		p.rules = append(p.rules, aclRule{pattern: patterns[0], acl: acl})
+	}
+	return scanner.Err()
+}
+
+// For returns the ACL the node at rel should have, nil for the backend
+// default.
+func (p *ACLPolicy) For(rel string) []ACL {
+	if p == nil {
+		return nil
+	}
+	if acl, ok := p.exact[rel]; ok {
+		return acl
+	}
+	for i := len(p.rules) - 1; i >= 0; i-- {
+		if rel != "" && matchAny([]pattern{p.rules[i].pattern}, rel) {
+			return p.rules[i].acl
+		}
+	}
+	return p.Default
+}
+
+// SyncACLs sets the ACL of every node under remotePath that does not have
+// the one opts.ACLs gives it.
+func (c *Client) SyncACLs(ctx context.Context, remotePath string, opts Options) (*Result, error) {
+	if _, ok := c.Backend.(ACLBackend); !ok {
+		return nil, ErrUnsupported
+	}
+	p, err := c.planACLs(ctx, remotePath, "", opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.run(ctx, p, opts)
+}
+
+func (c *Client) planACLs(ctx context.Context, serverPrefix, rel string, opts Options) (Plan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts.Filter.Excluded(rel) {
+		return nil, nil
+	}
+
+	var p Plan
+	if want := opts.ACLs.For(rel); len(want) > 0 {
+		have, err := c.Backend.(ACLBackend).GetACL(serverPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("reading ACL of %s: %w", serverPrefix, err)
+		}
+		if !sameACL(have, want) {
+			p = append(p, Op{Kind: OpSetACL, Target: serverPrefix, ACL: want, OldACL: have})
+		}
+	}
+
+	children, _, err := c.Backend.List(serverPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", serverPrefix, err)
+	}
+	for _, child := range children {
+		childPlan, err := c.planACLs(ctx, path.Join(serverPrefix, child), path.Join(rel, child), opts)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, childPlan...)
+	}
+	return p, nil
+}
@@ -0,0 +1,57 @@
+package zksync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// checkAtomic reports why p cannot be applied in a single transaction, if
+// it cannot. ZooKeeper has no rename, so a tree cannot be staged aside and
+// swapped in: the transaction carries every change itself.
+func (c *Client) checkAtomic(p Plan) error {
+	tx, ok := c.Backend.(Transactor)
+	if !ok {
+		return fmt.Errorf("atomic writes: %w", ErrUnsupported)
+	}
+	maxOps, maxBytes := tx.MultiLimit()
+	size := 0
+	for _, o := range p {
+		switch o.Kind {
+		case OpCreate, OpSet, OpDelete:
+		default:
+			return fmt.Errorf("%s cannot be part of a transaction", strings.TrimSpace(o.String()))
+		}
+		size += len(o.Target) + len(o.Data)
+	}
+	if (maxOps > 0 && len(p) > maxOps) || (maxBytes > 0 && size > maxBytes) {
+		return fmt.Errorf("%d changes of %d bytes do not fit in one transaction, upload -release swaps in trees of any size", len(p), size)
+	}
+	return nil
+}
+
+// applyAtomic applies all of p in one transaction, failing every op if it
+// fails.
+func (c *Client) applyAtomic(ctx context.Context, p Plan, opts Options) []error {
+	if len(p) == 0 {
+		return nil
+	}
+	t := c.newTracker(p, opts)
+	err := ctx.Err()
+	if err == nil {
+		err = c.Backend.(Transactor).Transact(p)
+	}
+	if err != nil {
+		errs := make([]error, len(p))
+		for i, o := range p {
+			errs[i] = &OpError{Op: o, Err: err}
+			t.done(o, errs[i])
+		}
+		return errs
+	}
+	for _, o := range p {
+		t.done(o, nil)
+	}
+	c.logger().Info("Applied in one transaction", "changes", len(p))
+	return nil
+}
@@ -0,0 +1,196 @@
+package zksync
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEntry records one change made to the server.
+type AuditEntry struct {
+	Time    time.Time `json:"time" yaml:"time"`
+	User    string    `json:"user" yaml:"user"`
+	Host    string    `json:"host" yaml:"host"`
+	Command string    `json:"command,omitempty" yaml:"command,omitempty"`
+	// Action is create, set, delete or setacl.
+	Action string `json:"action" yaml:"action"`
+	Path   string `json:"path" yaml:"path"`
+	// OldVersion is the version a set or delete replaced.
+	OldVersion *int64 `json:"old_version,omitempty" yaml:"old_version,omitempty"`
+	// Size and SHA256 describe the data written, as stored, so compressed
+	// or encrypted if it was.
+	Size   int    `json:"size,omitempty" yaml:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	ACL    string `json:"acl,omitempty" yaml:"acl,omitempty"`
+}
+
+// Audit records every change a Client makes to the server, once it went
+// through, as JSON lines appended to File, as nodes below Node, or both.
+// Nodes are named after the time of the change, so that listing Node gives
+// them in order.
+type Audit struct {
+	File string
+	Node string
+	// User, Host and Command are recorded with every change. NewAudit fills
+	// in the first two.
+	User, Host, Command string
+
+	mu      sync.Mutex
+	f       *os.File
+	seq     int
+	hasNode bool
+}
+
+// NewAudit returns an Audit for the current user and host.
+func NewAudit(file, node, command string) *Audit {
+	a := &Audit{File: file, Node: node, Command: command}
+	if u, err := user.Current(); err == nil {
+		a.User = u.Username
+	}
+	a.Host, _ = os.Hostname()
+	return a
+}
+
+// record records o, which c has just applied. Failing to record is only
+// logged, as the change is made by then.
+func (a *Audit) record(c *Client, o Op) {
+	if a == nil {
+		return
+	}
+	e := AuditEntry{Time: time.Now().UTC(), User: a.User, Host: a.Host, Command: a.Command, Path: o.Target}
+	switch o.Kind {
+	case OpCreate:
+		e.Action = "create"
+	case OpSet:
+		e.Action = "set"
+		e.OldVersion = &o.Version
+	case OpDelete:
+		e.Action = "delete"
+		e.OldVersion = &o.Version
+	case OpSetACL:
+		e.Action = "setacl"
+		e.ACL = formatACL(o.ACL)
+	default:
+		return
+	}
+	if (o.Kind == OpCreate || o.Kind == OpSet) && !o.Dir {
+		sum := sha256.Sum256(o.Data)
+		e.Size, e.SHA256 = len(o.Data), hex.EncodeToString(sum[:])
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.File != "" {
+		if err := a.appendFile(line); err != nil {
+			c.logger().Error("Could not write audit log", "file", a.File, "err", err)
+		}
+	}
+	if a.Node != "" {
+		if err := a.createNode(c, e.Time, line); err != nil {
+			c.logger().Error("Could not write audit node", "node", a.Node, "err", err)
+		}
+	}
+}
+
+func (a *Audit) appendFile(line []byte) error {
+	if a.f == nil {
+		f, err := os.OpenFile(a.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+		if err != nil {
+			return err
+		}
+		a.f = f
+	}
+	_, err := a.f.Write(append(line, '\n'))
+	return err
+}
+
+func (a *Audit) createNode(c *Client, t time.Time, line []byte) error {
+	if !a.hasNode {
+		p, err := c.planRemotePath(a.Node)
+		if err != nil {
+			return err
+		}
+		for _, o := range p {
+			if err := c.Backend.Create(o.Target, nil); err != nil && err != ErrNodeExists {
+				return err
+			}
+		}
+		a.hasNode = true
+	}
+	// the host and a counter keep names apart within the same nanosecond
+	a.seq++
+	name := fmt.Sprintf("%s-%s-%d", t.Format("20060102T150405.000000000Z"), a.Host, a.seq)
+	return c.Backend.Create(path.Join(a.Node, name), line)
+}
+
+// Close closes the audit log file.
+func (a *Audit) Close() error {
+	if a == nil || a.f == nil {
+		return nil
+	}
+	return a.f.Close()
+}
+
+// ReadAuditLog returns the entries of the audit log file, oldest first.
+func ReadAuditLog(file string) ([]AuditEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// ReadAuditNode returns the entries recorded below node, oldest first.
+func (c *Client) ReadAuditNode(node string) ([]AuditEntry, error) {
+	names, _, err := c.Backend.List(node)
+	if err == ErrNoNode {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", node, err)
+	}
+	sort.Strings(names)
+	entries := make([]AuditEntry, 0, len(names))
+	for _, name := range names {
+		p := path.Join(node, name)
+		data, _, err := c.Backend.Get(p)
+		if err == ErrNoNode {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", p, err)
+		}
+		var e AuditEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", p, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
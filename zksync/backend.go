@@ -0,0 +1,215 @@
+package zksync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Stat is what the sync needs to know about a remote node.
+type Stat struct {
+	Version     int64
+	Mtime       time.Time // zero when the backend does not track it
+	DataLength  int
+	NumChildren int  // backends without real hierarchy may count all descendants
+	Chunks      int  // chunk nodes a large file is split into, not counted as children
+	EmptyFile   bool // a file with no data, which would otherwise look like a dir
+	Ephemeral   bool // goes away with the session that made it, as service registrations do
+	Encrypted   bool // the file is stored encrypted
+	// Czxid and Mzxid order the creation and the last change of the node
+	// among all changes: zxids in ZooKeeper, revisions in etcd and indexes
+	// in Consul. Zero when the backend does not have them.
+	Czxid, Mzxid int64
+	Ctime        time.Time // zero when the backend does not track it
+}
+
+// IsDir reports whether the node is a dir, nodes with no data being dirs
+// unless they are marked as empty files.
+func (s *Stat) IsDir() bool {
+	return s.DataLength == 0 && !s.EmptyFile
+}
+
+// EventType tells what happened to a watched node.
+type EventType int
+
+const (
+	EventCreated EventType = iota
+	EventChanged
+	EventDeleted
+)
+
+var eventTypeNames = [...]string{"created", "changed", "deleted"}
+
+func (t EventType) String() string {
+	if int(t) < len(eventTypeNames) {
+		return eventTypeNames[t]
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// Event reports a change somewhere under a watched path. A non-nil Err means
+// the watch is broken and no more events will follow.
+type Event struct {
+	Type EventType
+	Path string
+	Err  error
+}
+
+// Backend is a store holding a tree of nodes addressed by slash separated
+// paths. A node with no data is treated as a dir.
+type Backend interface {
+	// Get returns the data and stat of the node at p, or ErrNoNode.
+	Get(p string) ([]byte, *Stat, error)
+	// List returns the names of the children of p and the stat of p.
+	List(p string) ([]string, *Stat, error)
+	// Create makes a new node at p, or fails with ErrNodeExists.
+	Create(p string, data []byte) error
+	// Set replaces the data at p, failing with ErrBadVersion if the node is
+	// not at the given version. A version of -1 matches any version.
+	Set(p string, data []byte, version int64) error
+	// Delete removes the node at p if it is at the given version.
+	Delete(p string, version int64) error
+	// Watch reports every change to p and its descendants until ctx is done.
+	Watch(ctx context.Context, p string) (<-chan Event, error)
+	Close()
+}
+
+// Stater is implemented by backends that can read the stat of a node
+// without its data.
+type Stater interface {
+	// Stat returns the stat of the node at p, or ErrNoNode.
+	Stat(p string) (*Stat, error)
+}
+
+// NodeVersion names a node expected to be at a given version.
+type NodeVersion struct {
+	Path    string
+	Version int64
+}
+
+// MultiDeleter is implemented by backends that can delete several nodes in
+// one all-or-nothing transaction.
+type MultiDeleter interface {
+	// DeleteMulti deletes every node, in order, or none of them.
+	DeleteMulti(nodes []NodeVersion) error
+	// MultiLimit is how many ops, and how many bytes worth of paths and
+	// data, fit in one transaction. Zero means no limit.
+	MultiLimit() (ops int, bytes int)
+}
+
+// Transactor is implemented by backends that can write several nodes in
+// one all-or-nothing transaction.
+type Transactor interface {
+	// Transact applies every create, set and delete in p, in order, or
+	// none of them.
+	Transact(p Plan) error
+	// MultiLimit is as for MultiDeleter.
+	MultiLimit() (ops int, bytes int)
+}
+
+// Quoter is implemented by backends whose servers can limit how many
+// nodes and bytes a tree holds, as ZooKeeper quotas do.
+type Quoter interface {
+	// SetQuota limits the tree at p to count nodes and bytes of data, -1
+	// for no limit, or removes its quota when both are -1.
+	SetQuota(p string, count, bytes int64) error
+}
+
+// BackendConfig holds connection settings. Not every backend uses every
+// setting.
+type BackendConfig struct {
+	// Kind is one of zookeeper, etcd or consul.
+	Kind string
+	// Servers is a comma separated list of host[:port], followed for
+	// ZooKeeper by an optional chroot, such as /apps/myapp, that every path
+	// is then below. For ZooKeeper it can also be srv:<name>, to connect to
+	// the targets of the DNS SRV record name instead, or, with no chroot,
+	// exhibitor:<host:port> or an http(s) URL, to connect to the servers an
+	// Exhibitor or a JSON document lists.
+	Servers string
+	// DiscoveryRefresh is how old the lookup of such Servers can get before
+	// it is done again, when reconnecting. Zero only looks it up again once
+	// every server it gave has failed.
+	DiscoveryRefresh time.Duration
+	// Auth is digest credentials for ZooKeeper, user:password for etcd or
+	// an ACL token for Consul.
+	Auth string
+	// ExtraAuth is more ZooKeeper credentials for the session, sent after
+	// Auth, for trees whose ACLs ask for different schemes in different
+	// subtrees.
+	ExtraAuth []AuthEntry
+	// Datacenter is the Consul datacenter, the agent's own if empty.
+	Datacenter string
+	// TLS secures the connection, for servers only listening on a secure
+	// port.
+	TLS TLSConfig
+	// SASL authenticates with ZooKeeper through Kerberos, on top of or
+	// instead of digest Auth.
+	SASL SASLConfig
+	// Retry says how ZooKeeper operations are retried when the connection
+	// or session is lost. etcd and Consul clients retry on their own.
+	Retry RetryPolicy
+	// RateLimit throttles ZooKeeper operations. etcd and Consul are not
+	// throttled.
+	RateLimit RateLimit
+	// ReadOnly refuses every change to the backend, see ReadOnly.
+	ReadOnly bool
+	// ReadOnlySession lets ZooKeeper connect to servers cut off from the
+	// quorum, which only serve reads, possibly stale. Implies ReadOnly.
+	ReadOnlySession bool
+	// OnSessionEvent, if set, is called with the name of the new state,
+	// such as StateHasSession or StateExpired, every time the ZooKeeper
+	// connection or session changes state.
+	OnSessionEvent func(state string)
+}
+
+// AuthEntry is credentials for a ZooKeeper auth scheme. The sasl scheme
+// is not sent like the others but turns SASL on, as whoever Credential
+// names if not empty.
+type AuthEntry struct {
+	Scheme     string
+	Credential string
+}
+
+// ParseAuthEntry parses scheme:credential, such as digest:user:password,
+// ip:10.0.0.1 or x509:CN=client.
+func ParseAuthEntry(s string) (AuthEntry, error) {
+	scheme, credential, ok := strings.Cut(s, ":")
+	switch {
+	case scheme == "":
+		return AuthEntry{}, fmt.Errorf("auth %q: want scheme:credential", s)
+	case !ok && scheme != "sasl":
+		return AuthEntry{}, fmt.Errorf("auth %q: no credential for %s", s, scheme)
+	}
+	return AuthEntry{Scheme: scheme, Credential: credential}, nil
+}
+
+// Open connects to the backend described by cfg.
+func Open(cfg BackendConfig) (Backend, error) {
+	if len(cfg.ExtraAuth) > 0 && cfg.Kind != "zookeeper" {
+		return nil, fmt.Errorf("only zookeeper has auth schemes, %s takes Auth alone", cfg.Kind)
+	}
+	if cfg.ReadOnlySession && cfg.Kind != "zookeeper" {
+		return nil, fmt.Errorf("only zookeeper has read-only sessions")
+	}
+	var b Backend
+	var err error
+	switch cfg.Kind {
+	case "zookeeper":
+		b, err = newZKBackend(cfg)
+	case "etcd":
+		b, err = newEtcdBackend(cfg)
+	case "consul":
+		b, err = newConsulBackend(cfg)
+	default:
+		return nil, fmt.Errorf("unknown backend: %s", cfg.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ReadOnly || cfg.ReadOnlySession {
+		b = ReadOnly(b)
+	}
+	return b, nil
+}
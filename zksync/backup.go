@@ -0,0 +1,209 @@
+package zksync
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Snapshot is a point in time copy of a remote tree, data, ACLs and all.
+type Snapshot struct {
+	Root  string         `json:"root"`
+	Taken time.Time      `json:"taken"`
+	Nodes []SnapshotNode `json:"nodes"`
+}
+
+// SnapshotNode is one node of a Snapshot. Parents come before their
+// children.
+type SnapshotNode struct {
+	Path    string    `json:"path"` // relative to the root, empty for the root itself
+	Data    []byte    `json:"data"`
+	ACL     string    `json:"acl,omitempty"` // as ParseACL reads it, empty if unknown
+	Version int64     `json:"version"`
+	Mtime   time.Time `json:"mtime"`
+}
+
+// Backup copies the tree at remotePath, leaving out whatever opts.Filter
+// excludes.
+func (c *Client) Backup(ctx context.Context, remotePath string, opts Options) (*Snapshot, error) {
+	s := &Snapshot{Root: remotePath, Taken: time.Now().UTC()}
+	if err := c.backupNode(ctx, s, remotePath, "", opts); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (c *Client) backupNode(ctx context.Context, s *Snapshot, serverPrefix, rel string, opts Options) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if opts.Filter.Excluded(rel) {
+		return nil
+	}
+	data, stat, err := c.Backend.Get(serverPrefix)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", serverPrefix, err)
+	}
+	n := SnapshotNode{Path: rel, Data: data, Version: stat.Version, Mtime: stat.Mtime}
+	if ab, ok := c.Backend.(ACLBackend); ok {
+		acl, err := ab.GetACL(serverPrefix)
+		if err != nil {
+			return fmt.Errorf("reading ACL of %s: %w", serverPrefix, err)
+		}
+		n.ACL = formatACL(acl)
+	}
+	s.Nodes = append(s.Nodes, n)
+
+	children, _, err := c.Backend.List(serverPrefix)
+	if err != nil {
+		return fmt.Errorf("listing %s: %w", serverPrefix, err)
+	}
+	for _, child := range children {
+		if err := c.backupNode(ctx, s, path.Join(serverPrefix, child), path.Join(rel, child), opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save writes s to file as JSON, gzipped if the name ends in .gz.
+func (s *Snapshot) Save(file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = f
+	var zw *gzip.Writer
+	if strings.HasSuffix(file, ".gz") {
+		zw = gzip.NewWriter(f)
+		w = zw
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(s)
+	if zw != nil {
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// LoadSnapshot reads a snapshot written by Save.
+func LoadSnapshot(file string) (*Snapshot, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", file, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	s := &Snapshot{}
+	if err := json.NewDecoder(r).Decode(s); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", file, err)
+	}
+	return s, nil
+}
+
+// Restore makes the tree at remotePath what it was when s was taken,
+// creating and overwriting nodes and setting their ACLs back on backends
+// that have them. With opts.Prune, nodes that were not in s are deleted.
+func (c *Client) Restore(ctx context.Context, s *Snapshot, remotePath string, opts Options) (*Result, error) {
+	p, err := c.planRestore(ctx, s, remotePath, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Prune {
+		inSnapshot := make(map[string]docNode, len(s.Nodes))
+		for _, n := range s.Nodes {
+			// nodes may have both data and children, so every one of them
+			// is looked into
+			inSnapshot[n.Path] = docNode{rel: n.Path, dir: true}
+		}
+		if err := checkPrunable(remotePath, opts); err != nil {
+			return nil, err
+		}
+		prunePlan, err := c.planPruneDoc(ctx, remotePath, "", inSnapshot, opts)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, prunePlan...)
+	}
+	return c.run(ctx, p, opts)
+}
+
+func (c *Client) planRestore(ctx context.Context, s *Snapshot, serverPrefix string, opts Options) (Plan, error) {
+	p, err := c.planRemotePath(path.Dir(serverPrefix))
+	if err != nil {
+		return nil, err
+	}
+	created := make(map[string]bool)
+	for _, o := range p {
+		created[o.Target] = true
+	}
+	ab, withACLs := c.Backend.(ACLBackend)
+
+	for _, n := range s.Nodes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if opts.Filter.Excluded(n.Path) {
+			continue
+		}
+		var acl []ACL
+		if n.ACL != "" && withACLs {
+			if acl, err = ParseACL(n.ACL); err != nil {
+				return nil, fmt.Errorf("ACL of %s: %w", n.Path, err)
+			}
+		}
+		remotePath := path.Join(serverPrefix, n.Path)
+
+		exists := false
+		var data []byte
+		var stat *Stat
+		if !created[path.Dir(remotePath)] {
+			data, stat, err = c.Backend.Get(remotePath)
+			if err == nil {
+				exists = true
+			} else if err != ErrNoNode {
+				return nil, fmt.Errorf("checking %s: %w", remotePath, err)
+			}
+		}
+		if !exists {
+			p = append(p, Op{Kind: OpCreate, Source: s.Root, Target: remotePath, Dir: len(n.Data) == 0, Data: n.Data, ACL: acl})
+			created[remotePath] = true
+			continue
+		}
+
+		if !bytes.Equal(data, n.Data) {
+			p = append(p, Op{Kind: OpSet, Source: s.Root, Target: remotePath, Data: n.Data, OldSize: stat.DataLength, Version: stat.Version})
+		}
+		if len(acl) > 0 {
+			have, err := ab.GetACL(remotePath)
+			if err != nil {
+				return nil, fmt.Errorf("reading ACL of %s: %w", remotePath, err)
+			}
+			if !sameACL(have, acl) {
+				p = append(p, Op{Kind: OpSetACL, Target: remotePath, ACL: acl, OldACL: have})
+			}
+		}
+	}
+	return p, nil
+}
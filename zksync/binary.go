@@ -0,0 +1,70 @@
+package zksync
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"unicode/utf8"
+)
+
+// BinaryPolicy decides what uploads make of binary files in the local
+// tree.
+type BinaryPolicy string
+
+const (
+	// BinaryAllow uploads them like any other file.
+	BinaryAllow BinaryPolicy = "allow"
+	// BinarySkip leaves them out, with a warning.
+	BinarySkip BinaryPolicy = "skip"
+	// BinaryError fails on the first one found.
+	BinaryError BinaryPolicy = "error"
+)
+
+// ParseBinaryPolicy checks s names a known policy.
+func ParseBinaryPolicy(s string) (BinaryPolicy, error) {
+	switch p := BinaryPolicy(s); p {
+	case BinaryAllow, BinarySkip, BinaryError:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown binary policy: %s", s)
+}
+
+// base64Magic marks node data as base64 encoded by Options.EncodeBinary.
+// Unlike the other markers it is plain text, for whoever reads the node
+// expecting some.
+var base64Magic = []byte("configurator:base64:")
+
+// binarySniff is how much of a file IsBinary looks at, as git does.
+const binarySniff = 8000
+
+// IsBinary reports whether data looks like the contents of a binary file
+// rather than text: it has a NUL byte early on, or is not valid UTF-8.
+func IsBinary(data []byte) bool {
+	sniff := data
+	if len(sniff) > binarySniff {
+		sniff = sniff[:binarySniff]
+	}
+	return bytes.IndexByte(sniff, 0) >= 0 || !utf8.Valid(data)
+}
+
+func encodeBinary(data []byte) []byte {
+	out := make([]byte, len(base64Magic)+base64.StdEncoding.EncodedLen(len(data)))
+	copy(out, base64Magic)
+	base64.StdEncoding.Encode(out[len(base64Magic):], data)
+	return out
+}
+
+// decodeBinary undoes encodeBinary on the data of node p, returning data
+// that was not encoded as it is.
+func decodeBinary(p string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, base64Magic) {
+		return data, nil
+	}
+	encoded := data[len(base64Magic):]
+	whole := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+	n, err := base64.StdEncoding.Decode(whole, encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", p, err)
+	}
+	return whole[:n], nil
+}
@@ -0,0 +1,231 @@
+package zksync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DefaultChunkSize keeps nodes under ZooKeeper's default 1MB
+// jute.maxbuffer, with room to spare for the rest of the request.
+const DefaultChunkSize = 1000 * 1000
+
+// chunkPrefix names the child nodes holding the pieces of a file too large
+// for a single node. The file node itself holds a manifest of them.
+const chunkPrefix = ".chunk-"
+
+var chunkMagic = []byte("\x00configurator-chunks\x00")
+
+// emptyMagic is the data of an empty file, a node with no data at all
+// being a dir.
+var emptyMagic = []byte("\x00configurator-empty\x00")
+
+// errPartialChunks means a chunked file is caught halfway through being
+// written.
+var errPartialChunks = errors.New("chunks do not match their manifest")
+
+type chunkManifest struct {
+	Size   int    `json:"size"`
+	Chunks int    `json:"chunks"`
+	SHA256 string `json:"sha256"`
+}
+
+func chunkName(i int) string {
+	return fmt.Sprintf("%s%04d", chunkPrefix, i)
+}
+
+// isChunk reports whether the node named name is a piece of its parent.
+func isChunk(name string) bool {
+	return strings.HasPrefix(name, chunkPrefix)
+}
+
+// IsChunk reports whether the node at p is a piece of a large file,
+// rather than a node of its own.
+func IsChunk(p string) bool {
+	return isChunk(path.Base(p))
+}
+
+// getFile reads the file stored at p, putting it back together if it was
+// split into chunks, and decrypting and decompressing it if it was
+// encrypted or compressed. The stat is that of the node at p, except that
+// it counts the whole file.
+func (c *Client) getFile(p string) ([]byte, *Stat, error) {
+	data, stat, err := c.Backend.Get(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(data, emptyMagic) {
+		fileStat := *stat
+		fileStat.DataLength = 0
+		fileStat.EmptyFile = true
+		return []byte{}, &fileStat, nil
+	}
+	if !bytes.HasPrefix(data, chunkMagic) {
+		if !bytes.HasPrefix(data, gzipMagic) && !bytes.HasPrefix(data, cryptMagic) && !bytes.HasPrefix(data, base64Magic) {
+			return data, stat, nil
+		}
+		whole, err := c.decode(p, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		fileStat := *stat
+		fileStat.DataLength = len(whole)
+		fileStat.EmptyFile = len(whole) == 0
+		fileStat.Encrypted = bytes.HasPrefix(data, cryptMagic)
+		return whole, &fileStat, nil
+	}
+
+	var m chunkManifest
+	if err := json.Unmarshal(data[len(chunkMagic):], &m); err != nil {
+		return nil, nil, fmt.Errorf("bad chunk manifest in %s: %w", p, err)
+	}
+	whole := make([]byte, 0, m.Size)
+	for i := 0; i < m.Chunks; i++ {
+		chunk, _, err := c.Backend.Get(path.Join(p, chunkName(i)))
+		if err == ErrNoNode {
+			return nil, nil, fmt.Errorf("%s: %w", p, errPartialChunks)
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("reading chunk %d of %s: %w", i, p, err)
+		}
+		whole = append(whole, chunk...)
+	}
+	sum := sha256.Sum256(whole)
+	if len(whole) != m.Size || hex.EncodeToString(sum[:]) != m.SHA256 {
+		return nil, nil, fmt.Errorf("%s: %w", p, errPartialChunks)
+	}
+
+	encrypted := bytes.HasPrefix(whole, cryptMagic)
+	if whole, err = c.decode(p, whole); err != nil {
+		return nil, nil, err
+	}
+
+	fileStat := *stat
+	fileStat.Encrypted = encrypted
+	fileStat.DataLength = len(whole)
+	fileStat.EmptyFile = len(whole) == 0
+	fileStat.Chunks = m.Chunks
+	if fileStat.NumChildren -= m.Chunks; fileStat.NumChildren < 0 {
+		fileStat.NumChildren = 0
+	}
+	return whole, &fileStat, nil
+}
+
+// decode decrypts, decompresses and base64 decodes the data of the file at
+// p.
+func (c *Client) decode(p string, data []byte) ([]byte, error) {
+	data, err := c.Encryption.decrypt(p, data)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = decompress(p, data); err != nil {
+		return nil, err
+	}
+	return decodeBinary(p, data)
+}
+
+// planWrite plans storing data at target, over the file getFile returned
+// old for, or as a new node if old is nil. Binary data is base64 encoded
+// first with opts.EncodeBinary, then compressed with opts.Compress,
+// encrypted if c.Encryption says target is to be, and split into chunk
+// nodes below target if it is still over opts.ChunkSize. Empty files are
+// stored as emptyMagic unless opts.PlainEmpty, so they are not taken for
+// dirs. New chunks are written before the manifest pointing at them, and
+// the ones it no longer needs removed after it.
+func (c *Client) planWrite(source, target string, data []byte, old *Stat, acl []ACL, opts Options) (Plan, error) {
+	var oldChunks []*Stat
+	if old != nil {
+		for i := 0; i < old.Chunks; i++ {
+			_, stat, err := c.Backend.Get(path.Join(target, chunkName(i)))
+			if err != nil {
+				return nil, fmt.Errorf("reading chunk %d of %s: %w", i, target, err)
+			}
+			oldChunks = append(oldChunks, stat)
+		}
+	}
+
+	if opts.EncodeBinary && IsBinary(data) {
+		data = encodeBinary(data)
+	}
+	if opts.Compress && len(data) > 0 {
+		var err error
+		if data, err = compress(data); err != nil {
+			return nil, err
+		}
+	}
+	if c.Encryption.encrypts(target) && len(data) > 0 {
+		var err error
+		if data, err = c.Encryption.encrypt(data); err != nil {
+			return nil, err
+		}
+	}
+
+	stored := data
+	if len(data) == 0 && !opts.PlainEmpty {
+		stored = emptyMagic
+	}
+	var chunks Plan
+	if opts.ChunkSize > 0 && len(data) > opts.ChunkSize {
+		for i := 0; i*opts.ChunkSize < len(data); i++ {
+			end := (i + 1) * opts.ChunkSize
+			if end > len(data) {
+				end = len(data)
+			}
+			o := Op{Kind: OpCreate, Source: source, Target: path.Join(target, chunkName(i)), Data: data[i*opts.ChunkSize : end], ACL: acl}
+			if i < len(oldChunks) {
+				o = Op{Kind: OpSet, Source: source, Target: o.Target, Data: o.Data, OldSize: oldChunks[i].DataLength, Version: oldChunks[i].Version}
+			}
+			chunks = append(chunks, o)
+		}
+		sum := sha256.Sum256(data)
+		manifest, err := json.Marshal(chunkManifest{Size: len(data), Chunks: len(chunks), SHA256: hex.EncodeToString(sum[:])})
+		if err != nil {
+			return nil, err
+		}
+		stored = append(append([]byte{}, chunkMagic...), manifest...)
+	}
+
+	var p Plan
+	if old == nil {
+		// chunks need their parent to exist first
+		p = append(Plan{{Kind: OpCreate, Source: source, Target: target, Data: stored, ACL: acl}}, chunks...)
+	} else {
+		p = append(chunks, Op{Kind: OpSet, Source: source, Target: target, Data: stored, OldSize: old.DataLength, Version: old.Version})
+	}
+	for i := len(chunks); i < len(oldChunks); i++ {
+		p = append(p, Op{Kind: OpDelete, Target: path.Join(target, chunkName(i)), OldSize: oldChunks[i].DataLength, Version: oldChunks[i].Version})
+	}
+	return p, nil
+}
+
+// DefaultMaxNodeSize is ZooKeeper's default jute.maxbuffer, which the data
+// of a node has to fit in along with the rest of the request.
+const DefaultMaxNodeSize = 1024 * 1024
+
+// checkSizes fails with a SizeError if p writes nodes with more data than
+// opts.MaxNodeSize, or with opts.WarnOversize logs them.
+func (c *Client) checkSizes(p Plan, opts Options) error {
+	if opts.MaxNodeSize <= 0 {
+		return nil
+	}
+	var over []Op
+	for _, o := range p {
+		if (o.Kind == OpCreate || o.Kind == OpSet) && len(o.Data) > opts.MaxNodeSize {
+			over = append(over, o)
+		}
+	}
+	if len(over) == 0 {
+		return nil
+	}
+	if opts.WarnOversize {
+		for _, o := range over {
+			c.logger().Warn("Node larger than the server may take", "path", o.Target, "size", len(o.Data), "max", opts.MaxNodeSize)
+		}
+		return nil
+	}
+	return &SizeError{Ops: over, Limit: opts.MaxNodeSize}
+}
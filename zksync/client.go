@@ -0,0 +1,442 @@
+// Package zksync keeps a local directory tree and a tree of nodes in a
+// ZooKeeper, etcd or Consul backend in sync.
+//
+// Every operation first walks both trees to build a Plan, and only then
+// applies it, so a dry run sees exactly the changes a real run would make.
+package zksync
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const nodeMode = 0744
+
+// Client syncs trees between the local filesystem and a Backend.
+type Client struct {
+	Backend Backend
+	// Logger receives progress messages, slog.Default() if nil.
+	Logger *slog.Logger
+	// Encryption encrypts the files it matches as they are written, and
+	// decrypts any encrypted file read. Files are written in plain text if
+	// nil, and reading encrypted ones fails with ErrNoKey.
+	Encryption *Encryption
+	// Audit records every change made to the server, if not nil.
+	Audit *Audit
+}
+
+// New returns a Client working against b.
+func New(b Backend) *Client {
+	return &Client{Backend: b}
+}
+
+func (c *Client) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return slog.Default()
+}
+
+// Options tune how an operation is carried out.
+type Options struct {
+	// DryRun only plans the changes, leaving both trees untouched.
+	DryRun bool
+	// Clean deletes the remote tree before an upload.
+	Clean bool
+	// Prune deletes what is no longer at the source: remote nodes with no
+	// local file after an upload, local files with no node after a
+	// download.
+	Prune bool
+	// Policy settles conflicts during Sync, NewestWins if empty, or Skip for
+	// three-way syncs.
+	Policy ConflictPolicy
+	// Resolve, if set, is asked by Sync how to settle every conflict, in
+	// place of Policy, before anything is changed. An error stops the sync
+	// with nothing changed.
+	Resolve func(Difference) (Resolution, error)
+	// ThreeWay has Sync tell which side changed a file from the StateFile
+	// of the last sync, copying only that side over, so that only files
+	// changed on both sides are conflicts. Files deleted from one side are
+	// deleted from the other, where a two-way sync would copy them back.
+	// Without a StateFile, the first sync is two-way.
+	ThreeWay bool
+	// Debounce is the quiet period WatchLocal waits for before uploading.
+	Debounce time.Duration
+	// Resync has watches sync the whole tree again, as they did when they
+	// started, whenever it receives, to catch up with changes they may
+	// have missed. Each watch needs a channel of its own.
+	Resync <-chan struct{}
+	// Concurrency is how many changes are applied at once. A node is never
+	// written before its parent, whatever the concurrency.
+	Concurrency int
+	// Atomic applies uploads in a single transaction, so that readers see
+	// either none or all of the changes, failing up front if they do not
+	// fit in one. Concurrency does not apply.
+	Atomic bool
+	// Compare is how downloads tell a local file is out of date,
+	// CompareChecksum if empty.
+	Compare CompareMode
+	// Symlinks is what uploads, diffs and local watches do with symlinks
+	// in the local tree, SymlinksSkip if empty.
+	Symlinks SymlinkPolicy
+	// ACLs are given to the nodes uploads create, and enforced by
+	// SyncACLs. Nodes get the backend default if nil.
+	ACLs *ACLPolicy
+	// ModeACLs maps file modes to ACLs on upload, and back on download,
+	// in place of ACLs for files.
+	ModeACLs bool
+	// ChunkSize is the largest file uploaded to a single node, larger ones
+	// being split into chunk nodes below it. Zero never splits. Downloads
+	// put chunked files back together whatever it is.
+	ChunkSize int
+	// MaxNodeSize is the most data a node can be written with, over which
+	// runs fail with a SizeError listing every such node before changing
+	// anything. Zero has no limit.
+	MaxNodeSize int
+	// WarnOversize only logs the nodes over MaxNodeSize, leaving it to the
+	// server to refuse them.
+	WarnOversize bool
+	// Compress gzips files before uploading them. Downloads decompress
+	// whatever was compressed either way. Files already uploaded are only
+	// compressed when they next change.
+	Compress bool
+	// Binary says what uploads make of binary files, BinaryAllow if
+	// empty.
+	Binary BinaryPolicy
+	// EncodeBinary stores binary files base64 encoded, behind a marker,
+	// for readers of the nodes expecting text. Downloads decode them
+	// either way.
+	EncodeBinary bool
+	// PlainEmpty stores empty files as nodes with no data, as older
+	// versions did, rather than as a short marker, for readers of the
+	// nodes that do not expect it. Such nodes come back down as dirs.
+	// Downloads read the marker either way.
+	PlainEmpty bool
+	// Template renders local files before they are uploaded or diffed,
+	// leaving them as they are if nil. Syncs do not render, as they could
+	// not download into a template.
+	Template *Template
+	// Normalize rewrites local files after Template and Vault, before they
+	// are uploaded or diffed, leaving them as they are if nil.
+	Normalize *Normalizer
+	// Properties has uploads store the Java properties files it matches as
+	// a dir holding a node per key, and downloads join such dirs back into
+	// files, leaving them alone if nil.
+	Properties *Properties
+	// Vault resolves references to secrets in local files before they are
+	// uploaded or diffed, or in remote files before they are downloaded if
+	// its OnDownload is set. Syncs resolve nothing, as that would put
+	// secrets in the tree.
+	Vault *Vault
+	// OnApplied is called by watches after every batch of changes they
+	// apply, the initial sync first, with the changes that went through,
+	// the errors of those that did not and how long it all took. Batches
+	// may be empty, and changes that could not be planned come as a lone
+	// error.
+	OnApplied func(applied Plan, errs []error, took time.Duration)
+	// Validator checks the files uploads and syncs are about to write,
+	// nothing being written if one fails. Nothing is checked if nil.
+	Validator *Validator
+	// BeforeApply is called with the changes once they are all planned and
+	// before any is made, unless there are none or it is a dry run. An
+	// error stops the run with nothing changed.
+	BeforeApply func(Plan) error
+	// OnProgress is called after every change applied, or failed, with how
+	// far the plan has got, from whichever goroutine applied it.
+	OnProgress func(Progress)
+	// Journal, if set, records the plan and the changes made so far, for
+	// Resume to carry on from if the run is cut short.
+	Journal *Journal
+	// Ephemeral has downloads, syncs and watches copy ephemeral nodes too,
+	// which they otherwise skip as belonging to whatever session made
+	// them. Download lists the files it got from them in EphemeralFile.
+	// Uploads never overwrite or prune ephemeral nodes whatever it is.
+	Ephemeral bool
+	// Metadata has downloads record the zxids, version, times and ACL of
+	// every node in MetadataFile.
+	Metadata bool
+	// Cursor has downloads record the mzxid of every file in CursorFile,
+	// and only fetch the files whose mzxid, or local copy, changed since.
+	// It needs a backend that is a Stater, and is ignored when resolving
+	// secrets on download or with ModeACLs.
+	Cursor bool
+	// Redact keeps the data of the files it matches out of downloads,
+	// which list its patterns in RedactFile for uploads to skip them.
+	Redact *Redaction
+	// Trash, if set, keeps a copy of every node deleted.
+	Trash *Trash
+	// History, if set, keeps the version of every file overwritten or
+	// deleted, for Revert to put back.
+	History *History
+	// Quotas, if set, refuses runs that would take a tree over its
+	// budget.
+	Quotas *Quotas
+	// MinDeleteDepth is how many names deep a path has to be to be deleted
+	// or pruned, 1 if lower, so that the root never is.
+	MinDeleteDepth int
+	// ForceDelete deletes and prunes whatever is asked, protected paths and
+	// paths shallower than MinDeleteDepth included.
+	ForceDelete bool
+	// Filter picks the paths uploads, downloads, syncs and watches work on,
+	// everything if nil.
+	Filter *Filter
+	// Names maps local file names to node names and back, so that names
+	// either side cannot hold still round-trip, the same on both sides if
+	// nil. Filters, ignore files and relative paths all go by node names.
+	Names *NameMap
+	// CheckVersion reads every node to be written again before writing any,
+	// failing with a ChangedError if someone else changed one since it was
+	// planned, and stops at the first change that fails for the same
+	// reason, leaving the rest of the plan unapplied.
+	CheckVersion bool
+
+	stop   context.CancelFunc // ends the run early, for CheckVersion
+	cursor *cursor            // set by Download, for Cursor
+}
+
+// Result is what an operation did, or would have done on a dry run.
+type Result struct {
+	Plan Plan
+	// Failed holds an *OpError for every op that could not be applied.
+	Failed []error
+	// Conflicts are the paths Sync left alone as changed on both sides.
+	Conflicts []string
+	// Unreconciled are the paths Mirror could not make the same as the
+	// local tree.
+	Unreconciled []string
+}
+
+func (c *Client) run(ctx context.Context, p Plan, opts Options) (*Result, error) {
+	if opts.Filter.hasIncludes() {
+		p = pruneEmptyDirs(p)
+	}
+	if err := checkDeletes(p, opts); err != nil {
+		return nil, err
+	}
+	if err := c.checkSizes(p, opts); err != nil {
+		return nil, err
+	}
+	if opts.Quotas != nil {
+		if err := c.checkQuotas(ctx, p, opts.Quotas); err != nil {
+			return nil, err
+		}
+	}
+	var trash, history Plan
+	var kept []string
+	if opts.Trash != nil {
+		var err error
+		if trash, err = c.planTrash(p, opts.Trash); err != nil {
+			return nil, err
+		}
+	}
+	if opts.History != nil {
+		var err error
+		if history, kept, err = c.planHistory(p, opts.History, opts.Trash); err != nil {
+			return nil, err
+		}
+	}
+	saved := append(history, trash...)
+	p = append(saved, p...)
+	if opts.Atomic {
+		// the history and the trash go in the same transaction
+		if err := c.checkAtomic(p); err != nil {
+			return nil, err
+		}
+	}
+	res := &Result{Plan: p}
+	if opts.DryRun {
+		return res, nil
+	}
+	if _, ro := c.Backend.(readOnly); ro {
+		// rather than apply what the backend allows, which would be
+		// nothing remote and possibly something local
+		for _, o := range p {
+			if o.Kind.remote() {
+				return nil, fmt.Errorf("%s %s: %w", o.Kind, o.Target, ErrReadOnly)
+			}
+		}
+	}
+	if opts.BeforeApply != nil && len(p) > 0 {
+		if err := opts.BeforeApply(p); err != nil {
+			return nil, err
+		}
+	}
+	if opts.CheckVersion {
+		// as late as can be, the pre-apply hook may have taken a while
+		if err := c.checkVersions(p); err != nil {
+			return nil, err
+		}
+		ctx, opts.stop = context.WithCancel(ctx)
+		defer opts.stop()
+	}
+	if opts.Journal != nil {
+		if err := opts.Journal.begin(p); err != nil {
+			c.logger().Warn("Could not start journal, the run cannot be resumed", "err", err)
+			opts.Journal = nil
+		}
+	}
+	if opts.Atomic {
+		res.Failed = c.applyAtomic(ctx, p, opts)
+	} else {
+		if len(saved) > 0 {
+			// nothing is changed unless it is safe in the history or the
+			// trash
+			if failed := c.apply(ctx, saved, opts); len(failed) > 0 {
+				return nil, fmt.Errorf("saving to the history or the trash, nothing was changed: %w", failed[0])
+			}
+		}
+		res.Failed = c.apply(ctx, p[len(saved):], opts)
+	}
+	if len(trash) > 0 {
+		c.expireTrash(ctx, opts.Trash)
+	}
+	if len(kept) > 0 {
+		c.expireHistory(ctx, opts.History, kept)
+	}
+	return res, nil
+}
+
+// Upload copies the tree at localPath to remotePath, overwriting remote
+// files that differ. With opts.Prune, remote nodes gone from localPath are
+// deleted once everything else is uploaded.
+func (c *Client) Upload(ctx context.Context, localPath, remotePath string, opts Options) (*Result, error) {
+	if _, ok := c.Backend.(ACLBackend); (opts.ACLs != nil || opts.ModeACLs) && !ok {
+		return nil, ErrUnsupported
+	}
+	var p Plan
+	if opts.Clean {
+		deletePlan, err := c.planDelete(ctx, remotePath)
+		if err != nil {
+			return nil, err
+		}
+		p = deletePlan
+	}
+	uploadPlan, err := c.planUpload(ctx, remotePath, localPath, "", opts)
+	if err != nil {
+		return nil, err
+	}
+	p = append(p, uploadPlan...)
+	if opts.Prune && !opts.Clean {
+		absLocal, err := filepath.Abs(localPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkPrunable(remotePath, opts); err != nil {
+			return nil, err
+		}
+		prunePlan, err := c.planPruneRemote(ctx, remotePath, absLocal, "", opts, newIgnorer(absLocal, opts.Names))
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, prunePlan...)
+	}
+	return c.run(ctx, p, opts)
+}
+
+// Download copies the tree at remotePath to localPath, overwriting local
+// files that differ. With opts.Prune, local files gone from remotePath are
+// removed once everything else is downloaded.
+func (c *Client) Download(ctx context.Context, localPath, remotePath string, opts Options) (*Result, error) {
+	if _, ok := c.Backend.(ACLBackend); opts.ModeACLs && !ok {
+		return nil, ErrUnsupported
+	}
+	absLocal, err := filepath.Abs(localPath)
+	if err != nil {
+		return nil, err
+	}
+	opts.cursor = c.openCursor(absLocal, remotePath, opts)
+	p, err := c.planDownload(ctx, remotePath, localPath, "", opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Prune {
+		prunePlan, err := c.planPruneLocal(ctx, remotePath, absLocal, "", opts, newIgnorer(absLocal, opts.Names))
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, prunePlan...)
+	}
+	if opts.Ephemeral {
+		listPlan, err := c.planEphemeralFile(ctx, remotePath, absLocal, opts)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, listPlan...)
+	}
+	if opts.Metadata {
+		metaPlan, err := c.planMetadataFile(ctx, remotePath, absLocal, opts)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, metaPlan...)
+	}
+	if opts.Redact != nil && !opts.Redact.Skip {
+		redactPlan, err := c.planRedactFile(remotePath, absLocal, opts)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, redactPlan...)
+	}
+	res, err := c.run(ctx, p, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.cursor != nil && !opts.DryRun && len(res.Failed) == 0 {
+		if fInfo, err := os.Stat(absLocal); err == nil && fInfo.IsDir() {
+			if err := opts.cursor.write(absLocal, remotePath); err != nil {
+				c.logger().Warn("Could not record download cursor", "path", absLocal, "err", err)
+			}
+		}
+	}
+	return res, nil
+}
+
+// Delete removes remotePath and everything below it.
+func (c *Client) Delete(ctx context.Context, remotePath string, opts Options) (*Result, error) {
+	p, err := c.planDelete(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	return c.run(ctx, p, opts)
+}
+
+// Sync transfers whatever differs between localPath and remotePath in
+// whichever direction opts.Policy says, or, with opts.ThreeWay, whichever
+// side changed since the last sync. Three-way syncs record the new base in
+// the StateFile unless a change failed.
+func (c *Client) Sync(ctx context.Context, localPath, remotePath string, opts Options) (*Result, error) {
+	opts.Template, opts.Vault = nil, nil
+	absLocal, err := filepath.Abs(localPath)
+	if err != nil {
+		return nil, err
+	}
+	var base *syncState
+	if opts.ThreeWay {
+		if base, err = c.readSyncState(absLocal, remotePath); err != nil {
+			return nil, fmt.Errorf("reading sync state: %w", err)
+		}
+	}
+	diffs, err := c.Diff(ctx, absLocal, remotePath, opts)
+	if err != nil {
+		return nil, err
+	}
+	p, conflicts, kept, err := c.planSync(ctx, diffs, base, newIgnorer(absLocal, opts.Names), opts)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.run(ctx, p, opts)
+	if err != nil {
+		return nil, err
+	}
+	res.Conflicts = conflicts
+	if opts.ThreeWay && !opts.DryRun && len(res.Failed) == 0 {
+		if err := c.writeSyncState(absLocal, remotePath, base, kept, opts); err != nil {
+			c.logger().Warn("Could not record sync state", "path", absLocal, "err", err)
+		}
+	}
+	return res, nil
+}
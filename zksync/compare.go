@@ -0,0 +1,62 @@
+package zksync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// CompareMode decides how a local file is found to differ from the remote
+// one.
+type CompareMode string
+
+const (
+	// CompareChecksum compares SHA-256 sums of the contents.
+	CompareChecksum CompareMode = "checksum"
+	// CompareMtime takes files with equal mtimes to be the same, and only
+	// compares checksums when the mtimes differ or are unknown.
+	CompareMtime CompareMode = "mtime"
+)
+
+// ParseCompareMode checks s names a known mode.
+func ParseCompareMode(s string) (CompareMode, error) {
+	switch m := CompareMode(s); m {
+	case CompareChecksum, CompareMtime:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown compare mode: %s", s)
+}
+
+func fileChecksum(p string) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// sameContents reports whether the local file at p, described by fInfo,
+// holds data.
+func sameContents(p string, fInfo os.FileInfo, data []byte, mtime time.Time, mode CompareMode) (bool, error) {
+	if mode == CompareMtime && !mtime.IsZero() && mtime.Equal(fInfo.ModTime()) {
+		return true, nil
+	}
+	if fInfo.Size() != int64(len(data)) {
+		return false, nil
+	}
+	sum, err := fileChecksum(p)
+	if err != nil {
+		return false, err
+	}
+	remoteSum := sha256.Sum256(data)
+	return bytes.Equal(sum, remoteSum[:]), nil
+}
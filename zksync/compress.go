@@ -0,0 +1,43 @@
+package zksync
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+)
+
+// gzipMagic marks node data as gzipped by Options.Compress, so that files
+// that happen to be gzip themselves are left alone.
+var gzipMagic = []byte("\x00configurator-gzip\x00")
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(gzipMagic)
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress undoes compress on the data of node p, returning data that was
+// not compressed as it is.
+func decompress(p string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, gzipMagic) {
+		return data, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data[len(gzipMagic):]))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %s: %w", p, err)
+	}
+	defer zr.Close()
+	whole, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %s: %w", p, err)
+	}
+	return whole, nil
+}
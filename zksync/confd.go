@@ -0,0 +1,186 @@
+package zksync
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ConfdResource is a template resource of confd, as its conf.d dir holds
+// them, so that what was set up for confd can be rendered as it is.
+type ConfdResource struct {
+	// File is the .toml file the resource was read from.
+	File      string
+	Src       string   `toml:"src"`
+	Dest      string   `toml:"dest"`
+	Keys      []string `toml:"keys"`
+	Prefix    string   `toml:"prefix"`
+	Mode      string   `toml:"mode"`
+	UID       *int     `toml:"uid"`
+	GID       *int     `toml:"gid"`
+	CheckCmd  string   `toml:"check_cmd"`
+	ReloadCmd string   `toml:"reload_cmd"`
+}
+
+// LoadConfdResources reads the template resources in the conf.d dir of
+// confdir, sorted by file name.
+func LoadConfdResources(confdir string) ([]ConfdResource, error) {
+	files, err := filepath.Glob(filepath.Join(confdir, "conf.d", "*.toml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	var resources []ConfdResource
+	for _, file := range files {
+		var doc struct {
+			Template ConfdResource `toml:"template"`
+		}
+		if _, err := toml.DecodeFile(file, &doc); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", file, err)
+		}
+		r := doc.Template
+		r.File = file
+		if r.Src == "" || r.Dest == "" {
+			return nil, fmt.Errorf("%s: a template resource needs src and dest", file)
+		}
+		if len(r.Keys) == 0 {
+			return nil, fmt.Errorf("%s: a template resource needs keys", file)
+		}
+		if !filepath.IsAbs(r.Src) {
+			r.Src = filepath.Join(confdir, "templates", r.Src)
+		}
+		resources = append(resources, r)
+	}
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("no template resources in %s", filepath.Join(confdir, "conf.d"))
+	}
+	return resources, nil
+}
+
+// Target returns the RenderTarget rendering r, its keys read below its
+// prefix.
+func (r ConfdResource) Target() (RenderTarget, error) {
+	t := RenderTarget{Template: r.Src, Dest: r.Dest, Keys: r.Keys, Check: r.CheckCmd, Reload: r.ReloadCmd, Confd: true}
+	if r.Mode != "" {
+		mode, err := strconv.ParseUint(r.Mode, 8, 32)
+		if err != nil {
+			return RenderTarget{}, fmt.Errorf("%s: bad mode %q: %w", r.File, r.Mode, err)
+		}
+		t.Mode = os.FileMode(mode)
+	}
+	return t, nil
+}
+
+// confdFuncs are the functions confd gives templates, where get and gets
+// return keys along with their values.
+func (t *renderTree) confdFuncs() template.FuncMap {
+	funcs := t.funcs()
+	confd := template.FuncMap{
+		"get": func(key string) (KeyValue, error) {
+			key = path.Join("/", key)
+			v, ok := t.values[key]
+			if !ok {
+				return KeyValue{}, fmt.Errorf("key does not exist: %s", key)
+			}
+			return KeyValue{Key: key, Value: v}, nil
+		},
+		"jsonArray": func(s string) ([]interface{}, error) {
+			var v []interface{}
+			err := json.Unmarshal([]byte(s), &v)
+			return v, err
+		},
+		"map": func(kv ...interface{}) (map[string]interface{}, error) {
+			if len(kv)%2 != 0 {
+				return nil, fmt.Errorf("map needs pairs of keys and values")
+			}
+			m := make(map[string]interface{}, len(kv)/2)
+			for i := 0; i < len(kv); i += 2 {
+				k, ok := kv[i].(string)
+				if !ok {
+					return nil, fmt.Errorf("map keys must be strings")
+				}
+				m[k] = kv[i+1]
+			}
+			return m, nil
+		},
+		"datetime":     time.Now,
+		"toUpper":      strings.ToUpper,
+		"toLower":      strings.ToLower,
+		"contains":     strings.Contains,
+		"replace":      strings.Replace,
+		"trimSuffix":   strings.TrimSuffix,
+		"getenv":       confdGetenv,
+		"lookupIP":     confdLookupIP,
+		"fileExists":   confdFileExists,
+		"base64Encode": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"base64Decode": func(s string) (string, error) {
+			b, err := base64.StdEncoding.DecodeString(s)
+			return string(b), err
+		},
+		"parseBool": strconv.ParseBool,
+		"atoi":      strconv.Atoi,
+		"add":       func(a, b int) int { return a + b },
+		"sub":       func(a, b int) int { return a - b },
+		"mul":       func(a, b int) int { return a * b },
+		"div":       func(a, b int) int { return a / b },
+		"mod":       func(a, b int) int { return a % b },
+		"seq": func(first, last int) []int {
+			var s []int
+			for i := first; i <= last; i++ {
+				s = append(s, i)
+			}
+			return s
+		},
+		"reverse": func(s []string) []string {
+			r := make([]string, len(s))
+			for i, v := range s {
+				r[len(s)-1-i] = v
+			}
+			return r
+		},
+	}
+	for name, f := range confd {
+		funcs[name] = f
+	}
+	return funcs
+}
+
+// confdGetenv returns the environment variable key, or the default given
+// when it is unset or empty.
+func confdGetenv(key string, def ...string) string {
+	if v := os.Getenv(key); v != "" || len(def) == 0 {
+		return v
+	}
+	return def[0]
+}
+
+// confdLookupIP resolves a host name to its addresses, sorted, none if it
+// does not resolve.
+func confdLookupIP(host string) []string {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = ip.String()
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func confdFileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
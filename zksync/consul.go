@@ -0,0 +1,289 @@
+package zksync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// consulBackend maps the tree onto Consul's KV store. Keys have no leading
+// slash, and dirs are stored the way the Consul UI stores folders, as keys
+// with a trailing slash.
+type consulBackend struct {
+	c  *api.Client
+	kv *api.KV
+}
+
+func newConsulBackend(cfg BackendConfig) (*consulBackend, error) {
+	// Consul clients talk to a single agent
+	address := strings.Split(cfg.Servers, ",")[0]
+	if !strings.Contains(address, ":") {
+		address += ":8500"
+	}
+
+	consulCfg := &api.Config{
+		Address:    address,
+		Token:      cfg.Auth,
+		Datacenter: cfg.Datacenter,
+	}
+	if cfg.TLS.enabled() {
+		consulCfg.Scheme = "https"
+		consulCfg.TLSConfig = api.TLSConfig{
+			CertFile: cfg.TLS.CertFile,
+			KeyFile:  cfg.TLS.KeyFile,
+			CAFile:   cfg.TLS.CAFile,
+		}
+	}
+	c, err := api.NewClient(consulCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// the client connects lazily, make sure the agent is really there
+	if _, _, err := c.KV().Keys("", "/", &api.QueryOptions{}); err != nil {
+		if err := consulError(err); errors.Is(err, ErrNoAuth) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrNoSession, err)
+	}
+	return &consulBackend{c: c, kv: c.KV()}, nil
+}
+
+func consulError(err error) error {
+	var statusErr api.StatusError
+	if errors.As(err, &statusErr) && statusErr.Code == 403 {
+		return fmt.Errorf("%w: %v", ErrNoAuth, err)
+	}
+	return err
+}
+
+func consulKey(p string) string {
+	return strings.Trim(p, "/")
+}
+
+// consulDirKey is the folder key for p, which is also the prefix of all its
+// descendants.
+func consulDirKey(p string) string {
+	if key := consulKey(p); key != "" {
+		return key + "/"
+	}
+	return ""
+}
+
+func (b *consulBackend) Get(p string) ([]byte, *Stat, error) {
+	if key := consulKey(p); key != "" {
+		pair, _, err := b.kv.Get(key, nil)
+		if err != nil {
+			return nil, nil, consulError(err)
+		}
+		if pair != nil {
+			return pair.Value, &Stat{Version: int64(pair.ModifyIndex), DataLength: len(pair.Value), Ephemeral: pair.Session != "", Czxid: int64(pair.CreateIndex), Mzxid: int64(pair.ModifyIndex)}, nil
+		}
+	}
+
+	keys, _, err := b.kv.Keys(consulDirKey(p), "", nil)
+	if err != nil {
+		return nil, nil, consulError(err)
+	}
+	stat := &Stat{}
+	for _, key := range keys {
+		if key == consulDirKey(p) {
+			folder, _, err := b.kv.Get(key, nil)
+			if err != nil {
+				return nil, nil, consulError(err)
+			}
+			if folder != nil {
+				stat.Version = int64(folder.ModifyIndex)
+				stat.Czxid, stat.Mzxid = int64(folder.CreateIndex), int64(folder.ModifyIndex)
+			}
+		} else {
+			stat.NumChildren++
+		}
+	}
+	if len(keys) == 0 && consulKey(p) != "" {
+		return nil, nil, ErrNoNode
+	}
+	return nil, stat, nil
+}
+
+func (b *consulBackend) List(p string) ([]string, *Stat, error) {
+	_, stat, err := b.Get(p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prefix := consulDirKey(p)
+	keys, _, err := b.kv.Keys(prefix, "/", nil)
+	if err != nil {
+		return nil, nil, consulError(err)
+	}
+
+	var children []string
+	for _, key := range keys {
+		if name := strings.TrimSuffix(strings.TrimPrefix(key, prefix), "/"); name != "" {
+			children = append(children, name)
+		}
+	}
+	stat.NumChildren = len(children)
+	return children, stat, nil
+}
+
+func (b *consulBackend) Create(p string, data []byte) error {
+	key := consulKey(p)
+	if len(data) == 0 {
+		key = consulDirKey(p)
+	}
+	ok, _, err := b.kv.CAS(&api.KVPair{Key: key, Value: data, ModifyIndex: 0}, nil)
+	if err != nil {
+		return consulError(err)
+	}
+	if !ok {
+		return ErrNodeExists
+	}
+	return nil
+}
+
+func (b *consulBackend) Set(p string, data []byte, version int64) error {
+	pair := &api.KVPair{Key: consulKey(p), Value: data}
+	if version < 0 {
+		_, err := b.kv.Put(pair, nil)
+		return consulError(err)
+	}
+
+	pair.ModifyIndex = uint64(version)
+	ok, _, err := b.kv.CAS(pair, nil)
+	if err != nil {
+		return consulError(err)
+	}
+	if !ok {
+		return ErrBadVersion
+	}
+	return nil
+}
+
+func (b *consulBackend) Delete(p string, version int64) error {
+	pair, _, err := b.kv.Get(consulKey(p), nil)
+	if err != nil {
+		return consulError(err)
+	}
+	key := consulKey(p)
+	if pair == nil {
+		key = consulDirKey(p)
+		if pair, _, err = b.kv.Get(key, nil); err != nil {
+			return consulError(err)
+		}
+		if pair == nil {
+			// a dir only implied by its children, nothing to remove
+			return nil
+		}
+	}
+
+	ok, _, err := b.kv.DeleteCAS(&api.KVPair{Key: key, ModifyIndex: uint64(version)}, nil)
+	if err != nil {
+		return consulError(err)
+	}
+	if !ok {
+		return ErrBadVersion
+	}
+	return nil
+}
+
+// Watch uses Consul blocking queries on the whole prefix and diffs the
+// results to work out what changed.
+func (b *consulBackend) Watch(ctx context.Context, p string) (<-chan Event, error) {
+	prefix := consulDirKey(p)
+	pairs, meta, err := b.kv.List(prefix, nil)
+	if err != nil {
+		return nil, consulError(err)
+	}
+	known := consulIndexes(pairs)
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		send := func(ev Event) bool {
+			select {
+			case events <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		lastIndex := meta.LastIndex
+		for {
+			q := (&api.QueryOptions{WaitIndex: lastIndex}).WithContext(ctx)
+			pairs, meta, err := b.kv.List(prefix, q)
+			if err != nil {
+				if ctx.Err() == nil {
+					send(Event{Path: p, Err: consulError(err)})
+				}
+				return
+			}
+			lastIndex = meta.LastIndex
+
+			current := consulIndexes(pairs)
+			for key, index := range current {
+				if old, ok := known[key]; !ok {
+					if !send(Event{Type: EventCreated, Path: "/" + strings.TrimSuffix(key, "/")}) {
+						return
+					}
+				} else if old != index {
+					if !send(Event{Type: EventChanged, Path: "/" + strings.TrimSuffix(key, "/")}) {
+						return
+					}
+				}
+			}
+			for key := range known {
+				if _, ok := current[key]; !ok {
+					if !send(Event{Type: EventDeleted, Path: "/" + strings.TrimSuffix(key, "/")}) {
+						return
+					}
+				}
+			}
+			known = current
+		}
+	}()
+	return events, nil
+}
+
+func consulIndexes(pairs api.KVPairs) map[string]uint64 {
+	indexes := make(map[string]uint64, len(pairs))
+	for _, pair := range pairs {
+		indexes[pair.Key] = pair.ModifyIndex
+	}
+	return indexes
+}
+
+// Lock holds a Consul lock on the key for p, which records the holder.
+// The key is removed once the lock is released, unless another run is
+// waiting for it.
+func (b *consulBackend) Lock(ctx context.Context, p, holder string) (func(), error) {
+	key := consulKey(p)
+	lock, err := b.c.LockOpts(&api.LockOptions{Key: key, Value: []byte(holder), SessionName: "configurator"})
+	if err != nil {
+		return nil, consulError(err)
+	}
+	lost, err := lock.Lock(ctx.Done())
+	if err != nil {
+		return nil, consulError(err)
+	}
+	if lost == nil {
+		if ctx.Err() == context.Canceled {
+			return nil, ctx.Err()
+		}
+		if pair, _, err := b.kv.Get(key, nil); err == nil && pair != nil {
+			return nil, lockedBy(string(pair.Value))
+		}
+		return nil, ErrLocked
+	}
+	return func() {
+		lock.Unlock()
+		lock.Destroy()
+	}, nil
+}
+
+func (b *consulBackend) Close() {}
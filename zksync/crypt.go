@@ -0,0 +1,108 @@
+package zksync
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// cryptMagic marks node data as encrypted, and is followed by the id of the
+// key, the nonce and the sealed data.
+var cryptMagic = []byte("\x00configurator-aes-gcm\x00")
+
+const keyIDSize = 8
+
+// ErrNoKey is returned for encrypted nodes when the client has no key.
+var ErrNoKey = errors.New("node is encrypted and no key was given")
+
+// Encryption encrypts files with AES-GCM before they are stored, so that
+// what they hold is never in plain text on the server. Files are
+// compressed, if at all, before being encrypted, and split into chunks
+// after.
+type Encryption struct {
+	aead     cipher.AEAD
+	keyID    []byte
+	patterns []pattern
+}
+
+// NewEncryption returns an Encryption with key, which must be 16, 24 or 32
+// bytes long, encrypting the files matching patterns. Patterns are matched
+// like Filter patterns, against whole remote paths without the leading
+// slash. Without patterns nothing is encrypted, but encrypted nodes can
+// still be read.
+func NewEncryption(key []byte, patterns []string) (*Encryption, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := compilePatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &Encryption{aead: aead, keyID: sum[:keyIDSize], patterns: compiled}, nil
+}
+
+// ParseKey decodes a key written in base64, as from
+// "head -c 32 /dev/urandom | base64".
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("key is not base64: %w", err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("key is %d bytes, want 16, 24 or 32", len(key))
+}
+
+// encrypts reports whether the node at p is to be stored encrypted.
+func (e *Encryption) encrypts(p string) bool {
+	return e != nil && matchAny(e.patterns, strings.TrimPrefix(p, "/"))
+}
+
+func (e *Encryption) encrypt(data []byte) ([]byte, error) {
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(cryptMagic)+keyIDSize+len(nonce)+len(data)+e.aead.Overhead())
+	out = append(append(append(out, cryptMagic...), e.keyID...), nonce...)
+	return e.aead.Seal(out, nonce, data, nil), nil
+}
+
+// decrypt undoes encrypt on the data of node p, returning data that was
+// not encrypted as it is.
+func (e *Encryption) decrypt(p string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, cryptMagic) {
+		return data, nil
+	}
+	if e == nil {
+		return nil, fmt.Errorf("%s: %w", p, ErrNoKey)
+	}
+	data = data[len(cryptMagic):]
+	nonceSize := e.aead.NonceSize()
+	if len(data) < keyIDSize+nonceSize {
+		return nil, fmt.Errorf("decrypting %s: data too short", p)
+	}
+	if !bytes.Equal(data[:keyIDSize], e.keyID) {
+		return nil, fmt.Errorf("decrypting %s: encrypted with another key", p)
+	}
+	data = data[keyIDSize:]
+	plain, err := e.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s: %w", p, err)
+	}
+	return plain, nil
+}
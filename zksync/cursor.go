@@ -0,0 +1,144 @@
+package zksync
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// CursorFile records, at the root of a local tree downloaded with
+// Options.Cursor, the mzxid every file had when it was last downloaded,
+// and what was written for it, so that the next download only fetches
+// the files changed since.
+const CursorFile = ".zkcursor"
+
+type cursorEntry struct {
+	Mzxid  int64  `json:"mzxid"`
+	SHA256 string `json:"sha256"`
+}
+
+// cursorState is what the CursorFile holds.
+type cursorState struct {
+	Remote string                 `json:"remote"`
+	Files  map[string]cursorEntry `json:"files"`
+}
+
+// cursor tracks, over a download, which files have not changed since the
+// last one and what the next should know.
+type cursor struct {
+	stater Stater
+	old    map[string]cursorEntry
+
+	mu    sync.Mutex
+	stats map[string]*Stat // read ahead by readStats, by remote path
+	next  map[string]cursorEntry
+}
+
+// openCursor reads the CursorFile of the tree at root, returning a cursor
+// that skips nothing if there is none, it was recorded for another remote,
+// or the backend cannot read stats alone. Nil means opts do not ask for
+// one, or allow one: resolved secrets and modes from ACLs can change with
+// no new mzxid.
+func (c *Client) openCursor(root, remote string, opts Options) *cursor {
+	if !opts.Cursor || opts.Vault.onDownload() || opts.ModeACLs {
+		return nil
+	}
+	cur := &cursor{old: make(map[string]cursorEntry), stats: make(map[string]*Stat), next: make(map[string]cursorEntry)}
+	cur.stater, _ = c.Backend.(Stater)
+	data, err := ioutil.ReadFile(filepath.Join(root, CursorFile))
+	if err != nil {
+		return cur
+	}
+	var state cursorState
+	if err := json.Unmarshal(data, &state); err != nil {
+		c.logger().Warn("Ignoring unreadable cursor", "file", filepath.Join(root, CursorFile), "err", err)
+		return cur
+	}
+	if state.Remote == remote && cur.stater != nil {
+		cur.old = state.Files
+	}
+	return cur
+}
+
+// readStats reads ahead, batchwise, the stats of the children of dir that
+// the last download recorded.
+func (cur *cursor) readStats(dir, rel string, children []string) {
+	if cur == nil || cur.stater == nil {
+		return
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, readAhead)
+	for _, child := range children {
+		if _, ok := cur.old[path.Join(rel, child)]; !ok {
+			continue
+		}
+		p := path.Join(dir, child)
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			if stat, err := cur.stater.Stat(p); err == nil {
+				cur.mu.Lock()
+				cur.stats[p] = stat
+				cur.mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// unchanged reports whether the file at rel, serverPrefix remotely and
+// localPrefix locally, is as the last download left it on both sides, in
+// which case it is carried over to the next CursorFile.
+func (cur *cursor) unchanged(serverPrefix, localPrefix, rel string) bool {
+	if cur == nil || cur.stater == nil {
+		return false
+	}
+	entry, ok := cur.old[rel]
+	if !ok {
+		return false
+	}
+	cur.mu.Lock()
+	stat := cur.stats[serverPrefix]
+	cur.mu.Unlock()
+	if stat == nil {
+		var err error
+		if stat, err = cur.stater.Stat(serverPrefix); err != nil {
+			return false
+		}
+	}
+	if stat.Mzxid != entry.Mzxid || stat.Mzxid == 0 {
+		return false
+	}
+	data, err := ioutil.ReadFile(localPrefix)
+	if err != nil || hashData(data) != entry.SHA256 {
+		return false
+	}
+	cur.record(rel, entry.Mzxid, entry.SHA256)
+	return true
+}
+
+func (cur *cursor) record(rel string, mzxid int64, sha string) {
+	if cur == nil || mzxid == 0 {
+		return
+	}
+	cur.mu.Lock()
+	cur.next[rel] = cursorEntry{Mzxid: mzxid, SHA256: sha}
+	cur.mu.Unlock()
+}
+
+// write replaces the CursorFile of the tree at root.
+func (cur *cursor) write(root, remote string) error {
+	data, err := json.Marshal(cursorState{Remote: remote, Files: cur.next})
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(root, CursorFile+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(root, CursorFile))
+}
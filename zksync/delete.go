@@ -0,0 +1,33 @@
+package zksync
+
+import (
+	"context"
+	"fmt"
+	"path"
+)
+
+func (c *Client) planDelete(ctx context.Context, serverPrefix string) (Plan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	children, stat, err := c.Backend.List(serverPrefix)
+	if err != nil {
+		if err == ErrNoNode {
+			c.logger().Debug("Path not there", "path", serverPrefix)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("listing %s: %w", serverPrefix, err)
+	}
+
+	var p Plan
+	for _, child := range children {
+		fullpath := path.Join(serverPrefix, child)
+		childPlan, err := c.planDelete(ctx, fullpath)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, childPlan...)
+	}
+
+	return append(p, Op{Kind: OpDelete, Target: serverPrefix, OldSize: stat.DataLength, Version: stat.Version}), nil
+}
@@ -0,0 +1,169 @@
+package zksync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DeployNode holds, at the root of a tree deployed from git, what was
+// deployed there. Uploads, downloads and diffs leave it alone.
+const DeployNode = ".deployed"
+
+// Deployment is what DeployNode records of a deploy.
+type Deployment struct {
+	Repo   string    `json:"repo" yaml:"repo"`
+	Ref    string    `json:"ref" yaml:"ref"`
+	Commit string    `json:"commit" yaml:"commit"`
+	Dir    string    `json:"dir,omitempty" yaml:"dir,omitempty"`
+	Time   time.Time `json:"time" yaml:"time"`
+	User   string    `json:"user,omitempty" yaml:"user,omitempty"`
+}
+
+// Deploy uploads the tree at ref of the git repository repo, a path or a
+// URL, to remotePath, as Upload would, then records the commit in
+// remotePath/.deployed. dir, if not empty, is the subdir of the repository
+// to deploy. The commit is not recorded if anything fails, nor on dry runs.
+// Only the commit is fetched, so the git command must be able to fetch it
+// by name, as it can tags and branches and, from most servers, full SHAs.
+func (c *Client) Deploy(ctx context.Context, repo, ref, dir, remotePath string, opts Options) (*Result, *Deployment, error) {
+	tmp, err := ioutil.TempDir("", "configurator-deploy-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	d := &Deployment{Repo: repo, Ref: ref, Dir: dir}
+	if d.Commit, err = checkout(ctx, repo, ref, tmp); err != nil {
+		return nil, nil, err
+	}
+	c.logger().Info("Checked out", "repo", repo, "ref", ref, "commit", d.Commit)
+	tree := filepath.Join(tmp, "tree", filepath.FromSlash(dir))
+	if fInfo, err := os.Stat(tree); err != nil || !fInfo.IsDir() {
+		return nil, nil, fmt.Errorf("%s has no dir %s at %s", repo, dir, ref)
+	}
+
+	res, err := c.Upload(ctx, tree, remotePath, opts)
+	if err != nil || opts.DryRun || len(res.Failed) > 0 {
+		return res, d, err
+	}
+	d.Time = time.Now().UTC()
+	if u, err := user.Current(); err == nil {
+		d.User = u.Username
+	}
+	record, err := c.planRecordDeploy(remotePath, d)
+	if err != nil {
+		return res, d, err
+	}
+	res.Plan = append(res.Plan, record...)
+	res.Failed = c.apply(ctx, record, opts)
+	return res, d, nil
+}
+
+// DiffGit compares the tree at ref of the git repository repo, or its
+// subdir dir, with the one at remotePath, as Diff compares a local tree,
+// returning the commit compared against too.
+func (c *Client) DiffGit(ctx context.Context, repo, ref, dir, remotePath string, opts Options) ([]Difference, string, error) {
+	tmp, err := ioutil.TempDir("", "configurator-diff-")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	commit, err := checkout(ctx, repo, ref, tmp)
+	if err != nil {
+		return nil, "", err
+	}
+	tree := filepath.Join(tmp, "tree", filepath.FromSlash(dir))
+	if fInfo, err := os.Stat(tree); err != nil || !fInfo.IsDir() {
+		return nil, "", fmt.Errorf("%s has no dir %s at %s", repo, dir, ref)
+	}
+	diffs, err := c.Diff(ctx, tree, remotePath, opts)
+	return diffs, commit, err
+}
+
+// checkout fetches ref from repo into a repository under tmp and checks it
+// out in tmp/tree, returning the commit.
+func checkout(ctx context.Context, repo, ref, tmp string) (string, error) {
+	if _, err := os.Stat(repo); err == nil {
+		// git runs elsewhere, so relative paths would not be found
+		if repo, err = filepath.Abs(repo); err != nil {
+			return "", err
+		}
+	}
+	gitDir := filepath.Join(tmp, "git")
+	workTree := filepath.Join(tmp, "tree")
+	if err := os.Mkdir(workTree, 0755); err != nil {
+		return "", err
+	}
+	steps := [][]string{
+		{"init", "-q", "--bare", gitDir},
+		{"--git-dir", gitDir, "fetch", "-q", "--depth", "1", repo, ref},
+		{"--git-dir", gitDir, "--work-tree", workTree, "checkout", "-q", "-f", "FETCH_HEAD", "--", "."},
+	}
+	for _, args := range steps {
+		if _, err := git(ctx, args...); err != nil {
+			return "", err
+		}
+	}
+	commit, err := git(ctx, "--git-dir", gitDir, "rev-parse", "FETCH_HEAD^{commit}")
+	if err != nil {
+		return "", err
+	}
+	return commit, nil
+}
+
+func git(ctx context.Context, args ...string) (string, error) {
+	var out, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Stdout, cmd.Stderr = &out, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[len(args)-1], err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// planRecordDeploy plans writing d to remotePath/.deployed.
+func (c *Client) planRecordDeploy(remotePath string, d *Deployment) (Plan, error) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	target := path.Join(remotePath, DeployNode)
+	_, stat, err := c.Backend.Get(target)
+	if err == ErrNoNode {
+		return Plan{{Kind: OpCreate, Source: d.Commit, Target: target, Data: data}}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", target, err)
+	}
+	return Plan{{Kind: OpSet, Source: d.Commit, Target: target, Data: data, OldSize: stat.DataLength, Version: stat.Version}}, nil
+}
+
+// Deployed returns what was last deployed to remotePath from git, nil if
+// nothing was.
+func (c *Client) Deployed(remotePath string) (*Deployment, error) {
+	target := path.Join(remotePath, DeployNode)
+	data, _, err := c.Backend.Get(target)
+	if err == ErrNoNode {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", target, err)
+	}
+	var d Deployment
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", target, err)
+	}
+	return &d, nil
+}
@@ -0,0 +1,267 @@
+package zksync
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// diffContext is how many unchanged lines surround each change.
+const diffContext = 3
+
+type editKind int
+
+const (
+	editEqual editKind = iota
+	editDelete
+	editInsert
+)
+
+// edit is one line of an edit script, x and y being where it sits in the
+// old and new lines.
+type edit struct {
+	kind editKind
+	x, y int
+}
+
+// diffLines finds the shortest edit script turning a into b with Myers'
+// algorithm.
+func diffLines(a, b []string) []edit {
+	n, m := len(a), len(b)
+	offset := n + m
+	v := make([]int, 2*offset+2)
+	var trace [][]int
+
+	var d int
+search:
+	for d = 0; d <= offset; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// walk back from the end, each trace entry being where the furthest
+	// reaching paths were before that step
+	var edits []edit
+	x, y := n, m
+	for ; d > 0; d-- {
+		prev := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && prev[k-1+d] < prev[k+1+d]) {
+			prevK = k + 1
+		}
+		prevX := prev[prevK+d]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, edit{editEqual, x, y})
+		}
+		if x == prevX {
+			y--
+			edits = append(edits, edit{editInsert, x, y})
+		} else {
+			x--
+			edits = append(edits, edit{editDelete, x, y})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		edits = append(edits, edit{editEqual, x, y})
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+func splitLines(data []byte) []string {
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func writeLine(w io.Writer, prefix byte, line string) error {
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n\\ No newline at end of file\n"
+	}
+	_, err := fmt.Fprintf(w, "%c%s", prefix, line)
+	return err
+}
+
+// hunkRange formats a hunk header range the way diff -u does, where an
+// empty range gives the line before it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// WriteUnified writes a unified diff turning a into b, or nothing if they
+// are the same. Data that looks binary is only reported as differing.
+func WriteUnified(w io.Writer, aName, bName string, a, b []byte) error {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	if bytes.IndexByte(a, 0) >= 0 || bytes.IndexByte(b, 0) >= 0 {
+		_, err := fmt.Fprintf(w, "Binary files %s and %s differ\n", aName, bName)
+		return err
+	}
+
+	aLines, bLines := splitLines(a), splitLines(b)
+	edits := diffLines(aLines, bLines)
+	if _, err := fmt.Fprintf(w, "--- %s\n+++ %s\n", aName, bName); err != nil {
+		return err
+	}
+
+	for i := 0; i < len(edits); {
+		if edits[i].kind == editEqual {
+			i++
+			continue
+		}
+
+		// take in every change less than two contexts apart
+		last := i
+		for j := i; j < len(edits); j++ {
+			if edits[j].kind != editEqual {
+				last = j
+			} else if j-last > 2*diffContext {
+				break
+			}
+		}
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := last + diffContext + 1
+		if end > len(edits) {
+			end = len(edits)
+		}
+		hunk := edits[start:end]
+
+		aCount, bCount := 0, 0
+		for _, e := range hunk {
+			if e.kind != editInsert {
+				aCount++
+			}
+			if e.kind != editDelete {
+				bCount++
+			}
+		}
+		if _, err := fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(hunk[0].x, aCount), hunkRange(hunk[0].y, bCount)); err != nil {
+			return err
+		}
+		for _, e := range hunk {
+			var err error
+			switch e.kind {
+			case editEqual:
+				err = writeLine(w, ' ', aLines[e.x])
+			case editDelete:
+				err = writeLine(w, '-', aLines[e.x])
+			case editInsert:
+				err = writeLine(w, '+', bLines[e.y])
+			}
+			if err != nil {
+				return err
+			}
+		}
+		i = end
+	}
+	return nil
+}
+
+// MarkConflicts merges a and b the way git marks a conflicted merge, with
+// the lines they share as they are and every run of lines that differs
+// between markers naming each side, for someone to settle in an editor.
+func MarkConflicts(aName, bName string, a, b []byte) []byte {
+	aLines, bLines := splitLines(a), splitLines(b)
+	edits := diffLines(aLines, bLines)
+	var buf bytes.Buffer
+	line := func(l string) {
+		buf.WriteString(l)
+		if !strings.HasSuffix(l, "\n") {
+			buf.WriteByte('\n')
+		}
+	}
+	for i := 0; i < len(edits); {
+		if edits[i].kind == editEqual {
+			line(aLines[edits[i].x])
+			i++
+			continue
+		}
+		fmt.Fprintf(&buf, "<<<<<<< %s\n", aName)
+		var theirs []string
+		for ; i < len(edits) && edits[i].kind != editEqual; i++ {
+			if edits[i].kind == editDelete {
+				line(aLines[edits[i].x])
+			} else {
+				theirs = append(theirs, bLines[edits[i].y])
+			}
+		}
+		buf.WriteString("=======\n")
+		for _, l := range theirs {
+			line(l)
+		}
+		fmt.Fprintf(&buf, ">>>>>>> %s\n", bName)
+	}
+	return buf.Bytes()
+}
+
+// WriteDiffs writes a unified diff from the remote to the local contents of
+// every modified file, then lists what is only on one side.
+func WriteDiffs(w io.Writer, diffs []Difference) error {
+	var localOnly, remoteOnly []Difference
+	for _, d := range diffs {
+		switch d.Kind {
+		case Modified:
+			if err := WriteUnified(w, d.RemotePath, d.LocalPath, d.RemoteData, d.LocalData); err != nil {
+				return err
+			}
+		case TypeMismatch:
+			if _, err := fmt.Fprintf(w, "File and dir: %s <-> %s\n", d.LocalPath, d.RemotePath); err != nil {
+				return err
+			}
+		case LocalOnly:
+			localOnly = append(localOnly, d)
+		case RemoteOnly:
+			remoteOnly = append(remoteOnly, d)
+		}
+	}
+
+	for _, d := range localOnly {
+		if _, err := fmt.Fprintf(w, "Only local: %s\n", d.LocalPath); err != nil {
+			return err
+		}
+	}
+	for _, d := range remoteOnly {
+		if _, err := fmt.Fprintf(w, "Only remote: %s\n", d.RemotePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
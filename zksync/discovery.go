@@ -0,0 +1,160 @@
+package zksync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// ZooKeeper Servers starting with these name where to find the ensemble
+// rather than its members: a DNS SRV record, as in
+// srv:_zookeeper._tcp.example.com, an Exhibitor, as in
+// exhibitor:exhibitor.example.com:8080, or any HTTP endpoint listing the
+// members in JSON, as in https://example.com/zookeeper.json.
+const (
+	srvPrefix       = "srv:"
+	exhibitorPrefix = "exhibitor:"
+)
+
+// exhibitorList is where Exhibitor lists the ensemble, below its URL.
+const exhibitorList = "/exhibitor/v1/cluster/list"
+
+// fetchesServers reports whether servers is a URL to fetch the ensemble
+// servers from.
+func fetchesServers(servers string) bool {
+	return strings.HasPrefix(servers, exhibitorPrefix) || strings.HasPrefix(servers, "http://") || strings.HasPrefix(servers, "https://")
+}
+
+// discovery returns how to look up the ensemble servers names, nil if they
+// are the members themselves.
+func discovery(servers string) func() ([]string, error) {
+	switch {
+	case strings.HasPrefix(servers, srvPrefix):
+		name := strings.TrimPrefix(servers, srvPrefix)
+		return func() ([]string, error) { return lookupSRV(name) }
+	case strings.HasPrefix(servers, exhibitorPrefix):
+		u := strings.TrimPrefix(servers, exhibitorPrefix)
+		if !strings.Contains(u, "://") {
+			u = "http://" + u
+		}
+		if parsed, err := url.Parse(u); err == nil && strings.Trim(parsed.Path, "/") == "" {
+			parsed.Path = exhibitorList
+			u = parsed.String()
+		}
+		return func() ([]string, error) { return fetchServers(u) }
+	case fetchesServers(servers):
+		return func() ([]string, error) { return fetchServers(servers) }
+	}
+	return nil
+}
+
+// lookupSRV returns the host:port of every target of the SRV record name.
+func lookupSRV(name string) ([]string, error) {
+	_, addrs, err := net.LookupSRV("", "", name)
+	if err != nil {
+		return nil, fmt.Errorf("looking up servers: %w", err)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("looking up servers: no SRV records for %s", name)
+	}
+	hosts := make([]string, len(addrs))
+	for i, a := range addrs {
+		hosts[i] = net.JoinHostPort(strings.TrimSuffix(a.Target, "."), strconv.Itoa(int(a.Port)))
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+// fetchServers returns the servers the JSON at u lists, either as a list
+// of host[:port], or as Exhibitor does, an object with the hosts in
+// servers and the port they all listen on in port.
+func fetchServers(u string) ([]string, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("looking up servers: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("looking up servers: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("looking up servers: %s said %s", u, resp.Status)
+	}
+
+	var list struct {
+		Servers []string `json:"servers"`
+		Port    int      `json:"port"`
+	}
+	if err := json.Unmarshal(body, &list.Servers); err != nil {
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, fmt.Errorf("looking up servers: %s: %w", u, err)
+		}
+	}
+	if list.Port == 0 {
+		list.Port = 2181
+	}
+	var hosts []string
+	for _, h := range list.Servers {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(h); err != nil {
+			h = net.JoinHostPort(h, strconv.Itoa(list.Port))
+		}
+		hosts = append(hosts, h)
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("looking up servers: %s lists none", u)
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+// discoveryHostProvider gives the ZooKeeper library the servers lookup
+// finds, looking them up again as it picks one to connect to if the last
+// lookup is older than refresh, or every server has been tried, so that
+// long running sessions follow the ensemble as its members change.
+type discoveryHostProvider struct {
+	zk.DNSHostProvider
+	lookup  func() ([]string, error)
+	refresh time.Duration
+
+	mu       sync.Mutex
+	hosts    []string
+	resolved time.Time
+}
+
+func newDiscoveryHostProvider(lookup func() ([]string, error), hosts []string, refresh time.Duration) *discoveryHostProvider {
+	return &discoveryHostProvider{lookup: lookup, refresh: refresh, hosts: hosts, resolved: time.Now()}
+}
+
+func (hp *discoveryHostProvider) Next() (string, bool) {
+	server, retryStart := hp.DNSHostProvider.Next()
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	if !retryStart && (hp.refresh <= 0 || time.Since(hp.resolved) < hp.refresh) {
+		return server, retryStart
+	}
+	hp.resolved = time.Now()
+	hosts, err := hp.lookup()
+	if err != nil || strings.Join(hosts, ",") == strings.Join(hp.hosts, ",") {
+		// keep to the servers known, a failed lookup is no reason to drop them
+		return server, retryStart
+	}
+	if err := hp.DNSHostProvider.Init(hosts); err == nil {
+		hp.hosts = hosts
+	}
+	return server, retryStart
+}
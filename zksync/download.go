@@ -0,0 +1,179 @@
+package zksync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// planDownload plans copying the remote tree to disk. rel is where
+// serverPrefix sits in the tree being synced, for matching opts.Filter.
+func (c *Client) planDownload(ctx context.Context, serverPrefix, localPrefix, rel string, opts Options) (Plan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.skipDownload(serverPrefix, localPrefix, rel, opts) {
+		return nil, nil
+	}
+	fData, stat, err := c.getFile(serverPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", serverPrefix, err)
+	}
+	return c.planDownloadNode(ctx, serverPrefix, localPrefix, rel, fData, stat, opts)
+}
+
+// skipDownload reports whether the node at serverPrefix is left alone
+// without even being read.
+func (c *Client) skipDownload(serverPrefix, localPrefix, rel string, opts Options) bool {
+	if opts.Filter.Excluded(rel) {
+		return true
+	}
+	if opts.Redact.Redacted(rel) && opts.Redact.Skip {
+		c.logger().Debug("Skipping redacted node", "path", serverPrefix)
+		return true
+	}
+	if opts.cursor.unchanged(serverPrefix, localPrefix, rel) {
+		c.logger().Debug("Unchanged since the last download", "path", serverPrefix)
+		return true
+	}
+	return false
+}
+
+// planDownloadNode is planDownload for a node already read.
+func (c *Client) planDownloadNode(ctx context.Context, serverPrefix, localPrefix, rel string, fData []byte, stat *Stat, opts Options) (Plan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if stat.Ephemeral && !opts.Ephemeral {
+		c.logger().Debug("Skipping ephemeral node", "path", serverPrefix)
+		return nil, nil
+	}
+	if !stat.IsDir() && (!opts.Filter.Included(rel) || rel == DeployNode) {
+		return nil, nil
+	}
+
+	var p Plan
+	if stat.IsDir() && opts.Properties.matches(rel) {
+		return c.planDownloadProperties(ctx, serverPrefix, localPrefix, opts)
+	}
+	if stat.IsDir() {
+		// create dir
+		if _, err := os.Stat(localPrefix); err != nil {
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+			p = append(p, Op{Kind: OpMkdir, Source: serverPrefix, Target: localPrefix, Dir: true})
+		} else {
+			c.logger().Debug("Local dir already present", "path", localPrefix)
+		}
+
+		// iterate children
+		if stat.NumChildren > 0 {
+			children, _, err := c.Backend.List(serverPrefix)
+			if err != nil {
+				return nil, fmt.Errorf("listing %s: %w", serverPrefix, err)
+			}
+			opts.cursor.readStats(serverPrefix, rel, children)
+
+			// read the children all at once, rather than waiting on a round
+			// trip for each in turn
+			var fetch []string
+			for _, child := range children {
+				// only files are in the cursor
+				file := filepath.Join(localPrefix, path.Base(opts.Names.LocalFile(path.Join(rel, child))))
+				if !c.skipDownload(path.Join(serverPrefix, child), file, path.Join(rel, child), opts) {
+					fetch = append(fetch, child)
+				}
+			}
+			nodes := c.fetchAll(ctx, serverPrefix, fetch)
+
+			// whether a node is a file decides its local name
+			files := make([]bool, len(children))
+			fetched := make(map[string]int)
+			for i, child := range fetch {
+				fetched[child] = i
+			}
+			for i, child := range children {
+				j, ok := fetched[child]
+				files[i] = !ok || nodes[j].err != nil || !nodes[j].stat.IsDir()
+			}
+			names, err := opts.Names.localNames(serverPrefix, rel, children, files)
+			if err != nil {
+				return nil, err
+			}
+			locals := make(map[string]string)
+			for i, child := range children {
+				if err := checkLocalName(path.Join(serverPrefix, child), names[i]); err != nil {
+					return nil, err
+				}
+				locals[child] = filepath.Join(localPrefix, names[i])
+			}
+			for i, child := range fetch {
+				fullpath := path.Join(serverPrefix, child)
+				fulllocalpath := locals[child]
+				if nodes[i].err != nil {
+					return nil, fmt.Errorf("reading %s: %w", fullpath, nodes[i].err)
+				}
+				childPlan, err := c.planDownloadNode(ctx, fullpath, fulllocalpath, path.Join(rel, child), nodes[i].data, nodes[i].stat, opts)
+				if err != nil {
+					return nil, err
+				}
+				p = append(p, childPlan...)
+			}
+
+		}
+	} else {
+		// check local file
+		mtime := stat.Mtime
+		if opts.Redact.Redacted(rel) {
+			c.logger().Debug("Redacting file", "path", serverPrefix)
+			fData = opts.Redact.Placeholder
+		} else if opts.Vault.onDownload() {
+			var err error
+			if fData, err = opts.Vault.resolve(serverPrefix, fData); err != nil {
+				return nil, err
+			}
+		}
+		c.logger().Debug("Remote file modified", "path", serverPrefix, "mtime", mtime)
+		if opts.cursor != nil {
+			opts.cursor.record(rel, stat.Mzxid, hashData(fData))
+		}
+
+		mode, err := c.remoteMode(serverPrefix, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		kind := OpWrite
+		oldSize := 0
+		fInfo, err := os.Stat(localPrefix)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+			c.logger().Debug("Local file does not exist", "path", localPrefix)
+		} else {
+			c.logger().Debug("Local file modified", "path", localPrefix, "mtime", fInfo.ModTime())
+			same, err := sameContents(localPrefix, fInfo, fData, mtime, opts.Compare)
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				c.logger().Debug("Files are the same", "path", localPrefix)
+				if mode != 0 && !sameMode(fInfo.Mode().Perm(), mode) {
+					return Plan{{Kind: OpChmod, Source: serverPrefix, Target: localPrefix, Mode: mode}}, nil
+				}
+				return nil, nil
+			}
+			c.logger().Debug("Remote file differs, will overwrite", "path", localPrefix)
+			kind = OpOverwrite
+			oldSize = int(fInfo.Size())
+		}
+
+		// create file
+		p = append(p, Op{Kind: kind, Source: serverPrefix, Target: localPrefix, Data: fData, OldSize: oldSize, Mtime: mtime, Mode: mode})
+	}
+	return p, nil
+}
@@ -0,0 +1,231 @@
+package zksync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrDegraded is returned when an ensemble is not healthy enough to be
+// written to.
+var ErrDegraded = errors.New("ensemble degraded")
+
+// HealthCheck says how to ask the servers of a ZooKeeper ensemble how they
+// are.
+type HealthCheck struct {
+	// AdminPort is the port of the AdminServer, asked over HTTP. Zero
+	// sends the four letter words ruok and mntr to the client port
+	// instead, which the servers must have in 4lw.commands.whitelist.
+	AdminPort int
+	// Timeout bounds the asking of every server, 5s if zero.
+	Timeout time.Duration
+}
+
+// ServerHealth is how a server of the ensemble answered.
+type ServerHealth struct {
+	Server string `json:"server" yaml:"server"`
+	// State is leader, follower, observer or standalone for servers
+	// serving, read-only for one cut off from the quorum.
+	State string `json:"state,omitempty" yaml:"state,omitempty"`
+	// Learners and Synced count the followers and observers of a leader,
+	// and those of them in sync with it.
+	Learners int    `json:"learners,omitempty" yaml:"learners,omitempty"`
+	Synced   int    `json:"synced,omitempty" yaml:"synced,omitempty"`
+	Error    string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// CheckEnsemble asks every server of the ZooKeeper ensemble servers lists,
+// as BackendConfig.Servers does, how it is.
+func CheckEnsemble(ctx context.Context, servers string, h HealthCheck) ([]ServerHealth, error) {
+	hosts, err := ensembleHosts(servers)
+	if err != nil {
+		return nil, err
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	health := make([]ServerHealth, len(hosts))
+	for i, host := range hosts {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		var stats map[string]string
+		if h.AdminPort > 0 {
+			stats, err = adminStats(ctx, host, h.AdminPort)
+		} else {
+			stats, err = fourLetterStats(ctx, host)
+		}
+		cancel()
+		health[i] = ServerHealth{Server: host}
+		if err != nil {
+			health[i].Error = err.Error()
+			continue
+		}
+		health[i].State = stats["server_state"]
+		if health[i].State == "leader" {
+			learners := stats["learners"]
+			if learners == "" {
+				// before 3.6
+				learners = stats["followers"]
+			}
+			health[i].Learners, _ = strconv.Atoi(learners)
+			for _, key := range []string{"synced_followers", "synced_non_voting_followers", "synced_observers"} {
+				n, _ := strconv.Atoi(stats[key])
+				health[i].Synced += n
+			}
+		}
+	}
+	return health, nil
+}
+
+// EnsembleDegraded returns an error wrapping ErrDegraded, saying why, if
+// a server did not answer or is not serving, there is not exactly one
+// leader, or the leader has learners out of sync.
+func EnsembleDegraded(health []ServerHealth) error {
+	var why []string
+	leaders := 0
+	for _, s := range health {
+		switch {
+		case s.Error != "":
+			why = append(why, fmt.Sprintf("%s: %s", s.Server, s.Error))
+		case s.State == "leader":
+			leaders++
+			if s.Synced < s.Learners {
+				why = append(why, fmt.Sprintf("%s: %d of %d learners in sync", s.Server, s.Synced, s.Learners))
+			}
+		case s.State == "standalone":
+			leaders++
+		case s.State == "follower" || s.State == "observer":
+		default:
+			why = append(why, fmt.Sprintf("%s: %s", s.Server, s.State))
+		}
+	}
+	switch {
+	case leaders == 0:
+		why = append(why, "no leader")
+	case leaders > 1:
+		why = append(why, fmt.Sprintf("%d leaders", leaders))
+	}
+	if len(why) > 0 {
+		return fmt.Errorf("%w: %s", ErrDegraded, strings.Join(why, "; "))
+	}
+	return nil
+}
+
+// ensembleHosts returns the host:port of every server in servers.
+func ensembleHosts(servers string) ([]string, error) {
+	var err error
+	if !fetchesServers(servers) {
+		if servers, _, err = splitChroot(servers); err != nil {
+			return nil, err
+		}
+	}
+	hosts := strings.Split(servers, ",")
+	if lookup := discovery(servers); lookup != nil {
+		if hosts, err = lookup(); err != nil {
+			return nil, err
+		}
+	}
+	for i, host := range hosts {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			hosts[i] = net.JoinHostPort(host, "2181")
+		}
+	}
+	return hosts, nil
+}
+
+// fourLetterStats sends ruok then mntr to host, returning what mntr lists
+// without the zk_ its names start with.
+func fourLetterStats(ctx context.Context, host string) (map[string]string, error) {
+	out, err := fourLetterWord(ctx, host, "ruok")
+	if err != nil {
+		return nil, err
+	}
+	if out != "imok" {
+		return nil, fmt.Errorf("ruok answered %q", out)
+	}
+	if out, err = fourLetterWord(ctx, host, "mntr"); err != nil {
+		return nil, err
+	}
+	stats := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.SplitN(line, "\t", 2); len(fields) == 2 {
+			stats[strings.TrimPrefix(fields[0], "zk_")] = strings.TrimSpace(fields[1])
+		}
+	}
+	if stats["server_state"] == "" {
+		return nil, fmt.Errorf("mntr answered %q, is it in 4lw.commands.whitelist?", out)
+	}
+	return stats, nil
+}
+
+func fourLetterWord(ctx context.Context, host, word string) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := io.WriteString(conn, word); err != nil {
+		return "", err
+	}
+	out, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", word, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// adminStats asks the AdminServer of host for ruok and mntr.
+func adminStats(ctx context.Context, host string, port int) (map[string]string, error) {
+	h, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+	base := "http://" + net.JoinHostPort(h, strconv.Itoa(port)) + "/commands/"
+	if _, err := adminCommand(ctx, base+"ruok"); err != nil {
+		return nil, err
+	}
+	m, err := adminCommand(ctx, base+"mntr")
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]string)
+	for k, v := range m {
+		stats[k] = strings.TrimSpace(fmt.Sprint(v))
+	}
+	return stats, nil
+}
+
+func adminCommand(ctx context.Context, url string) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	var m map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	if msg, ok := m["error"].(string); ok && msg != "" {
+		return nil, fmt.Errorf("%s: %s", url, msg)
+	}
+	return m, nil
+}
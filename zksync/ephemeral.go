@@ -0,0 +1,140 @@
+package zksync
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// EphemeralFile lists, one path per line, the local files that were
+// downloaded from ephemeral nodes. Uploads skip them, so that they are not
+// recreated as persistent nodes once the session that owned them is gone.
+// Downloads with Options.Ephemeral keep it at the root of the local tree,
+// and it is never uploaded itself.
+const EphemeralFile = ".zkephemeral"
+
+// readEphemeralFile returns the paths listed in the EphemeralFile of the
+// tree at root.
+func readEphemeralFile(root string) (map[string]bool, error) {
+	listed := make(map[string]bool)
+	f, err := os.Open(filepath.Join(root, EphemeralFile))
+	if os.IsNotExist(err) {
+		return listed, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && line[0] != '#' {
+			listed[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", EphemeralFile, err)
+	}
+	return listed, nil
+}
+
+// planEphemeralFile plans writing the EphemeralFile of the tree at
+// absLocal, adding the ephemeral nodes now under remotePath to those listed
+// before. Entries stay for as long as their files are on disk, as it is
+// when their nodes are gone that they matter.
+func (c *Client) planEphemeralFile(ctx context.Context, remotePath, absLocal string, opts Options) (Plan, error) {
+	if fInfo, err := os.Stat(absLocal); err == nil && !fInfo.IsDir() {
+		// a single file was downloaded, there is no tree to keep it in
+		return nil, nil
+	}
+	listed, err := readEphemeralFile(absLocal)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool)
+	if err := c.findEphemeral(ctx, remotePath, "", opts, found); err != nil {
+		return nil, err
+	}
+	for rel := range listed {
+		if found[rel] {
+			continue
+		}
+		if _, err := os.Lstat(opts.Names.localChild(filepath.Join(absLocal, filepath.FromSlash(opts.Names.LocalPath(path.Dir(rel)))), path.Dir(rel), path.Base(rel))); err == nil {
+			found[rel] = true
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	rels := make([]string, 0, len(found))
+	for rel := range found {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	var b strings.Builder
+	b.WriteString("# Files downloaded from ephemeral nodes, which uploads skip\n")
+	for _, rel := range rels {
+		b.WriteString(rel + "\n")
+	}
+	data := []byte(b.String())
+
+	file := filepath.Join(absLocal, EphemeralFile)
+	old, err := ioutil.ReadFile(file)
+	switch {
+	case os.IsNotExist(err):
+		if len(rels) == 0 {
+			return nil, nil
+		}
+		return Plan{{Kind: OpWrite, Source: remotePath, Target: file, Data: data}}, nil
+	case err != nil:
+		return nil, err
+	case string(old) == string(data):
+		return nil, nil
+	}
+	return Plan{{Kind: OpOverwrite, Source: remotePath, Target: file, Data: data, OldSize: len(old)}}, nil
+}
+
+// findEphemeral adds the ephemeral nodes at and below serverPrefix that
+// opts.Filter matches to found, by their path relative to the tree.
+func (c *Client) findEphemeral(ctx context.Context, serverPrefix, rel string, opts Options, found map[string]bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if opts.Filter.Excluded(rel) {
+		return nil
+	}
+	_, stat, err := c.Backend.Get(serverPrefix)
+	if err == ErrNoNode {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("reading %s: %w", serverPrefix, err)
+	}
+	if stat.Ephemeral {
+		if rel != "" && opts.Filter.Included(rel) {
+			found[rel] = true
+		}
+		return nil
+	}
+	if stat.NumChildren == 0 {
+		return nil
+	}
+	children, _, err := c.Backend.List(serverPrefix)
+	if err == ErrNoNode {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("listing %s: %w", serverPrefix, err)
+	}
+	for _, child := range children {
+		if isChunk(child) {
+			continue
+		}
+		if err := c.findEphemeral(ctx, path.Join(serverPrefix, child), path.Join(rel, child), opts, found); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package zksync
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors shared by all backends. Backends wrap anything more specific they
+// have to say about auth and connection failures.
+var (
+	ErrNoNode     = errors.New("node does not exist")
+	ErrNodeExists = errors.New("node already exists")
+	ErrBadVersion = errors.New("version conflict")
+	ErrNotEmpty   = errors.New("node has children")
+	ErrNoAuth     = errors.New("not authenticated")
+	ErrNoSession  = errors.New("could not establish a session")
+	// ErrUnsupported is returned for features the backend lacks.
+	ErrUnsupported = errors.New("not supported by this backend")
+	// ErrSymlink is returned for symlinks in the local tree when they are
+	// not allowed.
+	ErrSymlink = errors.New("symlinks not allowed")
+	// ErrBinary is returned for binary files in the local tree when they
+	// are not allowed.
+	ErrBinary = errors.New("binary files not allowed")
+	// ErrLocked is returned when another run held a lock for longer than
+	// there was to wait.
+	ErrLocked = errors.New("locked")
+	// ErrProtected is returned for deletions of the root, of ZooKeeper's own
+	// nodes, or of paths shallower than Options.MinDeleteDepth.
+	ErrProtected = errors.New("protected path")
+	// ErrReadOnly is returned for changes to a backend opened read-only.
+	ErrReadOnly = errors.New("read-only")
+	// ErrTooLarge is returned for nodes that would be written with more
+	// data than Options.MaxNodeSize.
+	ErrTooLarge = errors.New("node too large")
+	// ErrOverQuota is returned for runs that would take a tree over its
+	// budget.
+	ErrOverQuota = errors.New("over quota")
+	// ErrNameCollision is returned when two names in a dir would be the
+	// same file or node on the other side once mapped by Options.Names.
+	ErrNameCollision = errors.New("name collision")
+)
+
+// OpError records which planned change failed.
+type OpError struct {
+	Op  Op
+	Err error
+}
+
+func (e *OpError) Error() string {
+	return fmt.Sprintf("%s: %v", strings.TrimSpace(e.Op.String()), e.Err)
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError lists the files that failed validation, which stopped
+// the upload before anything was changed.
+type ValidationError struct {
+	Errs []error
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d files failed validation: %s", len(e.Errs), strings.Join(msgs, "; "))
+}
+
+// ChangedError lists the nodes someone else changed since a plan read them,
+// which stopped a run with Options.CheckVersion before anything was
+// changed.
+type ChangedError struct {
+	Paths []string
+}
+
+func (e *ChangedError) Error() string {
+	return fmt.Sprintf("%d nodes changed since they were read: %s", len(e.Paths), strings.Join(e.Paths, ", "))
+}
+
+func (e *ChangedError) Unwrap() error {
+	return ErrBadVersion
+}
+
+// SizeError lists the nodes that would have been written with more data
+// than Options.MaxNodeSize, which stopped a run before anything was
+// changed.
+type SizeError struct {
+	Ops   []Op
+	Limit int
+}
+
+func (e *SizeError) Error() string {
+	msgs := make([]string, len(e.Ops))
+	for i, o := range e.Ops {
+		msgs[i] = fmt.Sprintf("%s (%d bytes)", o.Target, len(o.Data))
+	}
+	return fmt.Sprintf("%d nodes over %d bytes: %s", len(e.Ops), e.Limit, strings.Join(msgs, ", "))
+}
+
+func (e *SizeError) Unwrap() error {
+	return ErrTooLarge
+}
+
+// QuotaError lists the budgets a run would have gone over, and what their
+// trees would have held, which stopped it before anything was changed.
+type QuotaError struct {
+	Over []QuotaUsage
+}
+
+func (e *QuotaError) Error() string {
+	msgs := make([]string, len(e.Over))
+	for i, u := range e.Over {
+		msgs[i] = fmt.Sprintf("%s (%s)", u.Prefix, strings.Join(u.over(), ", "))
+	}
+	return fmt.Sprintf("would go over budget: %s", strings.Join(msgs, "; "))
+}
+
+func (e *QuotaError) Unwrap() error {
+	return ErrOverQuota
+}
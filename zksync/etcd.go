@@ -0,0 +1,320 @@
+package zksync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+const etcdTimeout = 5 * time.Second
+
+// etcdBackend maps the tree onto etcd's flat key space: every node is a key
+// holding its full path, and dirs are keys with an empty value. Keys with
+// nothing stored at a parent path still show up as dirs.
+type etcdBackend struct {
+	c *clientv3.Client
+}
+
+func newEtcdBackend(cfg BackendConfig) (*etcdBackend, error) {
+	endpoints := strings.Split(cfg.Servers, ",")
+	for i, endpoint := range endpoints {
+		if !strings.Contains(endpoint, ":") {
+			endpoints[i] = endpoint + ":2379"
+		}
+	}
+
+	tlsCfg, err := cfg.TLS.config()
+	if err != nil {
+		return nil, err
+	}
+	etcdCfg := clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: etcdTimeout,
+		TLS:         tlsCfg,
+	}
+	if cfg.Auth != "" {
+		parts := strings.SplitN(cfg.Auth, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("etcd auth must be user:password")
+		}
+		etcdCfg.Username, etcdCfg.Password = parts[0], parts[1]
+	}
+
+	c, err := clientv3.New(etcdCfg)
+	if err != nil {
+		return nil, etcdError(err)
+	}
+
+	// the client connects lazily, make sure the cluster is really there
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	if _, err := c.Get(ctx, "/", clientv3.WithCountOnly()); err != nil {
+		c.Close()
+		return nil, etcdError(err)
+	}
+	return &etcdBackend{c: c}, nil
+}
+
+func etcdError(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case rpctypes.ErrPermissionDenied, rpctypes.ErrAuthFailed, rpctypes.ErrInvalidAuthToken, rpctypes.ErrUserEmpty:
+		return fmt.Errorf("%w: %v", ErrNoAuth, err)
+	case context.DeadlineExceeded, rpctypes.ErrNoLeader:
+		return fmt.Errorf("%w: %v", ErrNoSession, err)
+	}
+	return err
+}
+
+// dirPrefix is the prefix shared by all descendants of p.
+func dirPrefix(p string) string {
+	return strings.TrimSuffix(p, "/") + "/"
+}
+
+func (b *etcdBackend) Get(p string) ([]byte, *Stat, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	resp, err := b.c.Txn(ctx).Then(
+		clientv3.OpGet(p),
+		clientv3.OpGet(dirPrefix(p), clientv3.WithPrefix(), clientv3.WithCountOnly()),
+	).Commit()
+	if err != nil {
+		return nil, nil, etcdError(err)
+	}
+	node := resp.Responses[0].GetResponseRange()
+	descendants := resp.Responses[1].GetResponseRange().Count
+
+	stat := &Stat{NumChildren: int(descendants)}
+	if len(node.Kvs) == 0 {
+		if descendants == 0 {
+			return nil, nil, ErrNoNode
+		}
+		return nil, stat, nil
+	}
+	kv := node.Kvs[0]
+	stat.Version = kv.ModRevision
+	stat.DataLength = len(kv.Value)
+	stat.Ephemeral = kv.Lease != 0
+	stat.Czxid, stat.Mzxid = kv.CreateRevision, kv.ModRevision
+	return kv.Value, stat, nil
+}
+
+func (b *etcdBackend) List(p string) ([]string, *Stat, error) {
+	_, stat, err := b.Get(p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	prefix := dirPrefix(p)
+	resp, err := b.c.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, nil, etcdError(err)
+	}
+
+	var children []string
+	seen := make(map[string]bool)
+	for _, kv := range resp.Kvs {
+		name := strings.SplitN(strings.TrimPrefix(string(kv.Key), prefix), "/", 2)[0]
+		if name != "" && !seen[name] {
+			seen[name] = true
+			children = append(children, name)
+		}
+	}
+	stat.NumChildren = len(children)
+	return children, stat, nil
+}
+
+func (b *etcdBackend) Create(p string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	resp, err := b.c.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(p), "=", 0)).
+		Then(clientv3.OpPut(p, string(data))).
+		Commit()
+	if err != nil {
+		return etcdError(err)
+	}
+	if !resp.Succeeded {
+		return ErrNodeExists
+	}
+	return nil
+}
+
+func (b *etcdBackend) Set(p string, data []byte, version int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	txn := b.c.Txn(ctx)
+	if version >= 0 {
+		txn = txn.If(clientv3.Compare(clientv3.ModRevision(p), "=", version))
+	}
+	resp, err := txn.Then(clientv3.OpPut(p, string(data))).Commit()
+	if err != nil {
+		return etcdError(err)
+	}
+	if !resp.Succeeded {
+		return ErrBadVersion
+	}
+	return nil
+}
+
+func (b *etcdBackend) Delete(p string, version int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	resp, err := b.c.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(p), "=", version)).
+		Then(clientv3.OpDelete(p)).
+		Commit()
+	if err != nil {
+		return etcdError(err)
+	}
+	if !resp.Succeeded {
+		return ErrBadVersion
+	}
+	return nil
+}
+
+func (b *etcdBackend) DeleteMulti(nodes []NodeVersion) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	cmps := make([]clientv3.Cmp, len(nodes))
+	ops := make([]clientv3.Op, len(nodes))
+	for i, node := range nodes {
+		cmps[i] = clientv3.Compare(clientv3.ModRevision(node.Path), "=", node.Version)
+		ops[i] = clientv3.OpDelete(node.Path)
+	}
+	resp, err := b.c.Txn(ctx).If(cmps...).Then(ops...).Commit()
+	if err != nil {
+		return etcdError(err)
+	}
+	if !resp.Succeeded {
+		return ErrBadVersion
+	}
+	return nil
+}
+
+func (b *etcdBackend) Transact(p Plan) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	var cmps []clientv3.Cmp
+	ops := make([]clientv3.Op, len(p))
+	for i, o := range p {
+		switch o.Kind {
+		case OpCreate:
+			cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(o.Target), "=", 0))
+			ops[i] = clientv3.OpPut(o.Target, string(o.Data))
+		case OpSet:
+			if o.Version >= 0 {
+				cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(o.Target), "=", o.Version))
+			}
+			ops[i] = clientv3.OpPut(o.Target, string(o.Data))
+		case OpDelete:
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(o.Target), "=", o.Version))
+			ops[i] = clientv3.OpDelete(o.Target)
+		default:
+			return fmt.Errorf("%s in a transaction: %w", o.Kind, ErrUnsupported)
+		}
+	}
+	resp, err := b.c.Txn(ctx).If(cmps...).Then(ops...).Commit()
+	if err != nil {
+		return etcdError(err)
+	}
+	if !resp.Succeeded {
+		return ErrBadVersion
+	}
+	return nil
+}
+
+// MultiLimit matches the defaults of etcd's --max-txn-ops and
+// --max-request-bytes.
+func (b *etcdBackend) MultiLimit() (int, int) {
+	return 128, 1024 * 1024
+}
+
+func (b *etcdBackend) Watch(ctx context.Context, p string) (<-chan Event, error) {
+	events := make(chan Event)
+	prefix := dirPrefix(p)
+	wch := b.c.Watch(clientv3.WithRequireLeader(ctx), p, clientv3.WithPrefix())
+
+	go func() {
+		defer close(events)
+		for resp := range wch {
+			if err := resp.Err(); err != nil {
+				select {
+				case events <- Event{Path: p, Err: etcdError(err)}:
+				case <-ctx.Done():
+				}
+				return
+			}
+			for _, ev := range resp.Events {
+				key := string(ev.Kv.Key)
+				if key != p && !strings.HasPrefix(key, prefix) {
+					// a sibling sharing the prefix, e.g. /foobar for /foo
+					continue
+				}
+				e := Event{Type: EventChanged, Path: key}
+				if ev.Type == clientv3.EventTypeDelete {
+					e.Type = EventDeleted
+				} else if ev.IsCreate() {
+					e.Type = EventCreated
+				}
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// Lock holds a concurrency.Mutex on p, in a session of its own. The key
+// it is held with records the holder.
+func (b *etcdBackend) Lock(ctx context.Context, p, holder string) (func(), error) {
+	s, err := concurrency.NewSession(b.c)
+	if err != nil {
+		return nil, etcdError(err)
+	}
+	m := concurrency.NewMutex(s, dirPrefix(p))
+	if err := m.Lock(ctx); err != nil {
+		s.Close()
+		if ctx.Err() == context.DeadlineExceeded {
+			getCtx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+			defer cancel()
+			if resp, err := b.c.Get(getCtx, dirPrefix(p), clientv3.WithFirstCreate()...); err == nil && len(resp.Kvs) > 0 {
+				return nil, lockedBy(string(resp.Kvs[0].Value))
+			}
+			return nil, ErrLocked
+		}
+		return nil, etcdError(err)
+	}
+	// the create revision, which orders the waiters, is left alone
+	putCtx, cancel := context.WithTimeout(ctx, etcdTimeout)
+	defer cancel()
+	b.c.Put(putCtx, m.Key(), holder, clientv3.WithLease(s.Lease()))
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+		defer cancel()
+		m.Unlock(ctx)
+		s.Close()
+	}, nil
+}
+
+func (b *etcdBackend) Close() {
+	b.c.Close()
+}
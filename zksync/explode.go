@@ -0,0 +1,295 @@
+package zksync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// docNode is one node of an exploded document: a dir for every map, and a
+// file holding the value of every other key.
+type docNode struct {
+	rel  string
+	dir  bool
+	data []byte
+}
+
+// Explode uploads the YAML or JSON document in file to remotePath, one node
+// per key, storing values as uploads store files. Maps become dirs, scalars
+// are stored as written and lists as JSON. Quoting is lost, so a quoted
+// "8080" comes back from Implode as a number, and null values cannot be
+// told apart from dirs, so they come back as empty maps. With opts.Prune,
+// remote nodes with no key in the document are deleted.
+func (c *Client) Explode(ctx context.Context, file, remotePath string, opts Options) (*Result, error) {
+	if _, ok := c.Backend.(ACLBackend); opts.ACLs != nil && !ok {
+		return nil, ErrUnsupported
+	}
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if src, err = opts.Template.render(file, src); err != nil {
+		return nil, err
+	}
+	if opts.Vault.onUpload() {
+		if src, err = opts.Vault.resolve(file, src); err != nil {
+			return nil, err
+		}
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(src, &doc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", file, err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s does not hold a map", file)
+	}
+	nodes, err := flattenDoc(doc.Content[0], "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	var p Plan
+	if opts.Clean {
+		if p, err = c.planDelete(ctx, remotePath); err != nil {
+			return nil, err
+		}
+	}
+	explodePlan, err := c.planImport(ctx, remotePath, file, nodes, opts)
+	if err != nil {
+		return nil, err
+	}
+	p = append(p, explodePlan...)
+	if opts.Prune && !opts.Clean {
+		inDoc := make(map[string]docNode, len(nodes))
+		for _, n := range nodes {
+			inDoc[n.rel] = n
+		}
+		if err := checkPrunable(remotePath, opts); err != nil {
+			return nil, err
+		}
+		prunePlan, err := c.planPruneDoc(ctx, remotePath, "", inDoc, opts)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, prunePlan...)
+	}
+	return c.run(ctx, p, opts)
+}
+
+// flattenDoc appends n and everything below it to nodes, parents first.
+func flattenDoc(n *yaml.Node, rel string, nodes []docNode) ([]docNode, error) {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	switch n.Kind {
+	case yaml.MappingNode:
+		nodes = append(nodes, docNode{rel: rel, dir: true})
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			if key == "" || key == "." || key == ".." || strings.Contains(key, "/") {
+				return nil, fmt.Errorf("line %d: key %q cannot be a node name", n.Content[i].Line, key)
+			}
+			var err error
+			if nodes, err = flattenDoc(n.Content[i+1], path.Join(rel, key), nodes); err != nil {
+				return nil, err
+			}
+		}
+	case yaml.SequenceNode:
+		var v interface{}
+		if err := n.Decode(&v); err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		nodes = append(nodes, docNode{rel: rel, data: data})
+	case yaml.ScalarNode:
+		if n.ShortTag() == "!!null" {
+			nodes = append(nodes, docNode{rel: rel, dir: true})
+		} else {
+			nodes = append(nodes, docNode{rel: rel, data: []byte(n.Value)})
+		}
+	}
+	return nodes, nil
+}
+
+// planPruneDoc plans deleting the remote nodes under serverPrefix that have
+// no entry in inDoc, the nodes of an exploded document or a snapshot.
+func (c *Client) planPruneDoc(ctx context.Context, serverPrefix, rel string, inDoc map[string]docNode, opts Options) (Plan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	children, _, err := c.Backend.List(serverPrefix)
+	if err == ErrNoNode {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", serverPrefix, err)
+	}
+
+	var p Plan
+	for _, child := range children {
+		remotePath := path.Join(serverPrefix, child)
+		childRel := path.Join(rel, child)
+		if opts.Filter.Excluded(childRel) || opts.Trash.holds(remotePath) || opts.History.holds(remotePath) {
+			continue
+		}
+		n, ok := inDoc[childRel]
+		if ok && !n.dir {
+			continue
+		}
+
+		_, stat, err := c.Backend.Get(remotePath)
+		if err == ErrNoNode {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", remotePath, err)
+		}
+		isDir := stat.DataLength == 0
+
+		var childPlan Plan
+		switch {
+		case ok || (isDir && opts.Filter.hasIncludes()):
+			childPlan, err = c.planPruneDoc(ctx, remotePath, childRel, inDoc, opts)
+		case isDir || opts.Filter.Included(childRel):
+			c.logger().Debug("Gone from the document, will delete", "path", remotePath)
+			childPlan, err = c.planDelete(ctx, remotePath)
+		}
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, childPlan...)
+	}
+	return p, nil
+}
+
+// Implode collapses the tree at remotePath into a single document written
+// to file, JSON if its name ends in .json and YAML otherwise. Dirs become
+// maps, and values are read back the way Explode stores them.
+func (c *Client) Implode(ctx context.Context, file, remotePath string, opts Options) (*Result, error) {
+	root, err := c.implodeNode(ctx, remotePath, "", opts)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		root = &yaml.Node{Kind: yaml.MappingNode}
+	}
+	data, err := encodeDoc(root, strings.EqualFold(filepath.Ext(file), ".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var p Plan
+	old, err := ioutil.ReadFile(file)
+	switch {
+	case os.IsNotExist(err):
+		p = Plan{{Kind: OpWrite, Source: remotePath, Target: file, Data: data}}
+	case err != nil:
+		return nil, err
+	case bytes.Equal(old, data):
+		c.logger().Debug("Files are the same", "path", file)
+	default:
+		p = Plan{{Kind: OpOverwrite, Source: remotePath, Target: file, Data: data, OldSize: len(old)}}
+	}
+	return c.run(ctx, p, opts)
+}
+
+// implodeNode returns the document node for the tree at serverPrefix, nil
+// if the filter leaves nothing of it.
+func (c *Client) implodeNode(ctx context.Context, serverPrefix, rel string, opts Options) (*yaml.Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts.Filter.Excluded(rel) || opts.Redact.Redacted(rel) {
+		// placeholders would be uploaded by Explode, so redacted files are
+		// always left out
+		return nil, nil
+	}
+	data, stat, err := c.getFile(serverPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", serverPrefix, err)
+	}
+	if stat.Ephemeral && !opts.Ephemeral {
+		return nil, nil
+	}
+	if !stat.IsDir() {
+		if !opts.Filter.Included(rel) || rel == DeployNode {
+			return nil, nil
+		}
+		if opts.Vault.onDownload() {
+			if data, err = opts.Vault.resolve(serverPrefix, data); err != nil {
+				return nil, err
+			}
+		}
+		return valueNode(data), nil
+	}
+
+	children, _, err := c.Backend.List(serverPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", serverPrefix, err)
+	}
+	sort.Strings(children)
+	m := &yaml.Node{Kind: yaml.MappingNode}
+	for _, child := range children {
+		n, err := c.implodeNode(ctx, path.Join(serverPrefix, child), path.Join(rel, child), opts)
+		if err != nil {
+			return nil, err
+		}
+		if n != nil {
+			m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: child}, n)
+		}
+	}
+	if len(m.Content) == 0 && opts.Filter.hasIncludes() {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// valueNode reads a value back the way flattenDoc stored it: lists as
+// JSON, anything else as a scalar typed by how it is written.
+func valueNode(data []byte) *yaml.Node {
+	if bytes.HasPrefix(data, []byte("[")) {
+		var doc yaml.Node
+		if yaml.Unmarshal(data, &doc) == nil && len(doc.Content) == 1 && doc.Content[0].Kind == yaml.SequenceNode {
+			return doc.Content[0]
+		}
+	}
+	if len(data) == 0 {
+		// left untagged it would be written back as null
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str"}
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: string(data)}
+}
+
+func encodeDoc(root *yaml.Node, asJSON bool) ([]byte, error) {
+	if asJSON {
+		var v interface{}
+		if err := root.Decode(&v); err != nil {
+			return nil, err
+		}
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
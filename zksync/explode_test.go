@@ -0,0 +1,41 @@
+package zksync
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExplodeWrites(t *testing.T) {
+	b := NewMemoryBackend()
+	c := New(b)
+	ctx, ok := context.Background(), applied(t)
+	dir := t.TempDir()
+	long := strings.Repeat("x", 100)
+	file := filepath.Join(dir, "doc.yaml")
+	if err := os.WriteFile(file, []byte("long: "+long+"\nempty: \"\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{Compress: true, ChunkSize: 16}
+	ok(c.Explode(ctx, file, "/app", opts))
+	if data, _, err := b.Get("/app/long"); err != nil || bytes.Contains(data, []byte(long)) {
+		t.Errorf("/app/long is stored as %q, %v, want it compressed", data, err)
+	}
+	if res := ok(c.Explode(ctx, file, "/app", opts)); len(res.Plan) > 0 {
+		t.Errorf("second explode plans %v", res.Plan)
+	}
+
+	out := filepath.Join(dir, "out.yaml")
+	ok(c.Implode(ctx, out, "/app", opts))
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(got, []byte("long: "+long)) || !bytes.Contains(got, []byte(`empty: ""`)) {
+		t.Errorf("imploded %q", got)
+	}
+}
@@ -0,0 +1,370 @@
+package zksync
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DocFormat is the format of a document Export writes and Import reads.
+type DocFormat string
+
+const (
+	FormatYAML DocFormat = "yaml"
+	FormatJSON DocFormat = "json"
+	// FormatCSV is a flat list of path,value rows, for trees edited as
+	// spreadsheets. Dirs are implied by the paths below them, empty ones
+	// having rows of their own with a path ending in a slash. Values with
+	// carriage returns are base64 encoded, as CSV readers drop them.
+	FormatCSV DocFormat = "csv"
+)
+
+// ParseDocFormat checks s names a known format.
+func ParseDocFormat(s string) (DocFormat, error) {
+	switch f := DocFormat(s); f {
+	case FormatYAML, FormatJSON, FormatCSV:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format: %s", s)
+}
+
+// exportBase64 starts the values of an exported document holding base64
+// encoded data: that of binary files, and of text files that happen to
+// start with it themselves.
+const exportBase64 = "base64:"
+
+// Export returns the tree at remotePath as a single document in format,
+// for reading what is deployed in one go. Dirs become maps and files
+// strings holding their data as it is, unless it is binary, when it is
+// base64 encoded behind "base64:". Unlike Implode, no value is
+// reinterpreted, so Import puts back exactly what was exported.
+func (c *Client) Export(ctx context.Context, remotePath string, format DocFormat, opts Options) ([]byte, error) {
+	root, err := c.exportNode(ctx, remotePath, "", opts)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		root = &yaml.Node{Kind: yaml.MappingNode}
+	}
+	if format == FormatCSV {
+		return encodeCSV(root)
+	}
+	return encodeDoc(root, format == FormatJSON)
+}
+
+// encodeCSV writes the files of the document root as path,value rows
+// under a header, and its empty dirs as rows of their own.
+func encodeCSV(root *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(csvHeader)
+	var write func(n *yaml.Node, rel string)
+	write = func(n *yaml.Node, rel string) {
+		if n.Kind != yaml.MappingNode {
+			v := n.Value
+			if strings.Contains(v, "\r") {
+				// CSV readers drop the CR of line breaks in values
+				v = exportBase64 + base64.StdEncoding.EncodeToString([]byte(v))
+			}
+			w.Write([]string{rel, v})
+			return
+		}
+		if len(n.Content) == 0 && rel != "" {
+			w.Write([]string{rel + "/", ""})
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			write(n.Content[i+1], path.Join(rel, n.Content[i].Value))
+		}
+	}
+	write(root, "")
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+var csvHeader = []string{"path", "value"}
+
+// exportNode returns the document node for the tree at serverPrefix, nil
+// if the filter leaves nothing of it.
+func (c *Client) exportNode(ctx context.Context, serverPrefix, rel string, opts Options) (*yaml.Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts.Filter.Excluded(rel) {
+		return nil, nil
+	}
+	data, stat, err := c.getFile(serverPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", serverPrefix, err)
+	}
+	if stat.Ephemeral && !opts.Ephemeral {
+		return nil, nil
+	}
+	if !stat.IsDir() {
+		if !opts.Filter.Included(rel) || rel == DeployNode {
+			return nil, nil
+		}
+		return exportValue(data), nil
+	}
+
+	children, _, err := c.Backend.List(serverPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", serverPrefix, err)
+	}
+	sort.Strings(children)
+	m := &yaml.Node{Kind: yaml.MappingNode}
+	for _, child := range children {
+		n, err := c.exportNode(ctx, path.Join(serverPrefix, child), path.Join(rel, child), opts)
+		if err != nil {
+			return nil, err
+		}
+		if n != nil {
+			m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: child}, n)
+		}
+	}
+	if len(m.Content) == 0 && opts.Filter.hasIncludes() {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// exportValue returns the string node holding data, multi-line text as a
+// YAML literal block so that it reads as it does in the file.
+func exportValue(data []byte) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str"}
+	switch {
+	case IsBinary(data) || bytes.HasPrefix(data, []byte(exportBase64)):
+		n.Value = exportBase64 + base64.StdEncoding.EncodeToString(data)
+	case strings.Contains(string(data), "\n") && !strings.ContainsAny(string(data), "\t\r"):
+		// yaml.v3 gives up on literal blocks holding tabs or CRs, quoting
+		// them instead
+		n.Value = string(data)
+		n.Style = yaml.LiteralStyle
+	default:
+		n.Value = string(data)
+	}
+	return n
+}
+
+// Import uploads the document src, as Export returns it in format, to
+// remotePath: maps as dirs, and strings as files, storing them as uploads
+// do. file names src in errors and the plan. With opts.Prune, remote nodes
+// with no key in the document are deleted.
+func (c *Client) Import(ctx context.Context, src []byte, format DocFormat, file, remotePath string, opts Options) (*Result, error) {
+	var nodes []docNode
+	var err error
+	if format == FormatCSV {
+		nodes, err = importCSV(src)
+	} else {
+		var doc yaml.Node
+		if err := yaml.Unmarshal(src, &doc); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", file, err)
+		}
+		if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s does not hold a map", file)
+		}
+		nodes, err = importDoc(doc.Content[0], "", nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	var p Plan
+	if opts.Clean {
+		if p, err = c.planDelete(ctx, remotePath); err != nil {
+			return nil, err
+		}
+	}
+	importPlan, err := c.planImport(ctx, remotePath, file, nodes, opts)
+	if err != nil {
+		return nil, err
+	}
+	p = append(p, importPlan...)
+	if opts.Prune && !opts.Clean {
+		inDoc := make(map[string]docNode, len(nodes))
+		for _, n := range nodes {
+			inDoc[n.rel] = n
+		}
+		if err := checkPrunable(remotePath, opts); err != nil {
+			return nil, err
+		}
+		prunePlan, err := c.planPruneDoc(ctx, remotePath, "", inDoc, opts)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, prunePlan...)
+	}
+	return c.run(ctx, p, opts)
+}
+
+// importDoc appends n and everything below it to nodes, parents first,
+// undoing exportValue on the values.
+func importDoc(n *yaml.Node, rel string, nodes []docNode) ([]docNode, error) {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	switch n.Kind {
+	case yaml.MappingNode:
+		nodes = append(nodes, docNode{rel: rel, dir: true})
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			if key == "" || key == "." || key == ".." || strings.Contains(key, "/") {
+				return nil, fmt.Errorf("line %d: key %q cannot be a node name", n.Content[i].Line, key)
+			}
+			var err error
+			if nodes, err = importDoc(n.Content[i+1], path.Join(rel, key), nodes); err != nil {
+				return nil, err
+			}
+		}
+	case yaml.ScalarNode:
+		if n.ShortTag() == "!!null" {
+			return nil, fmt.Errorf("line %d: %s has no value, an empty dir is {} and an empty file \"\"", n.Line, rel)
+		}
+		data, err := importValue(n.Value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", n.Line, rel, err)
+		}
+		nodes = append(nodes, docNode{rel: rel, data: data})
+	default:
+		return nil, fmt.Errorf("line %d: %s is neither a map nor a string", n.Line, rel)
+	}
+	return nodes, nil
+}
+
+// importValue undoes exportValue.
+func importValue(v string) ([]byte, error) {
+	if !strings.HasPrefix(v, exportBase64) {
+		return []byte(v), nil
+	}
+	return base64.StdEncoding.DecodeString(v[len(exportBase64):])
+}
+
+// importCSV returns the nodes the path,value rows of src give, parents
+// first, the dirs they are in included. A header row is skipped, as is the
+// byte order mark spreadsheets start their CSV files with.
+func importCSV(src []byte) ([]docNode, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(src, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = 2
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	// rows are numbered as spreadsheets number them
+	first := 1
+	if len(rows) > 0 && strings.EqualFold(rows[0][0], csvHeader[0]) && strings.EqualFold(rows[0][1], csvHeader[1]) {
+		rows, first = rows[1:], 2
+	}
+
+	nodes := []docNode{{rel: "", dir: true}}
+	// whether every path seen is a dir
+	seen := map[string]bool{"": true}
+	for i, row := range rows {
+		i += first
+		dir := strings.HasSuffix(row[0], "/")
+		rel := strings.Trim(path.Clean("/"+row[0]), "/")
+		if rel == "" {
+			return nil, fmt.Errorf("row %d: no path", i)
+		}
+		if dir && row[1] != "" {
+			return nil, fmt.Errorf("row %d: %s is a dir, which holds no value", i, row[0])
+		}
+		parts := strings.Split(rel, "/")
+		for j := 1; j < len(parts); j++ {
+			parent := strings.Join(parts[:j], "/")
+			isDir, ok := seen[parent]
+			if ok && !isDir {
+				return nil, fmt.Errorf("row %d: %s is below the file %s", i, rel, parent)
+			}
+			if !ok {
+				seen[parent] = true
+				nodes = append(nodes, docNode{rel: parent, dir: true})
+			}
+		}
+		if _, ok := seen[rel]; ok {
+			return nil, fmt.Errorf("row %d: %s given twice, or as a file with others below it", i, rel)
+		}
+		seen[rel] = dir
+		data, err := importValue(row[1])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %s: %w", i, rel, err)
+		}
+		nodes = append(nodes, docNode{rel: rel, dir: dir, data: data})
+	}
+	return nodes, nil
+}
+
+// planImport plans creating or updating a node under serverPrefix for
+// every one of nodes, which come from file, writing files as uploads do.
+func (c *Client) planImport(ctx context.Context, serverPrefix, file string, nodes []docNode, opts Options) (Plan, error) {
+	p, err := c.planRemotePath(path.Dir(serverPrefix))
+	if err != nil {
+		return nil, err
+	}
+	for i := range p {
+		p[i].ACL = opts.ACLs.For("")
+	}
+	created := make(map[string]bool)
+	for _, o := range p {
+		created[o.Target] = true
+	}
+
+	for _, n := range nodes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if opts.Filter.Excluded(n.rel) || (!n.dir && !opts.Filter.Included(n.rel)) {
+			continue
+		}
+		remotePath := path.Join(serverPrefix, n.rel)
+
+		exists := false
+		var remoteData []byte
+		var stat *Stat
+		if !opts.Clean && !created[path.Dir(remotePath)] {
+			remoteData, stat, err = c.getFile(remotePath)
+			if err == nil {
+				exists = true
+			} else if err != ErrNoNode {
+				return nil, fmt.Errorf("checking %s: %w", remotePath, err)
+			}
+		}
+
+		switch {
+		case !exists && n.dir:
+			p = append(p, Op{Kind: OpCreate, Source: file, Target: remotePath, Dir: true, ACL: opts.ACLs.For(n.rel)})
+			created[remotePath] = true
+		case !exists:
+			writePlan, err := c.planWrite(file, remotePath, n.data, nil, opts.ACLs.For(n.rel), opts)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, writePlan...)
+		case stat.Ephemeral:
+			c.logger().Warn("Remote node is ephemeral, not overwriting", "path", remotePath)
+		case n.dir && !stat.IsDir():
+			if stat.NumChildren > 0 || stat.Chunks > 0 {
+				return nil, fmt.Errorf("remote path is a file when a dir is expected: %s", remotePath)
+			}
+			p = append(p, Op{Kind: OpSet, Source: file, Target: remotePath, Dir: true, Data: []byte{}, OldSize: stat.DataLength, Version: stat.Version})
+		case n.dir:
+			c.logger().Debug("Dir already there", "path", remotePath)
+		case stat.NumChildren > 0:
+			return nil, fmt.Errorf("remote path is a dir when a file is expected: %s", remotePath)
+		case (!stat.IsDir() || opts.PlainEmpty) && bytes.Equal(remoteData, n.data):
+			c.logger().Debug("Files are the same", "path", remotePath)
+		default:
+			writePlan, err := c.planWrite(file, remotePath, n.data, stat, nil, opts)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, writePlan...)
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,70 @@
+package zksync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportTwice(t *testing.T) {
+	c := New(NewMemoryBackend())
+	ctx, ok := context.Background(), applied(t)
+	doc := []byte("a: \"1\"\nempty: \"\"\ndir:\n  also-empty: \"\"\n")
+
+	for remote, opts := range map[string]Options{"/marked": {}, "/plain": {PlainEmpty: true}} {
+		ok(c.Import(ctx, doc, FormatYAML, "doc.yaml", remote, opts))
+		if res := ok(c.Import(ctx, doc, FormatYAML, "doc.yaml", remote, opts)); len(res.Plan) > 0 {
+			t.Errorf("second import with PlainEmpty %v plans %v", opts.PlainEmpty, res.Plan)
+		}
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	b := NewMemoryBackend()
+	c := New(b)
+	ctx, ok := context.Background(), applied(t)
+	local := t.TempDir()
+	files := map[string]string{
+		"plain":        "value",
+		"comma":        "a,b",
+		"quotes":       `say "hi"`,
+		"lines":        "one\ntwo\n",
+		"crlf":         "one\r\ntwo\r\n",
+		"spaces":       "  padded  ",
+		"empty":        "",
+		"dir/nested":   "x",
+		"dir/deep/b64": "base64:not really",
+		"binary":       "\x00\x01\xff",
+		"with,comma/f": "y",
+	}
+	writeTree(t, local, files)
+	if err := os.Mkdir(filepath.Join(local, "empty-dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files["empty-dir/"] = ""
+	ok(c.Upload(ctx, local, "/src", Options{}))
+
+	doc, err := c.Export(ctx, "/src", FormatCSV, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []string{"crlf,base64:b25lDQp0d28NCg==\n", "comma,\"a,b\"\n", "quotes,\"say \"\"hi\"\"\"\n", "lines,\"one\ntwo\n\"\n", "empty-dir/,\n", "\"with,comma/f\",y\n"} {
+		if !strings.Contains(string(doc), row) {
+			t.Errorf("export has no row %q:\n%s", row, doc)
+		}
+	}
+
+	ok(c.Import(ctx, doc, FormatCSV, "tree.csv", "/dst", Options{}))
+	again, err := c.Export(ctx, "/dst", FormatCSV, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(doc) {
+		t.Errorf("export of the import is\n%s\nwant\n%s", again, doc)
+	}
+	out := t.TempDir()
+	ok(c.Download(ctx, out, "/dst", Options{}))
+	sameTree(t, out, files)
+}
@@ -0,0 +1,139 @@
+package zksync
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Filter picks the paths an operation works on. Patterns are globs, or
+// regular expressions when prefixed with "re:". A glob containing a slash
+// is matched against the path relative to the tree root, any other glob
+// against single names, so "*.yaml" and ".git" match at any depth. Regular
+// expressions are matched against the relative path.
+//
+// A path is excluded when it or any of its parents matches an exclude
+// pattern. With include patterns, a file must match one itself or be below
+// a dir that does; dirs are always walked, but only created when something
+// below them is included.
+type Filter struct {
+	include []pattern
+	exclude []pattern
+}
+
+type pattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+func compilePatterns(patterns []string) ([]pattern, error) {
+	compiled := make([]pattern, 0, len(patterns))
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "re:") {
+			re, err := regexp.Compile(strings.TrimPrefix(p, "re:"))
+			if err != nil {
+				return nil, err
+			}
+			compiled = append(compiled, pattern{re: re})
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %w", p, err)
+		}
+		compiled = append(compiled, pattern{glob: p})
+	}
+	return compiled, nil
+}
+
+// NewFilter compiles the include and exclude patterns.
+func NewFilter(include, exclude []string) (*Filter, error) {
+	inc, err := compilePatterns(include)
+	if err != nil {
+		return nil, err
+	}
+	exc, err := compilePatterns(exclude)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{include: inc, exclude: exc}, nil
+}
+
+func (p pattern) match(rel string) bool {
+	if p.re != nil {
+		return p.re.MatchString(rel)
+	}
+	if !strings.Contains(p.glob, "/") {
+		rel = path.Base(rel)
+	}
+	ok, _ := path.Match(p.glob, rel)
+	return ok
+}
+
+// matchAny reports whether rel or one of its parents matches a pattern.
+func matchAny(patterns []pattern, rel string) bool {
+	for i := 0; i <= len(rel); i++ {
+		if i < len(rel) && rel[i] != '/' {
+			continue
+		}
+		for _, p := range patterns {
+			if p.match(rel[:i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Excluded reports whether rel, and everything below it, is left out. The
+// tree root itself is never excluded.
+func (f *Filter) Excluded(rel string) bool {
+	return f != nil && rel != "" && matchAny(f.exclude, rel)
+}
+
+// Included reports whether the file at rel takes part.
+func (f *Filter) Included(rel string) bool {
+	if f == nil {
+		return true
+	}
+	if f.Excluded(rel) {
+		return false
+	}
+	return len(f.include) == 0 || matchAny(f.include, rel)
+}
+
+func (f *Filter) hasIncludes() bool {
+	return f != nil && len(f.include) > 0
+}
+
+// pruneEmptyDirs drops dir creations that no included file needs.
+func pruneEmptyDirs(p Plan) Plan {
+	needed := make(map[string]bool)
+	for _, o := range p {
+		if o.Dir || o.Kind == OpDelete || o.Kind == OpRemove {
+			continue
+		}
+		if o.Kind == OpCreate || o.Kind == OpSet {
+			for dir := path.Dir(o.Target); !needed["remote:"+dir]; dir = path.Dir(dir) {
+				needed["remote:"+dir] = true
+			}
+		} else {
+			for dir := filepath.Dir(o.Target); !needed["local:"+dir]; dir = filepath.Dir(dir) {
+				needed["local:"+dir] = true
+			}
+		}
+	}
+
+	pruned := p[:0:0]
+	for _, o := range p {
+		if o.Kind == OpCreate && o.Dir && !needed["remote:"+o.Target] {
+			continue
+		}
+		if o.Kind == OpMkdir && !needed["local:"+o.Target] {
+			continue
+		}
+		pruned = append(pruned, o)
+	}
+	return pruned
+}
@@ -0,0 +1,224 @@
+package zksync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultHistoryRoot is where earlier versions of nodes go unless told
+// otherwise.
+const DefaultHistoryRoot = "/_history"
+
+// History keeps what runs overwrite or delete, so that a node can be put
+// back as it was before a change. Every file written over or deleted is
+// first copied to Root/<path>/<mzxid>, named after the mzxid of the
+// change that made the version kept, or its version on backends without
+// zxids. Copies are stored as the node was, compressed, encrypted or in
+// chunks.
+type History struct {
+	// Root holds the versions, DefaultHistoryRoot if empty. It is never
+	// pruned, nor are changes below it kept.
+	Root string
+	// Keep is how many versions of a node are kept, older ones being
+	// purged after every run adding one. Zero keeps them until purged by
+	// hand.
+	Keep int
+}
+
+func (h *History) root() string {
+	if h == nil || h.Root == "" {
+		return DefaultHistoryRoot
+	}
+	return h.Root
+}
+
+// holds reports whether p is the history or below it.
+func (h *History) holds(p string) bool {
+	root := h.root()
+	return p == root || strings.HasPrefix(p, root+"/")
+}
+
+// HistoryVersion is one version of a node the history keeps.
+type HistoryVersion struct {
+	Version int64     `json:"version"`
+	Saved   time.Time `json:"saved"`
+	Size    int       `json:"size"`
+}
+
+// historyTargets returns the files p sets or deletes, chunks standing for
+// the file they are part of.
+func historyTargets(p Plan, h *History, t *Trash) []string {
+	seen := make(map[string]bool)
+	var targets []string
+	for _, o := range p {
+		if o.Kind != OpSet && o.Kind != OpDelete {
+			continue
+		}
+		target := o.Target
+		if isChunk(path.Base(target)) {
+			target = path.Dir(target)
+		}
+		if seen[target] || target == "/" || h.holds(target) || t.holds(target) {
+			continue
+		}
+		seen[target] = true
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// planHistory plans copying the files p sets or deletes to the history,
+// to be applied before p, and returns them too. Dirs have nothing to keep
+// and are left out, as are the trash t and versions the history already
+// has.
+func (c *Client) planHistory(p Plan, h *History, t *Trash) (Plan, []string, error) {
+	ab, withACLs := c.Backend.(ACLBackend)
+	made := make(map[string]bool)
+	var history Plan
+	var kept []string
+	for _, target := range historyTargets(p, h, t) {
+		data, stat, err := c.Backend.Get(target)
+		if err == ErrNoNode {
+			continue
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("reading %s: %w", target, err)
+		}
+		if stat.IsDir() {
+			continue
+		}
+		version := stat.Mzxid
+		if version == 0 {
+			version = stat.Version
+		}
+		dest := path.Join(h.root(), target, strconv.FormatInt(version, 10))
+		if _, _, err := c.Backend.Get(dest); err == nil {
+			continue
+		} else if err != ErrNoNode {
+			return nil, nil, fmt.Errorf("checking %s: %w", dest, err)
+		}
+		var acl []ACL
+		if withACLs {
+			// the kept copy must not be any easier to read
+			if acl, err = ab.GetACL(target); err != nil {
+				return nil, nil, fmt.Errorf("reading ACL of %s: %w", target, err)
+			}
+		}
+
+		var missing []string
+		for dir := path.Dir(dest); dir != "/" && !made[dir]; dir = path.Dir(dir) {
+			made[dir] = true
+			if _, _, err := c.Backend.Get(dir); err == nil {
+				break
+			} else if err != ErrNoNode {
+				return nil, nil, fmt.Errorf("checking %s: %w", dir, err)
+			}
+			missing = append(missing, dir)
+		}
+		for i := len(missing) - 1; i >= 0; i-- {
+			history = append(history, Op{Kind: OpCreate, Target: missing[i], Dir: true})
+		}
+		history = append(history, Op{Kind: OpCreate, Source: target, Target: dest, Data: data, ACL: acl})
+		var m chunkManifest
+		if bytes.HasPrefix(data, chunkMagic) {
+			if err := json.Unmarshal(data[len(chunkMagic):], &m); err != nil {
+				return nil, nil, fmt.Errorf("bad chunk manifest in %s: %w", target, err)
+			}
+		}
+		for i := 0; i < m.Chunks; i++ {
+			chunk, _, err := c.Backend.Get(path.Join(target, chunkName(i)))
+			if err != nil {
+				return nil, nil, fmt.Errorf("reading chunk %d of %s: %w", i, target, err)
+			}
+			history = append(history, Op{Kind: OpCreate, Source: target, Target: path.Join(dest, chunkName(i)), Data: chunk, ACL: acl})
+		}
+		kept = append(kept, target)
+	}
+	return history, kept, nil
+}
+
+// NodeHistory lists the versions the history keeps of the file at p,
+// oldest first.
+func (c *Client) NodeHistory(h *History, p string) ([]HistoryVersion, error) {
+	dir := path.Join(h.root(), p)
+	children, _, err := c.Backend.List(dir)
+	if err == ErrNoNode {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", dir, err)
+	}
+	var versions []HistoryVersion
+	for _, name := range children {
+		version, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		_, stat, err := c.getFile(path.Join(dir, name))
+		if err == ErrNoNode || (err == nil && stat.IsDir()) {
+			// gone, or a dir of files below p
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path.Join(dir, name), err)
+		}
+		versions = append(versions, HistoryVersion{Version: version, Saved: stat.Ctime, Size: stat.DataLength})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions, nil
+}
+
+// Revert writes the version of the file at p the history keeps as version
+// back to p, over whatever it holds now, or as a new node if it is gone.
+// The version stays in the history.
+func (c *Client) Revert(ctx context.Context, h *History, p string, version int64, opts Options) (*Result, error) {
+	src := path.Join(h.root(), p, strconv.FormatInt(version, 10))
+	data, stat, err := c.getFile(src)
+	if err == ErrNoNode || (err == nil && stat.IsDir()) {
+		return nil, fmt.Errorf("no version %d of %s in the history", version, p)
+	} else if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", src, err)
+	}
+	return c.Put(ctx, p, data, -1, opts)
+}
+
+// purgeHistory deletes all but the h.Keep latest versions of files.
+func (c *Client) purgeHistory(ctx context.Context, h *History, files []string) (*Result, error) {
+	var p Plan
+	for _, file := range files {
+		versions, err := c.NodeHistory(h, file)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(versions)-h.Keep; i++ {
+			versionPlan, err := c.planDelete(ctx, path.Join(h.root(), file, strconv.FormatInt(versions[i].Version, 10)))
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, versionPlan...)
+		}
+	}
+	// versions are as deep as they are, MinDeleteDepth is for the files
+	// they were of
+	return c.run(ctx, p, Options{ForceDelete: true})
+}
+
+// expireHistory purges the versions of files over h.Keep, after a run has
+// added some.
+func (c *Client) expireHistory(ctx context.Context, h *History, files []string) {
+	if h.Keep <= 0 {
+		return
+	}
+	res, err := c.purgeHistory(ctx, h, files)
+	if err == nil && len(res.Failed) > 0 {
+		err = res.Failed[0]
+	}
+	if err != nil {
+		c.logger().Warn("Could not purge old history", "path", h.root(), "err", err)
+	}
+}
@@ -0,0 +1,212 @@
+package zksync
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFile names the files listing local paths that are never uploaded,
+// in .gitignore syntax. Patterns in a nested one are relative to its dir,
+// and the last pattern matching a path decides whether it is ignored. The
+// ignore files themselves are never uploaded either.
+const IgnoreFile = ".zkignore"
+
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignorer reads the ignore files of a local tree as the walk reaches them.
+type ignorer struct {
+	root      string
+	names     *NameMap
+	rules     map[string][]ignoreRule // by dir, relative to root
+	ephemeral map[string]bool         // listed in the EphemeralFile
+	redacted  []pattern               // listed in the RedactFile, nil until read
+}
+
+func newIgnorer(root string, names *NameMap) *ignorer {
+	return &ignorer{root: root, names: names, rules: make(map[string][]ignoreRule)}
+}
+
+// ignorerFor returns an ignorer for the tree absLocal sits in at rel.
+func ignorerFor(absLocal, rel string, names *NameMap) *ignorer {
+	root := absLocal
+	if rel != "" {
+		for i := 0; i <= strings.Count(rel, "/"); i++ {
+			root = filepath.Dir(root)
+		}
+	}
+	return newIgnorer(root, names)
+}
+
+func (ig *ignorer) load(dir string) ([]ignoreRule, error) {
+	if rules, ok := ig.rules[dir]; ok {
+		return rules, nil
+	}
+
+	var rules []ignoreRule
+	f, err := os.Open(filepath.Join(ig.root, filepath.FromSlash(ig.names.LocalPath(dir)), IgnoreFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if rule, ok := parseIgnoreLine(scanner.Text()); ok {
+				rules = append(rules, rule)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	ig.rules[dir] = rules
+	return rules, nil
+}
+
+// ignored reports whether the path at rel, or a dir above it, is listed in
+// an ignore file, the EphemeralFile or the RedactFile. The DeployNode,
+// StateFile, MetadataFile and CursorFile are always ignored.
+func (ig *ignorer) ignored(rel string, isDir bool) (bool, error) {
+	if rel == "" {
+		return false, nil
+	}
+	if !isDir && (path.Base(rel) == IgnoreFile || rel == EphemeralFile || rel == DeployNode || rel == StateFile || rel == StateFile+".tmp" || rel == MetadataFile || rel == RedactFile || rel == CursorFile || rel == CursorFile+".tmp") {
+		return true, nil
+	}
+	if ig.ephemeral == nil {
+		listed, err := readEphemeralFile(ig.root)
+		if err != nil {
+			return false, err
+		}
+		ig.ephemeral = listed
+	}
+	if ig.ephemeral[rel] {
+		return true, nil
+	}
+	if ig.redacted == nil {
+		listed, err := readRedactFile(ig.root)
+		if err != nil {
+			return false, err
+		}
+		if ig.redacted, err = compilePatterns(listed); err != nil {
+			return false, fmt.Errorf("reading %s: %w", RedactFile, err)
+		}
+	}
+	if matchAny(ig.redacted, rel) {
+		return true, nil
+	}
+	if parent := path.Dir(rel); parent != "." {
+		if ignored, err := ig.ignored(parent, true); ignored || err != nil {
+			return ignored, err
+		}
+	}
+
+	ignored := false
+	dir := ""
+	for {
+		rules, err := ig.load(dir)
+		if err != nil {
+			return false, err
+		}
+		sub := strings.TrimPrefix(rel[len(dir):], "/")
+		for _, rule := range rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.re.MatchString(sub) {
+				ignored = !rule.negate
+			}
+		}
+
+		next := strings.IndexByte(sub, '/')
+		if next < 0 {
+			return ignored, nil
+		}
+		dir = path.Join(dir, sub[:next])
+	}
+}
+
+func parseIgnoreLine(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || line[0] == '#' {
+		return ignoreRule{}, false
+	}
+
+	var rule ignoreRule
+	if line[0] == '!' {
+		rule.negate = true
+		line = line[1:]
+	} else if line[0] == '\\' {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	re, err := regexp.Compile(ignoreRegexp(line))
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.re = re
+	return rule, true
+}
+
+// ignoreRegexp translates a gitignore pattern. A pattern without a slash
+// matches at any depth, any other is anchored to the dir of its ignore file.
+func ignoreRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	if !strings.Contains(pattern, "/") {
+		b.WriteString("(?:.*/)?")
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/") && (i == 0 || pattern[i-1] == '/'):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case ch == '*':
+			b.WriteString("[^/]*")
+		case ch == '?':
+			b.WriteString("[^/]")
+		case ch == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case ch == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
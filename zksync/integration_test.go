@@ -0,0 +1,252 @@
+//go:build integration
+
+package zksync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The integration tests run against ZooKeeper in a Docker container, the
+// image $ZOOKEEPER_IMAGE names or zookeeper:3.8, and are skipped without
+// Docker:
+//
+//	go test -tags integration ./zksync
+var zookeeper struct {
+	once      sync.Once
+	container string
+	servers   string
+	err       error
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if zookeeper.container != "" {
+		exec.Command("docker", "rm", "-f", zookeeper.container).Run()
+	}
+	os.Exit(code)
+}
+
+// docker runs the docker command with args, returning what it printed.
+func docker(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("docker", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("docker %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// startZooKeeper starts the container the tests share, once, and waits for
+// it to take connections.
+func startZooKeeper() (string, error) {
+	image := os.Getenv("ZOOKEEPER_IMAGE")
+	if image == "" {
+		image = "zookeeper:3.8"
+	}
+	container, err := docker("run", "-d", "-p", "127.0.0.1::2181", image)
+	if err != nil {
+		return "", err
+	}
+	zookeeper.container = container
+	addr, err := docker("port", container, "2181/tcp")
+	if err != nil {
+		return "", err
+	}
+	addr = strings.Split(addr, "\n")[0]
+
+	deadline := time.Now().Add(time.Minute)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			// the port is open before the server answers, srvr says when
+			// it does
+			conn.SetDeadline(time.Now().Add(time.Second))
+			conn.Write([]byte("srvr"))
+			var reply bytes.Buffer
+			reply.ReadFrom(conn)
+			conn.Close()
+			if strings.Contains(reply.String(), "Mode:") {
+				return addr, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("ZooKeeper at %s not up after a minute", addr)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// zkClient returns a client of the shared ZooKeeper, working below a
+// prefix of the test's own that is deleted once it is done.
+func zkClient(t *testing.T) (*Client, string) {
+	t.Helper()
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("no docker")
+	}
+	zookeeper.once.Do(func() {
+		zookeeper.servers, zookeeper.err = startZooKeeper()
+	})
+	if zookeeper.err != nil {
+		t.Skipf("no ZooKeeper: %v", zookeeper.err)
+	}
+	b, err := Open(BackendConfig{Kind: "zookeeper", Servers: zookeeper.servers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New(b)
+	prefix := "/" + strings.ReplaceAll(t.Name(), "/", "-")
+	t.Cleanup(func() {
+		c.Delete(context.Background(), prefix, Options{})
+		b.Close()
+	})
+	return c, prefix
+}
+
+func TestIntegrationRoundTrip(t *testing.T) {
+	c, prefix := zkClient(t)
+	ctx, ok := context.Background(), applied(t)
+	files := map[string]string{
+		"app.conf":             "port = 8080\n",
+		"db/primary.json":      "{\"host\": \"db1\"}\n",
+		"db/replicas/r1.json":  "{\"host\": \"db2\"}\n",
+		"large.txt":            strings.Repeat("0123456789", 500),
+		"empty":                "",
+		"deep/er/still/x.yaml": "x: 1\n",
+		"binary.bin":           "\x00\x01\x02\xff",
+	}
+	up, down := t.TempDir(), t.TempDir()
+	writeTree(t, up, files)
+	opts := Options{ChunkSize: 1000}
+
+	ok(c.Upload(ctx, up, prefix, opts))
+	data, stat, err := c.ReadNode(prefix + "/large.txt")
+	if err != nil || string(data) != files["large.txt"] || stat.Chunks != 5 {
+		t.Fatalf("large.txt reads back %d bytes in %d chunks, %v", len(data), stat.Chunks, err)
+	}
+	ok(c.Download(ctx, down, prefix, opts))
+	sameTree(t, down, files)
+	if diffs, err := c.Diff(ctx, up, prefix, opts); err != nil || len(diffs) > 0 {
+		t.Fatalf("diff after upload: %v %v", diffs, err)
+	}
+
+	// a second upload has nothing to do
+	if res := ok(c.Upload(ctx, up, prefix, opts)); len(res.Plan) > 0 {
+		t.Fatalf("second upload: %v", res.Plan)
+	}
+}
+
+func TestIntegrationPrune(t *testing.T) {
+	c, prefix := zkClient(t)
+	ctx, ok := context.Background(), applied(t)
+	local, mirror := t.TempDir(), t.TempDir()
+	writeTree(t, local, map[string]string{"keep": "1", "gone": "2", "dir/gone": "3", "dir/keep": "4"})
+	ok(c.Upload(ctx, local, prefix, Options{}))
+	ok(c.Download(ctx, mirror, prefix, Options{}))
+
+	os.Remove(filepath.Join(local, "gone"))
+	os.Remove(filepath.Join(local, "dir", "gone"))
+	ok(c.Upload(ctx, local, prefix, Options{Prune: true}))
+	for _, p := range []string{"/gone", "/dir/gone"} {
+		if _, _, err := c.Backend.Get(prefix + p); err != ErrNoNode {
+			t.Errorf("%s still there after pruning: %v", p, err)
+		}
+	}
+	ok(c.Download(ctx, mirror, prefix, Options{Prune: true}))
+	sameTree(t, mirror, map[string]string{"keep": "1", "dir/keep": "4"})
+}
+
+func TestIntegrationDelete(t *testing.T) {
+	c, prefix := zkClient(t)
+	ctx, ok := context.Background(), applied(t)
+	local := t.TempDir()
+	writeTree(t, local, map[string]string{"a": "1", "b/c": "2", "b/d/e": "3"})
+	ok(c.Upload(ctx, local, prefix, Options{}))
+
+	ok(c.Delete(ctx, prefix+"/b", Options{}))
+	if _, _, err := c.Backend.Get(prefix + "/b"); err != ErrNoNode {
+		t.Fatalf("%s/b still there: %v", prefix, err)
+	}
+	if data, _, err := c.ReadNode(prefix + "/a"); err != nil || string(data) != "1" {
+		t.Fatalf("%s/a reads %q, %v", prefix, data, err)
+	}
+	ok(c.Delete(ctx, prefix, Options{}))
+	if _, _, err := c.Backend.Get(prefix); err != ErrNoNode {
+		t.Fatalf("%s still there: %v", prefix, err)
+	}
+}
+
+// waitEvent waits for an event of type typ on p, failing t if events end
+// or say nothing of it for long.
+func waitEvent(t *testing.T, events <-chan Event, typ EventType, p string) {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatalf("events ended waiting for %s %s", typ, p)
+			}
+			if ev.Err != nil {
+				t.Fatalf("waiting for %s %s: %v", typ, p, ev.Err)
+			}
+			if ev.Type == typ && ev.Path == p {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no %s %s", typ, p)
+		}
+	}
+}
+
+func TestIntegrationWatchRecreated(t *testing.T) {
+	c, prefix := zkClient(t)
+	b := c.Backend
+	child := prefix + "/child"
+	if err := b.Create(prefix, nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := b.Watch(ctx, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Create(child, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, events, EventCreated, child)
+
+	// deleted and created again at once, most likely before the children
+	// watch on prefix is set again, it is still watched
+	for i := 0; i < 5; i++ {
+		if err := b.Delete(child, -1); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Create(child, []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+		waitEvent(t, events, EventCreated, child)
+		if err := b.Set(child, []byte(fmt.Sprint(i)), -1); err != nil {
+			t.Fatal(err)
+		}
+		waitEvent(t, events, EventChanged, child)
+	}
+
+	if err := b.Create(child+"/grandchild", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, events, EventCreated, child+"/grandchild")
+}
@@ -0,0 +1,198 @@
+package zksync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Journal records plans and the changes of them that went through, so
+// that a run that was killed halfway can be resumed without walking the
+// trees again. A journal file may hold the plans of several trees, each
+// under its own key.
+type Journal struct {
+	*journalFile
+	key string
+}
+
+type journalFile struct {
+	path string
+
+	mu       sync.Mutex
+	f        *os.File
+	plans    map[string]Plan
+	finished map[string]map[string]bool
+}
+
+// journalEntry is a line of the journal file: either the plan of a tree,
+// or one of its changes being done.
+type journalEntry struct {
+	Key  string `json:"key"`
+	Plan Plan   `json:"plan,omitempty"`
+	Done *Op    `json:"done,omitempty"`
+}
+
+// OpenJournal opens the journal file at path, reading what it recorded
+// if resume is set and starting it afresh otherwise.
+func OpenJournal(path string, resume bool) (*Journal, error) {
+	jf := &journalFile{path: path, plans: make(map[string]Plan), finished: make(map[string]map[string]bool)}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		if err := jf.read(); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+	jf.f = f
+	return &Journal{journalFile: jf}, nil
+}
+
+func (jf *journalFile) read() error {
+	f, err := os.Open(jf.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<30)
+	for sc.Scan() {
+		var e journalEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			// the line being written when the run was killed
+			break
+		}
+		if e.Done == nil {
+			jf.plans[e.Key] = e.Plan
+			jf.finished[e.Key] = make(map[string]bool)
+		} else if jf.finished[e.Key] != nil {
+			jf.finished[e.Key][journalKey(*e.Done)] = true
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("reading journal %s: %w", jf.path, err)
+	}
+	return nil
+}
+
+func journalKey(o Op) string {
+	return o.Kind.String() + " " + o.Target
+}
+
+// For returns the journal of the tree key stands for.
+func (j *Journal) For(key string) *Journal {
+	return &Journal{journalFile: j.journalFile, key: key}
+}
+
+// Pending returns the changes of the recorded plan that were not done, and
+// whether there was a plan at all.
+func (j *Journal) Pending() (Plan, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	p, ok := j.plans[j.key]
+	if !ok {
+		return nil, false
+	}
+	var pending Plan
+	for _, o := range p {
+		if !j.finished[j.key][journalKey(o)] {
+			pending = append(pending, o)
+		}
+	}
+	return pending, true
+}
+
+func (j *Journal) write(e journalEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.f.Write(append(line, '\n'))
+	return err
+}
+
+// begin records p as the plan of the tree.
+func (j *Journal) begin(p Plan) error {
+	if err := j.write(journalEntry{Key: j.key, Plan: p}); err != nil {
+		return fmt.Errorf("writing journal %s: %w", j.path, err)
+	}
+	return nil
+}
+
+// done records that o went through. Failing to is only logged, as the
+// change itself was made.
+func (j *Journal) done(c *Client, o Op) {
+	done := o
+	// the data is in the plan already
+	done.Data, done.ACL, done.OldACL = nil, nil, nil
+	if err := j.write(journalEntry{Key: j.key, Done: &done}); err != nil {
+		c.logger().Warn("Could not write journal", "path", j.path, "err", err)
+	}
+}
+
+// Close closes the journal file, keeping it for a later resume.
+func (j *Journal) Close() error {
+	return j.f.Close()
+}
+
+// Remove closes and deletes the journal file, once there is nothing left
+// to resume.
+func (j *Journal) Remove() error {
+	j.f.Close()
+	return os.Remove(j.path)
+}
+
+// Resume applies the changes the journal says were left to do when the
+// run that planned them stopped. Changes that went through without being
+// recorded, while the run was being killed, are found and skipped.
+func (c *Client) Resume(ctx context.Context, j *Journal, opts Options) (*Result, error) {
+	p, _ := j.Pending()
+	var todo Plan
+	for _, o := range p {
+		applied, err := c.applied(o)
+		if err != nil {
+			return nil, err
+		}
+		if applied {
+			c.logger().Debug("Already applied", "op", o.String())
+			continue
+		}
+		todo = append(todo, o)
+	}
+	return c.run(ctx, todo, opts)
+}
+
+// applied tells whether o was already made, for the changes that could
+// not simply be made twice.
+func (c *Client) applied(o Op) (bool, error) {
+	switch o.Kind {
+	case OpCreate, OpSet:
+		if o.Dir {
+			// creating dirs that exist is fine
+			return false, nil
+		}
+		data, _, err := c.Backend.Get(o.Target)
+		if err == ErrNoNode {
+			return false, nil
+		} else if err != nil {
+			return false, fmt.Errorf("checking %s: %w", o.Target, err)
+		}
+		return bytes.Equal(data, o.Data), nil
+	case OpDelete:
+		_, _, err := c.Backend.Get(o.Target)
+		if err == ErrNoNode {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
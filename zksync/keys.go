@@ -0,0 +1,321 @@
+package zksync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// splitKey splits a dotted key such as db.pool.size into the keys it goes
+// through, numbers indexing into lists. A key holding a dot escapes it as
+// \.
+func splitKey(key string) ([]string, error) {
+	var keys []string
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch {
+		case key[i] == '\\' && i+1 < len(key) && key[i+1] == '.':
+			b.WriteByte('.')
+			i++
+		case key[i] == '.':
+			keys = append(keys, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(key[i])
+		}
+	}
+	keys = append(keys, b.String())
+	for _, k := range keys {
+		if k == "" {
+			return nil, fmt.Errorf("bad key %q", key)
+		}
+	}
+	return keys, nil
+}
+
+// keyDoc is the JSON or YAML document in a file, parsed so that it can be
+// written back as it was but for the keys set.
+type keyDoc struct {
+	doc    yaml.Node
+	json   bool
+	indent bool
+}
+
+func parseKeyDoc(p string, data []byte) (*keyDoc, error) {
+	d := &keyDoc{}
+	trimmed := bytes.TrimSpace(data)
+	if json.Valid(trimmed) && len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		d.json = true
+		d.indent = bytes.Contains(trimmed, []byte("\n"))
+	}
+	if err := yaml.Unmarshal(data, &d.doc); err != nil {
+		return nil, fmt.Errorf("%s holds neither JSON nor YAML: %w", p, err)
+	}
+	if len(d.doc.Content) == 0 {
+		return nil, fmt.Errorf("%s is empty", p)
+	}
+	return d, nil
+}
+
+// encode returns n, the whole document if nil, written as the document is.
+func (d *keyDoc) encode(n *yaml.Node) ([]byte, error) {
+	if n == nil {
+		n = &d.doc
+	}
+	if !d.json {
+		return encodeDoc(n, false)
+	}
+	var buf bytes.Buffer
+	if err := writeJSONNode(&buf, n, d.indent, 0); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// writeJSONNode writes n as JSON, keys in the order they come and scalars
+// as they are written, unlike encoding/json.
+func writeJSONNode(buf *bytes.Buffer, n *yaml.Node, indent bool, depth int) error {
+	newline := func(depth int) {
+		if indent {
+			buf.WriteByte('\n')
+			buf.WriteString(strings.Repeat("  ", depth))
+		}
+	}
+	switch n.Kind {
+	case yaml.DocumentNode:
+		return writeJSONNode(buf, n.Content[0], indent, depth)
+	case yaml.AliasNode:
+		return writeJSONNode(buf, n.Alias, indent, depth)
+	case yaml.MappingNode, yaml.SequenceNode:
+		open, close, step := "[", "]", 1
+		if n.Kind == yaml.MappingNode {
+			open, close, step = "{", "}", 2
+		}
+		buf.WriteString(open)
+		for i := 0; i < len(n.Content); i += step {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			newline(depth + 1)
+			if step == 2 {
+				writeJSONString(buf, n.Content[i].Value)
+				buf.WriteString(":")
+				if indent {
+					buf.WriteByte(' ')
+				}
+			}
+			if err := writeJSONNode(buf, n.Content[i+step-1], indent, depth+1); err != nil {
+				return err
+			}
+		}
+		if len(n.Content) > 0 {
+			newline(depth)
+		}
+		buf.WriteString(close)
+		return nil
+	}
+	switch n.ShortTag() {
+	case "!!null":
+		buf.WriteString("null")
+	case "!!bool":
+		v, err := strconv.ParseBool(strings.ToLower(n.Value))
+		if err != nil {
+			return fmt.Errorf("bad bool %q", n.Value)
+		}
+		buf.WriteString(strconv.FormatBool(v))
+	case "!!int", "!!float":
+		if !json.Valid([]byte(n.Value)) {
+			return fmt.Errorf("%q is not a JSON number", n.Value)
+		}
+		buf.WriteString(n.Value)
+	default:
+		writeJSONString(buf, n.Value)
+	}
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	// Encode ends every value with a newline
+	buf.Truncate(buf.Len() - 1)
+}
+
+// find returns the node at keys, nil if there is none.
+func find(n *yaml.Node, keys []string) *yaml.Node {
+	for _, k := range keys {
+		if n.Kind == yaml.DocumentNode {
+			n = n.Content[0]
+		}
+		if n.Kind == yaml.AliasNode {
+			n = n.Alias
+		}
+		i := childIndex(n, k)
+		if i < 0 {
+			return nil
+		}
+		n = n.Content[i]
+	}
+	return n
+}
+
+// childIndex returns where in the Content of the map or list n the value
+// of key is, -1 if it has none.
+func childIndex(n *yaml.Node, key string) int {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == key {
+				return i + 1
+			}
+		}
+	case yaml.SequenceNode:
+		if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(n.Content) {
+			return i
+		}
+	}
+	return -1
+}
+
+// setKey sets the node at keys below the map or list n to value, adding
+// maps for the keys that are missing, and items to lists one past their
+// end.
+func setKey(n *yaml.Node, keys []string, value *yaml.Node) error {
+	i := childIndex(n, keys[0])
+	if i < 0 {
+		next := value
+		if len(keys) > 1 {
+			next = &yaml.Node{Kind: yaml.MappingNode, Style: n.Style & yaml.FlowStyle}
+		}
+		if n.Kind == yaml.MappingNode {
+			n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: keys[0]}, next)
+		} else if keys[0] == strconv.Itoa(len(n.Content)) {
+			n.Content = append(n.Content, next)
+		} else {
+			return fmt.Errorf("%s is past the end of the list", keys[0])
+		}
+		i = len(n.Content) - 1
+	}
+	if len(keys) == 1 {
+		old := n.Content[i]
+		if value.HeadComment == "" && value.LineComment == "" && value.FootComment == "" {
+			// the comments are on the key, not on what it holds
+			copied := *value
+			copied.HeadComment, copied.LineComment, copied.FootComment = old.HeadComment, old.LineComment, old.FootComment
+			value = &copied
+		}
+		n.Content[i] = value
+		return nil
+	}
+	child := n.Content[i]
+	if child.Kind == yaml.AliasNode {
+		child = child.Alias
+	}
+	if child.Kind != yaml.MappingNode && child.Kind != yaml.SequenceNode {
+		return fmt.Errorf("%s is neither a map nor a list", keys[0])
+	}
+	if err := setKey(child, keys[1:], value); err != nil {
+		return fmt.Errorf("%s.%w", keys[0], err)
+	}
+	return nil
+}
+
+// GetKey returns the value of the dotted key of the JSON or YAML document
+// in the file at p: scalars as they are, without quotes, and maps and
+// lists written as the document is.
+func (c *Client) GetKey(p, key string) ([]byte, error) {
+	keys, err := splitKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := c.getFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", p, err)
+	}
+	d, err := parseKeyDoc(p, data)
+	if err != nil {
+		return nil, err
+	}
+	n := find(&d.doc, keys)
+	if n == nil {
+		return nil, fmt.Errorf("%s has no key %s", p, key)
+	}
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if n.Kind == yaml.ScalarNode {
+		return []byte(n.Value), nil
+	}
+	return d.encode(n)
+}
+
+// SetKey sets the dotted key of the JSON or YAML document in the file at p
+// to value, adding the maps the key goes through if missing, and leaving
+// the rest of the document as it was: keys in their order, numbers as
+// written and YAML comments kept, though the document is reformatted.
+// value is read as JSON or YAML, whichever the document is, so that 20 is
+// a number and {"a": 1} a map, unless asString. With a version of 0 or
+// more the node must be at that version; either way it is only written
+// over the version read, failing with ErrBadVersion if it changed in
+// between.
+func (c *Client) SetKey(ctx context.Context, p, key, value string, asString bool, version int64, opts Options) (*Result, error) {
+	keys, err := splitKey(key)
+	if err != nil {
+		return nil, err
+	}
+	old, stat, err := c.getFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", p, err)
+	}
+	if stat.IsDir() {
+		return nil, fmt.Errorf("%s is a dir", p)
+	}
+	if version >= 0 && stat.Version != version {
+		return nil, fmt.Errorf("%s is at version %d, not %d: %w", p, stat.Version, version, ErrBadVersion)
+	}
+	d, err := parseKeyDoc(p, old)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	if !asString && (!d.json || json.Valid([]byte(value))) {
+		var parsed yaml.Node
+		if yaml.Unmarshal([]byte(value), &parsed) == nil && len(parsed.Content) == 1 {
+			v = parsed.Content[0]
+		}
+	}
+	root := d.doc.Content[0]
+	if root.Kind == yaml.AliasNode {
+		root = root.Alias
+	}
+	if root.Kind != yaml.MappingNode && root.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("%s holds neither a map nor a list", p)
+	}
+	if err := setKey(root, keys, v); err != nil {
+		return nil, fmt.Errorf("setting %s in %s: %w", key, p, err)
+	}
+
+	data, err := d.encode(nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.json && !bytes.HasSuffix(old, []byte("\n")) {
+		data = bytes.TrimSuffix(data, []byte("\n"))
+	}
+	if bytes.Equal(old, data) {
+		return &Result{}, nil
+	}
+	writePlan, err := c.planWrite("", p, data, stat, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.run(ctx, writePlan, opts)
+}
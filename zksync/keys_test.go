@@ -0,0 +1,146 @@
+package zksync
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitKey(t *testing.T) {
+	for _, tt := range []struct {
+		key  string
+		want []string
+	}{
+		{"a", []string{"a"}},
+		{"db.pool.size", []string{"db", "pool", "size"}},
+		{"servers.0.host", []string{"servers", "0", "host"}},
+		{`a\.b.c`, []string{"a.b", "c"}},
+		{`a\.`, []string{"a."}},
+		{`\.a`, []string{".a"}},
+		// only a dot is escaped, other backslashes are kept
+		{`a\b`, []string{`a\b`}},
+		{`a\\.b`, []string{`a\.b`}},
+		{`a\`, []string{`a\`}},
+		{" a . b ", []string{" a ", " b "}},
+		// keys that are empty somewhere
+		{"", nil},
+		{".", nil},
+		{".a", nil},
+		{"a.", nil},
+		{"a..b", nil},
+		{`a.\.`, []string{"a", "."}},
+	} {
+		got, err := splitKey(tt.key)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("splitKey(%q) = %q, want an error", tt.key, got)
+			}
+		} else if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitKey(%q) = %q, %v, want %q", tt.key, got, err, tt.want)
+		}
+	}
+}
+
+func TestGetKey(t *testing.T) {
+	b := NewMemoryBackend()
+	c := New(b)
+	for p, data := range map[string]string{
+		"/app.json":  `{"db": {"port": 5432, "hosts": ["a", "b"], "a.b": "dotted", "empty": ""}}`,
+		"/app.yaml":  "base: &base\n  level: info\nlog: *base\nquoted: \"08\"\n",
+		"/list.json": `[{"name": "x"}]`,
+		"/text":      "not: [a document",
+		"/blank":     "",
+	} {
+		if err := b.Create(p, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range []struct {
+		p, key string
+		// want is the value, or with err set what the error says
+		want string
+		err  bool
+	}{
+		{"/app.json", "db.port", "5432", false},
+		{"/app.json", "db.hosts.1", "b", false},
+		{"/app.json", "db.hosts", `["a","b"]` + "\n", false},
+		{"/app.json", `db.a\.b`, "dotted", false},
+		{"/app.json", "db.empty", "", false},
+		{"/app.yaml", "log.level", "info", false},
+		{"/app.yaml", "quoted", "08", false},
+		{"/list.json", "0.name", "x", false},
+		{"/app.json", "db.hosts.2", "has no key db.hosts.2", true},
+		{"/app.json", "db.hosts.-1", "has no key", true},
+		{"/app.json", "db.port.x", "has no key", true},
+		{"/app.json", "db.a.b", "has no key", true},
+		{"/list.json", "name", "has no key", true},
+		{"/app.json", "db..port", `bad key "db..port"`, true},
+		{"/text", "not", "holds neither JSON nor YAML", true},
+		{"/blank", "a", "is empty", true},
+		{"/missing", "a", "reading /missing", true},
+	} {
+		got, err := c.GetKey(tt.p, tt.key)
+		if tt.err {
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("GetKey(%s, %q) fails with %v, want %q", tt.p, tt.key, err, tt.want)
+			}
+		} else if err != nil || string(got) != tt.want {
+			t.Errorf("GetKey(%s, %q) = %q, %v, want %q", tt.p, tt.key, got, err, tt.want)
+		}
+	}
+}
+
+func TestSetKey(t *testing.T) {
+	for _, tt := range []struct {
+		doc, key, value string
+		asString        bool
+		// want is the document written, or with err set what the error
+		// says
+		want string
+		err  bool
+	}{
+		{`{"a": 1}`, "a", "2", false, `{"a":2}`, false},
+		{`{"a": 1}`, "a", "2", true, `{"a":"2"}`, false},
+		{`{"a": 1}`, "a", "two", false, `{"a":"two"}`, false},
+		{`{"a": 1}`, "b.c", "true", false, `{"a":1,"b":{"c":true}}`, false},
+		{`{"a": 1.50}`, "b", "1e3", false, `{"a":1.50,"b":1e3}`, false},
+		{`{"a": [1]}`, "a.1", "2", false, `{"a":[1,2]}`, false},
+		{`{"a": [1]}`, "a.0", `{"b": null}`, false, `{"a":[{"b":null}]}`, false},
+		{`{"a.b": 1}`, `a\.b`, "2", false, `{"a.b":2}`, false},
+		{`["x"]`, "0", `"y"`, false, `["y"]`, false},
+		{"a: 1 # one\nb: x\n", "a", "2", false, "a: 2 # one\nb: x\n", false},
+		{"a: 1\n", "b", "[1, 2]", false, "a: 1\nb: [1, 2]\n", false},
+		{"a: 1\n", "b", "'08'", false, "a: 1\nb: '08'\n", false},
+		// values given to JSON documents that are not JSON are strings
+		{`{"a": 1}`, "a", "[1,", false, `{"a":"[1,"}`, false},
+		{`{"a": 1}`, "a", "yes", false, `{"a":"yes"}`, false},
+		{`{"a": [1]}`, "a.2", "3", false, "past the end of the list", true},
+		{`{"a": [1]}`, "a.x", "3", false, "past the end of the list", true},
+		{`{"a": 1}`, "a.b", "2", false, "a is neither a map nor a list", true},
+		{`{"a": {"b": "c"}}`, "a.b.c", "2", false, "a.b is neither a map nor a list", true},
+		{`"scalar"`, "a", "2", false, "holds neither a map nor a list", true},
+		{`{"a": 1}`, ".a", "2", false, `bad key ".a"`, true},
+		{`{"a": 1}`, "", "2", false, `bad key ""`, true},
+	} {
+		b := NewMemoryBackend()
+		c := New(b)
+		if err := b.Create("/f", []byte(tt.doc)); err != nil {
+			t.Fatal(err)
+		}
+		_, err := c.SetKey(context.Background(), "/f", tt.key, tt.value, tt.asString, -1, Options{})
+		if tt.err {
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("setting %q to %q in %s fails with %v, want %q", tt.key, tt.value, tt.doc, err, tt.want)
+			}
+			if data, _, _ := b.Get("/f"); string(data) != tt.doc {
+				t.Errorf("setting %q to %q failed but wrote %s", tt.key, tt.value, data)
+			}
+			continue
+		}
+		data, _, _ := b.Get("/f")
+		if err != nil || string(data) != tt.want {
+			t.Errorf("setting %q to %q in %s = %q, %v, want %q", tt.key, tt.value, tt.doc, data, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package zksync
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxConfigMapSize is what the API server accepts for a ConfigMap or a
+// Secret.
+const maxConfigMapSize = 1 << 20
+
+var kubeKeyRe = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// KubeConfig says how a tree is turned into Kubernetes manifests.
+type KubeConfig struct {
+	Name      string
+	Namespace string
+	// Secrets are patterns, as for a Filter, picking the files that go in a
+	// Secret of the same name instead of the ConfigMap.
+	Secrets []string
+}
+
+type kubeMeta struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+type kubeObject struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   kubeMeta          `yaml:"metadata"`
+	Type       string            `yaml:"type,omitempty"`
+	Data       map[string]string `yaml:"data,omitempty"`
+	BinaryData map[string]string `yaml:"binaryData,omitempty"`
+}
+
+// ReadTree returns the contents of every file under remotePath that
+// opts.Filter lets through, by path relative to remotePath, the properties
+// files of opts.Properties joined back as downloads join them.
+func (c *Client) ReadTree(ctx context.Context, remotePath string, opts Options) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	if err := c.readTree(ctx, remotePath, "", opts, files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (c *Client) readTree(ctx context.Context, serverPrefix, rel string, opts Options, files map[string][]byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if opts.Filter.Excluded(rel) {
+		return nil
+	}
+	data, stat, err := c.getFile(serverPrefix)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", serverPrefix, err)
+	}
+	if !stat.IsDir() {
+		if opts.Filter.Included(rel) {
+			files[rel] = data
+		}
+		return nil
+	}
+	if opts.Properties.matches(rel) {
+		props, _, err := c.readProperties(ctx, serverPrefix, opts)
+		if err != nil {
+			return err
+		}
+		if opts.Filter.Included(rel) {
+			files[rel] = formatProperties(props)
+		}
+		return nil
+	}
+
+	children, _, err := c.Backend.List(serverPrefix)
+	if err != nil {
+		return fmt.Errorf("listing %s: %w", serverPrefix, err)
+	}
+	for _, child := range children {
+		if err := c.readTree(ctx, path.Join(serverPrefix, child), path.Join(rel, child), opts, files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KubeManifests renders files, as ReadTree returns them, as a ConfigMap
+// and, if any file matches cfg.Secrets, a Secret. Keys are the relative
+// paths with slashes turned into dots, as keys cannot hold slashes.
+func KubeManifests(files map[string][]byte, cfg KubeConfig) ([]byte, error) {
+	secrets, err := compilePatterns(cfg.Secrets)
+	if err != nil {
+		return nil, err
+	}
+	meta := kubeMeta{Name: cfg.Name, Namespace: cfg.Namespace}
+	cm := &kubeObject{APIVersion: "v1", Kind: "ConfigMap", Metadata: meta}
+	secret := &kubeObject{APIVersion: "v1", Kind: "Secret", Metadata: meta, Type: "Opaque"}
+
+	rels := make([]string, 0, len(files))
+	for rel := range files {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	keys := make(map[string]string)
+	var cmSize, secretSize int
+	for _, rel := range rels {
+		key := strings.ReplaceAll(rel, "/", ".")
+		if !kubeKeyRe.MatchString(key) {
+			return nil, fmt.Errorf("%s cannot be a ConfigMap key", rel)
+		}
+		if other, ok := keys[key]; ok {
+			return nil, fmt.Errorf("%s and %s both map to key %s", other, rel, key)
+		}
+		keys[key] = rel
+
+		data := files[rel]
+		switch {
+		case len(secrets) > 0 && matchAny(secrets, rel):
+			if secret.Data == nil {
+				secret.Data = make(map[string]string)
+			}
+			secret.Data[key] = base64.StdEncoding.EncodeToString(data)
+			secretSize += len(data)
+		case utf8.Valid(data):
+			if cm.Data == nil {
+				cm.Data = make(map[string]string)
+			}
+			cm.Data[key] = string(data)
+			cmSize += len(data)
+		default:
+			if cm.BinaryData == nil {
+				cm.BinaryData = make(map[string]string)
+			}
+			cm.BinaryData[key] = base64.StdEncoding.EncodeToString(data)
+			cmSize += len(data)
+		}
+	}
+	if cmSize > maxConfigMapSize || secretSize > maxConfigMapSize {
+		return nil, fmt.Errorf("%s is over the 1MB Kubernetes allows", cfg.Name)
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	objects := []*kubeObject{cm}
+	if secret.Data != nil {
+		objects = append(objects, secret)
+	}
+	for _, o := range objects {
+		if err := enc.Encode(o); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package zksync
+
+import "os"
+
+// localMode is the mode of a local file as Options.ModeACLs maps it.
+func localMode(fInfo os.FileInfo) os.FileMode {
+	return fInfo.Mode().Perm()
+}
+
+// sameMode reports whether a local file with mode have already has the
+// mode want.
+func sameMode(have, want os.FileMode) bool {
+	return have == want
+}
+
+// checkLocalName checks the node at p can be the local file name. Any name
+// a node can have is one here.
+func checkLocalName(p, name string) error {
+	return nil
+}
+
+// DefaultLocalChars are the characters local file names cannot hold,
+// besides NUL and /.
+const DefaultLocalChars = ""
@@ -0,0 +1,38 @@
+//go:build windows
+
+package zksync
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// localMode is the mode of a local file as Options.ModeACLs maps it.
+// Windows files have no group or other bits, only a read-only flag that
+// Go reports as 0444 against 0666, so they count as readable by others
+// and writable by no one but the owner, as 0644 is on Unix.
+func localMode(fInfo os.FileInfo) os.FileMode {
+	return fInfo.Mode().Perm() &^ 0022
+}
+
+// sameMode reports whether a local file with mode have already has the
+// mode want. Only the owner write bit can be changed, as the read-only
+// flag, so that is all that is compared.
+func sameMode(have, want os.FileMode) bool {
+	return have&0200 == want&0200
+}
+
+// checkLocalName checks the node at p can be the local file name. A
+// backslash is a separator here, so a node named a\b would end up as b in
+// a dir a, and uploaded back as a/b, unless Options.Names escapes it.
+func checkLocalName(p, name string) error {
+	if strings.ContainsRune(name, '\\') {
+		return fmt.Errorf("%s cannot be a local file here: its name holds a backslash", p)
+	}
+	return nil
+}
+
+// DefaultLocalChars are the characters local file names cannot hold,
+// besides NUL and /.
+const DefaultLocalChars = "<>:\"\\|?*\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13\x14\x15\x16\x17\x18\x19\x1a\x1b\x1c\x1d\x1e\x1f"
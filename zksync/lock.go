@@ -0,0 +1,59 @@
+package zksync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+)
+
+// LockRoot holds the locks on the trees configurator changes, each at
+// LockRoot followed by the path of the tree, outside of any tree synced.
+const LockRoot = "/_locks"
+
+// Locker is implemented by backends that can hold a lock on a path for as
+// long as their session lives, so that only one run at a time changes the
+// tree it stands for.
+type Locker interface {
+	// Lock waits until it holds the lock at p, recording holder with it,
+	// or until ctx is done, when it fails with ErrLocked naming the
+	// holder if it can. unlock releases the lock.
+	Lock(ctx context.Context, p, holder string) (unlock func(), err error)
+}
+
+// Lock takes the lock on the tree at remotePath, waiting for other runs
+// holding it until ctx is done. Backends that cannot lock leave the tree
+// unlocked. The lock goes away with the session if it is not released.
+func (c *Client) Lock(ctx context.Context, remotePath string) (unlock func(), err error) {
+	l, ok := c.Backend.(Locker)
+	if !ok {
+		c.logger().Debug("Backend cannot lock, not locking", "path", remotePath)
+		return func() {}, nil
+	}
+	lockPath := path.Join(LockRoot, remotePath)
+	unlock, err = l.Lock(ctx, lockPath, lockHolder())
+	if err != nil {
+		return nil, fmt.Errorf("locking %s: %w", remotePath, err)
+	}
+	c.logger().Debug("Locked", "path", remotePath, "lock", lockPath)
+	return unlock, nil
+}
+
+// lockHolder describes this run, for those waiting for its locks.
+func lockHolder() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s@%s pid %d", name, host, os.Getpid())
+}
+
+// lockedBy returns ErrLocked, naming holder if known.
+func lockedBy(holder string) error {
+	if holder == "" {
+		return ErrLocked
+	}
+	return fmt.Errorf("%w by %s", ErrLocked, holder)
+}
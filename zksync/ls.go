@@ -0,0 +1,69 @@
+package zksync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+)
+
+// Entry is a node found by ListTree.
+type Entry struct {
+	// Path is relative to the path listed.
+	Path string
+	// Stat counts whole files, as for a download.
+	Stat *Stat
+}
+
+// Dir reports whether the node is treated as a dir.
+func (e Entry) Dir() bool {
+	return e.Stat.IsDir()
+}
+
+// ListTree returns the children of p, sorted, and with recursive all of
+// their descendants too, each dir followed by what is in it. The pieces of
+// chunked files are left out.
+func (c *Client) ListTree(ctx context.Context, p string, recursive bool) ([]Entry, error) {
+	var entries []Entry
+	err := c.listTree(ctx, p, "", recursive, &entries)
+	return entries, err
+}
+
+func (c *Client) listTree(ctx context.Context, serverPrefix, rel string, recursive bool, entries *[]Entry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	children, _, err := c.Backend.List(serverPrefix)
+	if err != nil {
+		return fmt.Errorf("listing %s: %w", serverPrefix, err)
+	}
+	sort.Strings(children)
+	for _, child := range children {
+		if isChunk(child) {
+			continue
+		}
+		childPath := path.Join(serverPrefix, child)
+		_, stat, err := c.getFile(childPath)
+		if errors.Is(err, ErrNoKey) {
+			// listed as stored
+			if _, stat, err = c.Backend.Get(childPath); err == nil {
+				stat.Encrypted = true
+			}
+		}
+		if err == ErrNoNode {
+			// deleted since it was listed
+			continue
+		} else if err != nil {
+			return fmt.Errorf("reading %s: %w", childPath, err)
+		}
+		e := Entry{Path: path.Join(rel, child), Stat: stat}
+		*entries = append(*entries, e)
+		if recursive && e.Dir() {
+			if err := c.listTree(ctx, childPath, e.Path, recursive, entries); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,367 @@
+package zksync
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryBackend is a Backend holding its tree in memory, the way ZooKeeper
+// would: nodes need their parent to be created, only childless nodes can
+// be deleted, and every change bumps a zxid. It also has ACLs, which it
+// keeps but does not enforce, transactions, watches and locks, so that
+// code using a Client can be exercised without a server.
+type MemoryBackend struct {
+	mu       sync.Mutex
+	nodes    map[string]*memoryNode
+	zxid     int64
+	watchers map[*memoryWatcher]bool
+	locks    map[string]string // holders by path
+	unlocked chan struct{}     // closed and replaced whenever a lock is released
+}
+
+type memoryNode struct {
+	data     []byte
+	stat     Stat
+	acl      []ACL
+	children map[string]bool
+}
+
+// NewMemoryBackend returns an empty MemoryBackend, holding only the root.
+func NewMemoryBackend() *MemoryBackend {
+	now := time.Now()
+	return &MemoryBackend{
+		nodes:    map[string]*memoryNode{"/": {stat: Stat{Mtime: now, Ctime: now}, children: make(map[string]bool)}},
+		watchers: make(map[*memoryWatcher]bool),
+		locks:    make(map[string]string),
+		unlocked: make(chan struct{}),
+	}
+}
+
+func (n *memoryNode) statCopy() *Stat {
+	stat := n.stat
+	stat.DataLength = len(n.data)
+	stat.NumChildren = len(n.children)
+	return &stat
+}
+
+func (b *MemoryBackend) Get(p string) ([]byte, *Stat, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n, ok := b.nodes[path.Clean(p)]
+	if !ok {
+		return nil, nil, ErrNoNode
+	}
+	return append([]byte(nil), n.data...), n.statCopy(), nil
+}
+
+func (b *MemoryBackend) Stat(p string) (*Stat, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n, ok := b.nodes[path.Clean(p)]
+	if !ok {
+		return nil, ErrNoNode
+	}
+	return n.statCopy(), nil
+}
+
+func (b *MemoryBackend) List(p string) ([]string, *Stat, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n, ok := b.nodes[path.Clean(p)]
+	if !ok {
+		return nil, nil, ErrNoNode
+	}
+	children := make([]string, 0, len(n.children))
+	for child := range n.children {
+		children = append(children, child)
+	}
+	sort.Strings(children)
+	return children, n.statCopy(), nil
+}
+
+func (b *MemoryBackend) Create(p string, data []byte) error {
+	return b.CreateWithACL(p, data, nil)
+}
+
+func (b *MemoryBackend) CreateWithACL(p string, data []byte, acl []ACL) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.create(p, data, acl); err != nil {
+		return err
+	}
+	b.notify(Event{Type: EventCreated, Path: path.Clean(p)})
+	return nil
+}
+
+func (b *MemoryBackend) Set(p string, data []byte, version int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.set(p, data, version); err != nil {
+		return err
+	}
+	b.notify(Event{Type: EventChanged, Path: path.Clean(p)})
+	return nil
+}
+
+func (b *MemoryBackend) Delete(p string, version int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.checkDelete(p, version); err != nil {
+		return err
+	}
+	b.remove(p)
+	b.notify(Event{Type: EventDeleted, Path: path.Clean(p)})
+	return nil
+}
+
+func (b *MemoryBackend) GetACL(p string) ([]ACL, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n, ok := b.nodes[path.Clean(p)]
+	if !ok {
+		return nil, ErrNoNode
+	}
+	if n.acl == nil {
+		return []ACL{{Scheme: "world", ID: "anyone", Perms: PermAll}}, nil
+	}
+	return append([]ACL(nil), n.acl...), nil
+}
+
+func (b *MemoryBackend) SetACL(p string, acl []ACL) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n, ok := b.nodes[path.Clean(p)]
+	if !ok {
+		return ErrNoNode
+	}
+	n.acl = append([]ACL(nil), acl...)
+	return nil
+}
+
+func (b *MemoryBackend) DeleteMulti(nodes []NodeVersion) error {
+	p := make(Plan, len(nodes))
+	for i, n := range nodes {
+		p[i] = Op{Kind: OpDelete, Target: n.Path, Version: n.Version}
+	}
+	return b.Transact(p)
+}
+
+// Transact checks every op of p against the tree as the ops before it
+// would leave it, then applies them all, or fails with nothing changed.
+func (b *MemoryBackend) Transact(p Plan) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	saved, savedZxid := b.snapshot(), b.zxid
+	var events []Event
+	for _, o := range p {
+		var err error
+		e := Event{Path: path.Clean(o.Target)}
+		switch o.Kind {
+		case OpCreate:
+			e.Type, err = EventCreated, b.create(o.Target, o.Data, o.ACL)
+		case OpSet:
+			e.Type, err = EventChanged, b.set(o.Target, o.Data, o.Version)
+		case OpDelete:
+			if err = b.checkDelete(o.Target, o.Version); err == nil {
+				e.Type = EventDeleted
+				b.remove(o.Target)
+			}
+		default:
+			err = ErrUnsupported
+		}
+		if err != nil {
+			b.nodes, b.zxid = saved, savedZxid
+			return err
+		}
+		events = append(events, e)
+	}
+	for _, e := range events {
+		b.notify(e)
+	}
+	return nil
+}
+
+// MultiLimit is no limit at all.
+func (b *MemoryBackend) MultiLimit() (int, int) {
+	return 0, 0
+}
+
+// Lock holds p until unlocked, other holders waiting on it in the same
+// process, as there is no other.
+func (b *MemoryBackend) Lock(ctx context.Context, p, holder string) (func(), error) {
+	p = path.Clean(p)
+	for {
+		b.mu.Lock()
+		held, taken := b.locks[p]
+		if !taken {
+			b.locks[p] = holder
+			b.mu.Unlock()
+			var once sync.Once
+			return func() {
+				once.Do(func() {
+					b.mu.Lock()
+					delete(b.locks, p)
+					close(b.unlocked)
+					b.unlocked = make(chan struct{})
+					b.mu.Unlock()
+				})
+			}, nil
+		}
+		unlocked := b.unlocked
+		b.mu.Unlock()
+		select {
+		case <-unlocked:
+		case <-ctx.Done():
+			return nil, lockedBy(held)
+		}
+	}
+}
+
+// Watch reports the changes to p and below it until ctx is done.
+func (b *MemoryBackend) Watch(ctx context.Context, p string) (<-chan Event, error) {
+	w := &memoryWatcher{path: path.Clean(p), wake: make(chan struct{}, 1)}
+	b.mu.Lock()
+	b.watchers[w] = true
+	b.mu.Unlock()
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer func() {
+			b.mu.Lock()
+			delete(b.watchers, w)
+			b.mu.Unlock()
+		}()
+		for {
+			select {
+			case <-w.wake:
+			case <-ctx.Done():
+				return
+			}
+			for _, e := range w.take() {
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// Close leaves the tree as it is, for the backend to be used again.
+func (b *MemoryBackend) Close() {}
+
+// memoryWatcher queues the events of a Watch, so that changes never wait
+// on whoever reads them.
+type memoryWatcher struct {
+	path string
+	wake chan struct{}
+
+	mu     sync.Mutex
+	queued []Event
+}
+
+func (w *memoryWatcher) take() []Event {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	events := w.queued
+	w.queued = nil
+	return events
+}
+
+// notify queues e for the watchers of its path. b.mu is held.
+func (b *MemoryBackend) notify(e Event) {
+	for w := range b.watchers {
+		if e.Path != w.path && !strings.HasPrefix(e.Path, strings.TrimSuffix(w.path, "/")+"/") {
+			continue
+		}
+		w.mu.Lock()
+		w.queued = append(w.queued, e)
+		w.mu.Unlock()
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// create makes the node at p. Like set, checkDelete and remove, it is
+// called with b.mu held.
+func (b *MemoryBackend) create(p string, data []byte, acl []ACL) error {
+	p = path.Clean(p)
+	if _, ok := b.nodes[p]; ok {
+		return ErrNodeExists
+	}
+	parent, ok := b.nodes[path.Dir(p)]
+	if !ok {
+		return ErrNoNode
+	}
+	b.zxid++
+	now := time.Now()
+	parent.children[path.Base(p)] = true
+	b.nodes[p] = &memoryNode{
+		data:     append([]byte(nil), data...),
+		stat:     Stat{Mtime: now, Ctime: now, Czxid: b.zxid, Mzxid: b.zxid},
+		acl:      append([]ACL(nil), acl...),
+		children: make(map[string]bool),
+	}
+	return nil
+}
+
+func (b *MemoryBackend) set(p string, data []byte, version int64) error {
+	n, ok := b.nodes[path.Clean(p)]
+	if !ok {
+		return ErrNoNode
+	}
+	if version >= 0 && n.stat.Version != version {
+		return ErrBadVersion
+	}
+	b.zxid++
+	n.data = append([]byte(nil), data...)
+	n.stat.Version++
+	n.stat.Mzxid = b.zxid
+	n.stat.Mtime = time.Now()
+	return nil
+}
+
+func (b *MemoryBackend) checkDelete(p string, version int64) error {
+	n, ok := b.nodes[path.Clean(p)]
+	switch {
+	case !ok:
+		return ErrNoNode
+	case path.Clean(p) == "/":
+		return ErrUnsupported
+	case version >= 0 && n.stat.Version != version:
+		return ErrBadVersion
+	case len(n.children) > 0:
+		return ErrNotEmpty
+	}
+	return nil
+}
+
+func (b *MemoryBackend) remove(p string) {
+	p = path.Clean(p)
+	b.zxid++
+	delete(b.nodes[path.Dir(p)].children, path.Base(p))
+	delete(b.nodes, p)
+}
+
+// snapshot copies the tree, for a failed transaction to go back to.
+func (b *MemoryBackend) snapshot() map[string]*memoryNode {
+	saved := make(map[string]*memoryNode, len(b.nodes))
+	for p, n := range b.nodes {
+		c := *n
+		c.children = make(map[string]bool, len(n.children))
+		for child := range n.children {
+			c.children[child] = true
+		}
+		saved[p] = &c
+	}
+	return saved
+}
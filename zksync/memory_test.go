@@ -0,0 +1,297 @@
+package zksync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUploadDownload(t *testing.T) {
+	c := New(NewMemoryBackend())
+	ctx, ok := context.Background(), applied(t)
+	files := map[string]string{
+		"app.conf":            "port = 8080\n",
+		"db/primary.json":     "{\"host\": \"db1\"}\n",
+		"db/replicas/r1.json": "{\"host\": \"db2\"}\n",
+		"large.txt":           "0123456789abcdef0123456789abcdef",
+	}
+	up, down := t.TempDir(), t.TempDir()
+	writeTree(t, up, files)
+	opts := Options{ChunkSize: 10}
+
+	ok(c.Upload(ctx, up, "/app", opts))
+	for name, want := range files {
+		data, _, err := c.ReadNode("/app/" + name)
+		if err != nil || string(data) != want {
+			t.Errorf("/app/%s reads %q, %v, want %q", name, data, err, want)
+		}
+	}
+	if _, stat, _ := c.ReadNode("/app/large.txt"); stat.Chunks != 4 {
+		t.Errorf("large.txt is in %d chunks, want 4", stat.Chunks)
+	}
+	if res := ok(c.Upload(ctx, up, "/app", opts)); len(res.Plan) > 0 {
+		t.Errorf("second upload plans %v", res.Plan)
+	}
+
+	ok(c.Download(ctx, down, "/app", opts))
+	sameTree(t, down, files)
+	if res := ok(c.Download(ctx, down, "/app", opts)); len(res.Plan) > 0 {
+		t.Errorf("second download plans %v", res.Plan)
+	}
+}
+
+func TestEmptyFiles(t *testing.T) {
+	b := NewMemoryBackend()
+	c := New(b)
+	ctx, ok := context.Background(), applied(t)
+	up, down := t.TempDir(), t.TempDir()
+	writeTree(t, up, map[string]string{"empty": "", "dir/empty": ""})
+
+	ok(c.Upload(ctx, up, "/app", Options{}))
+	if res := ok(c.Upload(ctx, up, "/app", Options{})); len(res.Plan) > 0 {
+		t.Errorf("second upload plans %v", res.Plan)
+	}
+	ok(c.Download(ctx, down, "/app", Options{}))
+	sameTree(t, down, map[string]string{"empty": "", "dir/empty": ""})
+
+	// with PlainEmpty they are nodes with no data, which come back as dirs
+	opts := Options{PlainEmpty: true}
+	ok(c.Upload(ctx, up, "/plain", opts))
+	if data, _, err := b.Get("/plain/empty"); err != nil || len(data) > 0 {
+		t.Errorf("/plain/empty holds %q, %v", data, err)
+	}
+	if res := ok(c.Upload(ctx, up, "/plain", opts)); len(res.Plan) > 0 {
+		t.Errorf("second upload with PlainEmpty plans %v", res.Plan)
+	}
+	if diffs, err := c.Diff(ctx, up, "/plain", opts); err != nil || len(diffs) > 0 {
+		t.Errorf("diff with PlainEmpty finds %v, %v", diffs, err)
+	}
+	// and uploading them again without it adds the marker
+	if res := ok(c.Upload(ctx, up, "/plain", Options{})); len(res.Plan) != 2 {
+		t.Errorf("upload over empty nodes plans %v, want both marked", res.Plan)
+	}
+}
+
+func TestUploadDryRun(t *testing.T) {
+	b := NewMemoryBackend()
+	c := New(b)
+	local := t.TempDir()
+	writeTree(t, local, map[string]string{"a": "1", "b/c": "2"})
+
+	res := applied(t)(c.Upload(context.Background(), local, "/app", Options{DryRun: true}))
+	if len(res.Plan) != 4 {
+		t.Errorf("dry run plans %v, want /app, a, b and b/c created", res.Plan)
+	}
+	if _, _, err := b.Get("/app"); err != ErrNoNode {
+		t.Errorf("dry run created /app: %v", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	b := NewMemoryBackend()
+	c := New(b)
+	ctx, ok := context.Background(), applied(t)
+	local := t.TempDir()
+	writeTree(t, local, map[string]string{"same": "1", "changed": "2", "dir/file": "3", "turned": "4"})
+	ok(c.Upload(ctx, local, "/app", Options{}))
+
+	writeTree(t, local, map[string]string{"changed": "2 and more", "local-only": "5"})
+	os.Remove(filepath.Join(local, "turned"))
+	writeTree(t, local, map[string]string{"turned/into-a-dir": "6"})
+	if err := b.Create("/app/remote-only", []byte("7")); err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err := c.Diff(ctx, local, "/app", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]DiffKind)
+	for _, d := range diffs {
+		got[d.Path] = d.Kind
+	}
+	want := map[string]DiffKind{"changed": Modified, "local-only": LocalOnly, "remote-only": RemoteOnly, "turned": TypeMismatch}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diff finds %v, want %v", got, want)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	b := NewMemoryBackend()
+	c := New(b)
+	ctx, ok := context.Background(), applied(t)
+	local, mirror := t.TempDir(), t.TempDir()
+	writeTree(t, local, map[string]string{"keep": "1", "gone": "2", "dir/gone": "3", "dir/keep": "4", "old/x": "5"})
+	ok(c.Upload(ctx, local, "/app", Options{}))
+	ok(c.Download(ctx, mirror, "/app", Options{}))
+
+	for _, name := range []string{"gone", "dir/gone", "old/x", "old"} {
+		if err := os.Remove(filepath.Join(local, filepath.FromSlash(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ok(c.Upload(ctx, local, "/app", Options{}))
+	if _, _, err := b.Get("/app/gone"); err != nil {
+		t.Errorf("upload without -prune deleted /app/gone: %v", err)
+	}
+	ok(c.Upload(ctx, local, "/app", Options{Prune: true}))
+	for _, p := range []string{"/app/gone", "/app/dir/gone", "/app/old"} {
+		if _, _, err := b.Get(p); err != ErrNoNode {
+			t.Errorf("%s still there after pruning: %v", p, err)
+		}
+	}
+
+	ok(c.Download(ctx, mirror, "/app", Options{Prune: true}))
+	sameTree(t, mirror, map[string]string{"keep": "1", "dir/keep": "4"})
+}
+
+func TestTransactRollback(t *testing.T) {
+	b := NewMemoryBackend()
+	for _, p := range []string{"/app", "/app/a", "/app/dir", "/app/dir/b"} {
+		if err := b.Create(p, []byte(filepath.Base(p))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, before, _ := b.Get("/app/a")
+	_, last, _ := b.Get("/app/dir/b")
+
+	for name, p := range map[string]Plan{
+		"bad version":   {{Kind: OpSet, Target: "/app/a", Data: []byte("new")}, {Kind: OpSet, Target: "/app/dir/b", Data: []byte("new"), Version: 7}},
+		"no parent":     {{Kind: OpCreate, Target: "/app/c", Data: []byte("c")}, {Kind: OpCreate, Target: "/app/none/d", Data: []byte("d")}},
+		"node exists":   {{Kind: OpDelete, Target: "/app/a", Version: -1}, {Kind: OpCreate, Target: "/app/dir", Data: []byte("x")}},
+		"has children":  {{Kind: OpSet, Target: "/app/a", Data: []byte("new"), Version: -1}, {Kind: OpDelete, Target: "/app/dir", Version: -1}},
+		"after created": {{Kind: OpCreate, Target: "/app/e", Data: []byte("e")}, {Kind: OpCreate, Target: "/app/e", Data: []byte("e")}},
+	} {
+		if err := b.Transact(p); err == nil {
+			t.Errorf("%s: transaction went through", name)
+		}
+		data, stat, err := b.Get("/app/a")
+		if err != nil || string(data) != "a" || stat.Version != before.Version || stat.Mzxid != before.Mzxid {
+			t.Errorf("%s: /app/a reads %q at version %d, %v, after the transaction failed", name, data, stat.Version, err)
+		}
+		for _, p := range []string{"/app/c", "/app/e"} {
+			if _, _, err := b.Get(p); err != ErrNoNode {
+				t.Errorf("%s: %s created by a failed transaction", name, p)
+			}
+		}
+		if _, _, err := b.Get("/app/dir/b"); err != nil {
+			t.Errorf("%s: /app/dir/b gone after the transaction failed: %v", name, err)
+		}
+	}
+
+	// a failed transaction leaves the zxids where they were too
+	if err := b.Transact(Plan{{Kind: OpCreate, Target: "/app/f", Data: []byte("f")}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, stat, _ := b.Get("/app/f"); stat.Czxid != last.Czxid+1 {
+		t.Errorf("/app/f created at zxid %d, want %d", stat.Czxid, last.Czxid+1)
+	}
+}
+
+func TestUploadAtomic(t *testing.T) {
+	b := NewMemoryBackend()
+	c := New(b)
+	ctx := context.Background()
+	local := t.TempDir()
+	writeTree(t, local, map[string]string{"a": "1", "b/c": "2"})
+
+	applied(t)(c.Upload(ctx, local, "/app", Options{Atomic: true}))
+	if data, _, err := b.Get("/app/b/c"); err != nil || string(data) != "2" {
+		t.Fatalf("/app/b/c reads %q, %v", data, err)
+	}
+
+	// a node changed behind the plan's back fails the whole of it
+	writeTree(t, local, map[string]string{"a": "10", "b/c": "20", "d": "30"})
+	p, err := c.planUpload(ctx, "/app", local, "", Options{Atomic: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Set("/app/b/c", []byte("changed"), -1); err != nil {
+		t.Fatal(err)
+	}
+	errs := c.applyAtomic(ctx, p, Options{})
+	if len(errs) != len(p) || !errors.Is(errs[0], ErrBadVersion) {
+		t.Fatalf("applying over a changed node fails with %v", errs)
+	}
+	for p, want := range map[string]string{"/app/a": "1", "/app/b/c": "changed"} {
+		if data, _, _ := b.Get(p); string(data) != want {
+			t.Errorf("%s reads %q after the transaction failed, want %q", p, data, want)
+		}
+	}
+	if _, _, err := b.Get("/app/d"); err != ErrNoNode {
+		t.Errorf("/app/d created by a failed transaction: %v", err)
+	}
+}
+
+// limitedBackend fits only so many ops in a transaction.
+type limitedBackend struct {
+	*MemoryBackend
+	ops int
+}
+
+func (b limitedBackend) MultiLimit() (int, int) {
+	return b.ops, 0
+}
+
+func TestUploadAtomicTrash(t *testing.T) {
+	b := limitedBackend{NewMemoryBackend(), 2}
+	c := New(b)
+	ctx := context.Background()
+	local := t.TempDir()
+	writeTree(t, local, map[string]string{"a": "1"})
+	applied(t)(c.Upload(ctx, local, "/app", Options{}))
+
+	// deleting /app/a fits in one transaction, trashing it as well does not
+	if err := os.Remove(filepath.Join(local, "a")); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Atomic: true, Prune: true, Trash: &Trash{}}
+	if _, err := c.Upload(ctx, local, "/app", opts); err == nil {
+		t.Fatal("upload fitting only without the trash succeeds")
+	}
+	if _, _, err := b.Get("/app/a"); err != nil {
+		t.Errorf("/app/a after the upload was refused: %v", err)
+	}
+}
+
+func TestWatchCreatedDryRun(t *testing.T) {
+	b := NewMemoryBackend()
+	c := New(b)
+	ctx := context.Background()
+	for _, p := range []string{"/app", "/app/a", "/app/a/b"} {
+		if err := b.Create(p, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Create("/app/a/b/c", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	local := t.TempDir()
+	ev := Event{Type: EventCreated, Path: "/app/a/b/c"}
+
+	// a backend reporting the node before its parents leaves them to
+	// create first
+	p, err := c.planWatchEvent(ctx, ev, "/app", local, Options{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []OpKind
+	for _, o := range p {
+		kinds = append(kinds, o.Kind)
+	}
+	if want := []OpKind{OpMkdir, OpMkdir, OpWrite}; !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("plans %v, want a and a/b created before c is written", p)
+	}
+	c.applyWatched(ctx, p, Options{DryRun: true})
+	sameTree(t, local, nil)
+
+	p, err = c.planWatchEvent(ctx, ev, "/app", local, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.applyWatched(ctx, p, Options{})
+	sameTree(t, local, map[string]string{"a/b/c": "1"})
+}
@@ -0,0 +1,151 @@
+package zksync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// MetadataFile records, at the root of a local tree downloaded with
+// Options.Metadata, what the server knew of every node beyond its data. It
+// is never uploaded, but ACLPolicy.LoadMetadata gives uploads the ACLs it
+// recorded.
+const MetadataFile = ".zkmeta"
+
+// NodeMeta is what the MetadataFile records of a node.
+type NodeMeta struct {
+	Czxid     int64     `json:"czxid,omitempty"`
+	Mzxid     int64     `json:"mzxid,omitempty"`
+	Version   int64     `json:"version"`
+	Ctime     time.Time `json:"ctime"`
+	Mtime     time.Time `json:"mtime"`
+	ACL       string    `json:"acl,omitempty"`
+	Ephemeral bool      `json:"ephemeral,omitempty"`
+}
+
+// Metadata is the MetadataFile: the nodes of the tree at Remote by path
+// relative to it, the root being "".
+type Metadata struct {
+	Remote string              `json:"remote"`
+	Nodes  map[string]NodeMeta `json:"nodes"`
+}
+
+// ReadMetadata reads a MetadataFile.
+func ReadMetadata(file string) (*Metadata, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := &Metadata{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", file, err)
+	}
+	return m, nil
+}
+
+// LoadMetadata gives every node recorded in a MetadataFile the ACL it had,
+// over any rule or Default.
+func (p *ACLPolicy) LoadMetadata(file string) error {
+	m, err := ReadMetadata(file)
+	if err != nil {
+		return err
+	}
+	if p.exact == nil {
+		p.exact = make(map[string][]ACL)
+	}
+	for rel, n := range m.Nodes {
+		if n.ACL == "" {
+			continue
+		}
+		acl, err := ParseACL(n.ACL)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", file, rel, err)
+		}
+		p.exact[rel] = acl
+	}
+	return nil
+}
+
+// planMetadataFile plans writing the MetadataFile of the tree at absLocal
+// with what the nodes under remotePath are now.
+func (c *Client) planMetadataFile(ctx context.Context, remotePath, absLocal string, opts Options) (Plan, error) {
+	if fInfo, err := os.Stat(absLocal); err == nil && !fInfo.IsDir() {
+		// a single file was downloaded, there is no tree to keep it in
+		return nil, nil
+	}
+	m := &Metadata{Remote: remotePath, Nodes: make(map[string]NodeMeta)}
+	if err := c.readMetadata(ctx, remotePath, "", opts, m.Nodes); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	file := filepath.Join(absLocal, MetadataFile)
+	old, err := ioutil.ReadFile(file)
+	switch {
+	case os.IsNotExist(err):
+		return Plan{{Kind: OpWrite, Source: remotePath, Target: file, Data: data}}, nil
+	case err != nil:
+		return nil, err
+	case string(old) == string(data):
+		return nil, nil
+	}
+	return Plan{{Kind: OpOverwrite, Source: remotePath, Target: file, Data: data, OldSize: len(old)}}, nil
+}
+
+// readMetadata adds what there is to know of the nodes at and below
+// serverPrefix that a download copies to nodes, by their path relative to
+// the tree.
+func (c *Client) readMetadata(ctx context.Context, serverPrefix, rel string, opts Options, nodes map[string]NodeMeta) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if opts.Filter.Excluded(rel) || rel == DeployNode {
+		return nil
+	}
+	_, stat, err := c.Backend.Get(serverPrefix)
+	if err == ErrNoNode {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("reading %s: %w", serverPrefix, err)
+	}
+	if stat.Ephemeral && !opts.Ephemeral {
+		return nil
+	}
+	if stat.NumChildren > 0 || opts.Filter.Included(rel) {
+		n := NodeMeta{Czxid: stat.Czxid, Mzxid: stat.Mzxid, Version: stat.Version, Ctime: stat.Ctime.UTC(), Mtime: stat.Mtime.UTC(), Ephemeral: stat.Ephemeral}
+		if ab, ok := c.Backend.(ACLBackend); ok {
+			acl, err := ab.GetACL(serverPrefix)
+			if err != nil {
+				return fmt.Errorf("reading the ACL of %s: %w", serverPrefix, err)
+			}
+			n.ACL = formatACL(acl)
+		}
+		nodes[rel] = n
+	}
+	if stat.NumChildren == 0 {
+		return nil
+	}
+	children, _, err := c.Backend.List(serverPrefix)
+	if err == ErrNoNode {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("listing %s: %w", serverPrefix, err)
+	}
+	for _, child := range children {
+		if isChunk(child) {
+			continue
+		}
+		if err := c.readMetadata(ctx, path.Join(serverPrefix, child), path.Join(rel, child), opts, nodes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package zksync
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// mirrorPasses is how many times Mirror uploads before giving up on what
+// still differs, as someone else may be writing to the tree meanwhile.
+const mirrorPasses = 3
+
+// Mirror makes the tree at remotePath an exact replica of the one at
+// localPath: it uploads with opts.Prune, gives every node the ACL opts.ACLs
+// says, then compares the trees again, going over what still differs up to
+// mirrorPasses times. What it could not reconcile is left in the Result's
+// Unreconciled. Dry runs only plan the first pass.
+func (c *Client) Mirror(ctx context.Context, localPath, remotePath string, opts Options) (*Result, error) {
+	opts.Prune = true
+	opts.Clean = false
+	_, hasACLs := c.Backend.(ACLBackend)
+	total := &Result{}
+	for pass := 1; ; pass++ {
+		res, err := c.Upload(ctx, localPath, remotePath, opts)
+		if err != nil {
+			return nil, err
+		}
+		total.Plan = append(total.Plan, res.Plan...)
+		total.Failed = append(total.Failed, res.Failed...)
+		applied := len(res.Plan) - len(res.Failed)
+		if opts.ACLs != nil && hasACLs {
+			aclRes, err := c.SyncACLs(ctx, remotePath, opts)
+			if err != nil {
+				return nil, err
+			}
+			total.Plan = append(total.Plan, aclRes.Plan...)
+			total.Failed = append(total.Failed, aclRes.Failed...)
+			applied += len(aclRes.Plan) - len(aclRes.Failed)
+		}
+		if opts.DryRun {
+			return total, nil
+		}
+
+		left, err := c.unreconciled(ctx, localPath, remotePath, opts, hasACLs)
+		if err != nil {
+			return nil, err
+		}
+		if len(left) == 0 {
+			return total, nil
+		}
+		if pass == mirrorPasses || applied == 0 {
+			// another pass would change nothing more
+			total.Unreconciled = left
+			return total, nil
+		}
+		c.logger().Warn("Remote tree still differs, going over it again", "path", remotePath, "differences", len(left))
+	}
+}
+
+// unreconciled returns the paths, relative to the trees, where the one at
+// remotePath is not yet what Mirror would make it.
+func (c *Client) unreconciled(ctx context.Context, localPath, remotePath string, opts Options, hasACLs bool) ([]string, error) {
+	diffs, err := c.Diff(ctx, localPath, remotePath, opts)
+	if err != nil {
+		return nil, err
+	}
+	left := make(map[string]bool)
+	for _, d := range diffs {
+		left[d.Path] = true
+	}
+	if opts.ACLs != nil && hasACLs {
+		aclPlan, err := c.planACLs(ctx, remotePath, "", opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range aclPlan {
+			left[strings.TrimPrefix(strings.TrimPrefix(o.Target, remotePath), "/")] = true
+		}
+	}
+	paths := make([]string, 0, len(left))
+	for p := range left {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
@@ -0,0 +1,310 @@
+package zksync
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// NameMap maps local file names to node names and back, so that names one
+// side cannot hold still round-trip through the other. With Escape set, a
+// character a side cannot hold is written there as % and the upper case
+// hex digits of its UTF-8 bytes, so that the node a:b is the file a%3Ab
+// where : is in Local, and a % that would otherwise read as such an escape
+// is written as %25. Any other % is left as it is, so that names such as
+// 50%off.txt are the same on both sides.
+type NameMap struct {
+	// Escape escapes what either side cannot hold: in node names, the
+	// control and other characters ZooKeeper forbids, and in local names,
+	// NUL and the characters in Local.
+	Escape bool
+	// Local holds the characters local file names cannot hold, such as
+	// DefaultLocalChars.
+	Local string
+	// FoldCase has nodes whose names differ only in case refused as the
+	// same file, as they are on case-insensitive file systems.
+	FoldCase bool
+	// Exts give local files an extension their nodes do not have, so that
+	// the node app is the file app.json. The first rule matching a node
+	// decides; dirs never have one.
+	Exts []ExtRule
+}
+
+// ExtRule gives the files whose node paths match its pattern, or are below
+// a dir that does, the extension Ext locally.
+type ExtRule struct {
+	Ext     string
+	pattern *pattern // nil for every file
+}
+
+// ParseExtRule reads a rule such as .json, for every file, or
+// conf/*=.yaml, a pattern as Filter has them and the extension.
+func ParseExtRule(s string) (ExtRule, error) {
+	glob, ext := "", s
+	if i := strings.LastIndexByte(s, '='); i >= 0 {
+		glob, ext = s[:i], s[i+1:]
+	}
+	if !strings.HasPrefix(ext, ".") || len(ext) < 2 || strings.ContainsAny(ext, "/%") {
+		return ExtRule{}, fmt.Errorf("bad extension %q in %q, want one such as .json", ext, s)
+	}
+	r := ExtRule{Ext: ext}
+	if glob != "" {
+		patterns, err := compilePatterns([]string{glob})
+		if err != nil {
+			return ExtRule{}, err
+		}
+		r.pattern = &patterns[0]
+	}
+	return r, nil
+}
+
+// ext returns the extension the file for the node at rel has locally,
+// empty if none.
+func (m *NameMap) ext(rel string) string {
+	if m == nil {
+		return ""
+	}
+	for _, r := range m.Exts {
+		if r.pattern == nil || matchAny([]pattern{*r.pattern}, rel) {
+			return r.Ext
+		}
+	}
+	return ""
+}
+
+// stripExt returns the node path of the local file at rel, already mapped
+// by RemotePath: rel without the extension the node there would have, if
+// it ends with it and leaves a name a node can have.
+func (m *NameMap) stripExt(rel string) string {
+	if m == nil {
+		return rel
+	}
+	for _, r := range m.Exts {
+		stripped := strings.TrimSuffix(rel, r.Ext)
+		name := stripped[strings.LastIndexByte(stripped, '/')+1:]
+		if stripped != rel && name != "" && name != "." && name != ".." && m.ext(stripped) == r.Ext {
+			return stripped
+		}
+	}
+	return rel
+}
+
+// zkForbids reports whether ZooKeeper refuses r in node names.
+func zkForbids(r rune) bool {
+	return r < 0x20 || (r >= 0x7f && r <= 0x9f) || (r >= 0xd800 && r <= 0xf8ff) || r >= 0xfff0 && r <= 0xffff
+}
+
+func (m *NameMap) localForbids(r rune) bool {
+	return r == 0 || strings.ContainsRune(m.Local, r)
+}
+
+// LocalPath maps the node names of the slash separated path rel to the
+// local names they have, the path still slash separated.
+func (m *NameMap) LocalPath(rel string) string {
+	if m == nil || !m.Escape {
+		return rel
+	}
+	return mapPath(rel, func(name string) string {
+		return escapeName(unescapeName(name, zkForbids), m.localForbids)
+	})
+}
+
+// LocalFile is LocalPath for the path of a file, adding the extension of
+// the first rule of Exts matching rel.
+func (m *NameMap) LocalFile(rel string) string {
+	return m.LocalPath(rel) + m.ext(rel)
+}
+
+// RemotePath maps the local names of the slash separated path rel to the
+// node names they have, all of them taken for dirs.
+func (m *NameMap) RemotePath(rel string) string {
+	if m == nil || !m.Escape {
+		return rel
+	}
+	return mapPath(rel, func(name string) string {
+		return escapeName(unescapeName(name, m.localForbids), zkForbids)
+	})
+}
+
+// RemoteFile is RemotePath for the path of a file, relative to the root of
+// the tree, dropping the extension the node has locally.
+func (m *NameMap) RemoteFile(rel string) string {
+	return m.stripExt(m.RemotePath(rel))
+}
+
+func mapPath(rel string, mapName func(string) string) string {
+	names := strings.Split(rel, "/")
+	for i, name := range names {
+		if name != "" && name != "." && name != ".." {
+			names[i] = mapName(name)
+		}
+	}
+	return strings.Join(names, "/")
+}
+
+// localChild returns the local path of the node child of the dir at rel
+// in the tree, which is localDir locally: the file it would be if there is
+// one, its dir otherwise, for when the node is not there to say which.
+func (m *NameMap) localChild(localDir, rel, child string) string {
+	dir := filepath.Join(localDir, m.LocalPath(child))
+	ext := m.ext(path.Join(rel, child))
+	if ext == "" {
+		return dir
+	}
+	if fInfo, err := os.Stat(dir + ext); err == nil && !fInfo.IsDir() {
+		return dir + ext
+	}
+	return dir
+}
+
+// localNames returns the local names of the nodes children of dir, at rel
+// in the tree, failing with ErrNameCollision if two would be the same
+// file. files says which are files, all are taken for dirs if nil.
+func (m *NameMap) localNames(dir, rel string, children []string, files []bool) ([]string, error) {
+	if m == nil {
+		return children, nil
+	}
+	names := make([]string, len(children))
+	for i, child := range children {
+		names[i] = m.LocalPath(child)
+		if files != nil && files[i] {
+			names[i] += m.ext(path.Join(rel, child))
+		}
+	}
+	fold := func(name string) string {
+		if m.FoldCase {
+			return strings.ToLower(name)
+		}
+		return name
+	}
+	return names, checkCollisions(dir, children, names, fold)
+}
+
+// remoteNames returns the node names of the local files names in dir, at
+// rel in the tree, failing with ErrNameCollision if two would be the same
+// node. files says which are files, all are taken for dirs if nil.
+func (m *NameMap) remoteNames(dir, rel string, names []string, files []bool) ([]string, error) {
+	if m == nil {
+		return names, nil
+	}
+	children := make([]string, len(names))
+	for i, name := range names {
+		children[i] = m.RemotePath(name)
+		if files != nil && files[i] {
+			children[i] = path.Base(m.stripExt(path.Join(rel, children[i])))
+		}
+	}
+	return children, checkCollisions(dir, names, children, func(name string) string { return name })
+}
+
+// checkCollisions fails if two of names map to the same of mapped, once
+// folded.
+func checkCollisions(dir string, names, mapped []string, fold func(string) string) error {
+	seen := make(map[string]string)
+	var collisions []string
+	for i, name := range names {
+		key := fold(mapped[i])
+		if other, ok := seen[key]; ok {
+			collisions = append(collisions, fmt.Sprintf("%q and %q", other, name))
+			continue
+		}
+		seen[key] = name
+	}
+	if len(collisions) == 0 {
+		return nil
+	}
+	sort.Strings(collisions)
+	return fmt.Errorf("%s: %s would have the same name: %w", dir, strings.Join(collisions, ", "), ErrNameCollision)
+}
+
+// escapeName escapes the characters of name forbids, and the % of what
+// would read as an escape. It works from the end, so that whether a %
+// starts an escape is known from what follows it as written.
+func escapeName(name string, forbids func(rune) bool) string {
+	escaped := ""
+	for i := len(name); i > 0; {
+		r, size := utf8.DecodeLastRuneInString(name[:i])
+		i -= size
+		s := name[i : i+size]
+		if (r != utf8.RuneError || size > 1) && forbids(r) || r == '%' && escapeLen(s+escaped, forbids) > 0 {
+			s = hexEscape(s)
+		}
+		escaped = s + escaped
+	}
+	return escaped
+}
+
+// unescapeName undoes escapeName.
+func unescapeName(name string, forbids func(rune) bool) string {
+	var b strings.Builder
+	for i := 0; i < len(name); {
+		if n := escapeLen(name[i:], forbids); n > 0 {
+			b.WriteString(hexUnescape(name[i : i+n]))
+			i += n
+			continue
+		}
+		b.WriteByte(name[i])
+		i++
+	}
+	return b.String()
+}
+
+// escapeLen returns how long the escape s starts with is, 0 if it does not
+// start with one: a character forbids has, or a % that would start one
+// where it is.
+func escapeLen(s string, forbids func(rune) bool) int {
+	var buf []byte
+	for n := 0; len(buf) < utf8.UTFMax && n+3 <= len(s) && s[n] == '%'; n += 3 {
+		hi, lo := unhex(s[n+1]), unhex(s[n+2])
+		if hi < 0 || lo < 0 {
+			return 0
+		}
+		buf = append(buf, byte(hi<<4|lo))
+		if !utf8.FullRune(buf) {
+			continue
+		}
+		r, size := utf8.DecodeRune(buf)
+		switch {
+		case r == utf8.RuneError && size <= 1:
+			return 0
+		case forbids(r):
+			return n + 3
+		case r == '%' && escapeLen("%"+s[3:], forbids) > 0:
+			return 3
+		}
+		return 0
+	}
+	return 0
+}
+
+// unhex returns the value of the upper case hex digit c, -1 if it is not
+// one: escapes are written upper case, so that each has a single spelling.
+func unhex(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'A' && c <= 'F':
+		return int(c - 'A' + 10)
+	}
+	return -1
+}
+
+func hexEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		fmt.Fprintf(&b, "%%%02X", s[i])
+	}
+	return b.String()
+}
+
+func hexUnescape(s string) string {
+	b := make([]byte, 0, len(s)/3)
+	for i := 0; i+2 < len(s); i += 3 {
+		b = append(b, byte(unhex(s[i+1])<<4|unhex(s[i+2])))
+	}
+	return string(b)
+}
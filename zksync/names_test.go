@@ -0,0 +1,289 @@
+package zksync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// windowsChars are the DefaultLocalChars of Windows, for the tests to map
+// names the same way on every system.
+const windowsChars = "<>:\"\\|?*\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13\x14\x15\x16\x17\x18\x19\x1a\x1b\x1c\x1d\x1e\x1f"
+
+func TestNameMapEscape(t *testing.T) {
+	m := &NameMap{Escape: true, Local: windowsChars}
+	for _, tt := range []struct {
+		node, local string
+	}{
+		{"plain.conf", "plain.conf"},
+		{"50%off.txt", "50%off.txt"},
+		{"100%", "100%"},
+		{"%", "%"},
+		{"a:b", "a%3Ab"},
+		{"a?b*c", "a%3Fb%2Ac"},
+		{`back\slash`, "back%5Cslash"},
+		// a % that would read as an escape locally is escaped itself
+		{"a%3Ab", "a%253Ab"},
+		{"%253A", "%25253A"},
+		// and one that would not is left alone
+		{"%25", "%25"},
+		{"%3a", "%3a"},
+		{"%FF", "%FF"},
+		{"%C3", "%C3"},
+		// ZooKeeper forbids control characters, which are escaped in
+		// node names, and Windows too, so they stay escaped
+		{"a%01b", "a%01b"},
+		{"tab%09", "tab%09"},
+		// a % ahead of a control character escape is one in node names
+		// but not in local ones, where it is the control character
+		{"%2501", "%2501"},
+		// multibyte characters are escaped byte by byte, and left
+		// alone unless forbidden
+		{"café", "café"},
+		{"日本:語", "日本%3A語"},
+		{"x%EE%80%80", "x\ue000"},
+		{"%C3%A9", "%C3%A9"},
+		{"a%E2%80", "a%E2%80"},
+	} {
+		if got := m.LocalPath(tt.node); got != tt.local {
+			t.Errorf("LocalPath(%q) = %q, want %q", tt.node, got, tt.local)
+		}
+		if got := m.RemotePath(tt.local); got != tt.node {
+			t.Errorf("RemotePath(%q) = %q, want %q", tt.local, got, tt.node)
+		}
+	}
+}
+
+func TestNameMapEscapeUnix(t *testing.T) {
+	// only NUL is forbidden locally, so control characters ZooKeeper
+	// forbids are only escaped in node names
+	m := &NameMap{Escape: true}
+	for _, tt := range []struct {
+		node, local string
+	}{
+		{"a:b", "a:b"},
+		{"a%3Ab", "a%3Ab"},
+		{"a%01b", "a\x01b"},
+		{"a%2501b", "a%01b"},
+		{"new%0Aline", "new\nline"},
+		{"x%EE%80%80", "x\ue000"},
+		{"%C2%85", "\u0085"},
+		{"a%00b", "a%00b"},
+		// invalid UTF-8 is left as it is on both sides
+		{"a\xffb", "a\xffb"},
+		{"\xc3", "\xc3"},
+	} {
+		if got := m.LocalPath(tt.node); got != tt.local {
+			t.Errorf("LocalPath(%q) = %q, want %q", tt.node, got, tt.local)
+		}
+		if got := m.RemotePath(tt.local); got != tt.node {
+			t.Errorf("RemotePath(%q) = %q, want %q", tt.local, got, tt.node)
+		}
+	}
+}
+
+func TestNameMapPaths(t *testing.T) {
+	m := &NameMap{Escape: true, Local: windowsChars}
+	if got, want := m.LocalPath("a:b/c?d/e"), "a%3Ab/c%3Fd/e"; got != want {
+		t.Errorf("LocalPath = %q, want %q", got, want)
+	}
+	if got, want := m.RemotePath("../a%3Ab/./"), "../a:b/./"; got != want {
+		t.Errorf("RemotePath = %q, want %q", got, want)
+	}
+
+	var none *NameMap
+	if got := none.LocalPath("a:b/c"); got != "a:b/c" {
+		t.Errorf("nil LocalPath = %q", got)
+	}
+	if got := (&NameMap{FoldCase: true}).RemotePath("a%3Ab"); got != "a%3Ab" {
+		t.Errorf("RemotePath without Escape = %q", got)
+	}
+}
+
+func TestNameMapCollisions(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		m       *NameMap
+		nodes   []string
+		locals  []string
+		collide bool
+	}{
+		{name: "case", m: &NameMap{}, nodes: []string{"Readme", "readme"}},
+		{name: "folded case", m: &NameMap{FoldCase: true}, nodes: []string{"Readme", "readme"}, collide: true},
+		{name: "folded multibyte case", m: &NameMap{FoldCase: true}, nodes: []string{"ÉTÉ", "été"}, collide: true},
+		{name: "folded escapes", m: &NameMap{Escape: true, Local: windowsChars, FoldCase: true}, nodes: []string{"a:b", "A:B", "a%3Ab"}, collide: true},
+		{name: "escapes", m: &NameMap{Escape: true, Local: windowsChars}, nodes: []string{"a:b", "a%3Ab", "a%253Ab"}},
+		// a local name with a character it should not hold is the
+		// node its escape is
+		{name: "unescaped local", m: &NameMap{Escape: true, Local: ":"}, locals: []string{"a:b", "a%3Ab"}, collide: true},
+		{name: "local escapes", m: &NameMap{Escape: true}, locals: []string{"a%01b", "a\x01b", "a%2501b"}},
+	} {
+		var err error
+		if tt.nodes != nil {
+			_, err = tt.m.localNames("/app", "", tt.nodes, nil)
+		} else {
+			_, err = tt.m.remoteNames("/tmp/app", "", tt.locals, nil)
+		}
+		if collided := errors.Is(err, ErrNameCollision); collided != tt.collide || (err != nil && !collided) {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+// validName reports whether s is a name one side could hold, forbids
+// saying what it cannot.
+func validName(s string, forbids func(rune) bool) bool {
+	if s == "" || s == "." || s == ".." || strings.ContainsRune(s, '/') {
+		return false
+	}
+	for _, r := range s {
+		if r != utf8.RuneError && forbids(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func FuzzNameMapRoundTrip(f *testing.F) {
+	for _, s := range []string{"a:b", "a%3Ab", "50%off", "%25", "%2501", "%%3A", "a%E2%80", "日本:語", "\x01", "%C3%A9", "\xff%3A"} {
+		f.Add(s)
+	}
+	maps := []*NameMap{{Escape: true}, {Escape: true, Local: windowsChars}, {Escape: true, Local: ":|"}}
+	f.Fuzz(func(t *testing.T, s string) {
+		for _, m := range maps {
+			if validName(s, zkForbids) {
+				local := m.LocalPath(s)
+				if !validName(local, m.localForbids) {
+					t.Errorf("node %q is local %q, which is not a valid name for %q", s, local, m.Local)
+				}
+				if back := m.RemotePath(local); back != s {
+					t.Errorf("node %q is local %q, which is node %q", s, local, back)
+				}
+			}
+			if validName(s, m.localForbids) {
+				node := m.RemotePath(s)
+				if !validName(node, zkForbids) {
+					t.Errorf("local %q is node %q, which ZooKeeper refuses", s, node)
+				}
+				if back := m.LocalPath(node); back != s {
+					t.Errorf("local %q is node %q, which is local %q", s, node, back)
+				}
+			}
+		}
+	})
+}
+
+// extMap returns a NameMap giving local files the extensions of rules.
+func extMap(t *testing.T, rules ...string) *NameMap {
+	t.Helper()
+	m := &NameMap{}
+	for _, s := range rules {
+		r, err := ParseExtRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Exts = append(m.Exts, r)
+	}
+	return m
+}
+
+func TestParseExtRule(t *testing.T) {
+	for _, s := range []string{".json", "conf/*=.yaml", "=.json", ".tar.gz"} {
+		if _, err := ParseExtRule(s); err != nil {
+			t.Errorf("ParseExtRule(%q): %v", s, err)
+		}
+	}
+	for _, s := range []string{"", "json", ".", "conf/*=json", "conf/*=", ".a/b", ".50%"} {
+		if _, err := ParseExtRule(s); err == nil {
+			t.Errorf("ParseExtRule(%q) takes it", s)
+		}
+	}
+}
+
+func TestNameMapExts(t *testing.T) {
+	m := extMap(t, "conf/*=.yaml", ".json")
+	for _, tt := range []struct {
+		node, local string
+	}{
+		{"app", "app.json"},
+		{"dir/app", "dir/app.json"},
+		{"conf/app", "conf/app.yaml"},
+		// a node already named as the file would be gets the extension
+		// on top of its own
+		{"foo.json", "foo.json.json"},
+		{"conf/app.json", "conf/app.json.yaml"},
+		{"conf/app.yaml", "conf/app.yaml.yaml"},
+	} {
+		if got := m.LocalFile(tt.node); got != tt.local {
+			t.Errorf("LocalFile(%q) = %q, want %q", tt.node, got, tt.local)
+		}
+		if got := m.RemoteFile(tt.local); got != tt.node {
+			t.Errorf("RemoteFile(%q) = %q, want %q", tt.local, got, tt.node)
+		}
+	}
+
+	// files without the extension their node would have keep their names,
+	// as do those it would leave without one
+	for _, local := range []string{"app", "app.yaml", "conf/app.json", ".json", "dir/.json", "..json", "...json"} {
+		if got := m.RemoteFile(local); got != local {
+			t.Errorf("RemoteFile(%q) = %q, want it as it is", local, got)
+		}
+	}
+}
+
+func TestNameMapExtNames(t *testing.T) {
+	m := extMap(t, ".json")
+
+	// the local foo and foo.json are both the node foo
+	if _, err := m.remoteNames("/tmp/app", "", []string{"foo", "foo.json"}, []bool{true, true}); !errors.Is(err, ErrNameCollision) {
+		t.Errorf("foo and foo.json: %v", err)
+	}
+	// unless foo is a dir, which has no extension
+	if _, err := m.remoteNames("/tmp/app", "", []string{"foo", "foo.json"}, []bool{false, true}); !errors.Is(err, ErrNameCollision) {
+		t.Errorf("dir foo and foo.json: %v", err)
+	}
+	if names, err := m.remoteNames("/tmp/app", "", []string{"foo.json", "bar.json"}, []bool{false, true}); err != nil || !reflect.DeepEqual(names, []string{"foo.json", "bar"}) {
+		t.Errorf("dir foo.json and bar.json are %q, %v", names, err)
+	}
+	if names, err := m.remoteNames("/tmp/app", "dir", []string{"x.json", ".json"}, []bool{true, true}); err != nil || !reflect.DeepEqual(names, []string{"x", ".json"}) {
+		t.Errorf("x.json and .json are %q, %v", names, err)
+	}
+
+	// the nodes foo and foo.json are the files foo.json and foo.json.json,
+	// and as dirs foo and foo.json
+	if names, err := m.localNames("/app", "", []string{"foo", "foo.json"}, []bool{true, true}); err != nil || !reflect.DeepEqual(names, []string{"foo.json", "foo.json.json"}) {
+		t.Errorf("files foo and foo.json are %q, %v", names, err)
+	}
+	if names, err := m.localNames("/app", "", []string{"foo", "foo.json"}, nil); err != nil || !reflect.DeepEqual(names, []string{"foo", "foo.json"}) {
+		t.Errorf("dirs foo and foo.json are %q, %v", names, err)
+	}
+	// but the file foo and the dir foo.json collide
+	if _, err := m.localNames("/app", "", []string{"foo", "foo.json"}, []bool{true, false}); !errors.Is(err, ErrNameCollision) {
+		t.Errorf("file foo and dir foo.json: %v", err)
+	}
+}
+
+func TestNameMapLocalChild(t *testing.T) {
+	m := extMap(t, ".json")
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"file.json": "{}", "x.json/y": "1"})
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for child, want := range map[string]string{
+		"file":   "file.json",
+		"sub":    "sub",
+		"none":   "none",
+		"x":      "x",
+		"x.json": "x.json",
+	} {
+		if got := m.localChild(dir, "", child); got != filepath.Join(dir, want) {
+			t.Errorf("localChild(%q) = %q, want %q", child, got, filepath.Join(dir, want))
+		}
+	}
+}
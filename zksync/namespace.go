@@ -0,0 +1,206 @@
+package zksync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultNamespaceRoot is where namespaces are registered unless told
+// otherwise.
+const DefaultNamespaceRoot = "/_namespaces"
+
+// Namespace is a prefix set aside for a team, registered as a JSON node
+// named after it below the namespace root.
+type Namespace struct {
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"`
+	Owner  string `json:"owner,omitempty"`
+	// ACL is given to the prefix node, as ParseACL reads it, the backend
+	// default if empty.
+	ACL string `json:"acl,omitempty"`
+	// MaxNodes and MaxBytes limit what the prefix holds, itself included,
+	// zero for no limit. They are its Budget in the quota node of
+	// Options.Quotas, and a quota the server keeps count of on backends
+	// that are Quoters.
+	MaxNodes int64     `json:"max_nodes,omitempty"`
+	MaxBytes int64     `json:"max_bytes,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+// checkNamespace checks ns can be created below root.
+func checkNamespace(root string, ns Namespace) error {
+	if ns.Name == "" || ns.Name == "." || ns.Name == ".." || strings.Contains(ns.Name, "/") {
+		return fmt.Errorf("bad namespace name %q", ns.Name)
+	}
+	if !strings.HasPrefix(ns.Prefix, "/") || path.Clean(ns.Prefix) != ns.Prefix || ns.Prefix == "/" {
+		return fmt.Errorf("bad namespace prefix %q, want an absolute path below the root", ns.Prefix)
+	}
+	for _, reserved := range append([]string{root, DefaultTrashRoot, DefaultHistoryRoot}, protectedPaths...) {
+		if ns.Prefix == reserved || strings.HasPrefix(ns.Prefix, reserved+"/") || strings.HasPrefix(reserved, ns.Prefix+"/") {
+			return fmt.Errorf("namespace prefix %s overlaps %s", ns.Prefix, reserved)
+		}
+	}
+	if ns.MaxNodes < 0 || ns.MaxBytes < 0 {
+		return fmt.Errorf("namespace limits cannot be negative")
+	}
+	return nil
+}
+
+// CreateNamespace creates the prefix of ns, which must not exist yet, with
+// its ACL, registers ns below root and sets its budget. Its limits are
+// then set as a quota on the prefix, if the backend is a Quoter, unless on
+// a dry run.
+func (c *Client) CreateNamespace(ctx context.Context, root string, ns Namespace, opts Options) (*Result, error) {
+	if err := checkNamespace(root, ns); err != nil {
+		return nil, err
+	}
+	var acl []ACL
+	if ns.ACL != "" {
+		if _, ok := c.Backend.(ACLBackend); !ok {
+			return nil, fmt.Errorf("ACLs: %w", ErrUnsupported)
+		}
+		var err error
+		if acl, err = ParseACL(ns.ACL); err != nil {
+			return nil, err
+		}
+	}
+	meta := path.Join(root, ns.Name)
+	if _, _, err := c.Backend.Get(meta); err == nil {
+		return nil, fmt.Errorf("namespace %s: %w", ns.Name, ErrNodeExists)
+	} else if err != ErrNoNode {
+		return nil, fmt.Errorf("checking %s: %w", meta, err)
+	}
+	if _, _, err := c.Backend.Get(ns.Prefix); err == nil {
+		return nil, fmt.Errorf("%s is already there, a namespace needs a prefix of its own: %w", ns.Prefix, ErrNodeExists)
+	} else if err != ErrNoNode {
+		return nil, fmt.Errorf("checking %s: %w", ns.Prefix, err)
+	}
+
+	ns.Created = time.Now().UTC()
+	data, err := json.MarshalIndent(ns, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	p, err := c.planRemotePath(path.Dir(ns.Prefix))
+	if err != nil {
+		return nil, err
+	}
+	p = append(p, Op{Kind: OpCreate, Target: ns.Prefix, Dir: true, Data: []byte{}, ACL: acl})
+	rootPlan, err := c.planRemotePath(root)
+	if err != nil {
+		return nil, err
+	}
+	p = append(p, rootPlan...)
+	p = append(p, Op{Kind: OpCreate, Target: meta, Data: append(data, '\n')})
+	if ns.MaxNodes > 0 || ns.MaxBytes > 0 {
+		budgetPlan, err := c.planBudget(opts.Quotas, Budget{Prefix: ns.Prefix, MaxNodes: ns.MaxNodes, MaxBytes: ns.MaxBytes})
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, budgetPlan...)
+	}
+
+	res, err := c.run(ctx, p, opts)
+	if err != nil || opts.DryRun || len(res.Failed) > 0 {
+		return res, err
+	}
+	if ns.MaxNodes > 0 || ns.MaxBytes > 0 {
+		if err := c.setQuota(ns.Prefix, ns.MaxNodes, ns.MaxBytes); err != nil {
+			return res, fmt.Errorf("setting the quota of %s: %w", ns.Prefix, err)
+		}
+	}
+	return res, nil
+}
+
+// setQuota has a Quoter backend limit the tree at p, zero being no limit,
+// or drops its quota when both are zero.
+func (c *Client) setQuota(p string, count, bytes int64) error {
+	q, ok := c.Backend.(Quoter)
+	if !ok {
+		return nil
+	}
+	if count == 0 {
+		count = -1
+	}
+	if bytes == 0 {
+		bytes = -1
+	}
+	return q.SetQuota(p, count, bytes)
+}
+
+// Namespaces lists the namespaces registered below root, by name.
+func (c *Client) Namespaces(root string) ([]Namespace, error) {
+	children, _, err := c.Backend.List(root)
+	if err == ErrNoNode {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", root, err)
+	}
+	sort.Strings(children)
+	var namespaces []Namespace
+	for _, name := range children {
+		ns, err := c.namespace(root, name)
+		if err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, *ns)
+	}
+	return namespaces, nil
+}
+
+// namespace reads the namespace registered below root as name.
+func (c *Client) namespace(root, name string) (*Namespace, error) {
+	meta := path.Join(root, name)
+	data, _, err := c.getFile(meta)
+	if err == ErrNoNode {
+		return nil, fmt.Errorf("no namespace %s: %w", name, ErrNoNode)
+	} else if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", meta, err)
+	}
+	var ns Namespace
+	if err := json.Unmarshal(data, &ns); err != nil {
+		return nil, fmt.Errorf("bad namespace in %s: %w", meta, err)
+	}
+	return &ns, nil
+}
+
+// DeleteNamespace deletes the namespace registered below root as name:
+// its prefix and all below it, its registration and budget, and then its
+// quota unless on a dry run.
+func (c *Client) DeleteNamespace(ctx context.Context, root, name string, opts Options) (*Result, error) {
+	ns, err := c.namespace(root, name)
+	if err != nil {
+		return nil, err
+	}
+	p, err := c.planDelete(ctx, ns.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	metaPlan, err := c.planDelete(ctx, path.Join(root, name))
+	if err != nil {
+		return nil, err
+	}
+	p = append(p, metaPlan...)
+	if ns.MaxNodes > 0 || ns.MaxBytes > 0 {
+		budgetPlan, err := c.planBudget(opts.Quotas, Budget{Prefix: ns.Prefix})
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, budgetPlan...)
+	}
+	res, err := c.run(ctx, p, opts)
+	if err != nil || opts.DryRun || len(res.Failed) > 0 {
+		return res, err
+	}
+	if ns.MaxNodes > 0 || ns.MaxBytes > 0 {
+		if err := c.setQuota(ns.Prefix, 0, 0); err != nil {
+			return res, fmt.Errorf("removing the quota of %s: %w", ns.Prefix, err)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,57 @@
+package zksync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+)
+
+// ReadNode returns the data of the file at p, put back together and
+// decompressed as for a download.
+func (c *Client) ReadNode(p string) ([]byte, *Stat, error) {
+	data, stat, err := c.getFile(p)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading %s: %w", p, err)
+	}
+	return data, stat, nil
+}
+
+// Put writes data to the single file at p, creating it and any missing
+// parents. With a version of 0 or more the node must exist and be at that
+// version, otherwise the write fails with ErrBadVersion. Either way a node
+// changed between reading and writing it is never overwritten.
+func (c *Client) Put(ctx context.Context, p string, data []byte, version int64, opts Options) (*Result, error) {
+	old, stat, err := c.getFile(p)
+	if err == ErrNoNode {
+		if version >= 0 {
+			return nil, fmt.Errorf("%s: %w", p, ErrNoNode)
+		}
+		parents, err := c.planRemotePath(path.Dir(p))
+		if err != nil {
+			return nil, err
+		}
+		writePlan, err := c.planWrite("", p, data, nil, nil, opts)
+		if err != nil {
+			return nil, err
+		}
+		return c.run(ctx, append(parents, writePlan...), opts)
+	} else if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", p, err)
+	}
+
+	if stat.IsDir() && stat.NumChildren > 0 {
+		return nil, fmt.Errorf("%s is a dir", p)
+	}
+	if version >= 0 && stat.Version != version {
+		return nil, fmt.Errorf("%s is at version %d, not %d: %w", p, stat.Version, version, ErrBadVersion)
+	}
+	if (!stat.IsDir() || opts.PlainEmpty) && bytes.Equal(old, data) {
+		return &Result{}, nil
+	}
+	writePlan, err := c.planWrite("", p, data, stat, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.run(ctx, writePlan, opts)
+}
@@ -0,0 +1,52 @@
+package zksync
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Normalizer rewrites local files as they are uploaded or diffed, so that
+// a file edited on Windows does not end up differing from the same file
+// edited anywhere else. Binary files never have their line endings
+// touched.
+type Normalizer struct {
+	// LineEndings turns CRLF line endings into LF.
+	LineEndings bool
+	// UTF8 refuses files that are not valid UTF-8, failing the upload
+	// before anything is changed.
+	UTF8 bool
+	skip []pattern
+}
+
+// NewNormalizer returns a Normalizer leaving the files matching any of
+// skip as they are, patterns being matched as Filter matches them.
+func NewNormalizer(lineEndings, utf8 bool, skip []string) (*Normalizer, error) {
+	patterns, err := compilePatterns(skip)
+	if err != nil {
+		return nil, err
+	}
+	return &Normalizer{LineEndings: lineEndings, UTF8: utf8, skip: patterns}, nil
+}
+
+var crlf = []byte("\r\n")
+
+// normalize returns data, the file at rel read from file, normalized. A
+// nil Normalizer leaves data as it is.
+func (n *Normalizer) normalize(rel, file string, data []byte) ([]byte, error) {
+	if n == nil {
+		return data, nil
+	}
+	for _, p := range n.skip {
+		if p.match(rel) {
+			return data, nil
+		}
+	}
+	if n.UTF8 && !utf8.Valid(data) {
+		return nil, fmt.Errorf("%s: not valid UTF-8", file)
+	}
+	if n.LineEndings && !IsBinary(data) && bytes.Contains(data, crlf) {
+		data = bytes.ReplaceAll(data, crlf, []byte("\n"))
+	}
+	return data, nil
+}
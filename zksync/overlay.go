@@ -0,0 +1,200 @@
+package zksync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PatchSuffix ends the names of overlay files patching the file of the
+// same name without it, rather than replacing it: settings.json.patch is a
+// JSON merge patch (RFC 7396) for settings.json. Patches and the files
+// they patch may be JSON or YAML, which is kept in the order it is
+// written.
+const PatchSuffix = ".patch"
+
+// MergeOverlays writes the tree at base to dest, with the trees at overlays
+// laid over it in turn, for trees that differ by environment. A file in an
+// overlay replaces the one at the same path below it, or patches it if its
+// name ends in PatchSuffix. Symlinks are recreated pointing where they
+// pointed, so that uploads treat them as Options.Symlinks says.
+func MergeOverlays(base string, overlays []string, dest string) error {
+	for i, layer := range append([]string{base}, overlays...) {
+		if fInfo, err := os.Stat(layer); err != nil {
+			return err
+		} else if !fInfo.IsDir() {
+			return fmt.Errorf("%s is not a dir", layer)
+		}
+		if err := mergeLayer(layer, dest, i > 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeLayer copies the tree at layer over the one at dest, applying the
+// patches in it if patch is set.
+func mergeLayer(layer, dest string, patch bool) error {
+	absLayer, err := filepath.Abs(layer)
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(absLayer, func(visitedPath string, fInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(absLayer, visitedPath)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		below, err := os.Lstat(target)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		exists := err == nil
+
+		switch {
+		case fInfo.IsDir():
+			if exists && !below.IsDir() {
+				return fmt.Errorf("%s is a dir, but a file in the layers below", visitedPath)
+			}
+			if !exists {
+				return os.Mkdir(target, fInfo.Mode().Perm()|0700)
+			}
+			return nil
+		case exists && below.IsDir():
+			return fmt.Errorf("%s is a file, but a dir in the layers below", visitedPath)
+		case fInfo.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(visitedPath)
+			if err != nil {
+				return err
+			}
+			if !filepath.IsAbs(link) {
+				link = filepath.Join(filepath.Dir(visitedPath), link)
+			}
+			if err := removeBelow(target); err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case !fInfo.Mode().IsRegular():
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(visitedPath)
+		if err != nil {
+			return err
+		}
+		mode := fInfo.Mode().Perm()
+		if patch && strings.HasSuffix(visitedPath, PatchSuffix) {
+			target = strings.TrimSuffix(target, PatchSuffix)
+			if data, err = patchFile(target, visitedPath, data); err != nil {
+				return err
+			}
+			patched, err := os.Stat(target)
+			if err != nil {
+				return err
+			}
+			mode = patched.Mode().Perm()
+		}
+		if err := removeBelow(target); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, data, mode)
+	})
+}
+
+// removeBelow removes the file at target a layer below put there, if any,
+// rather than writing through it, as it may be a symlink or read only.
+func removeBelow(target string) error {
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// patchFile returns the file at target with the merge patch read from
+// file applied, JSON if target's name ends in .json and YAML otherwise.
+func patchFile(target, file string, data []byte) ([]byte, error) {
+	orig, err := ioutil.ReadFile(target)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s patches %s, which no layer below has", file, filepath.Base(target))
+	} else if err != nil {
+		return nil, err
+	}
+	var doc, patch yaml.Node
+	if err := yaml.Unmarshal(orig, &doc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", target, err)
+	}
+	if err := yaml.Unmarshal(data, &patch); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", file, err)
+	}
+	if len(patch.Content) == 0 {
+		return orig, nil
+	}
+	var root *yaml.Node
+	if len(doc.Content) > 0 {
+		root = doc.Content[0]
+	}
+	merged := mergePatch(root, patch.Content[0])
+	if merged == nil {
+		return nil, fmt.Errorf("%s patches all of %s away", file, filepath.Base(target))
+	}
+	return encodeDoc(merged, strings.EqualFold(filepath.Ext(target), ".json"))
+}
+
+// mergePatch applies the merge patch patch to target, either of them
+// possibly nil, as RFC 7396 says: maps are merged key by key, a null
+// deleting the key, and anything else replaces what it patches.
+func mergePatch(target, patch *yaml.Node) *yaml.Node {
+	if patch.Kind == yaml.AliasNode {
+		patch = patch.Alias
+	}
+	if patch.Kind != yaml.MappingNode {
+		if patch.ShortTag() == "!!null" {
+			return nil
+		}
+		return patch
+	}
+	if target != nil && target.Kind == yaml.AliasNode {
+		target = target.Alias
+	}
+	if target == nil || target.Kind != yaml.MappingNode {
+		target = &yaml.Node{Kind: yaml.MappingNode}
+	} else {
+		// the original is shared by any alias to it
+		copied := *target
+		copied.Content = append([]*yaml.Node(nil), target.Content...)
+		target = &copied
+	}
+	for i := 0; i+1 < len(patch.Content); i += 2 {
+		key := patch.Content[i].Value
+		at := -1
+		for j := 0; j+1 < len(target.Content); j += 2 {
+			if target.Content[j].Value == key {
+				at = j
+				break
+			}
+		}
+		var old *yaml.Node
+		if at >= 0 {
+			old = target.Content[at+1]
+		}
+		merged := mergePatch(old, patch.Content[i+1])
+		switch {
+		case merged == nil && at >= 0:
+			target.Content = append(target.Content[:at], target.Content[at+2:]...)
+		case merged == nil:
+			// deleting a key that is not there
+		case at >= 0:
+			target.Content[at+1] = merged
+		default:
+			target.Content = append(target.Content, patch.Content[i], merged)
+		}
+	}
+	return target
+}
@@ -0,0 +1,386 @@
+package zksync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// PatchKind says how a patch given to PatchNode is read.
+type PatchKind string
+
+const (
+	// JSONPatch is a list of operations, as RFC 6902 has them.
+	JSONPatch PatchKind = "json"
+	// MergePatch is a document whose keys replace those of the one it is
+	// applied to, a null deleting the key, as RFC 7386 has it.
+	MergePatch PatchKind = "merge"
+)
+
+// ParsePatchKind checks s names a known kind of patch.
+func ParsePatchKind(s string) (PatchKind, error) {
+	switch k := PatchKind(s); k {
+	case JSONPatch, MergePatch:
+		return k, nil
+	}
+	return "", fmt.Errorf("unknown patch type: %s", s)
+}
+
+// PatchNode applies patch, of kind, to the JSON document in the file at p
+// and writes the result back, for changing a field without uploading the
+// whole tree. Keys come back sorted. With a version of 0 or more the node
+// must be at that version; either way it is only written over the version
+// the patch was applied to, failing with ErrBadVersion if it changed in
+// between.
+func (c *Client) PatchNode(ctx context.Context, p string, patch []byte, kind PatchKind, version int64, opts Options) (*Result, error) {
+	old, stat, err := c.getFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", p, err)
+	}
+	if stat.IsDir() {
+		return nil, fmt.Errorf("%s is a dir", p)
+	}
+	if version >= 0 && stat.Version != version {
+		return nil, fmt.Errorf("%s is at version %d, not %d: %w", p, stat.Version, version, ErrBadVersion)
+	}
+	doc, err := decodeJSON(old)
+	if err != nil {
+		return nil, fmt.Errorf("%s does not hold JSON: %w", p, err)
+	}
+	patchDoc, err := decodeJSON(patch)
+	if err != nil {
+		return nil, fmt.Errorf("parsing patch: %w", err)
+	}
+
+	if kind == JSONPatch {
+		ops, ok := patchDoc.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("a JSON patch is a list of operations")
+		}
+		if doc, err = applyJSONPatch(doc, ops); err != nil {
+			return nil, fmt.Errorf("patching %s: %w", p, err)
+		}
+	} else {
+		doc = mergePatchValue(doc, patchDoc)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if bytes.Contains(bytes.TrimSpace(old), []byte("\n")) {
+		// written to be read, so kept that way
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	if !bytes.HasSuffix(old, []byte("\n")) {
+		data = bytes.TrimSuffix(data, []byte("\n"))
+	}
+	if bytes.Equal(old, data) {
+		return &Result{}, nil
+	}
+	writePlan, err := c.planWrite("", p, data, stat, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.run(ctx, writePlan, opts)
+}
+
+// decodeJSON decodes the single JSON value in data, keeping numbers as
+// they are written.
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("more than one value")
+	}
+	return v, nil
+}
+
+// mergePatchValue applies the merge patch patch to target as RFC 7386
+// says, the same as mergePatch does for YAML.
+func mergePatchValue(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatchValue(t[k], v)
+		}
+	}
+	return t
+}
+
+// applyJSONPatch applies the operations of an RFC 6902 patch to doc in
+// turn, all of them or none.
+func applyJSONPatch(doc interface{}, ops []interface{}) (interface{}, error) {
+	for i, raw := range ops {
+		op, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("operation %d is not an object", i)
+		}
+		name, _ := op["op"].(string)
+		target, err := operationPointer(op, "path")
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+		value, hasValue := op["value"]
+		if !hasValue && (name == "add" || name == "replace" || name == "test") {
+			return nil, fmt.Errorf("operation %d: %s needs a value", i, name)
+		}
+
+		switch name {
+		case "add":
+			doc, err = pointerAdd(doc, target, value)
+		case "remove":
+			doc, _, err = pointerRemove(doc, target)
+		case "replace":
+			if doc, _, err = pointerRemove(doc, target); err == nil {
+				doc, err = pointerAdd(doc, target, value)
+			}
+		case "move", "copy":
+			var from []string
+			if from, err = operationPointer(op, "from"); err != nil {
+				break
+			}
+			var moved interface{}
+			if name == "move" {
+				if len(from) < len(target) && reflect.DeepEqual(from, target[:len(from)]) {
+					err = fmt.Errorf("cannot move %s into itself", pointerString(from))
+					break
+				}
+				doc, moved, err = pointerRemove(doc, from)
+			} else if moved, err = pointerGet(doc, from); err == nil {
+				moved, err = copyValue(moved)
+			}
+			if err == nil {
+				doc, err = pointerAdd(doc, target, moved)
+			}
+		case "test":
+			var got interface{}
+			if got, err = pointerGet(doc, target); err == nil && !equalJSON(got, value) {
+				err = fmt.Errorf("test failed: %s is not as expected", pointerString(target))
+			}
+		default:
+			err = fmt.Errorf("unknown op %q", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return doc, nil
+}
+
+// operationPointer returns the JSON pointer in the field of op, split into
+// reference tokens.
+func operationPointer(op map[string]interface{}, field string) ([]string, error) {
+	s, ok := op[field].(string)
+	if !ok {
+		return nil, fmt.Errorf("no %s", field)
+	}
+	if s == "" {
+		return nil, nil
+	}
+	if s[0] != '/' {
+		return nil, fmt.Errorf("bad pointer %q: it must start with /", s)
+	}
+	tokens := strings.Split(s[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return tokens, nil
+}
+
+func pointerString(tokens []string) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteString("/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(t))
+	}
+	return b.String()
+}
+
+// arrayIndex returns the index token names in an array of n elements,
+// which may be n itself, as "-" always is, with end.
+func arrayIndex(token string, n int, end bool) (int, error) {
+	if token == "-" && end {
+		return n, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && token[0] == '0') {
+		return 0, fmt.Errorf("bad array index %q", token)
+	}
+	if i > n || (i == n && !end) {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+func pointerGet(doc interface{}, tokens []string) (interface{}, error) {
+	for i, t := range tokens {
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			v, ok := d[t]
+			if !ok {
+				return nil, fmt.Errorf("%s does not exist", pointerString(tokens[:i+1]))
+			}
+			doc = v
+		case []interface{}:
+			j, err := arrayIndex(t, len(d), false)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", pointerString(tokens[:i+1]), err)
+			}
+			doc = d[j]
+		default:
+			return nil, fmt.Errorf("%s does not exist", pointerString(tokens[:i+1]))
+		}
+	}
+	return doc, nil
+}
+
+// pointerAdd returns doc with value added at tokens: set in an object, or
+// inserted into an array.
+func pointerAdd(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parent, err := pointerGet(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch d := parent.(type) {
+	case map[string]interface{}:
+		d[last] = value
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(d), true)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pointerString(tokens), err)
+		}
+		d = append(d, nil)
+		copy(d[i+1:], d[i:])
+		d[i] = value
+		return pointerSet(doc, tokens[:len(tokens)-1], d)
+	}
+	return nil, fmt.Errorf("%s is neither an object nor an array", pointerString(tokens[:len(tokens)-1]))
+}
+
+// pointerRemove returns doc without the value at tokens, and that value.
+func pointerRemove(doc interface{}, tokens []string) (interface{}, interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+	parent, err := pointerGet(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch d := parent.(type) {
+	case map[string]interface{}:
+		v, ok := d[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("%s does not exist", pointerString(tokens))
+		}
+		delete(d, last)
+		return doc, v, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(d), false)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", pointerString(tokens), err)
+		}
+		v := d[i]
+		d = append(d[:i:i], d[i+1:]...)
+		doc, err = pointerSet(doc, tokens[:len(tokens)-1], d)
+		return doc, v, err
+	}
+	return nil, nil, fmt.Errorf("%s does not exist", pointerString(tokens))
+}
+
+// pointerSet returns doc with the value at tokens, which exists, replaced,
+// as arrays that grow or shrink have to be.
+func pointerSet(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parent, err := pointerGet(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch d := parent.(type) {
+	case map[string]interface{}:
+		d[last] = value
+	case []interface{}:
+		i, err := arrayIndex(last, len(d), false)
+		if err != nil {
+			return nil, err
+		}
+		d[i] = value
+	}
+	return doc, nil
+}
+
+func copyValue(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(data)
+}
+
+// equalJSON compares values as RFC 6902 tests them, numbers by value
+// however they are written.
+func equalJSON(a, b interface{}) bool {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, okA := new(big.Float).SetString(a.String())
+		y, okB := new(big.Float).SetString(b.String())
+		return okA && okB && x.Cmp(y) == 0
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			w, ok := b[k]
+			if !ok || !equalJSON(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equalJSON(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,148 @@
+package zksync
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestApplyJSONPatch(t *testing.T) {
+	for _, tt := range []struct {
+		doc, patch string
+		// want is the patched document, or with err set what the error
+		// says
+		want string
+		err  bool
+	}{
+		{`{"a":1}`, `[]`, `{"a":1}`, false},
+		{`{"a":1}`, `[{"op":"add","path":"/b","value":2}]`, `{"a":1,"b":2}`, false},
+		{`{"a":1}`, `[{"op":"add","path":"/a","value":2}]`, `{"a":2}`, false},
+		{`{"a":[1,3]}`, `[{"op":"add","path":"/a/1","value":2}]`, `{"a":[1,2,3]}`, false},
+		{`{"a":[1]}`, `[{"op":"add","path":"/a/-","value":2}]`, `{"a":[1,2]}`, false},
+		{`{"a":1}`, `[{"op":"add","path":"","value":[]}]`, `[]`, false},
+		{`{"a":1,"b":2}`, `[{"op":"remove","path":"/a"}]`, `{"b":2}`, false},
+		{`{"a":[1,2,3]}`, `[{"op":"remove","path":"/a/0"}]`, `{"a":[2,3]}`, false},
+		{`{"a":1}`, `[{"op":"replace","path":"/a","value":{"b":null}}]`, `{"a":{"b":null}}`, false},
+		{`{"a":{"b":1}}`, `[{"op":"move","from":"/a/b","path":"/c"}]`, `{"a":{},"c":1}`, false},
+		{`{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"}]`, `{"a":{"b":1},"c":{"b":1}}`, false},
+		{`{"a/b":1,"c~d":2}`, `[{"op":"remove","path":"/a~1b"},{"op":"remove","path":"/c~0d"}]`, `{}`, false},
+		{`{"a":1.0}`, `[{"op":"test","path":"/a","value":1}]`, `{"a":1.0}`, false},
+		// operations apply in turn, each to what the last left
+		{`{}`, `[{"op":"add","path":"/a","value":[]},{"op":"add","path":"/a/0","value":1},{"op":"move","from":"/a","path":"/b"}]`, `{"b":[1]}`, false},
+		{`{}`, `[{"op":"add","path":"/a/0","value":1},{"op":"add","path":"/a","value":[]}]`, "/a does not exist", true},
+		// malformed operations
+		{`{}`, `[1]`, "operation 0 is not an object", true},
+		{`{}`, `[{"op":"add","value":1}]`, "no path", true},
+		{`{}`, `[{"op":"add","path":"a","value":1}]`, "must start with /", true},
+		{`{}`, `[{"op":"add","path":"/a"}]`, "add needs a value", true},
+		{`{}`, `[{"op":"test","path":"/a"}]`, "test needs a value", true},
+		{`{}`, `[{"op":"frobnicate","path":"/a"}]`, `unknown op "frobnicate"`, true},
+		{`{"a":1}`, `[{"op":"move","path":"/b"}]`, "no from", true},
+		{`{"a":{}}`, `[{"op":"move","from":"/a","path":"/a/b"}]`, "cannot move /a into itself", true},
+		// paths that are not there
+		{`{"a":1}`, `[{"op":"remove","path":"/b"}]`, "/b does not exist", true},
+		{`{"a":1}`, `[{"op":"replace","path":"/b","value":1}]`, "/b does not exist", true},
+		{`{"a":1}`, `[{"op":"add","path":"/b/c","value":1}]`, "/b does not exist", true},
+		{`{"a":1}`, `[{"op":"add","path":"/a/b","value":1}]`, "/a is neither an object nor an array", true},
+		{`{"a":[1]}`, `[{"op":"add","path":"/a/2","value":1}]`, "array index 2 out of range", true},
+		{`{"a":[1]}`, `[{"op":"remove","path":"/a/1"}]`, "array index 1 out of range", true},
+		{`{"a":[1]}`, `[{"op":"remove","path":"/a/01"}]`, `bad array index "01"`, true},
+		{`{"a":[1]}`, `[{"op":"remove","path":"/a/-"}]`, `bad array index "-"`, true},
+		{`{"a":1}`, `[{"op":"test","path":"/a","value":"1"}]`, "test failed: /a is not as expected", true},
+		// the operation that failed is named
+		{`{"a":1}`, `[{"op":"remove","path":"/a"},{"op":"remove","path":"/a"}]`, "operation 1: /a does not exist", true},
+	} {
+		doc, err := decodeJSON([]byte(tt.doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ops, err := decodeJSON([]byte(tt.patch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := applyJSONPatch(doc, ops.([]interface{}))
+		if tt.err {
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("applying %s to %s fails with %v, want %q", tt.patch, tt.doc, err, tt.want)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("applying %s to %s fails with %v", tt.patch, tt.doc, err)
+			continue
+		}
+		want, err := decodeJSON([]byte(tt.want))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalJSON(got, want) {
+			t.Errorf("applying %s to %s = %v, want %s", tt.patch, tt.doc, got, tt.want)
+		}
+	}
+}
+
+func TestMergePatchValue(t *testing.T) {
+	for _, tt := range []struct {
+		doc, patch, want string
+	}{
+		{`{"a":1}`, `{"a":2}`, `{"a":2}`},
+		{`{"a":1}`, `{"b":2}`, `{"a":1,"b":2}`},
+		{`{"a":1,"b":2}`, `{"a":null}`, `{"b":2}`},
+		{`{"a":{"b":1,"c":2}}`, `{"a":{"b":null,"d":3}}`, `{"a":{"c":2,"d":3}}`},
+		{`{"a":[1,2]}`, `{"a":[3]}`, `{"a":[3]}`},
+		{`{"a":1}`, `{"a":{"b":null}}`, `{"a":{}}`},
+		{`[1]`, `{"a":1}`, `{"a":1}`},
+		{`{"a":1}`, `"b"`, `"b"`},
+	} {
+		doc, err := decodeJSON([]byte(tt.doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		patch, err := decodeJSON([]byte(tt.patch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := decodeJSON([]byte(tt.want))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := mergePatchValue(doc, patch); !equalJSON(got, want) {
+			t.Errorf("merging %s into %s = %v, want %s", tt.patch, tt.doc, got, tt.want)
+		}
+	}
+}
+
+func TestPatchNode(t *testing.T) {
+	b := NewMemoryBackend()
+	c := New(b)
+	ctx, ok := context.Background(), applied(t)
+	if err := b.Create("/app.json", []byte(`{"b":1,"a":{"c":2}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	ok(c.PatchNode(ctx, "/app.json", []byte(`[{"op":"replace","path":"/b","value":10}]`), JSONPatch, 0, Options{}))
+	ok(c.PatchNode(ctx, "/app.json", []byte(`{"a":{"c":null},"d":"x"}`), MergePatch, -1, Options{}))
+	if data, _, _ := b.Get("/app.json"); string(data) != `{"a":{},"b":10,"d":"x"}` {
+		t.Errorf("/app.json reads %s", data)
+	}
+
+	// a failing patch writes nothing
+	if _, err := c.PatchNode(ctx, "/app.json", []byte(`[{"op":"add","path":"/e","value":1},{"op":"remove","path":"/f"}]`), JSONPatch, -1, Options{}); err == nil {
+		t.Error("patch removing a missing key succeeds")
+	}
+	if _, err := c.PatchNode(ctx, "/app.json", []byte(`{"op":"add"}`), JSONPatch, -1, Options{}); err == nil {
+		t.Error("JSON patch that is not a list succeeds")
+	}
+	if _, err := c.PatchNode(ctx, "/app.json", []byte(`[] []`), JSONPatch, -1, Options{}); err == nil {
+		t.Error("patch of two values succeeds")
+	}
+	if _, err := c.PatchNode(ctx, "/app.json", []byte(`{}`), MergePatch, 0, Options{}); !errors.Is(err, ErrBadVersion) {
+		t.Errorf("patching an old version fails with %v", err)
+	}
+	if data, stat, _ := b.Get("/app.json"); string(data) != `{"a":{},"b":10,"d":"x"}` || stat.Version != 2 {
+		t.Errorf("/app.json reads %s at version %d after failed patches", data, stat.Version)
+	}
+	if _, err := c.PatchNode(ctx, "/missing.json", []byte(`{}`), MergePatch, -1, Options{}); !errors.Is(err, ErrNoNode) {
+		t.Errorf("patching a missing node fails with %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package zksync
+
+import (
+	"fmt"
+	"os"
+)
+
+// PermAll is every permission.
+const PermAll = PermRead | PermWrite | PermCreate | PermDelete | PermAdmin
+
+// modeACL maps a file mode to the ACL its node gets with Options.ModeACLs.
+// ZooKeeper has no groups, so only the owner and other bits count: the
+// creator always has full access, and everyone else gets read, or read and
+// write access if others have it locally. 0600 ends up creator only.
+func modeACL(mode os.FileMode) []ACL {
+	acl := []ACL{{Scheme: "auth", Perms: PermAll}}
+	var world int32
+	if mode&0004 != 0 {
+		world |= PermRead
+	}
+	if mode&0002 != 0 {
+		world |= PermWrite
+	}
+	if world != 0 {
+		acl = append(acl, ACL{Scheme: "world", ID: "anyone", Perms: world})
+	}
+	return acl
+}
+
+// aclMode maps an ACL back to the file mode a download gives the file.
+func aclMode(acl []ACL) os.FileMode {
+	mode := os.FileMode(0600)
+	for _, a := range acl {
+		if a.Scheme != "world" || a.ID != "anyone" {
+			continue
+		}
+		if a.Perms&PermRead != 0 {
+			mode |= 0044
+		}
+		if a.Perms&PermWrite != 0 {
+			mode |= 0022
+		}
+	}
+	return mode
+}
+
+// planModeACL plans giving the existing node at remotePath the ACL that
+// matches mode, if it does not have an equivalent one already. The creator
+// entry is stored under whatever id the creator had, so ACLs are compared
+// by the modes they map to.
+func (c *Client) planModeACL(remotePath string, mode os.FileMode) (Plan, error) {
+	have, err := c.Backend.(ACLBackend).GetACL(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading ACL of %s: %w", remotePath, err)
+	}
+	want := modeACL(mode)
+	if aclMode(have) == aclMode(want) {
+		return nil, nil
+	}
+	return Plan{{Kind: OpSetACL, Target: remotePath, ACL: want, OldACL: have}}, nil
+}
+
+// remoteMode is the mode a download gives the file at remotePath, or 0 for
+// the default when modes are not mapped.
+func (c *Client) remoteMode(remotePath string, opts Options) (os.FileMode, error) {
+	if !opts.ModeACLs {
+		return 0, nil
+	}
+	acl, err := c.Backend.(ACLBackend).GetACL(remotePath)
+	if err != nil {
+		return 0, fmt.Errorf("reading ACL of %s: %w", remotePath, err)
+	}
+	return aclMode(acl), nil
+}
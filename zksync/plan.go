@@ -0,0 +1,353 @@
+package zksync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// OpKind tells what an Op changes.
+type OpKind int
+
+const (
+	OpCreate    OpKind = iota // create a remote node
+	OpSet                     // overwrite a remote node
+	OpDelete                  // delete a remote node
+	OpMkdir                   // create a local dir
+	OpWrite                   // create a local file
+	OpOverwrite               // overwrite a local file
+	OpRemove                  // remove a local file or dir
+	OpSetACL                  // change the ACL of a remote node
+	OpChmod                   // change the mode of a local file
+)
+
+var opKindNames = [...]string{"create", "set", "delete", "mkdir", "write", "overwrite", "remove", "setacl", "chmod"}
+
+func (k OpKind) String() string {
+	if int(k) < len(opKindNames) {
+		return opKindNames[k]
+	}
+	return fmt.Sprintf("OpKind(%d)", int(k))
+}
+
+// remote reports whether ops of kind k change the remote tree.
+func (k OpKind) remote() bool {
+	return k == OpCreate || k == OpSet || k == OpDelete || k == OpSetACL
+}
+
+// Op is a single change to either the remote or the local tree. Walks only
+// ever produce ops, nothing is touched until the plan is applied.
+type Op struct {
+	Kind    OpKind
+	Source  string
+	Target  string
+	Dir     bool
+	Data    []byte
+	OldSize int         // size of the data being replaced or deleted
+	Version int64       // expected remote version for sets and deletes
+	Mtime   time.Time   // remote mtime given to local files, if known
+	ACL     []ACL       // for creates and ACL changes, nil for the default
+	OldACL  []ACL       // the ACL being replaced
+	Mode    os.FileMode // for local files, 0 for the default
+}
+
+func (o Op) String() string {
+	switch o.Kind {
+	case OpCreate:
+		if o.Dir {
+			return fmt.Sprintf("create    %s (dir)", o.Target)
+		}
+		return fmt.Sprintf("create    %s (%d bytes)", o.Target, len(o.Data))
+	case OpSet:
+		return fmt.Sprintf("set       %s (%d -> %d bytes)", o.Target, o.OldSize, len(o.Data))
+	case OpDelete:
+		return fmt.Sprintf("delete    %s (%d bytes)", o.Target, o.OldSize)
+	case OpMkdir:
+		return fmt.Sprintf("mkdir     %s", o.Target)
+	case OpWrite:
+		return fmt.Sprintf("write     %s (%d bytes)", o.Target, len(o.Data))
+	case OpOverwrite:
+		return fmt.Sprintf("overwrite %s (%d -> %d bytes)", o.Target, o.OldSize, len(o.Data))
+	case OpRemove:
+		return fmt.Sprintf("remove    %s", o.Target)
+	case OpSetACL:
+		return fmt.Sprintf("setacl    %s (%s -> %s)", o.Target, formatACL(o.OldACL), formatACL(o.ACL))
+	case OpChmod:
+		return fmt.Sprintf("chmod     %s (%#o)", o.Target, o.Mode)
+	}
+	return fmt.Sprintf("unknown op %d on %s", o.Kind, o.Target)
+}
+
+func (c *Client) applyOp(o Op) error {
+	switch o.Kind {
+	case OpCreate:
+		var err error
+		if len(o.ACL) > 0 {
+			ab, ok := c.Backend.(ACLBackend)
+			if !ok {
+				return ErrUnsupported
+			}
+			err = ab.CreateWithACL(o.Target, o.Data, o.ACL)
+		} else {
+			err = c.Backend.Create(o.Target, o.Data)
+		}
+		if err != nil {
+			if err == ErrNodeExists && o.Dir {
+				c.logger().Info("Dir already created", "path", o.Target)
+				return nil
+			}
+			return err
+		}
+		if o.Dir {
+			c.logger().Info("Created remote dir", "path", o.Target)
+		} else {
+			c.logger().Info("Copied", "source", o.Source, "target", o.Target)
+		}
+	case OpSet:
+		if err := c.Backend.Set(o.Target, o.Data, o.Version); err != nil {
+			return err
+		}
+		c.logger().Info("Overwrote", "source", o.Source, "target", o.Target)
+	case OpDelete:
+		if err := c.Backend.Delete(o.Target, o.Version); err != nil {
+			return err
+		}
+		c.logger().Info("Deleted", "path", o.Target)
+	case OpMkdir:
+		if err := os.Mkdir(o.Target, nodeMode); err != nil {
+			if os.IsExist(err) {
+				c.logger().Info("Local dir already present", "path", o.Target)
+				return nil
+			}
+			return err
+		}
+		c.logger().Info("Created local dir", "path", o.Target)
+	case OpWrite, OpOverwrite:
+		mode := o.Mode
+		if mode == 0 {
+			mode = 0644
+		}
+		if err := ioutil.WriteFile(o.Target, o.Data, mode); err != nil {
+			return err
+		}
+		if o.Mode != 0 {
+			// the mode given to WriteFile only applies to new files
+			if err := os.Chmod(o.Target, o.Mode); err != nil {
+				return err
+			}
+		}
+		if !o.Mtime.IsZero() {
+			if err := os.Chtimes(o.Target, o.Mtime, o.Mtime); err != nil {
+				return err
+			}
+		}
+		c.logger().Info("Downloaded file", "source", o.Source, "target", o.Target)
+	case OpRemove:
+		if err := os.RemoveAll(o.Target); err != nil {
+			return err
+		}
+		c.logger().Info("Removed local path", "path", o.Target)
+	case OpSetACL:
+		if err := c.Backend.(ACLBackend).SetACL(o.Target, o.ACL); err != nil {
+			return err
+		}
+		c.logger().Info("Set ACL", "path", o.Target, "acl", formatACL(o.ACL))
+	case OpChmod:
+		if err := os.Chmod(o.Target, o.Mode); err != nil {
+			return err
+		}
+		c.logger().Info("Changed mode", "path", o.Target, "mode", fmt.Sprintf("%#o", o.Mode))
+	}
+	return nil
+}
+
+// Plan is an ordered list of changes; parents always come before their
+// children, except for deletes where children come first.
+type Plan []Op
+
+// Print writes the plan out one op per line, followed by a summary.
+func (p Plan) Print(w io.Writer) {
+	var bytes int
+	for _, o := range p {
+		fmt.Fprintln(w, o)
+		bytes += len(o.Data)
+	}
+	if len(p) == 0 {
+		fmt.Fprintln(w, "Nothing to do")
+		return
+	}
+	fmt.Fprintf(w, "%d changes, %d bytes to transfer\n", len(p), bytes)
+}
+
+// apply carries on past failed ops so that as much as possible is synced,
+// and returns every failure. Once ctx is done the remaining ops all fail.
+func (c *Client) apply(ctx context.Context, p Plan, opts Options) []error {
+	t := c.newTracker(p, opts)
+	var errs []error
+	for len(p) > 0 {
+		n := c.deleteBatchLen(p)
+		if n > 1 {
+			errs = append(errs, c.applyDeleteBatch(ctx, p[:n], t)...)
+			p = p[n:]
+			continue
+		}
+
+		if opts.Concurrency > 1 {
+			// deletes go children first and are left to the sequential
+			// path, everything else can be spread over workers
+			n = 0
+			for n < len(p) && p[n].Kind != OpDelete {
+				n++
+			}
+			if n > 1 {
+				errs = append(errs, c.applyParallel(ctx, p[:n], opts.Concurrency, t)...)
+				p = p[n:]
+				continue
+			}
+		}
+
+		err := c.applyOne(ctx, p[0])
+		if err != nil {
+			errs = append(errs, err)
+		}
+		t.done(p[0], err)
+		p = p[1:]
+	}
+	return errs
+}
+
+func (c *Client) applyOne(ctx context.Context, o Op) error {
+	err := ctx.Err()
+	if err == nil {
+		err = c.applyOp(o)
+	}
+	if err != nil {
+		return &OpError{Op: o, Err: err}
+	}
+	return nil
+}
+
+// opKey identifies the node an op writes, telling local and remote paths
+// apart.
+func opKey(kind OpKind, target string) string {
+	switch kind {
+	case OpCreate, OpSet, OpDelete, OpSetACL:
+		return "remote:" + target
+	}
+	return "local:" + filepath.Clean(target)
+}
+
+// parentKey is the opKey of whatever op would create the parent of o.
+func parentKey(o Op) string {
+	if o.Kind == OpCreate || o.Kind == OpSet || o.Kind == OpSetACL {
+		return opKey(o.Kind, path.Dir(o.Target))
+	}
+	return opKey(o.Kind, filepath.Dir(o.Target))
+}
+
+// applyParallel applies p with a pool of workers, holding every op back
+// until the op creating its parent, if there is one in p, is done. Ops are
+// handed out in plan order, so a parent is always already being worked on
+// by the time its children are waiting for it.
+func (c *Client) applyParallel(ctx context.Context, p Plan, workers int, t *tracker) []error {
+	done := make(map[string]chan struct{}, len(p))
+	owner := make(map[string]int, len(p))
+	for i, o := range p {
+		key := opKey(o.Kind, o.Target)
+		if _, ok := done[key]; !ok {
+			done[key] = make(chan struct{})
+			owner[key] = i
+		}
+	}
+
+	errs := make([]error, len(p))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				o := p[i]
+				if parent, ok := done[parentKey(o)]; ok {
+					<-parent
+				}
+				errs[i] = c.applyOne(ctx, o)
+				t.done(o, errs[i])
+				if key := opKey(o.Kind, o.Target); owner[key] == i {
+					close(done[key])
+				}
+			}
+		}()
+	}
+	for i := range p {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return failed
+}
+
+// deleteBatchLen is how many of the deletes at the start of p can go in a
+// single transaction, or 0 if the backend cannot do transactions.
+func (c *Client) deleteBatchLen(p Plan) int {
+	md, ok := c.Backend.(MultiDeleter)
+	if !ok {
+		return 0
+	}
+	maxOps, maxBytes := md.MultiLimit()
+
+	n, size := 0, 0
+	for _, o := range p {
+		if o.Kind != OpDelete {
+			break
+		}
+		size += len(o.Target)
+		if n > 0 && ((maxOps > 0 && n >= maxOps) || (maxBytes > 0 && size > maxBytes)) {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// applyDeleteBatch deletes all of batch in one transaction. Deletes are
+// planned children first, so each batch only ever needs earlier batches to
+// have gone through.
+func (c *Client) applyDeleteBatch(ctx context.Context, batch Plan, t *tracker) []error {
+	err := ctx.Err()
+	if err == nil {
+		nodes := make([]NodeVersion, len(batch))
+		for i, o := range batch {
+			nodes[i] = NodeVersion{Path: o.Target, Version: o.Version}
+		}
+		err = c.Backend.(MultiDeleter).DeleteMulti(nodes)
+	}
+
+	if err != nil {
+		errs := make([]error, len(batch))
+		for i, o := range batch {
+			errs[i] = &OpError{Op: o, Err: err}
+			t.done(o, errs[i])
+		}
+		return errs
+	}
+	for _, o := range batch {
+		t.done(o, nil)
+	}
+	c.logger().Info("Deleted nodes", "count", len(batch), "first", batch[0].Target, "last", batch[len(batch)-1].Target)
+	return nil
+}
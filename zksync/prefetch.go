@@ -0,0 +1,40 @@
+package zksync
+
+import (
+	"context"
+	"path"
+	"sync"
+)
+
+// readAhead is how many nodes walks read at once, to keep round trips to
+// a distant server from adding up.
+const readAhead = 32
+
+// fetchedNode is a node read ahead of its turn in a walk.
+type fetchedNode struct {
+	data []byte
+	stat *Stat
+	err  error
+}
+
+// fetchAll reads the children of dir, readAhead at a time, returning them
+// in the same order.
+func (c *Client) fetchAll(ctx context.Context, dir string, children []string) []fetchedNode {
+	nodes := make([]fetchedNode, len(children))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, readAhead)
+	for i, child := range children {
+		if err := ctx.Err(); err != nil {
+			nodes[i].err = err
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(n *fetchedNode, p string) {
+			defer func() { <-sem; wg.Done() }()
+			n.data, n.stat, n.err = c.getFile(p)
+		}(&nodes[i], path.Join(dir, child))
+	}
+	wg.Wait()
+	return nodes
+}
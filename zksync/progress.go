@@ -0,0 +1,58 @@
+package zksync
+
+import "sync"
+
+// Progress is how far applying a plan has got, passed to
+// Options.OnProgress after every change.
+type Progress struct {
+	// Op is the change just applied, and Err why it failed if it did.
+	Op  Op
+	Err error
+	// Done of Total changes have been applied or have failed, writing
+	// Bytes of the TotalBytes of data in the plan.
+	Done, Total       int
+	Bytes, TotalBytes int64
+}
+
+// tracker counts the changes of a plan as they are applied, for
+// Options.OnProgress, the journal and the audit log. Changes may be
+// applied from several goroutines at once.
+type tracker struct {
+	c        *Client
+	journal  *Journal
+	report   func(Progress)
+	stop     func()
+	mu       sync.Mutex
+	progress Progress
+}
+
+func (c *Client) newTracker(p Plan, opts Options) *tracker {
+	t := &tracker{c: c, journal: opts.Journal, report: opts.OnProgress, stop: opts.stop}
+	t.progress.Total = len(p)
+	for _, o := range p {
+		t.progress.TotalBytes += int64(len(o.Data))
+	}
+	return t
+}
+
+// done records that o was applied, or failed with err.
+func (t *tracker) done(o Op, err error) {
+	if err == nil {
+		t.c.Audit.record(t.c, o)
+	} else if t.stop != nil && isConflict(err) {
+		t.c.logger().Error("Changed since it was read, stopping", "path", o.Target)
+		t.stop()
+	}
+	if err == nil && t.journal != nil {
+		t.journal.done(t.c, o)
+	}
+	if t.report == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.progress.Op, t.progress.Err = o, err
+	t.progress.Done++
+	t.progress.Bytes += int64(len(o.Data))
+	t.report(t.progress)
+}
@@ -0,0 +1,409 @@
+package zksync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Properties picks the Java properties files that uploads store as a dir
+// holding a node per key, for services reading single keys, and that
+// downloads join back into a file.
+type Properties struct {
+	patterns []pattern
+}
+
+// NewProperties returns Properties for the files matching any of patterns,
+// matched as Filter matches them.
+func NewProperties(patterns []string) (*Properties, error) {
+	compiled, err := compilePatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return &Properties{patterns: compiled}, nil
+}
+
+// matches reports whether the file at rel is a properties file. A nil
+// Properties matches none.
+func (pr *Properties) matches(rel string) bool {
+	if pr == nil || rel == "" {
+		return false
+	}
+	for _, p := range pr.patterns {
+		if p.match(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// property is a key and its value, in the order a file gives them.
+type property struct {
+	key, value string
+}
+
+// parseProperties reads data as Properties.load does: comments start with
+// # or !, keys end at the first unescaped =, : or space, lines ending in a
+// backslash go on to the next, and backslash escapes are undone. A key
+// given twice keeps its last value.
+func parseProperties(file string, data []byte) ([]property, error) {
+	var props []property
+	index := make(map[string]int)
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		n := i + 1
+		line := strings.TrimLeft(lines[i], " \t\f")
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		for continues(line) && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + strings.TrimLeft(lines[i], " \t\f")
+		}
+		if continues(line) {
+			line = line[:len(line)-1]
+		}
+
+		end := 0
+		for end < len(line) && !strings.ContainsRune("=: \t\f", rune(line[end])) {
+			if line[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end > len(line) {
+			end = len(line)
+		}
+		rest := strings.TrimLeft(line[end:], " \t\f")
+		if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+			rest = strings.TrimLeft(rest[1:], " \t\f")
+		}
+		key, err := unescapeProperty(line[:end])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, n, err)
+		}
+		value, err := unescapeProperty(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, n, err)
+		}
+		if key == "" || key == "." || key == ".." || strings.Contains(key, "/") || strings.HasPrefix(key, ".chunk-") {
+			return nil, fmt.Errorf("%s:%d: key %q cannot be a node name", file, n, key)
+		}
+		if j, ok := index[key]; ok {
+			props[j].value = value
+			continue
+		}
+		index[key] = len(props)
+		props = append(props, property{key: key, value: value})
+	}
+	return props, nil
+}
+
+// continues reports whether line ends in an odd number of backslashes,
+// going on to the next line.
+func continues(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+func unescapeProperty(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+5 > len(s) {
+				return "", fmt.Errorf("malformed \\u escape")
+			}
+			r, err := strconv.ParseUint(s[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("malformed \\u escape: %s", s[i-1:i+5])
+			}
+			b.WriteRune(rune(r))
+			i += 4
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// formatProperties writes props sorted by key, one key=value line each,
+// escaped so that parseProperties reads them back as they are.
+func formatProperties(props map[string]string) []byte {
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	for _, k := range keys {
+		b.WriteString(escapeProperty(k, true))
+		b.WriteByte('=')
+		b.WriteString(escapeProperty(props[k], false))
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+func escapeProperty(s string, key bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '=', ':', '#', '!', ' ':
+			// only keys end at these, but a value starting with a space
+			// would lose it
+			if key || (i == 0 && r == ' ') {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// planUploadProperties plans storing the properties file read from
+// visitedPath at remotePath, as a dir holding a node per key. With fresh,
+// nothing is there yet. Keys gone from the file are deleted with
+// opts.Prune.
+func (c *Client) planUploadProperties(ctx context.Context, visitedPath, remotePath, rel string, data []byte, fresh bool, opts Options) (Plan, error) {
+	props, err := parseProperties(visitedPath, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var stat *Stat
+	if !fresh {
+		_, stat, err = c.getFile(remotePath)
+		if err != nil && err != ErrNoNode {
+			return nil, fmt.Errorf("checking %s: %w", remotePath, err)
+		}
+	}
+	var p Plan
+	switch {
+	case stat == nil:
+		p = append(p, Op{Kind: OpCreate, Source: visitedPath, Target: remotePath, Dir: true, Data: []byte{}, ACL: opts.ACLs.For(rel)})
+	case stat.Ephemeral:
+		c.logger().Warn("Remote node is ephemeral, not overwriting", "path", remotePath)
+		return nil, nil
+	case stat.Chunks > 0:
+		return nil, fmt.Errorf("remote path is a chunked file when properties are expected: %s", remotePath)
+	case !stat.IsDir():
+		// the file was uploaded whole before, its keys are to go below it
+		p = append(p, Op{Kind: OpSet, Source: visitedPath, Target: remotePath, Dir: true, Data: []byte{}, OldSize: stat.DataLength, Version: stat.Version})
+	}
+
+	inFile := make(map[string]bool, len(props))
+	for _, prop := range props {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		inFile[prop.key] = true
+		keyPath := path.Join(remotePath, prop.key)
+		value := []byte(prop.value)
+
+		var old []byte
+		var oldStat *Stat
+		if stat != nil && stat.IsDir() {
+			old, oldStat, err = c.getFile(keyPath)
+			if err != nil && err != ErrNoNode {
+				return nil, fmt.Errorf("checking %s: %w", keyPath, err)
+			}
+		}
+		switch {
+		case oldStat == nil:
+			writePlan, err := c.planWrite(visitedPath, keyPath, value, nil, opts.ACLs.For(path.Join(rel, prop.key)), opts)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, writePlan...)
+		case oldStat.NumChildren > 0:
+			return nil, fmt.Errorf("remote path is a dir when a property is expected: %s", keyPath)
+		case (!oldStat.IsDir() || opts.PlainEmpty) && bytes.Equal(old, value):
+			c.logger().Debug("Property is the same", "path", keyPath)
+		default:
+			writePlan, err := c.planWrite(visitedPath, keyPath, value, oldStat, nil, opts)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, writePlan...)
+		}
+	}
+
+	if opts.Prune && stat != nil && stat.IsDir() {
+		children, _, err := c.Backend.List(remotePath)
+		if err != nil {
+			return nil, fmt.Errorf("listing %s: %w", remotePath, err)
+		}
+		sort.Strings(children)
+		for _, child := range children {
+			if inFile[child] {
+				continue
+			}
+			c.logger().Debug("Gone from the properties file, will delete", "path", path.Join(remotePath, child))
+			deletePlan, err := c.planDelete(ctx, path.Join(remotePath, child))
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, deletePlan...)
+		}
+	}
+	return p, nil
+}
+
+// planDownloadProperties plans joining the keys below the dir at
+// serverPrefix into the properties file localPrefix, leaving a local file
+// holding the same keys and values as it is, whatever its comments and
+// order.
+func (c *Client) planDownloadProperties(ctx context.Context, serverPrefix, localPrefix string, opts Options) (Plan, error) {
+	props, _, err := c.readProperties(ctx, serverPrefix, opts)
+	if err != nil {
+		return nil, err
+	}
+	data := formatProperties(props)
+
+	mode, err := c.remoteMode(serverPrefix, opts)
+	if err != nil {
+		return nil, err
+	}
+	old, err := ioutil.ReadFile(localPrefix)
+	if os.IsNotExist(err) {
+		return Plan{{Kind: OpWrite, Source: serverPrefix, Target: localPrefix, Data: data, Mode: mode}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if local, err := parseProperties(localPrefix, old); err == nil && sameProperties(local, props) {
+		c.logger().Debug("Properties are the same", "path", localPrefix)
+		return nil, nil
+	}
+	return Plan{{Kind: OpOverwrite, Source: serverPrefix, Target: localPrefix, Data: data, OldSize: len(old), Mode: mode}}, nil
+}
+
+// readProperties returns the keys and values held below the dir at
+// serverPrefix, and when the latest of them was written.
+func (c *Client) readProperties(ctx context.Context, serverPrefix string, opts Options) (map[string]string, time.Time, error) {
+	children, _, err := c.Backend.List(serverPrefix)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("listing %s: %w", serverPrefix, err)
+	}
+	nodes := c.fetchAll(ctx, serverPrefix, children)
+	props := make(map[string]string, len(children))
+	var mtime time.Time
+	for i, child := range children {
+		keyPath := path.Join(serverPrefix, child)
+		if nodes[i].err != nil {
+			return nil, time.Time{}, fmt.Errorf("reading %s: %w", keyPath, nodes[i].err)
+		}
+		if nodes[i].stat.NumChildren > 0 {
+			return nil, time.Time{}, fmt.Errorf("%s has children, it is not a property", keyPath)
+		}
+		if nodes[i].stat.Ephemeral && !opts.Ephemeral {
+			continue
+		}
+		props[child] = string(nodes[i].data)
+		if nodes[i].stat.Mtime.After(mtime) {
+			mtime = nodes[i].stat.Mtime
+		}
+	}
+	return props, mtime, nil
+}
+
+// diffProperties compares the properties file at the local side of d
+// with the keys below the dir at its remote side, whatever the comments
+// and order of the file. A Modified difference holds both sides as
+// formatProperties writes them.
+func (c *Client) diffProperties(ctx context.Context, d Difference, opts Options, diffs []Difference) ([]Difference, error) {
+	data, err := c.readLocal(d.LocalPath, d.Path, opts)
+	if err != nil {
+		return nil, err
+	}
+	local, err := parseProperties(d.LocalPath, data)
+	if err != nil {
+		return nil, err
+	}
+	remote, mtime, err := c.readProperties(ctx, d.RemotePath, opts)
+	if err != nil {
+		return nil, err
+	}
+	if sameProperties(local, remote) {
+		c.logger().Debug("Properties are the same", "path", d.RemotePath)
+		return diffs, nil
+	}
+
+	localProps := make(map[string]string, len(local))
+	for _, prop := range local {
+		localProps[prop.key] = prop.value
+	}
+	// the dir keeps its own mtime when only its keys change
+	stat := *d.Remote
+	if mtime.After(stat.Mtime) {
+		stat.Mtime = mtime
+	}
+	d.Kind, d.Remote = Modified, &stat
+	d.LocalData, d.RemoteData = formatProperties(localProps), formatProperties(remote)
+	return append(diffs, d), nil
+}
+
+// canonicalProperties returns the properties file data as formatProperties
+// writes it, for telling whether two files hold the same keys, or data as it
+// is if it does not parse.
+func canonicalProperties(file string, data []byte) []byte {
+	props, err := parseProperties(file, data)
+	if err != nil {
+		return data
+	}
+	m := make(map[string]string, len(props))
+	for _, prop := range props {
+		m[prop.key] = prop.value
+	}
+	return formatProperties(m)
+}
+
+func sameProperties(local []property, remote map[string]string) bool {
+	if len(local) != len(remote) {
+		return false
+	}
+	for _, prop := range local {
+		if v, ok := remote[prop.key]; !ok || v != prop.value {
+			return false
+		}
+	}
+	return true
+}
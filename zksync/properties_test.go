@@ -0,0 +1,211 @@
+package zksync
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProperties(t *testing.T) {
+	for _, tt := range []struct {
+		data string
+		want []property
+		// err is what the error says, if there is one
+		err string
+	}{
+		{"a=1\nb=2\n", []property{{"a", "1"}, {"b", "2"}}, ""},
+		// separators
+		{"a:1", []property{{"a", "1"}}, ""},
+		{"a 1", []property{{"a", "1"}}, ""},
+		{"a\t1", []property{{"a", "1"}}, ""},
+		{"a = 1", []property{{"a", "1"}}, ""},
+		{"a : 1", []property{{"a", "1"}}, ""},
+		{"a  =  1  ", []property{{"a", "1  "}}, ""},
+		{"a 1 = 2", []property{{"a", "1 = 2"}}, ""},
+		{"a==1", []property{{"a", "=1"}}, ""},
+		{"a=b:c=d", []property{{"a", "b:c=d"}}, ""},
+		{"a:=1", []property{{"a", "=1"}}, ""},
+		// empty values
+		{"a", []property{{"a", ""}}, ""},
+		{"a=", []property{{"a", ""}}, ""},
+		{"a = ", []property{{"a", ""}}, ""},
+		{"a:", []property{{"a", ""}}, ""},
+		// comments and blank lines
+		{"# a=1\n! b=2\n\n  \t\nc=3\n", []property{{"c", "3"}}, ""},
+		{"  # indented comment\n  a=1", []property{{"a", "1"}}, ""},
+		{"a=1 # not a comment", []property{{"a", "1 # not a comment"}}, ""},
+		{"# comment ending in \\\na=1", []property{{"a", "1"}}, ""},
+		// continuation lines
+		{"a=one \\\n    two", []property{{"a", "one two"}}, ""},
+		{"a=1\\\n\\\n2", []property{{"a", "12"}}, ""},
+		{"a\\\n b=1", []property{{"ab", "1"}}, ""},
+		{"a=1\\", []property{{"a", "1"}}, ""},
+		{"a=1\\\\\nb=2", []property{{"a", `1\`}, {"b", "2"}}, ""},
+		{"a=1\\\\\\\n2", []property{{"a", `1\2`}}, ""},
+		{"a=1\r\nb=2\r\n", []property{{"a", "1"}, {"b", "2"}}, ""},
+		// escapes
+		{`a\=b=1`, []property{{"a=b", "1"}}, ""},
+		{`a\:b\ c=1`, []property{{"a:b c", "1"}}, ""},
+		{`a=\ lead`, []property{{"a", " lead"}}, ""},
+		{`a=x\ty\nz\rw\fv`, []property{{"a", "x\ty\nz\rw\fv"}}, ""},
+		{`a=é中`, []property{{"a", "é中"}}, ""},
+		{`A=1`, []property{{"A", "1"}}, ""},
+		{`a=\q\\`, []property{{"a", `q\`}}, ""},
+		{`a=\u00`, nil, `app.properties:1: malformed \u escape`},
+		{"a=1\n" + `b=\u00zz`, nil, `app.properties:2: malformed \u escape: \u00zz`},
+		// keys given twice keep their place and their last value
+		{"a=1\nb=2\na=3", []property{{"a", "3"}, {"b", "2"}}, ""},
+		// keys that cannot be node names
+		{"=1", nil, `app.properties:1: key "" cannot be a node name`},
+		{"a/b=1", nil, `key "a/b" cannot be a node name`},
+		{"..=1", nil, `key ".." cannot be a node name`},
+		{".chunk-0000=1", nil, `key ".chunk-0000" cannot be a node name`},
+		{"", nil, ""},
+	} {
+		got, err := parseProperties("app.properties", []byte(tt.data))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseProperties(%q) fails with %v, want %q", tt.data, err, tt.err)
+			}
+		} else if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseProperties(%q) = %q, %v, want %q", tt.data, got, err, tt.want)
+		}
+	}
+}
+
+func TestFormatProperties(t *testing.T) {
+	props := map[string]string{
+		"plain":      "value",
+		"a=b:c d":    "x",
+		"#comment":   "!bang",
+		"lead":       "  two spaces",
+		"inner":      "a b=c:d #e",
+		"escapes":    "t\tn\nr\rf\f\\",
+		"unicode":    "é中",
+		"empty":      "",
+		"trailing\\": "\\",
+	}
+	data := formatProperties(props)
+	parsed, err := parseProperties("app.properties", data)
+	if err != nil {
+		t.Fatalf("parsing %q: %v", data, err)
+	}
+	got := make(map[string]string)
+	for _, p := range parsed {
+		got[p.key] = p.value
+	}
+	if !reflect.DeepEqual(got, props) {
+		t.Errorf("%q reads back as %q, want %q", data, got, props)
+	}
+}
+
+func TestUploadPropertiesTwice(t *testing.T) {
+	props, err := NewProperties([]string{"*.properties"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New(NewMemoryBackend())
+	ctx, ok := context.Background(), applied(t)
+	local := t.TempDir()
+	writeTree(t, local, map[string]string{"app.properties": "a=1\nempty=\nalso.empty\n"})
+
+	for remote, opts := range map[string]Options{"/marked": {Properties: props}, "/plain": {Properties: props, PlainEmpty: true}} {
+		ok(c.Upload(ctx, local, remote, opts))
+		if res := ok(c.Upload(ctx, local, remote, opts)); len(res.Plan) > 0 {
+			t.Errorf("second upload with PlainEmpty %v plans %v", opts.PlainEmpty, res.Plan)
+		}
+	}
+}
+
+func TestDiffProperties(t *testing.T) {
+	props, err := NewProperties([]string{"*.properties"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewMemoryBackend()
+	c := New(b)
+	ctx := context.Background()
+	local := t.TempDir()
+	writeTree(t, local, map[string]string{"app.properties": "a=1\nb=2\n"})
+	opts := Options{Properties: props}
+	applied(t)(c.Upload(ctx, local, "/app", opts))
+
+	// the same keys, whatever the comments and order
+	writeTree(t, local, map[string]string{"app.properties": "# settings\nb : 2\na=1\n"})
+	if diffs, err := c.Diff(ctx, local, "/app", opts); err != nil || len(diffs) > 0 {
+		t.Errorf("diff finds %v, %v", diffs, err)
+	}
+
+	if err := b.Set("/app/app.properties/b", []byte("3"), -1); err != nil {
+		t.Fatal(err)
+	}
+	diffs, err := c.Diff(ctx, local, "/app", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || diffs[0].Kind != Modified || string(diffs[0].LocalData) != "a=1\nb=2\n" || string(diffs[0].RemoteData) != "a=1\nb=3\n" {
+		t.Errorf("diff finds %+v, want app.properties modified", diffs)
+	}
+}
+
+func TestSyncProperties(t *testing.T) {
+	props, err := NewProperties([]string{"*.properties"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewMemoryBackend()
+	c := New(b)
+	ctx, ok := context.Background(), applied(t)
+	local := t.TempDir()
+	writeTree(t, local, map[string]string{"app.properties": "a=1\nb=2\n"})
+	opts := Options{Properties: props, ThreeWay: true}
+	ok(c.Upload(ctx, local, "/app", opts))
+	ok(c.Sync(ctx, local, "/app", opts))
+
+	// changed remotely, the file is written again
+	if err := b.Set("/app/app.properties/b", []byte("3"), -1); err != nil {
+		t.Fatal(err)
+	}
+	ok(c.Sync(ctx, local, "/app", opts))
+	if got := readTree(t, local)["app.properties"]; got != "a=1\nb=3\n" {
+		t.Errorf("app.properties holds %q after a remote change", got)
+	}
+
+	// changed locally, keys are set and deleted
+	writeTree(t, local, map[string]string{"app.properties": "# settings\nb=4\nc=5\n"})
+	ok(c.Sync(ctx, local, "/app", opts))
+	remote, _, err := c.readProperties(ctx, "/app/app.properties", opts)
+	if err != nil || !reflect.DeepEqual(remote, map[string]string{"b": "4", "c": "5"}) {
+		t.Errorf("keys are %v, %v after a local change", remote, err)
+	}
+
+	if res := ok(c.Sync(ctx, local, "/app", opts)); len(res.Plan) > 0 || len(res.Conflicts) > 0 {
+		t.Errorf("sync after sync plans %v, conflicts %v", res.Plan, res.Conflicts)
+	}
+}
+
+func TestWatchPropertiesKey(t *testing.T) {
+	props, err := NewProperties([]string{"*.properties"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewMemoryBackend()
+	c := New(b)
+	ctx, ok := context.Background(), applied(t)
+	up, local := t.TempDir(), t.TempDir()
+	writeTree(t, up, map[string]string{"app.properties": "a=1\n"})
+	opts := Options{Properties: props}
+	ok(c.Upload(ctx, up, "/app", opts))
+	ok(c.Download(ctx, local, "/app", opts))
+
+	if err := b.Create("/app/app.properties/b", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	p, err := c.planWatchEvent(ctx, Event{Type: EventCreated, Path: "/app/app.properties/b"}, "/app", local, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.applyWatched(ctx, p, opts)
+	sameTree(t, local, map[string]string{"app.properties": "a=1\nb=2\n"})
+}
@@ -0,0 +1,73 @@
+package zksync
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// protectedPaths are never deleted, nor is anything below them, without
+// Options.ForceDelete: ZooKeeper keeps its quotas and config there.
+var protectedPaths = []string{"/zookeeper"}
+
+// depth is how many names deep p is, 0 for the root.
+func depth(p string) int {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return 0
+	}
+	return strings.Count(p, "/")
+}
+
+func minDeleteDepth(opts Options) int {
+	if opts.MinDeleteDepth < 1 {
+		return 1
+	}
+	return opts.MinDeleteDepth
+}
+
+// checkDeletable fails with ErrProtected if opts do not allow deleting the
+// node at p.
+func checkDeletable(p string, opts Options) error {
+	if opts.ForceDelete {
+		return nil
+	}
+	if depth(p) == 0 {
+		return fmt.Errorf("deleting the root: %w", ErrProtected)
+	}
+	for _, protected := range protectedPaths {
+		if p == protected || strings.HasPrefix(p, protected+"/") {
+			return fmt.Errorf("deleting %s: %w", p, ErrProtected)
+		}
+	}
+	if depth(p) < minDeleteDepth(opts) {
+		return fmt.Errorf("deleting %s, less than %d deep: %w", p, minDeleteDepth(opts), ErrProtected)
+	}
+	return nil
+}
+
+// checkPrunable fails with ErrProtected if opts do not allow pruning the
+// tree at p, which is as shallow as the nodes deleted may be.
+func checkPrunable(p string, opts Options) error {
+	if opts.ForceDelete || depth(p) >= minDeleteDepth(opts) {
+		return nil
+	}
+	if depth(p) == 0 {
+		return fmt.Errorf("pruning the root: %w", ErrProtected)
+	}
+	return fmt.Errorf("pruning %s, less than %d deep: %w", p, minDeleteDepth(opts), ErrProtected)
+}
+
+// checkDeletes fails with ErrProtected if p deletes a node opts do not
+// allow deleting, so that none is.
+func checkDeletes(p Plan, opts Options) error {
+	for _, o := range p {
+		if o.Kind != OpDelete {
+			continue
+		}
+		if err := checkDeletable(o.Target, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
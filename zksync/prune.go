@@ -0,0 +1,145 @@
+package zksync
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// planPruneRemote plans deleting the remote nodes under serverPrefix that
+// are no longer on disk under localPrefix. Whatever the filter or ignore
+// files leave out is not the upload's to manage, so it is kept.
+func (c *Client) planPruneRemote(ctx context.Context, serverPrefix, localPrefix, rel string, opts Options, ig *ignorer) (Plan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	children, _, err := c.Backend.List(serverPrefix)
+	if err == ErrNoNode {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", serverPrefix, err)
+	}
+
+	if _, err := opts.Names.localNames(serverPrefix, rel, children, nil); err != nil {
+		return nil, err
+	}
+
+	var p Plan
+	for _, child := range children {
+		remotePath := path.Join(serverPrefix, child)
+		localPath := opts.Names.localChild(localPrefix, rel, child)
+		childRel := path.Join(rel, child)
+		if opts.Filter.Excluded(childRel) || opts.Trash.holds(remotePath) || opts.History.holds(remotePath) {
+			continue
+		}
+
+		fInfo, err := localStat(localPath, opts.Symlinks)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		localExists := err == nil
+		if localExists && !fInfo.IsDir() {
+			continue
+		}
+
+		_, stat, err := c.Backend.Get(remotePath)
+		if err == ErrNoNode {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", remotePath, err)
+		}
+		if stat.Ephemeral {
+			// owned by a session, not by the upload
+			continue
+		}
+		isDir := stat.DataLength == 0
+		if ignored, err := ig.ignored(childRel, isDir); err != nil {
+			return nil, err
+		} else if ignored {
+			continue
+		}
+
+		var childPlan Plan
+		switch {
+		case localExists || (isDir && opts.Filter.hasIncludes()):
+			// with includes only some of what is below is ours to delete
+			childPlan, err = c.planPruneRemote(ctx, remotePath, localPath, childRel, opts, ig)
+		case isDir || opts.Filter.Included(childRel):
+			c.logger().Debug("Gone locally, will delete", "path", remotePath)
+			childPlan, err = c.planDelete(ctx, remotePath)
+		}
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, childPlan...)
+	}
+	return p, nil
+}
+
+// planPruneLocal plans removing the local files and dirs under localPrefix
+// that are no longer on the server under serverPrefix. Paths the upload
+// would ignore are kept, as they were never meant to be on the server.
+func (c *Client) planPruneLocal(ctx context.Context, serverPrefix, localPrefix, rel string, opts Options, ig *ignorer) (Plan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(localPrefix)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	localNames := make([]string, len(entries))
+	files := make([]bool, len(entries))
+	for i, entry := range entries {
+		localNames[i], files[i] = entry.Name(), !entry.IsDir()
+	}
+	names, err := opts.Names.remoteNames(localPrefix, rel, localNames, files)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Plan
+	for i, entry := range entries {
+		remotePath := path.Join(serverPrefix, names[i])
+		localPath := filepath.Join(localPrefix, entry.Name())
+		childRel := path.Join(rel, names[i])
+		if opts.Filter.Excluded(childRel) {
+			continue
+		}
+		if ignored, err := ig.ignored(childRel, entry.IsDir()); err != nil {
+			return nil, err
+		} else if ignored {
+			continue
+		}
+
+		_, stat, err := c.Backend.Get(remotePath)
+		if err == ErrNoNode {
+			stat, err = nil, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", remotePath, err)
+		}
+		remoteExists := stat != nil
+		if remoteExists && (stat.DataLength != 0 || !entry.IsDir()) {
+			continue
+		}
+
+		var childPlan Plan
+		switch {
+		case remoteExists || (entry.IsDir() && opts.Filter.hasIncludes()):
+			childPlan, err = c.planPruneLocal(ctx, remotePath, localPath, childRel, opts, ig)
+		case entry.IsDir() || opts.Filter.Included(childRel):
+			c.logger().Debug("Gone remotely, will remove", "path", localPath)
+			childPlan = Plan{{Kind: OpRemove, Source: remotePath, Target: localPath}}
+		}
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, childPlan...)
+	}
+	return p, nil
+}
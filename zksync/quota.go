@@ -0,0 +1,217 @@
+package zksync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DefaultQuotaNode holds the budgets unless told otherwise.
+const DefaultQuotaNode = "/_quotas"
+
+// Budget limits how many nodes and bytes of data the tree at Prefix holds,
+// Prefix included, zero being no limit. Nodes and bytes are counted as
+// stored, chunks and compression included.
+type Budget struct {
+	Prefix   string `json:"prefix"`
+	MaxNodes int64  `json:"max_nodes,omitempty"`
+	MaxBytes int64  `json:"max_bytes,omitempty"`
+}
+
+// Quotas has runs refuse plans that would take a tree over its budget,
+// read from the JSON node at Node, before changing anything. Plans that
+// leave a tree over its budget but no further over are let through, so
+// that it can be cleaned up.
+type Quotas struct {
+	// Node holds the budgets, DefaultQuotaNode if empty.
+	Node string
+}
+
+func (q *Quotas) node() string {
+	if q == nil || q.Node == "" {
+		return DefaultQuotaNode
+	}
+	return q.Node
+}
+
+// QuotaUsage is what the tree of a Budget holds.
+type QuotaUsage struct {
+	Budget
+	Nodes int64 `json:"nodes"`
+	Bytes int64 `json:"bytes"`
+}
+
+// over lists the limits u is over, such as "nodes 12 > 10".
+func (u QuotaUsage) over() []string {
+	var over []string
+	if u.MaxNodes > 0 && u.Nodes > u.MaxNodes {
+		over = append(over, fmt.Sprintf("nodes %d > %d", u.Nodes, u.MaxNodes))
+	}
+	if u.MaxBytes > 0 && u.Bytes > u.MaxBytes {
+		over = append(over, fmt.Sprintf("bytes %d > %d", u.Bytes, u.MaxBytes))
+	}
+	return over
+}
+
+// readBudgets returns the budgets q holds, by prefix, and the stat of its
+// node, nil if there is none yet.
+func (c *Client) readBudgets(q *Quotas) ([]Budget, *Stat, error) {
+	data, stat, err := c.getFile(q.node())
+	if err == ErrNoNode {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("reading %s: %w", q.node(), err)
+	}
+	var budgets []Budget
+	if err := json.Unmarshal(data, &budgets); err != nil {
+		return nil, nil, fmt.Errorf("bad budgets in %s: %w", q.node(), err)
+	}
+	sort.Slice(budgets, func(i, j int) bool { return budgets[i].Prefix < budgets[j].Prefix })
+	return budgets, stat, nil
+}
+
+// Budgets lists the budgets q holds, by prefix.
+func (c *Client) Budgets(q *Quotas) ([]Budget, error) {
+	budgets, _, err := c.readBudgets(q)
+	return budgets, err
+}
+
+// SetBudget sets the budget of b.Prefix in q, replacing any it had, or
+// removes it if b has no limits.
+func (c *Client) SetBudget(ctx context.Context, q *Quotas, b Budget, opts Options) (*Result, error) {
+	p, err := c.planBudget(q, b)
+	if err != nil {
+		return nil, err
+	}
+	return c.run(ctx, p, opts)
+}
+
+// planBudget plans setting the budget of b.Prefix in q, as SetBudget.
+func (c *Client) planBudget(q *Quotas, b Budget) (Plan, error) {
+	if !strings.HasPrefix(b.Prefix, "/") || path.Clean(b.Prefix) != b.Prefix {
+		return nil, fmt.Errorf("bad budget prefix %q, want an absolute path", b.Prefix)
+	}
+	if b.MaxNodes < 0 || b.MaxBytes < 0 {
+		return nil, fmt.Errorf("budget limits cannot be negative")
+	}
+	budgets, stat, err := c.readBudgets(q)
+	if err != nil {
+		return nil, err
+	}
+	kept := budgets[:0]
+	for _, old := range budgets {
+		if old.Prefix != b.Prefix {
+			kept = append(kept, old)
+		}
+	}
+	if b.MaxNodes > 0 || b.MaxBytes > 0 {
+		kept = append(kept, b)
+	} else if len(kept) == len(budgets) {
+		c.logger().Debug("No budget to remove", "prefix", b.Prefix)
+		return nil, nil
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].Prefix < kept[j].Prefix })
+	if kept == nil {
+		kept = []Budget{}
+	}
+	data, err := json.MarshalIndent(kept, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	var p Plan
+	if stat == nil {
+		if p, err = c.planRemotePath(path.Dir(q.node())); err != nil {
+			return nil, err
+		}
+	}
+	writePlan, err := c.planWrite("", q.node(), append(data, '\n'), stat, nil, Options{})
+	if err != nil {
+		return nil, err
+	}
+	return append(p, writePlan...), nil
+}
+
+// QuotaUsage counts what the tree of every budget in q holds.
+func (c *Client) QuotaUsage(ctx context.Context, q *Quotas) ([]QuotaUsage, error) {
+	budgets, _, err := c.readBudgets(q)
+	if err != nil {
+		return nil, err
+	}
+	usage := make([]QuotaUsage, len(budgets))
+	for i, b := range budgets {
+		usage[i].Budget = b
+		if usage[i].Nodes, usage[i].Bytes, err = c.countTree(ctx, b.Prefix); err != nil {
+			return nil, err
+		}
+	}
+	return usage, nil
+}
+
+// countTree counts the nodes, and the bytes of data they hold as stored,
+// of the tree at p, nothing if it is not there.
+func (c *Client) countTree(ctx context.Context, p string) (int64, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+	children, stat, err := c.Backend.List(p)
+	if err == ErrNoNode {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, fmt.Errorf("listing %s: %w", p, err)
+	}
+	nodes, bytes := int64(1), int64(stat.DataLength)
+	for _, child := range children {
+		n, b, err := c.countTree(ctx, path.Join(p, child))
+		if err != nil {
+			return 0, 0, err
+		}
+		nodes, bytes = nodes+n, bytes+b
+	}
+	return nodes, bytes, nil
+}
+
+// checkQuotas fails with a QuotaError if p would take the tree of any
+// budget in q further over it, counting what the tree holds now and what
+// p changes in it.
+func (c *Client) checkQuotas(ctx context.Context, p Plan, q *Quotas) error {
+	budgets, _, err := c.readBudgets(q)
+	if err != nil || len(budgets) == 0 {
+		return err
+	}
+	var over []QuotaUsage
+	for _, b := range budgets {
+		var nodes, bytes int64
+		for _, o := range p {
+			if !o.Kind.remote() || (o.Target != b.Prefix && !strings.HasPrefix(o.Target, strings.TrimSuffix(b.Prefix, "/")+"/")) {
+				continue
+			}
+			switch o.Kind {
+			case OpCreate:
+				nodes, bytes = nodes+1, bytes+int64(len(o.Data))
+			case OpSet:
+				bytes += int64(len(o.Data) - o.OldSize)
+			case OpDelete:
+				nodes, bytes = nodes-1, bytes-int64(o.OldSize)
+			}
+		}
+		if (nodes <= 0 || b.MaxNodes == 0) && (bytes <= 0 || b.MaxBytes == 0) {
+			// nothing that could go over
+			continue
+		}
+		u := QuotaUsage{Budget: b}
+		if u.Nodes, u.Bytes, err = c.countTree(ctx, b.Prefix); err != nil {
+			return err
+		}
+		u.Nodes, u.Bytes = u.Nodes+nodes, u.Bytes+bytes
+		if (nodes > 0 && b.MaxNodes > 0 && u.Nodes > b.MaxNodes) || (bytes > 0 && b.MaxBytes > 0 && u.Bytes > b.MaxBytes) {
+			over = append(over, u)
+		}
+	}
+	if len(over) > 0 {
+		return &QuotaError{Over: over}
+	}
+	return nil
+}
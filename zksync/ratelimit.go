@@ -0,0 +1,62 @@
+package zksync
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit throttles ZooKeeper operations, so that bulk changes leave the
+// ensemble room for its other clients.
+type RateLimit struct {
+	// OpsPerSec caps the operations sent, a transaction counting one per
+	// node it touches. Zero is no limit.
+	OpsPerSec float64
+	// BytesPerSec caps the node data read and written. Zero is no limit.
+	BytesPerSec int
+}
+
+// limiter enforces a RateLimit. A nil limiter does not limit anything.
+type limiter struct {
+	ops   *rate.Limiter
+	bytes *rate.Limiter
+}
+
+func newLimiter(l RateLimit) *limiter {
+	if l.OpsPerSec <= 0 && l.BytesPerSec <= 0 {
+		return nil
+	}
+	lim := &limiter{}
+	if l.OpsPerSec > 0 {
+		lim.ops = rate.NewLimiter(rate.Limit(l.OpsPerSec), 1)
+	}
+	if l.BytesPerSec > 0 {
+		lim.bytes = rate.NewLimiter(rate.Limit(l.BytesPerSec), l.BytesPerSec)
+	}
+	return lim
+}
+
+// wait blocks until ops operations moving n bytes are allowed.
+func (l *limiter) wait(ops, n int) {
+	if l == nil {
+		return
+	}
+	waitN(l.ops, ops)
+	waitN(l.bytes, n)
+}
+
+// waitN takes n tokens from l, a burst at a time.
+func waitN(l *rate.Limiter, n int) {
+	if l == nil {
+		return
+	}
+	for n > 0 {
+		take := n
+		if take > l.Burst() {
+			take = l.Burst()
+		}
+		// the background context never ends, so the wait never fails
+		l.WaitN(context.Background(), take)
+		n -= take
+	}
+}
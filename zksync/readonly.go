@@ -0,0 +1,91 @@
+package zksync
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// readOnly is implemented by the backends ReadOnly returns.
+type readOnly interface {
+	readOnly()
+}
+
+// ReadOnly wraps b so that every change to it fails with ErrReadOnly,
+// whatever asks for it, as a safety net for looking into trees that must
+// not be touched. It reads the stats and ACLs b can, but takes no locks,
+// locks being nodes of their own, and has no transactions.
+func ReadOnly(b Backend) Backend {
+	if _, ok := b.(readOnly); ok {
+		return b
+	}
+	ro := readOnlyBackend{Backend: b}
+	ab, hasACLs := b.(ACLBackend)
+	st, hasStats := b.(Stater)
+	if hasACLs && hasStats {
+		return &readOnlyACLBackend{readOnlyBackend: ro, acls: ab, Stater: st}
+	}
+	return &ro
+}
+
+type readOnlyBackend struct {
+	Backend
+}
+
+func (readOnlyBackend) readOnly() {}
+
+func (readOnlyBackend) Create(p string, data []byte) error {
+	return fmt.Errorf("creating %s: %w", p, ErrReadOnly)
+}
+
+func (readOnlyBackend) Set(p string, data []byte, version int64) error {
+	return fmt.Errorf("setting %s: %w", p, ErrReadOnly)
+}
+
+func (readOnlyBackend) Delete(p string, version int64) error {
+	return fmt.Errorf("deleting %s: %w", p, ErrReadOnly)
+}
+
+// readOnlyACLBackend is readOnlyBackend for backends with ACLs, all of
+// which can read stats alone.
+type readOnlyACLBackend struct {
+	readOnlyBackend
+	Stater
+	acls ACLBackend
+}
+
+func (b *readOnlyACLBackend) GetACL(p string) ([]ACL, error) {
+	return b.acls.GetACL(p)
+}
+
+func (readOnlyACLBackend) CreateWithACL(p string, data []byte, acl []ACL) error {
+	return fmt.Errorf("creating %s: %w", p, ErrReadOnly)
+}
+
+func (readOnlyACLBackend) SetACL(p string, acl []ACL) error {
+	return fmt.Errorf("setting the ACL of %s: %w", p, ErrReadOnly)
+}
+
+// readOnlyConn asks for a read-only ZooKeeper session, which the client
+// library has no option for, by setting the flag ending the connect
+// request, the first packet written on every new connection. The library
+// reads the connect response as ever, ignoring the flag ending it.
+type readOnlyConn struct {
+	net.Conn
+	sent bool
+}
+
+func (c *readOnlyConn) Write(b []byte) (int, error) {
+	if c.sent || len(b) < 4 {
+		return c.Conn.Write(b)
+	}
+	c.sent = true
+	req := make([]byte, len(b)+1)
+	copy(req, b)
+	req[len(b)] = 1
+	binary.BigEndian.PutUint32(req, binary.BigEndian.Uint32(b)+1)
+	if _, err := c.Conn.Write(req); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,111 @@
+package zksync
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// RedactFile lists, at the root of a local tree downloaded with
+// Options.Redact, the patterns whose files were written with a placeholder
+// instead of their data. Uploads skip the files they match, so that the
+// placeholders never replace the secrets they stand in for.
+const RedactFile = ".zkredacted"
+
+// DefaultPlaceholder is what redacted files hold unless told otherwise.
+const DefaultPlaceholder = "REDACTED"
+
+// Redaction keeps the data of some files, such as credentials, out of
+// downloads.
+type Redaction struct {
+	// Skip leaves the files out altogether instead of writing them with
+	// the placeholder.
+	Skip        bool
+	Placeholder []byte
+	globs       []string
+	patterns    []pattern
+}
+
+// NewRedaction compiles the patterns of the files to redact, matched like
+// Filter patterns, a pattern for a dir redacting everything below it.
+func NewRedaction(patterns []string, placeholder string, skip bool) (*Redaction, error) {
+	compiled, err := compilePatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return &Redaction{Skip: skip, Placeholder: []byte(placeholder), globs: patterns, patterns: compiled}, nil
+}
+
+// Redacted reports whether the data of the file at rel is kept out. The
+// tree root is never redacted.
+func (r *Redaction) Redacted(rel string) bool {
+	return r != nil && rel != "" && matchAny(r.patterns, rel)
+}
+
+// readRedactFile returns the patterns listed in the RedactFile of the tree
+// at root.
+func readRedactFile(root string) ([]string, error) {
+	f, err := os.Open(filepath.Join(root, RedactFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var listed []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && line[0] != '#' {
+			listed = append(listed, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", RedactFile, err)
+	}
+	return listed, nil
+}
+
+// planRedactFile plans adding the patterns of opts.Redact to those the
+// RedactFile of the tree at absLocal lists. Patterns stay listed as the
+// placeholders they wrote stay on disk until overwritten by hand.
+func (c *Client) planRedactFile(remotePath, absLocal string, opts Options) (Plan, error) {
+	if fInfo, err := os.Stat(absLocal); err == nil && !fInfo.IsDir() {
+		// a single file was downloaded, there is no tree to keep it in
+		return nil, nil
+	}
+	listed, err := readRedactFile(absLocal)
+	if err != nil {
+		return nil, err
+	}
+	patterns := make(map[string]bool)
+	for _, p := range append(listed, opts.Redact.globs...) {
+		patterns[p] = true
+	}
+	sorted := make([]string, 0, len(patterns))
+	for p := range patterns {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+	var b strings.Builder
+	b.WriteString("# Files downloaded with their data redacted, which uploads skip\n")
+	for _, p := range sorted {
+		b.WriteString(p + "\n")
+	}
+	data := []byte(b.String())
+
+	file := filepath.Join(absLocal, RedactFile)
+	old, err := ioutil.ReadFile(file)
+	switch {
+	case os.IsNotExist(err):
+		return Plan{{Kind: OpWrite, Source: remotePath, Target: file, Data: data}}, nil
+	case err != nil:
+		return nil, err
+	case string(old) == string(data):
+		return nil, nil
+	}
+	return Plan{{Kind: OpOverwrite, Source: remotePath, Target: file, Data: data, OldSize: len(old)}}, nil
+}
@@ -0,0 +1,132 @@
+package zksync
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+)
+
+const (
+	// ReleasesNode holds one tree per release under the prefix.
+	ReleasesNode = "releases"
+	// CurrentNode holds the path of the release in use.
+	CurrentNode = "current"
+
+	// releaseLayout names releases so that they sort by age
+	releaseLayout = "20060102T150405Z"
+)
+
+// Release uploads the tree at localPath as a new release under
+// remotePath/releases, named after the time, and once all of it is there
+// points remotePath/current at it. Readers following current never see a
+// half uploaded tree. current is left alone if anything fails.
+func (c *Client) Release(ctx context.Context, localPath, remotePath string, opts Options) (*Result, error) {
+	if _, ok := c.Backend.(ACLBackend); (opts.ACLs != nil || opts.ModeACLs) && !ok {
+		return nil, ErrUnsupported
+	}
+	releasePath := path.Join(remotePath, ReleasesNode, time.Now().UTC().Format(releaseLayout))
+	if _, _, err := c.Backend.Get(releasePath); err == nil {
+		return nil, fmt.Errorf("release %s already exists", releasePath)
+	} else if err != ErrNoNode {
+		return nil, fmt.Errorf("checking %s: %w", releasePath, err)
+	}
+
+	// the release is new, there is nothing to compare with
+	opts.Clean = true
+	p, err := c.planUpload(ctx, releasePath, localPath, "", opts)
+	if err != nil {
+		return nil, err
+	}
+	flip, err := c.planFlip(remotePath, releasePath)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.run(ctx, p, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.DryRun && len(res.Failed) > 0 {
+		return res, nil
+	}
+	res.Plan = append(res.Plan, flip...)
+	if !opts.DryRun {
+		res.Failed = c.apply(ctx, flip, opts)
+	}
+	return res, nil
+}
+
+// Releases returns the paths of the releases under remotePath, oldest
+// first, and the one current points at.
+func (c *Client) Releases(remotePath string) ([]string, string, error) {
+	names, _, err := c.Backend.List(path.Join(remotePath, ReleasesNode))
+	if err != nil && err != ErrNoNode {
+		return nil, "", fmt.Errorf("listing releases: %w", err)
+	}
+	sort.Strings(names)
+	releases := make([]string, len(names))
+	for i, name := range names {
+		releases[i] = path.Join(remotePath, ReleasesNode, name)
+	}
+
+	current, _, err := c.Backend.Get(path.Join(remotePath, CurrentNode))
+	if err != nil && err != ErrNoNode {
+		return nil, "", fmt.Errorf("reading current release: %w", err)
+	}
+	return releases, string(current), nil
+}
+
+// Rollback points remotePath/current at the release before the current
+// one, or at the release named to if it is not empty.
+func (c *Client) Rollback(ctx context.Context, remotePath, to string, opts Options) (*Result, error) {
+	releases, current, err := c.Releases(remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var target string
+	if to != "" {
+		want := path.Join(remotePath, ReleasesNode, to)
+		for _, r := range releases {
+			if r == want {
+				target = r
+			}
+		}
+		if target == "" {
+			return nil, fmt.Errorf("no release %s", want)
+		}
+	} else {
+		for i, r := range releases {
+			if r == current && i > 0 {
+				target = releases[i-1]
+			}
+		}
+		if target == "" {
+			return nil, fmt.Errorf("no release before %q", current)
+		}
+	}
+
+	p, err := c.planFlip(remotePath, target)
+	if err != nil {
+		return nil, err
+	}
+	return c.run(ctx, p, opts)
+}
+
+// planFlip plans pointing remotePath/current at releasePath, in a single
+// write.
+func (c *Client) planFlip(remotePath, releasePath string) (Plan, error) {
+	currentPath := path.Join(remotePath, CurrentNode)
+	data, stat, err := c.Backend.Get(currentPath)
+	if err == ErrNoNode {
+		return Plan{{Kind: OpCreate, Source: releasePath, Target: currentPath, Data: []byte(releasePath)}}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", currentPath, err)
+	}
+	if string(data) == releasePath {
+		return nil, nil
+	}
+	return Plan{{Kind: OpSet, Source: releasePath, Target: currentPath, Data: []byte(releasePath), OldSize: stat.DataLength, Version: stat.Version}}, nil
+}
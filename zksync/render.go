@@ -0,0 +1,429 @@
+package zksync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// RenderTarget is a file Render writes from a Go template, for programs
+// that only read their config from files of their own format.
+type RenderTarget struct {
+	Template string
+	Dest     string
+	// Mode is given to Dest, 0644 if zero.
+	Mode os.FileMode
+	// Keys, if any, are the only subtrees the template reads, by their
+	// paths relative to the tree, rather than all of it.
+	Keys []string
+	// Check is a shell command run on the output before it replaces
+	// Dest, {{.src}} standing for the file holding it. Failing, it leaves
+	// Dest as it was.
+	Check string
+	// Reload is a shell command run after Dest is written.
+	Reload string
+	// Confd has the template use the functions of confd instead, for
+	// templates written for it.
+	Confd bool
+}
+
+// ParseRenderTarget reads a target written as template:dest.
+func ParseRenderTarget(s string) (RenderTarget, error) {
+	i := strings.LastIndex(s, ":")
+	if i <= 0 || i == len(s)-1 {
+		return RenderTarget{}, fmt.Errorf("bad render target %q, want template:dest", s)
+	}
+	return RenderTarget{Template: s[:i], Dest: s[i+1:]}, nil
+}
+
+// KeyValue is a file of the tree being rendered, as gets returns them.
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+// renderTree is the tree being rendered, by paths relative to its root
+// starting with "/".
+type renderTree struct {
+	nodes    map[string]bool
+	values   map[string]string // of the files
+	children map[string][]string
+}
+
+// Render reads the tree at remotePath and renders every target with it,
+// writing those whose output changed. Templates reach the tree through
+// functions taking paths relative to remotePath:
+//
+//	get "/db/host"            the file's data, an error if there is none
+//	getv "/db/port" "5432"    the same, or the default given if missing
+//	exists "/db"              whether there is such a node
+//	gets "/backends/*"        the files matching a pattern, as .Key and .Value
+//	getvs "/backends/*"       the data of those files
+//	ls "/backends"            the names of the children of a node
+//	lsdir "/services"         the names of the children having children
+//
+// and json, split, join, base, dir, upper, lower, trim, env and now
+// helpers. Ephemeral nodes are read too, so that servers registering
+// themselves show up in the files of whatever balances across them.
+func (c *Client) Render(ctx context.Context, remotePath string, targets []RenderTarget, opts Options) (*Result, error) {
+	p, err := c.planRender(ctx, remotePath, targets, opts)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.run(ctx, p, opts)
+	if err == nil && !opts.DryRun {
+		c.reloadRendered(ctx, targets, appliedOps(res))
+	}
+	return res, err
+}
+
+// RenderWatch renders the targets, then renders them again every time
+// something under remotePath changes, until ctx is done. A template
+// failing to render leaves its file as it was.
+func (c *Client) RenderWatch(ctx context.Context, remotePath string, targets []RenderTarget, opts Options) error {
+	events, err := c.Backend.Watch(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	if !opts.DryRun {
+		onApplied := opts.OnApplied
+		opts.OnApplied = func(applied Plan, errs []error, took time.Duration) {
+			c.reloadRendered(ctx, targets, applied)
+			if onApplied != nil {
+				onApplied(applied, errs, took)
+			}
+		}
+	}
+	p, err := c.planRender(ctx, remotePath, targets, opts)
+	if err != nil {
+		return err
+	}
+	c.applyWatched(ctx, p, opts)
+	c.logger().Info("Watching for changes", "path", remotePath)
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.logger().Info("Stopped watching")
+			return nil
+		case <-opts.Resync:
+			c.logger().Info("Resyncing", "path", remotePath)
+			p, err := c.planRender(ctx, remotePath, targets, opts)
+			if err != nil {
+				c.logger().Warn("Could not resync", "path", remotePath, "err", err)
+				reportWatched(opts, nil, []error{err}, 0)
+				continue
+			}
+			c.applyWatched(ctx, p, opts)
+		case ev, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					c.logger().Info("Stopped watching")
+					return nil
+				}
+				return ErrNoSession
+			}
+			if ev.Err != nil {
+				return ev.Err
+			}
+			// changes come in bursts, one render is enough for all of them
+			for drained := false; !drained; {
+				select {
+				case more, ok := <-events:
+					if !ok {
+						drained = true
+					} else if more.Err != nil {
+						return more.Err
+					}
+				default:
+					drained = true
+				}
+			}
+
+			p, err := c.planRender(ctx, remotePath, targets, opts)
+			if err != nil {
+				c.logger().Warn("Could not render", "path", ev.Path, "err", err)
+				reportWatched(opts, nil, []error{err}, 0)
+				continue
+			}
+			c.applyWatched(ctx, p, opts)
+		}
+	}
+}
+
+func (c *Client) planRender(ctx context.Context, remotePath string, targets []RenderTarget, opts Options) (Plan, error) {
+	var whole *renderTree
+	var p Plan
+	for _, t := range targets {
+		tree := newRenderTree()
+		var err error
+		switch {
+		case len(t.Keys) > 0:
+			for _, key := range t.Keys {
+				key = path.Join("/", key)
+				if err = c.readRenderTree(ctx, path.Join(remotePath, key), key, opts, tree); err != nil {
+					return nil, err
+				}
+			}
+		case whole == nil:
+			if err = c.readRenderTree(ctx, remotePath, "/", opts, tree); err != nil {
+				return nil, err
+			}
+			whole = tree
+		default:
+			tree = whole
+		}
+
+		src, err := ioutil.ReadFile(t.Template)
+		if err != nil {
+			return nil, err
+		}
+		funcs := tree.funcs()
+		if t.Confd {
+			funcs = tree.confdFuncs()
+		}
+		tmpl, err := template.New(path.Base(t.Template)).Option("missingkey=error").Funcs(funcs).Parse(string(src))
+		if err != nil {
+			return nil, fmt.Errorf("parsing template %s: %w", t.Template, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, nil); err != nil {
+			return nil, fmt.Errorf("rendering %s: %w", t.Template, err)
+		}
+		data := buf.Bytes()
+
+		old, err := ioutil.ReadFile(t.Dest)
+		if err == nil && bytes.Equal(old, data) {
+			c.logger().Debug("Files are the same", "path", t.Dest)
+			continue
+		}
+		if t.Check != "" {
+			if err := checkRendered(ctx, t, data); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case os.IsNotExist(err):
+			p = append(p, Op{Kind: OpWrite, Source: remotePath, Target: t.Dest, Data: data, Mode: t.Mode})
+		case err != nil:
+			return nil, err
+		default:
+			p = append(p, Op{Kind: OpOverwrite, Source: remotePath, Target: t.Dest, Data: data, Mode: t.Mode, OldSize: len(old)})
+		}
+	}
+	return p, nil
+}
+
+// checkRendered runs the Check command of t on data, staged next to
+// t.Dest.
+func checkRendered(ctx context.Context, t RenderTarget, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(t.Dest), "."+filepath.Base(t.Dest))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	command := strings.ReplaceAll(t.Check, "{{.src}}", f.Name())
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return fmt.Errorf("checking %s: %s: %w", t.Dest, command, err)
+	}
+	return nil
+}
+
+// reloadRendered runs the Reload commands of the targets whose files were
+// written by applied, logging those failing.
+func (c *Client) reloadRendered(ctx context.Context, targets []RenderTarget, applied Plan) {
+	written := make(map[string]bool)
+	for _, o := range applied {
+		if o.Kind == OpWrite || o.Kind == OpOverwrite {
+			written[o.Target] = true
+		}
+	}
+	for _, t := range targets {
+		if t.Reload == "" || !written[t.Dest] {
+			continue
+		}
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", t.Reload)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			c.logger().Error("Reload command failed", "path", t.Dest, "command", t.Reload, "err", err)
+		} else {
+			c.logger().Info("Ran reload command", "path", t.Dest, "command", t.Reload)
+		}
+	}
+}
+
+// appliedOps returns the ops of res that did not fail.
+func appliedOps(res *Result) Plan {
+	if res == nil {
+		return nil
+	}
+	failed := make(map[string]bool)
+	for _, err := range res.Failed {
+		var opErr *OpError
+		if errors.As(err, &opErr) {
+			failed[opKey(opErr.Op.Kind, opErr.Op.Target)] = true
+		}
+	}
+	var applied Plan
+	for _, o := range res.Plan {
+		if !failed[opKey(o.Kind, o.Target)] {
+			applied = append(applied, o)
+		}
+	}
+	return applied
+}
+
+func newRenderTree() *renderTree {
+	return &renderTree{nodes: make(map[string]bool), values: make(map[string]string), children: make(map[string][]string)}
+}
+
+// readRenderTree adds the node at serverPrefix and those below it to tree,
+// leaving out whatever opts.Filter excludes.
+func (c *Client) readRenderTree(ctx context.Context, serverPrefix, key string, opts Options, tree *renderTree) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if opts.Filter.Excluded(strings.TrimPrefix(key, "/")) {
+		return nil
+	}
+	data, stat, err := c.getFile(serverPrefix)
+	if err == ErrNoNode && key != "/" {
+		// deleted since it was listed
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("reading %s: %w", serverPrefix, err)
+	}
+	tree.nodes[key] = true
+	if !stat.IsDir() {
+		if opts.Vault.onDownload() {
+			if data, err = opts.Vault.resolve(serverPrefix, data); err != nil {
+				return err
+			}
+		}
+		tree.values[key] = string(data)
+	}
+	if stat.NumChildren == 0 {
+		return nil
+	}
+
+	children, _, err := c.Backend.List(serverPrefix)
+	if err != nil {
+		return fmt.Errorf("listing %s: %w", serverPrefix, err)
+	}
+	sort.Strings(children)
+	for _, child := range children {
+		if isChunk(child) {
+			continue
+		}
+		childKey := path.Join(key, child)
+		if err := c.readRenderTree(ctx, path.Join(serverPrefix, child), childKey, opts, tree); err != nil {
+			return err
+		}
+		if tree.nodes[childKey] {
+			tree.children[key] = append(tree.children[key], child)
+		}
+	}
+	return nil
+}
+
+func (t *renderTree) funcs() template.FuncMap {
+	return template.FuncMap{
+		"get": func(key string) (string, error) {
+			v, ok := t.values[path.Join("/", key)]
+			if !ok {
+				return "", fmt.Errorf("no file %s", key)
+			}
+			return v, nil
+		},
+		"getv": func(key string, def ...string) (string, error) {
+			if v, ok := t.values[path.Join("/", key)]; ok {
+				return v, nil
+			}
+			if len(def) > 0 {
+				return def[0], nil
+			}
+			return "", fmt.Errorf("no file %s", key)
+		},
+		"exists": func(key string) bool {
+			return t.nodes[path.Join("/", key)]
+		},
+		"gets": t.gets,
+		"getvs": func(pattern string) ([]string, error) {
+			kvs, err := t.gets(pattern)
+			values := make([]string, len(kvs))
+			for i, kv := range kvs {
+				values[i] = kv.Value
+			}
+			return values, err
+		},
+		"ls": func(key string) []string {
+			return t.children[path.Join("/", key)]
+		},
+		"lsdir": func(key string) []string {
+			key = path.Join("/", key)
+			var dirs []string
+			for _, child := range t.children[key] {
+				if len(t.children[path.Join(key, child)]) > 0 {
+					dirs = append(dirs, child)
+				}
+			}
+			return dirs
+		},
+		"json": func(s string) (interface{}, error) {
+			var v interface{}
+			err := json.Unmarshal([]byte(s), &v)
+			return v, err
+		},
+		"split": strings.Split,
+		"join":  strings.Join,
+		"base":  path.Base,
+		"dir":   path.Dir,
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+		"trim":  strings.TrimSpace,
+		"env":   os.Getenv,
+		"now":   time.Now,
+	}
+}
+
+// gets returns the files whose keys match pattern, as path.Match reads it,
+// sorted by key.
+func (t *renderTree) gets(pattern string) ([]KeyValue, error) {
+	pattern = path.Join("/", pattern)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("bad pattern %q: %w", pattern, err)
+	}
+	var kvs []KeyValue
+	for key, v := range t.values {
+		if ok, _ := path.Match(pattern, key); ok {
+			kvs = append(kvs, KeyValue{Key: key, Value: v})
+		}
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	return kvs, nil
+}
@@ -0,0 +1,298 @@
+package zksync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Replicate copies the tree at srcPath on src to dstPath on c, overwriting
+// files that differ. With opts.Prune, nodes gone from srcPath are deleted
+// once everything else is copied.
+func (c *Client) Replicate(ctx context.Context, src *Client, srcPath, dstPath string, opts Options) (*Result, error) {
+	if _, ok := c.Backend.(ACLBackend); opts.ACLs != nil && !ok {
+		return nil, ErrUnsupported
+	}
+	p, err := c.planReplica(ctx, src, srcPath, dstPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.run(ctx, p, opts)
+}
+
+// planReplica plans making dstPath, and any parents it is missing, a copy
+// of srcPath on src.
+func (c *Client) planReplica(ctx context.Context, src *Client, srcPath, dstPath string, opts Options) (Plan, error) {
+	p, err := c.planRemotePath(path.Dir(dstPath))
+	if err != nil {
+		return nil, err
+	}
+	for i := range p {
+		p[i].ACL = opts.ACLs.For("")
+	}
+	replicaPlan, err := c.planReplicate(ctx, src, srcPath, dstPath, "", len(p) > 0, opts)
+	if err != nil {
+		return nil, err
+	}
+	p = append(p, replicaPlan...)
+	if opts.Prune {
+		if err := checkPrunable(dstPath, opts); err != nil {
+			return nil, err
+		}
+		prunePlan, err := c.planPruneReplica(ctx, src, srcPath, dstPath, "", opts)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, prunePlan...)
+	}
+	return p, nil
+}
+
+// planReplicate plans copying srcPath on src to dstPath. With created set
+// the parent of dstPath is yet to be made, so nothing below it is looked up.
+func (c *Client) planReplicate(ctx context.Context, src *Client, srcPath, dstPath, rel string, created bool, opts Options) (Plan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts.Filter.Excluded(rel) {
+		return nil, nil
+	}
+
+	data, stat, err := src.getFile(srcPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", srcPath, err)
+	}
+	dir := stat.IsDir()
+	if stat.Ephemeral {
+		// it could only be copied as a persistent node
+		c.logger().Debug("Skipping ephemeral node", "path", srcPath)
+		return nil, nil
+	}
+	if !dir && !opts.Filter.Included(rel) {
+		return nil, nil
+	}
+
+	var dstData []byte
+	var dstStat *Stat
+	if !created {
+		dstData, dstStat, err = c.getFile(dstPath)
+		if err == ErrNoNode {
+			dstStat = nil
+		} else if err != nil {
+			return nil, fmt.Errorf("checking %s: %w", dstPath, err)
+		}
+	}
+
+	var p Plan
+	switch {
+	case dstStat == nil && dir:
+		p = append(p, Op{Kind: OpCreate, Source: srcPath, Target: dstPath, Dir: true, Data: []byte{}, ACL: opts.ACLs.For(rel)})
+		created = true
+	case dstStat == nil:
+		return c.planWrite(srcPath, dstPath, data, nil, opts.ACLs.For(rel), opts)
+	case dir && !dstStat.IsDir():
+		return nil, fmt.Errorf("destination path is a file when a dir is expected: %s", dstPath)
+	case dstStat.Ephemeral:
+		c.logger().Warn("Destination node is ephemeral, not overwriting", "path", dstPath)
+		return nil, nil
+	case dir:
+		c.logger().Debug("Dir already there", "path", dstPath)
+	case dstStat.IsDir() && dstStat.NumChildren > 0:
+		return nil, fmt.Errorf("destination path is a dir when a file is expected: %s", dstPath)
+	case !dstStat.IsDir() && bytes.Equal(data, dstData):
+		c.logger().Debug("Files are the same", "path", dstPath)
+		return nil, nil
+	default:
+		return c.planWrite(srcPath, dstPath, data, dstStat, nil, opts)
+	}
+
+	if stat.NumChildren == 0 {
+		return p, nil
+	}
+	children, _, err := src.Backend.List(srcPath)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", srcPath, err)
+	}
+	for _, child := range children {
+		if isChunk(child) {
+			continue
+		}
+		childPlan, err := c.planReplicate(ctx, src, path.Join(srcPath, child), path.Join(dstPath, child), path.Join(rel, child), created, opts)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, childPlan...)
+	}
+	return p, nil
+}
+
+// planPruneReplica plans deleting the nodes under dstPath that have no
+// counterpart under srcPath on src.
+func (c *Client) planPruneReplica(ctx context.Context, src *Client, srcPath, dstPath, rel string, opts Options) (Plan, error) {
+	children, _, err := c.Backend.List(dstPath)
+	if err == ErrNoNode {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", dstPath, err)
+	}
+
+	var p Plan
+	for _, child := range children {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		childRel := path.Join(rel, child)
+		childDst := path.Join(dstPath, child)
+		if isChunk(child) || opts.Filter.Excluded(childRel) || opts.Trash.holds(childDst) || opts.History.holds(childDst) {
+			continue
+		}
+		childSrc := path.Join(srcPath, child)
+		_, dstStat, err := c.Backend.Get(childDst)
+		if err == ErrNoNode {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("checking %s: %w", childDst, err)
+		}
+		if dstStat.Ephemeral {
+			continue
+		}
+		dir := dstStat.DataLength == 0
+
+		_, _, err = src.Backend.Get(childSrc)
+		switch {
+		case err == ErrNoNode:
+			if !dir && !opts.Filter.Included(childRel) {
+				continue
+			}
+			deletePlan, err := c.planDelete(ctx, childDst)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, deletePlan...)
+		case err != nil:
+			return nil, fmt.Errorf("checking %s: %w", childSrc, err)
+		case dir:
+			childPlan, err := c.planPruneReplica(ctx, src, childSrc, childDst, childRel, opts)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, childPlan...)
+		}
+	}
+	return p, nil
+}
+
+// ReplicateWatch keeps dstPath on c a copy of srcPath on src until ctx is
+// done, copying the whole tree first. As with Watch, the watch is set up
+// before the initial copy so that nothing changing in between is missed.
+func (c *Client) ReplicateWatch(ctx context.Context, src *Client, srcPath, dstPath string, opts Options) error {
+	events, err := src.Backend.Watch(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+
+	p, err := c.planReplica(ctx, src, srcPath, dstPath, opts)
+	if err != nil {
+		return err
+	}
+	c.applyWatched(ctx, p, opts)
+	c.logger().Info("Replicating changes", "path", srcPath)
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.logger().Info("Stopped replicating")
+			return nil
+		case <-opts.Resync:
+			c.logger().Info("Resyncing", "path", srcPath)
+			p, err := c.planReplica(ctx, src, srcPath, dstPath, opts)
+			if err != nil {
+				c.logger().Warn("Could not resync", "path", srcPath, "err", err)
+				reportWatched(opts, nil, []error{err}, 0)
+				continue
+			}
+			c.applyWatched(ctx, p, opts)
+		case ev, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					c.logger().Info("Stopped replicating")
+					return nil
+				}
+				return ErrNoSession
+			}
+			if ev.Err != nil {
+				return ev.Err
+			}
+
+			p, err := c.planReplicaEvent(ctx, src, ev, srcPath, dstPath, opts)
+			if err != nil {
+				c.logger().Warn("Could not replicate", "path", ev.Path, "err", err)
+				reportWatched(opts, nil, []error{err}, 0)
+				continue
+			}
+			c.applyWatched(ctx, p, opts)
+		}
+	}
+}
+
+func (c *Client) planReplicaEvent(ctx context.Context, src *Client, ev Event, srcPrefix, dstPrefix string, opts Options) (Plan, error) {
+	if isChunk(path.Base(ev.Path)) {
+		// a piece of a large file, which is copied whole once the last
+		// piece is in
+		ev = Event{Type: EventChanged, Path: path.Dir(ev.Path)}
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(ev.Path, srcPrefix), "/")
+	dstPath := path.Join(dstPrefix, rel)
+	if opts.Filter.Excluded(rel) {
+		return nil, nil
+	}
+
+	switch ev.Type {
+	case EventDeleted:
+		_, stat, err := c.Backend.Get(dstPath)
+		if err == ErrNoNode || (err == nil && (stat.Ephemeral || (stat.DataLength != 0 && !opts.Filter.Included(rel)))) {
+			return nil, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("checking %s: %w", dstPath, err)
+		}
+		return c.planDelete(ctx, dstPath)
+	case EventCreated:
+		// backends without real dirs can report a node before its parents
+		p, err := c.planRemotePath(path.Dir(dstPath))
+		if err != nil {
+			return nil, err
+		}
+		for i := range p {
+			p[i].ACL = opts.ACLs.For(path.Dir(rel))
+		}
+		replicaPlan, err := c.planReplicate(ctx, src, ev.Path, dstPath, rel, len(p) > 0, opts)
+		if errors.Is(err, ErrNoNode) || errors.Is(err, errPartialChunks) {
+			// gone again, or the rest of the chunks are still to come
+			return nil, nil
+		}
+		return append(p, replicaPlan...), err
+	}
+
+	// only the node itself changed, what is below it has events of its own
+	data, stat, err := src.getFile(ev.Path)
+	if err == ErrNoNode || errors.Is(err, errPartialChunks) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if stat.IsDir() || stat.Ephemeral || !opts.Filter.Included(rel) {
+		return nil, nil
+	}
+	dstData, dstStat, err := c.getFile(dstPath)
+	if err == ErrNoNode {
+		return c.planWrite(ev.Path, dstPath, data, nil, opts.ACLs.For(rel), opts)
+	} else if err != nil {
+		return nil, fmt.Errorf("checking %s: %w", dstPath, err)
+	} else if dstStat.Ephemeral || (!dstStat.IsDir() && bytes.Equal(data, dstData)) {
+		return nil, nil
+	}
+	return c.planWrite(ev.Path, dstPath, data, dstStat, nil, opts)
+}
@@ -0,0 +1,89 @@
+package zksync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Outcomes of an op in a Report.
+const (
+	OutcomePlanned    = "planned"     // a dry run, nothing was done
+	OutcomeApplied    = "applied"     // the change was made
+	OutcomeFailed     = "failed"      // the change was tried and failed
+	OutcomeNotApplied = "not-applied" // the run stopped before getting to it
+)
+
+// OpReport is what happened to one op, for machine readers.
+type OpReport struct {
+	Action string `json:"action" yaml:"action"`
+	Path   string `json:"path" yaml:"path"`
+	Source string `json:"source,omitempty" yaml:"source,omitempty"`
+	Bytes  int    `json:"bytes" yaml:"bytes"`
+	Result string `json:"result" yaml:"result"`
+	Error  string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Report is what a run did, for machine readers.
+type Report struct {
+	DryRun  bool `json:"dry_run" yaml:"dry_run"`
+	Applied int  `json:"applied" yaml:"applied"`
+	// Created, Updated and Deleted break Applied down.
+	Created    int        `json:"created" yaml:"created"`
+	Updated    int        `json:"updated" yaml:"updated"`
+	Deleted    int        `json:"deleted" yaml:"deleted"`
+	Failed     int        `json:"failed" yaml:"failed"`
+	NotApplied int        `json:"not_applied" yaml:"not_applied"`
+	Bytes      int        `json:"bytes" yaml:"bytes"`
+	Error      string     `json:"error,omitempty" yaml:"error,omitempty"`
+	Changes    []OpReport `json:"changes" yaml:"changes"`
+	// Conflicts are the paths a sync left alone as changed on both sides.
+	Conflicts []string `json:"conflicts,omitempty" yaml:"conflicts,omitempty"`
+	// Unreconciled are the paths a mirror could not make the same as the
+	// local tree.
+	Unreconciled []string `json:"unreconciled,omitempty" yaml:"unreconciled,omitempty"`
+}
+
+// Report lists every op of the result with its outcome.
+func (r *Result) Report(dryRun bool) Report {
+	failed := make(map[string]error, len(r.Failed))
+	for _, err := range r.Failed {
+		var opErr *OpError
+		if errors.As(err, &opErr) {
+			failed[reportKey(opErr.Op)] = opErr.Err
+		}
+	}
+
+	rep := Report{DryRun: dryRun, Changes: make([]OpReport, len(r.Plan)), Conflicts: r.Conflicts, Unreconciled: r.Unreconciled}
+	for i, o := range r.Plan {
+		or := OpReport{Action: o.Kind.String(), Path: o.Target, Source: o.Source, Bytes: len(o.Data), Result: OutcomeApplied}
+		err, ok := failed[reportKey(o)]
+		switch {
+		case dryRun:
+			or.Result = OutcomePlanned
+		case !ok:
+			rep.Applied++
+			rep.Bytes += or.Bytes
+			switch o.Kind {
+			case OpCreate, OpMkdir, OpWrite:
+				rep.Created++
+			case OpDelete, OpRemove:
+				rep.Deleted++
+			default:
+				rep.Updated++
+			}
+		case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+			or.Result = OutcomeNotApplied
+			rep.NotApplied++
+		default:
+			or.Result, or.Error = OutcomeFailed, err.Error()
+			rep.Failed++
+		}
+		rep.Changes[i] = or
+	}
+	return rep
+}
+
+func reportKey(o Op) string {
+	return fmt.Sprintf("%d:%s", o.Kind, o.Target)
+}
@@ -0,0 +1,46 @@
+package zksync
+
+import (
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy says how operations that fail because the connection or the
+// session was lost are tried again. Walks retry each node where it failed,
+// so a blip does not restart them from the top.
+type RetryPolicy struct {
+	// Attempts is how many times an operation is tried in all, just once if
+	// zero.
+	Attempts int
+	// Backoff is the wait before the first retry, doubled for every one
+	// after it, 100ms if zero. Waits are jittered by up to half.
+	Backoff time.Duration
+	// MaxBackoff caps the wait between attempts, 10s if zero.
+	MaxBackoff time.Duration
+}
+
+// do runs f until it succeeds, fails with an error transient does not
+// accept, or runs out of attempts. f is told whether an earlier attempt
+// failed, as that attempt may still have gone through.
+func (r RetryPolicy) do(op string, f func(retried bool) error, transient func(error) bool) error {
+	backoff, maxBackoff := r.Backoff, r.MaxBackoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = 10 * time.Second
+	}
+
+	err := f(false)
+	for attempt := 1; attempt < r.Attempts && err != nil && transient(err); attempt++ {
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		slog.Warn("Retrying", "op", op, "err", err, "attempt", attempt+1, "wait", wait)
+		time.Sleep(wait)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		err = f(true)
+	}
+	return err
+}
@@ -0,0 +1,233 @@
+package zksync
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+)
+
+// SASLConfig sets up Kerberos authentication with ZooKeeper, over SASL
+// GSSAPI. Credentials come from the keytab when there is one, and from the
+// ticket cache kinit left behind otherwise.
+type SASLConfig struct {
+	// Enabled turns SASL on. Setting a keytab turns it on too.
+	Enabled bool
+	// Principal is who to log in as with the keytab, user@REALM.
+	Principal string
+	Keytab    string
+	// CCache is the ticket cache, $KRB5CCNAME or /tmp/krb5cc_<uid> if
+	// empty.
+	CCache string
+	// KRB5Conf is the Kerberos configuration, $KRB5_CONFIG or
+	// /etc/krb5.conf if empty.
+	KRB5Conf string
+	// Service is the service part of the server principals, zookeeper if
+	// empty.
+	Service string
+}
+
+func (s SASLConfig) enabled() bool {
+	return s.Enabled || s.Keytab != ""
+}
+
+// login sets up a Kerberos client with a ticket granting ticket.
+func (s SASLConfig) login() (*client.Client, error) {
+	confPath := s.KRB5Conf
+	if confPath == "" {
+		confPath = os.Getenv("KRB5_CONFIG")
+	}
+	if confPath == "" {
+		confPath = "/etc/krb5.conf"
+	}
+	krb5conf, err := config.Load(confPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", confPath, err)
+	}
+
+	if s.Keytab != "" {
+		kt, err := keytab.Load(s.Keytab)
+		if err != nil {
+			return nil, fmt.Errorf("loading %s: %w", s.Keytab, err)
+		}
+		user, realm := s.Principal, krb5conf.LibDefaults.DefaultRealm
+		if i := strings.LastIndex(user, "@"); i >= 0 {
+			user, realm = user[:i], user[i+1:]
+		}
+		cl := client.NewWithKeytab(user, realm, kt, krb5conf, client.DisablePAFXFAST(true))
+		if err := cl.Login(); err != nil {
+			return nil, fmt.Errorf("%w: kerberos login: %v", ErrNoAuth, err)
+		}
+		return cl, nil
+	}
+
+	ccPath := s.CCache
+	if ccPath == "" {
+		ccPath = strings.TrimPrefix(os.Getenv("KRB5CCNAME"), "FILE:")
+	}
+	if ccPath == "" {
+		ccPath = fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+	}
+	ccache, err := credentials.LoadCCache(ccPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading ticket cache %s: %w", ccPath, err)
+	}
+	return client.NewFromCCache(ccache, krb5conf, client.DisablePAFXFAST(true))
+}
+
+const (
+	opSASL  = 102
+	saslXid = 1
+	// maxFrame is well over the largest packet ZooKeeper sends by default
+	maxFrame = 16 << 20
+)
+
+// saslConn authenticates a new ZooKeeper connection. SASL has to go right
+// after the session is set up and before any other request, which is
+// exactly when the client library reads the connect response, so the first
+// read holds the response back until the whole exchange is done.
+type saslConn struct {
+	net.Conn
+	krb     *client.Client
+	spn     string
+	onFail  func(error)
+	started bool
+	pending []byte
+}
+
+func (c *saslConn) Read(b []byte) (int, error) {
+	if !c.started {
+		c.started = true
+		frame, err := readFrame(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		// an expired session comes back with a zero timeout and no session
+		// to authenticate
+		if len(frame) >= 12 && binary.BigEndian.Uint32(frame[8:12]) > 0 {
+			if err := c.authenticate(); err != nil {
+				c.onFail(err)
+				return 0, err
+			}
+		}
+		c.pending = frame
+	}
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+// authenticate runs the GSSAPI mechanism of RFC 4752, settling for no
+// security layer since ZooKeeper only uses SASL to authenticate.
+func (c *saslConn) authenticate() error {
+	tkt, key, err := c.krb.GetServiceTicket(c.spn)
+	if err != nil {
+		return fmt.Errorf("%w: getting ticket for %s: %v", ErrNoAuth, c.spn, err)
+	}
+	apReq, err := spnego.NewKRB5TokenAPREQ(c.krb, tkt, key, []int{gssapi.ContextFlagInteg, gssapi.ContextFlagConf}, nil)
+	if err != nil {
+		return err
+	}
+	token, err := apReq.Marshal()
+	if err != nil {
+		return err
+	}
+
+	reply, err := c.exchange(token)
+	if err == nil && len(reply) == 0 {
+		// the context is set up, the security layer offer comes next
+		reply, err = c.exchange(nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	var offer gssapi.WrapToken
+	if err := offer.Unmarshal(reply, true); err != nil {
+		return fmt.Errorf("%w: bad SASL challenge: %v", ErrNoAuth, err)
+	}
+	if ok, err := offer.Verify(key, keyusage.GSSAPI_ACCEPTOR_SEAL); !ok {
+		return fmt.Errorf("%w: bad SASL challenge: %v", ErrNoAuth, err)
+	}
+	if len(offer.Payload) < 4 || offer.Payload[0]&1 == 0 {
+		return fmt.Errorf("%w: server requires a SASL security layer", ErrNoAuth)
+	}
+
+	// no security layer, no maximum message size, no authorization id
+	answer, err := gssapi.NewInitiatorWrapToken([]byte{1, 0, 0, 0}, key)
+	if err != nil {
+		return err
+	}
+	if token, err = answer.Marshal(); err != nil {
+		return err
+	}
+	_, err = c.exchange(token)
+	return err
+}
+
+// exchange sends a SASL token and returns the one the server answers with.
+func (c *saslConn) exchange(token []byte) ([]byte, error) {
+	req := make([]byte, 16+len(token))
+	binary.BigEndian.PutUint32(req[0:], uint32(12+len(token)))
+	binary.BigEndian.PutUint32(req[4:], saslXid)
+	binary.BigEndian.PutUint32(req[8:], opSASL)
+	binary.BigEndian.PutUint32(req[12:], uint32(len(token)))
+	copy(req[16:], token)
+	if _, err := c.Conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	// length, xid, zxid and error code, then the token
+	frame, err := readFrame(c.Conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) < 20 {
+		return nil, fmt.Errorf("short SASL reply")
+	}
+	if code := int32(binary.BigEndian.Uint32(frame[16:20])); code != 0 {
+		return nil, fmt.Errorf("%w: SASL authentication failed with code %d", ErrNoAuth, code)
+	}
+	if len(frame) < 24 {
+		return nil, nil
+	}
+	n := int32(binary.BigEndian.Uint32(frame[20:24]))
+	if n <= 0 {
+		return nil, nil
+	}
+	if int(n) > len(frame)-24 {
+		return nil, fmt.Errorf("short SASL reply")
+	}
+	return frame[24 : 24+n], nil
+}
+
+// readFrame reads one length prefixed packet, length included.
+func readFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > maxFrame {
+		return nil, fmt.Errorf("packet of %d bytes is too large", size)
+	}
+	frame := make([]byte, 4+size)
+	copy(frame, header)
+	if _, err := io.ReadFull(r, frame[4:]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
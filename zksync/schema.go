@@ -0,0 +1,162 @@
+package zksync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gopkg.in/yaml.v3"
+)
+
+type schemaRule struct {
+	pattern pattern
+	schema  *jsonschema.Schema
+}
+
+// LoadSchemaManifest reads JSON Schema rules from a file, one per line:
+//
+//	# pattern        schema
+//	*.json           schemas/any-object.json
+//	services/*.yaml  schemas/service.json
+//
+// Patterns are matched like Filter patterns against paths relative to the
+// tree root, and schema files are relative to the manifest. The last
+// matching rule wins. Files are read as YAML or TOML when their extension
+// says so, as JSON otherwise.
+func (v *Validator) LoadSchemaManifest(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	compiler := jsonschema.NewCompiler()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: want a pattern and a schema file", file, line)
+		}
+		patterns, err := compilePatterns(fields[:1])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+		schemaFile := fields[1]
+		if !filepath.IsAbs(schemaFile) {
+			schemaFile = filepath.Join(filepath.Dir(file), schemaFile)
+		}
+		schema, err := compiler.Compile(schemaFile)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+		v.schemas = append(v.schemas, schemaRule{pattern: patterns[0], schema: schema})
+	}
+	return scanner.Err()
+}
+
+// schemaFor returns the schema the file at rel must follow, nil if none.
+func (v *Validator) schemaFor(rel string) *jsonschema.Schema {
+	for i := len(v.schemas) - 1; i >= 0; i-- {
+		if matchAny([]pattern{v.schemas[i].pattern}, rel) {
+			return v.schemas[i].schema
+		}
+	}
+	return nil
+}
+
+// checkSchema checks data, the file at rel, against schema.
+func checkSchema(rel string, data []byte, schema *jsonschema.Schema) error {
+	var doc interface{}
+	var err error
+	switch strings.ToLower(path.Ext(rel)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		var m map[string]interface{}
+		err = toml.Unmarshal(data, &m)
+		doc = m
+	default:
+		err = json.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot check against schema: %w", err)
+	}
+	// a JSON round trip leaves only the types the schema library knows
+	normal, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("cannot check against schema: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(normal))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return err
+	}
+	if err := schema.Validate(doc); err != nil {
+		if ve, ok := err.(*jsonschema.ValidationError); ok {
+			return schemaError(ve)
+		}
+		return err
+	}
+	return nil
+}
+
+// schemaError flattens the causes of a schema violation into one line.
+func schemaError(ve *jsonschema.ValidationError) error {
+	var msgs []string
+	var walk func(*jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			loc := e.InstanceLocation
+			if loc == "" {
+				loc = "/"
+			}
+			msgs = append(msgs, loc+": "+e.Message)
+		}
+		for _, cause := range e.Causes {
+			walk(cause)
+		}
+	}
+	walk(ve)
+	return fmt.Errorf("does not match schema: %s", strings.Join(msgs, ", "))
+}
+
+// Validate checks every file under remotePath that opts.Filter lets
+// through with opts.Validator, returning a ValidationError listing those
+// that fail.
+func (c *Client) Validate(ctx context.Context, remotePath string, opts Options) error {
+	files, err := c.ReadTree(ctx, remotePath, opts)
+	if err != nil {
+		return err
+	}
+	rels := make([]string, 0, len(files))
+	for rel := range files {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+
+	var invalid []error
+	for _, rel := range rels {
+		if err := opts.Validator.check(ctx, rel, path.Join(remotePath, rel), files[rel]); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			invalid = append(invalid, err)
+		}
+	}
+	if len(invalid) > 0 {
+		return &ValidationError{Errs: invalid}
+	}
+	return nil
+}
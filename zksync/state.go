@@ -0,0 +1,191 @@
+package zksync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// StateFile records, at the root of a local tree, every file the tree held
+// after its last three-way sync, the base that tells which side changed
+// since. It is never uploaded.
+const StateFile = ".zksyncstate"
+
+// syncState is what the StateFile holds: the SHA-256 of every file, by
+// path relative to the root, when both trees were last the same.
+type syncState struct {
+	Remote string            `json:"remote"`
+	Files  map[string]string `json:"files"`
+}
+
+func hashData(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// readSyncState reads the StateFile of the tree at root, returning nil if
+// there is none or it was recorded against another remote tree.
+func (c *Client) readSyncState(root, remotePath string) (*syncState, error) {
+	data, err := ioutil.ReadFile(filepath.Join(root, StateFile))
+	if os.IsNotExist(err) {
+		c.logger().Info("No sync state yet, syncing two-way", "path", root)
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	s := &syncState{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if s.Remote != remotePath {
+		c.logger().Warn("Sync state is for another remote tree, syncing two-way", "path", root, "state_path", s.Remote)
+		return nil, nil
+	}
+	return s, nil
+}
+
+// writeSyncState records the files of the tree at root as the base of the
+// next sync against remotePath. Paths in kept, and below them, were left
+// different by the sync and keep their old base, as do those opts.Filter
+// leaves out.
+func (c *Client) writeSyncState(root, remotePath string, old *syncState, kept map[string]bool, opts Options) error {
+	files, err := localHashes(root, "", opts, newIgnorer(root, opts.Names))
+	if err != nil {
+		return err
+	}
+	isKept := func(rel string) bool {
+		for ; rel != "."; rel = path.Dir(rel) {
+			if kept[rel] {
+				return true
+			}
+		}
+		return false
+	}
+	for rel := range files {
+		if isKept(rel) {
+			delete(files, rel)
+		}
+	}
+	if old != nil {
+		for rel, sum := range old.Files {
+			if isKept(rel) || !opts.Filter.Included(rel) {
+				files[rel] = sum
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(&syncState{Remote: remotePath, Files: files}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(root, StateFile+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(root, StateFile))
+}
+
+// localHashes returns the SHA-256 of every file an upload would upload from
+// dir, the path at rel in the tree.
+func localHashes(dir, rel string, opts Options, ig *ignorer) (map[string]string, error) {
+	sums := make(map[string]string)
+	err := walkLocal(dir, opts.Symlinks, func(p string, fInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		sub, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		fRel := path.Join(rel, opts.Names.RemotePath(filepath.ToSlash(sub)))
+		if !fInfo.IsDir() {
+			fRel = opts.Names.stripExt(fRel)
+		}
+		if fRel == "." {
+			fRel = ""
+		}
+		ignored, err := ig.ignored(fRel, fInfo.IsDir())
+		if err != nil {
+			return err
+		}
+		if ignored || opts.Filter.Excluded(fRel) {
+			if fInfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fInfo.Mode().IsRegular() || !opts.Filter.Included(fRel) {
+			return nil
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if opts.Properties.matches(fRel) {
+			// as Diff has them, whatever their comments and order
+			data = canonicalProperties(p, data)
+		}
+		sums[fRel] = hashData(data)
+		return nil
+	})
+	return sums, err
+}
+
+// resolveThreeWay tells from base which side of d changed, returning the
+// policy that copies that change to the other side, or "" if both sides
+// changed. A file or dir only on one side was either created there, and is
+// copied over, or deleted from the other side, and del is set for it to be
+// deleted from this one too.
+func (c *Client) resolveThreeWay(ctx context.Context, d Difference, base *syncState, opts Options, ig *ignorer) (policy ConflictPolicy, del bool, err error) {
+	var sums map[string]string
+	switch d.Kind {
+	case Modified:
+		sum, ok := base.Files[d.Path]
+		switch {
+		case ok && hashData(d.LocalData) == sum:
+			return RemoteWins, false, nil
+		case ok && hashData(d.RemoteData) == sum:
+			return LocalWins, false, nil
+		}
+		return "", false, nil
+	case LocalOnly:
+		policy = LocalWins
+		if sums, err = localHashes(d.LocalPath, d.Path, opts, ig); err != nil {
+			return "", false, err
+		}
+	case RemoteOnly:
+		policy = RemoteWins
+		files := make(map[string][]byte)
+		if err := c.readTree(ctx, d.RemotePath, d.Path, opts, files); err != nil {
+			return "", false, err
+		}
+		sums = make(map[string]string, len(files))
+		for rel, data := range files {
+			sums[rel] = hashData(data)
+		}
+	default:
+		return "", false, nil
+	}
+
+	inBase, same := 0, 0
+	for rel, sum := range sums {
+		if old, ok := base.Files[rel]; ok {
+			inBase++
+			if old == sum {
+				same++
+			}
+		}
+	}
+	switch {
+	case inBase == 0:
+		return policy, false, nil
+	case same == len(sums):
+		return policy, true, nil
+	}
+	return "", false, nil
+}
@@ -0,0 +1,118 @@
+package zksync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// SymlinkPolicy decides what uploads, diffs and local watches make of
+// symlinks in the local tree.
+type SymlinkPolicy string
+
+const (
+	// SymlinksSkip leaves symlinks out, with a warning.
+	SymlinksSkip SymlinkPolicy = "skip"
+	// SymlinksFollow treats a symlink as the file or dir it points at.
+	SymlinksFollow SymlinkPolicy = "follow"
+	// SymlinksError fails on the first symlink found.
+	SymlinksError SymlinkPolicy = "error"
+)
+
+// ParseSymlinkPolicy checks s names a known policy.
+func ParseSymlinkPolicy(s string) (SymlinkPolicy, error) {
+	switch p := SymlinkPolicy(s); p {
+	case SymlinksSkip, SymlinksFollow, SymlinksError:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown symlink policy: %s", s)
+}
+
+// localStat is os.Lstat, except that symlinks are followed or refused as
+// policy says. Skipped symlinks come back as themselves, which is neither
+// a regular file nor a dir.
+func localStat(p string, policy SymlinkPolicy) (os.FileInfo, error) {
+	fInfo, err := os.Lstat(p)
+	if err != nil || fInfo.Mode()&os.ModeSymlink == 0 {
+		return fInfo, err
+	}
+	switch policy {
+	case SymlinksError:
+		return nil, fmt.Errorf("%s: %w", p, ErrSymlink)
+	case SymlinksFollow:
+		target, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("following symlink %s: %w", p, err)
+		}
+		return target, nil
+	}
+	return fInfo, nil
+}
+
+// walkLocal is filepath.Walk with symlinks handled as policy says. Paths
+// below a followed symlink are given under the symlink, and a symlink
+// leading back to a dir it is in is an error.
+func walkLocal(root string, policy SymlinkPolicy, fn filepath.WalkFunc) error {
+	fInfo, err := localStat(root, policy)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	err = walkLocalDir(root, fInfo, policy, make(map[string]bool), fn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// walkLocalDir walks p, with the real paths of the dirs it is in in
+// parents.
+func walkLocalDir(p string, fInfo os.FileInfo, policy SymlinkPolicy, parents map[string]bool, fn filepath.WalkFunc) error {
+	if err := fn(p, fInfo, nil); err != nil || !fInfo.IsDir() {
+		return err
+	}
+
+	real, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return fn(p, fInfo, err)
+	}
+	if parents[real] {
+		return fmt.Errorf("symlink cycle: %s leads back to %s", p, real)
+	}
+	parents[real] = true
+	defer delete(parents, real)
+
+	f, err := os.Open(p)
+	if err != nil {
+		return fn(p, fInfo, err)
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return fn(p, fInfo, err)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		child := filepath.Join(p, name)
+		childInfo, err := localStat(child, policy)
+		if err != nil {
+			if err := fn(child, nil, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		err = walkLocalDir(child, childInfo, policy, parents, fn)
+		if err == filepath.SkipDir {
+			if childInfo.IsDir() {
+				continue
+			}
+			// as with filepath.Walk, the rest of the dir is skipped
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+package zksync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ConflictPolicy decides which side wins when a file differs between the
+// local tree and the remote tree during a sync.
+type ConflictPolicy string
+
+const (
+	NewestWins ConflictPolicy = "newest-wins"
+	LocalWins  ConflictPolicy = "local-wins"
+	RemoteWins ConflictPolicy = "remote-wins"
+	// Skip leaves conflicts as they are, listing them in Result.Conflicts.
+	Skip ConflictPolicy = "skip"
+)
+
+// Resolution settles one conflict, as Options.Resolve decides.
+type Resolution struct {
+	// Policy is LocalWins or RemoteWins to copy that side over the other,
+	// or Skip to leave both alone.
+	Policy ConflictPolicy
+	// Merged, if not nil, is written to both sides of a Modified file in
+	// place of either.
+	Merged []byte
+}
+
+// ParseConflictPolicy checks s names a known policy, or is empty for the
+// default.
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(s); p {
+	case "", NewestWins, LocalWins, RemoteWins, Skip:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy: %s", s)
+}
+
+// DiffKind tells how a path differs between the two trees.
+type DiffKind int
+
+const (
+	LocalOnly    DiffKind = iota // the whole subtree is only on disk
+	RemoteOnly                   // the whole subtree is only on the server
+	Modified                     // a file with different contents on each side
+	TypeMismatch                 // a file on one side and a dir on the other
+)
+
+// Difference is one path that is not the same locally and remotely.
+type Difference struct {
+	Kind       DiffKind
+	Path       string // relative to the roots being compared
+	LocalPath  string
+	RemotePath string
+	LocalData  []byte // set for Modified
+	RemoteData []byte // set for Modified
+	LocalMtime time.Time
+	Remote     *Stat // nil for LocalOnly
+}
+
+// Diff compares the tree at localPath with the one at remotePath, leaving
+// out whatever opts.Filter does not match. Local files are rendered through
+// opts.Template, their secrets resolved through opts.Vault and normalized
+// by opts.Normalize first, so the diff shows what an upload would change.
+func (c *Client) Diff(ctx context.Context, localPath, remotePath string, opts Options) ([]Difference, error) {
+	absLocal, err := filepath.Abs(localPath)
+	if err != nil {
+		return nil, err
+	}
+	return c.diff(ctx, remotePath, absLocal, "", opts, newIgnorer(absLocal, opts.Names), nil)
+}
+
+func (c *Client) diff(ctx context.Context, serverPrefix, localPrefix, rel string, opts Options, ig *ignorer, diffs []Difference) ([]Difference, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts.Filter.Excluded(rel) {
+		return diffs, nil
+	}
+
+	localExists := true
+	fInfo, err := localStat(localPrefix, opts.Symlinks)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		localExists = false
+	} else if !fInfo.Mode().IsRegular() && !fInfo.IsDir() {
+		c.logger().Warn("Not a regular file, skipping", "path", localPrefix)
+		return diffs, nil
+	}
+
+	remoteExists := true
+	fData, stat, err := c.getFile(serverPrefix)
+	if err != nil {
+		if err != ErrNoNode {
+			return nil, fmt.Errorf("reading %s: %w", serverPrefix, err)
+		}
+		remoteExists = false
+	} else if stat.Ephemeral && !opts.Ephemeral {
+		c.logger().Debug("Skipping ephemeral node", "path", serverPrefix)
+		return diffs, nil
+	}
+
+	isDir := (localExists && fInfo.IsDir()) || (remoteExists && stat.IsDir())
+	if !isDir && !opts.Filter.Included(rel) {
+		return diffs, nil
+	}
+	if ignored, err := ig.ignored(rel, isDir); err != nil {
+		return nil, err
+	} else if ignored {
+		return diffs, nil
+	}
+
+	d := Difference{Path: rel, LocalPath: localPrefix, RemotePath: serverPrefix, Remote: stat}
+	if localExists {
+		d.LocalMtime = fInfo.ModTime()
+	}
+
+	switch {
+	case !localExists && !remoteExists:
+		c.logger().Debug("Path not there", "path", serverPrefix)
+	case !remoteExists:
+		d.Kind = LocalOnly
+		diffs = append(diffs, d)
+	case !localExists:
+		d.Kind = RemoteOnly
+		diffs = append(diffs, d)
+	case fInfo.IsDir() && stat.IsDir():
+		return c.diffDir(ctx, serverPrefix, localPrefix, rel, opts, ig, diffs)
+	case !fInfo.IsDir() && fInfo.Size() == 0 && stat.NumChildren == 0 && opts.PlainEmpty:
+		// with PlainEmpty an empty file is a node with no data
+		c.logger().Debug("Files are the same", "path", serverPrefix)
+	case !fInfo.IsDir() && stat.IsDir() && opts.Properties.matches(rel):
+		return c.diffProperties(ctx, d, opts, diffs)
+	case fInfo.IsDir() || stat.IsDir():
+		d.Kind = TypeMismatch
+		diffs = append(diffs, d)
+	default:
+		localData, err := c.readLocal(localPrefix, rel, opts)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(localData, fData) {
+			d.Kind = Modified
+			d.LocalData, d.RemoteData = localData, fData
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs, nil
+}
+
+// readLocal reads the local file at localPrefix, at rel in the tree, as an
+// upload would upload it: rendered through opts.Template, its secrets
+// resolved through opts.Vault and normalized by opts.Normalize.
+func (c *Client) readLocal(localPrefix, rel string, opts Options) ([]byte, error) {
+	data, err := ioutil.ReadFile(localPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = opts.Template.render(localPrefix, data); err != nil {
+		return nil, err
+	}
+	if opts.Vault.onUpload() {
+		if data, err = opts.Vault.resolve(localPrefix, data); err != nil {
+			return nil, err
+		}
+	}
+	return opts.Normalize.normalize(rel, localPrefix, data)
+}
+
+func (c *Client) diffDir(ctx context.Context, serverPrefix, localPrefix, rel string, opts Options, ig *ignorer, diffs []Difference) ([]Difference, error) {
+	children, _, err := c.Backend.List(serverPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", serverPrefix, err)
+	}
+	entries, err := ioutil.ReadDir(localPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// by node name, the local name each has
+	seen := make(map[string]string)
+	if _, err := opts.Names.localNames(serverPrefix, rel, children, nil); err != nil {
+		return nil, err
+	}
+	localNames := make([]string, len(entries))
+	files := make([]bool, len(entries))
+	for i, entry := range entries {
+		localNames[i], files[i] = entry.Name(), !entry.IsDir()
+	}
+	entryNames, err := opts.Names.remoteNames(localPrefix, rel, localNames, files)
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range entryNames {
+		seen[name] = localNames[i]
+	}
+	for _, child := range children {
+		if _, ok := seen[child]; ok {
+			continue
+		}
+		seen[child] = opts.Names.LocalPath(child)
+		if opts.Names.ext(path.Join(rel, child)) == "" {
+			continue
+		}
+		// only on the server, which says whether it is a file
+		_, stat, err := c.Backend.Get(path.Join(serverPrefix, child))
+		if err != nil && err != ErrNoNode {
+			return nil, fmt.Errorf("reading %s: %w", path.Join(serverPrefix, child), err)
+		}
+		if err == nil && !stat.IsDir() {
+			seen[child] = path.Base(opts.Names.LocalFile(path.Join(rel, child)))
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fullpath := path.Join(serverPrefix, name)
+		fulllocalpath := filepath.Join(localPrefix, seen[name])
+		if diffs, err = c.diff(ctx, fullpath, fulllocalpath, path.Join(rel, name), opts, ig, diffs); err != nil {
+			return nil, err
+		}
+	}
+	return diffs, nil
+}
+
+// planSync plans copying every difference over. With a base, only the side
+// that changed since wins, and only differences changed on both sides are
+// conflicts; without one every Modified file is. Conflicts are settled by
+// opts.Resolve, or opts.Policy if it is nil. It returns the conflicts
+// skipped, and the paths left different, whose base must be kept.
+func (c *Client) planSync(ctx context.Context, diffs []Difference, base *syncState, ig *ignorer, opts Options) (p Plan, conflicts []string, kept map[string]bool, err error) {
+	opts.Clean = false
+	policy := opts.Policy
+	if policy == "" && base != nil {
+		policy = Skip
+	}
+	kept = make(map[string]bool)
+	var invalid []error
+	for _, d := range diffs {
+		dPolicy, del, conflict := policy, false, d.Kind == Modified
+		if base != nil && d.Kind != TypeMismatch {
+			var resolved ConflictPolicy
+			if resolved, del, err = c.resolveThreeWay(ctx, d, base, opts, ig); err != nil {
+				return nil, nil, nil, err
+			}
+			if conflict = resolved == ""; !conflict {
+				dPolicy = resolved
+			}
+		}
+		var merged []byte
+		if conflict {
+			if opts.Resolve != nil {
+				r, err := opts.Resolve(d)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				dPolicy, merged = r.Policy, r.Merged
+			} else if base != nil && dPolicy != Skip {
+				c.logger().Warn("Changed on both sides", "local", d.LocalPath, "remote", d.RemotePath, "policy", dPolicy)
+			}
+			if dPolicy == Skip && merged == nil {
+				c.logger().Warn("Changed on both sides, skipping", "local", d.LocalPath, "remote", d.RemotePath)
+				conflicts = append(conflicts, d.Path)
+				kept[d.Path] = true
+				continue
+			}
+		}
+
+		var dPlan Plan
+		switch {
+		case d.Kind == LocalOnly && (del || conflict && dPolicy == RemoteWins):
+			c.logger().Debug("Gone remotely, will remove", "path", d.LocalPath)
+			dPlan = Plan{{Kind: OpRemove, Source: d.RemotePath, Target: d.LocalPath}}
+		case d.Kind == LocalOnly:
+			c.logger().Debug("Only present locally", "path", d.LocalPath)
+			dPlan, err = c.planUpload(ctx, d.RemotePath, d.LocalPath, d.Path, opts)
+		case d.Kind == RemoteOnly && (del || conflict && dPolicy == LocalWins):
+			c.logger().Debug("Gone locally, will delete", "path", d.RemotePath)
+			dPlan, err = c.planDelete(ctx, d.RemotePath)
+		case d.Kind == RemoteOnly:
+			c.logger().Debug("Only present remotely", "path", d.RemotePath)
+			dPlan, err = c.planDownload(ctx, d.RemotePath, d.LocalPath, d.Path, opts)
+		case d.Kind == TypeMismatch:
+			c.logger().Warn("Type mismatch, skipping", "local", d.LocalPath, "remote", d.RemotePath)
+			kept[d.Path] = true
+		case d.Kind == Modified && merged != nil:
+			c.logger().Debug("Merged", "path", d.Path)
+			dPlan, err = c.planSyncMerged(ctx, d, merged, opts)
+		case d.Kind == Modified:
+			fileOpts := opts
+			fileOpts.Policy = dPolicy
+			if dPlan, err = c.planSyncFile(ctx, d, fileOpts); err == nil && len(dPlan) == 0 {
+				kept[d.Path] = true
+			}
+		}
+		var ve *ValidationError
+		if errors.As(err, &ve) {
+			invalid = append(invalid, ve.Errs...)
+			kept[d.Path] = true
+			continue
+		} else if err != nil {
+			return nil, nil, nil, err
+		}
+		p = append(p, dPlan...)
+	}
+	if len(invalid) > 0 {
+		return nil, nil, nil, &ValidationError{Errs: invalid}
+	}
+	return p, conflicts, kept, nil
+}
+
+func (c *Client) planSyncFile(ctx context.Context, d Difference, opts Options) (Plan, error) {
+	mtime := d.Remote.Mtime
+	var upload bool
+	switch opts.Policy {
+	case LocalWins:
+		upload = true
+	case RemoteWins:
+		upload = false
+	default:
+		// an unknown remote mtime is always older, so local wins
+		if mtime.Equal(d.LocalMtime) {
+			c.logger().Warn("Files differ but have the same mtime, skipping", "path", d.LocalPath)
+			return nil, nil
+		}
+		upload = d.LocalMtime.After(mtime)
+	}
+
+	if upload {
+		if err := opts.Validator.check(ctx, d.Path, d.LocalPath, d.LocalData); err != nil {
+			return nil, &ValidationError{Errs: []error{err}}
+		}
+		return c.planSyncWrite(ctx, d, d.LocalData, opts)
+	}
+	return Plan{{Kind: OpOverwrite, Source: d.RemotePath, Target: d.LocalPath, Data: d.RemoteData, OldSize: len(d.LocalData), Mtime: mtime}}, nil
+}
+
+// planSyncMerged plans writing merged to both sides of d.
+func (c *Client) planSyncMerged(ctx context.Context, d Difference, merged []byte, opts Options) (Plan, error) {
+	if err := opts.Validator.check(ctx, d.Path, d.LocalPath, merged); err != nil {
+		return nil, &ValidationError{Errs: []error{err}}
+	}
+	p, err := c.planSyncWrite(ctx, d, merged, opts)
+	if err != nil {
+		return nil, err
+	}
+	return append(Plan{{Kind: OpOverwrite, Source: d.RemotePath, Target: d.LocalPath, Data: merged, OldSize: len(d.LocalData)}}, p...), nil
+}
+
+// planSyncWrite plans writing data over the remote side of d, as the keys
+// of a properties file if it is one.
+func (c *Client) planSyncWrite(ctx context.Context, d Difference, data []byte, opts Options) (Plan, error) {
+	if opts.Properties.matches(d.Path) && d.Remote.IsDir() {
+		// keys gone from the file go from the server too
+		opts.Prune = true
+		return c.planUploadProperties(ctx, d.LocalPath, d.RemotePath, d.Path, data, false, opts)
+	}
+	return c.planWrite(d.LocalPath, d.RemotePath, data, d.Remote, nil, opts)
+}
@@ -0,0 +1,161 @@
+package zksync
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TemplateSyntax is how templates refer to values.
+type TemplateSyntax string
+
+const (
+	// GoTemplate renders files with text/template, values being {{.key}}
+	// and environment variables {{env "VAR"}}.
+	GoTemplate TemplateSyntax = "go"
+	// EnvTemplate replaces ${key} with the value of key, or of the
+	// environment variable key when there is no such value.
+	EnvTemplate TemplateSyntax = "env"
+)
+
+// ParseTemplateSyntax checks s names a known syntax.
+func ParseTemplateSyntax(s string) (TemplateSyntax, error) {
+	switch t := TemplateSyntax(s); t {
+	case GoTemplate, EnvTemplate:
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown template syntax: %s", s)
+}
+
+// Template renders local files before they are uploaded, so that one tree
+// can serve several environments. A value that is missing is an error
+// rather than an empty string.
+type Template struct {
+	Syntax TemplateSyntax
+	// Values hold strings and nested maps of them, a dotted key such as
+	// db.host reaching into the maps.
+	Values map[string]interface{}
+}
+
+// Set sets the value of a dotted key from key=value, replacing any earlier
+// one.
+func (t *Template) Set(kv string) error {
+	i := strings.Index(kv, "=")
+	if i <= 0 {
+		return fmt.Errorf("bad value %q, want key=value", kv)
+	}
+	if t.Values == nil {
+		t.Values = make(map[string]interface{})
+	}
+	m := t.Values
+	keys := strings.Split(kv[:i], ".")
+	for _, key := range keys[:len(keys)-1] {
+		sub, ok := m[key].(map[string]interface{})
+		if !ok {
+			sub = make(map[string]interface{})
+			m[key] = sub
+		}
+		m = sub
+	}
+	m[keys[len(keys)-1]] = kv[i+1:]
+	return nil
+}
+
+// LoadValues reads values from a YAML or JSON file holding a map. Values
+// already set win over those in the file.
+func (t *Template) LoadValues(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("parsing %s: %w", file, err)
+	}
+	t.Values = mergeValues(values, t.Values)
+	return nil
+}
+
+// mergeValues returns base with the values in over laid on top of it.
+func mergeValues(base, over map[string]interface{}) map[string]interface{} {
+	if base == nil {
+		base = make(map[string]interface{})
+	}
+	for k, v := range over {
+		bm, ok1 := base[k].(map[string]interface{})
+		om, ok2 := v.(map[string]interface{})
+		if ok1 && ok2 {
+			base[k] = mergeValues(bm, om)
+		} else {
+			base[k] = v
+		}
+	}
+	return base
+}
+
+// lookup finds the value of a dotted key.
+func (t *Template) lookup(key string) (interface{}, bool) {
+	var v interface{} = t.Values
+	for _, k := range strings.Split(key, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_.]*)\}`)
+
+// render returns data, the contents of the file name, rendered. A nil
+// Template leaves data as it is.
+func (t *Template) render(name string, data []byte) ([]byte, error) {
+	if t == nil {
+		return data, nil
+	}
+
+	if t.Syntax == EnvTemplate {
+		var missing []string
+		out := envRef.ReplaceAllFunc(data, func(ref []byte) []byte {
+			key := string(ref[2 : len(ref)-1])
+			if v, ok := t.lookup(key); ok {
+				return []byte(fmt.Sprint(v))
+			}
+			if v, ok := os.LookupEnv(key); ok {
+				return []byte(v)
+			}
+			missing = append(missing, key)
+			return ref
+		})
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("rendering %s: no value for %s", name, strings.Join(missing, ", "))
+		}
+		return out, nil
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(template.FuncMap{
+		"env": func(key string) (string, error) {
+			if v, ok := os.LookupEnv(key); ok {
+				return v, nil
+			}
+			return "", fmt.Errorf("environment variable %s is not set", key)
+		},
+	}).Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("parsing template %s: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, t.Values); err != nil {
+		return nil, fmt.Errorf("rendering %s: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
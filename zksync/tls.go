@@ -0,0 +1,52 @@
+package zksync
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSConfig says how to secure the connection to a backend.
+type TLSConfig struct {
+	// Enabled turns TLS on. Setting any of the files turns it on too.
+	Enabled bool
+	// CertFile and KeyFile hold a client certificate, for servers that
+	// require one.
+	CertFile string
+	KeyFile  string
+	// CAFile holds the CAs to trust instead of the system ones.
+	CAFile string
+}
+
+func (t TLSConfig) enabled() bool {
+	return t.Enabled || t.CertFile != "" || t.KeyFile != "" || t.CAFile != ""
+}
+
+// config builds the crypto/tls configuration, or nil if TLS is off.
+func (t TLSConfig) config() (*tls.Config, error) {
+	if !t.enabled() {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
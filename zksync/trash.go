@@ -0,0 +1,182 @@
+package zksync
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultTrashRoot is where deleted nodes go unless told otherwise.
+const DefaultTrashRoot = "/_trash"
+
+// trashStamp names the entries of the trash, sorting in the order they
+// were made.
+const trashStamp = "2006-01-02T15:04:05.000Z"
+
+// Trash keeps what runs delete, so that deleting by mistake can be undone.
+// Every run moving nodes there makes an entry named after when it ran,
+// under which the nodes keep their paths, data and ACLs.
+type Trash struct {
+	// Root holds the entries, DefaultTrashRoot if empty. It is never
+	// pruned, nor are deletes below it trashed.
+	Root string
+	// Keep is how long entries are kept, older ones being purged after
+	// every run trashing nodes. Zero keeps them until purged by hand.
+	Keep time.Duration
+}
+
+func (t *Trash) root() string {
+	if t == nil || t.Root == "" {
+		return DefaultTrashRoot
+	}
+	return t.Root
+}
+
+// holds reports whether p is the trash or below it.
+func (t *Trash) holds(p string) bool {
+	root := t.root()
+	return p == root || strings.HasPrefix(p, root+"/")
+}
+
+// TrashEntry is what one run moved to the trash.
+type TrashEntry struct {
+	Name    string
+	Deleted time.Time
+}
+
+// planTrash plans copying every node p deletes to a new trash entry, to
+// be applied before p. Chunks deleted as large files are rewritten are
+// not worth keeping on their own, and are left out.
+func (c *Client) planTrash(p Plan, t *Trash) (Plan, error) {
+	deleted := make(map[string]bool)
+	for _, o := range p {
+		if o.Kind == OpDelete {
+			deleted[o.Target] = true
+		}
+	}
+	var targets []string
+	for target := range deleted {
+		switch {
+		case target == "/" || t.holds(target):
+		case isChunk(path.Base(target)) && !deleted[path.Dir(target)]:
+		default:
+			targets = append(targets, target)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	// parents first
+	sort.Strings(targets)
+
+	ab, withACLs := c.Backend.(ACLBackend)
+	entry := path.Join(t.root(), time.Now().UTC().Format(trashStamp))
+	made := make(map[string]bool)
+	var trash Plan
+	for _, target := range targets {
+		data, _, err := c.Backend.Get(target)
+		if err == ErrNoNode {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", target, err)
+		}
+		var acl []ACL
+		if withACLs {
+			// the trashed copy must not be any easier to read
+			if acl, err = ab.GetACL(target); err != nil {
+				return nil, fmt.Errorf("reading ACL of %s: %w", target, err)
+			}
+		}
+
+		dest := path.Join(entry, target)
+		var missing []string
+		for dir := path.Dir(dest); dir != "/" && !made[dir]; dir = path.Dir(dir) {
+			made[dir] = true
+			missing = append(missing, dir)
+		}
+		for i := len(missing) - 1; i >= 0; i-- {
+			trash = append(trash, Op{Kind: OpCreate, Target: missing[i], Dir: true})
+		}
+		made[dest] = true
+		trash = append(trash, Op{Kind: OpCreate, Source: target, Target: dest, Dir: len(data) == 0, Data: data, ACL: acl})
+	}
+	return trash, nil
+}
+
+// TrashEntries lists the entries of the trash, oldest first.
+func (c *Client) TrashEntries(t *Trash) ([]TrashEntry, error) {
+	children, _, err := c.Backend.List(t.root())
+	if err == ErrNoNode {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", t.root(), err)
+	}
+	var entries []TrashEntry
+	for _, name := range children {
+		deleted, err := time.Parse(trashStamp, name)
+		if err != nil {
+			// not ours
+			continue
+		}
+		entries = append(entries, TrashEntry{Name: name, Deleted: deleted})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Deleted.Before(entries[j].Deleted) })
+	return entries, nil
+}
+
+// RestoreTrash puts back what the trash entry named entry holds at
+// remotePath or below, "/" for all of it, creating the nodes since gone
+// and overwriting those since changed. The entry stays in the trash.
+func (c *Client) RestoreTrash(ctx context.Context, t *Trash, entry, remotePath string, opts Options) (*Result, error) {
+	if _, err := time.Parse(trashStamp, entry); err != nil {
+		return nil, fmt.Errorf("no trash entry %q", entry)
+	}
+	opts.Trash, opts.Prune = nil, false
+	s, err := c.Backup(ctx, path.Join(t.root(), entry, remotePath), opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.Restore(ctx, s, remotePath, opts)
+}
+
+// PurgeTrash deletes the entries of the trash older than t.Keep, or all
+// of them if t.Keep is zero.
+func (c *Client) PurgeTrash(ctx context.Context, t *Trash, opts Options) (*Result, error) {
+	entries, err := c.TrashEntries(t)
+	if err != nil {
+		return nil, err
+	}
+	// entries are as deep as they are, MinDeleteDepth is for the trees
+	// deleted into them
+	opts.Trash, opts.ForceDelete = nil, true
+	var p Plan
+	for _, e := range entries {
+		if t.Keep > 0 && time.Since(e.Deleted) < t.Keep {
+			continue
+		}
+		entryPlan, err := c.planDelete(ctx, path.Join(t.root(), e.Name))
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, entryPlan...)
+	}
+	return c.run(ctx, p, opts)
+}
+
+// expireTrash purges the entries of the trash older than t.Keep, after a
+// run has added one.
+func (c *Client) expireTrash(ctx context.Context, t *Trash) {
+	if t.Keep <= 0 {
+		return
+	}
+	res, err := c.PurgeTrash(ctx, t, Options{})
+	if err == nil && len(res.Failed) > 0 {
+		err = res.Failed[0]
+	}
+	if err != nil {
+		c.logger().Warn("Could not purge expired trash", "path", t.root(), "err", err)
+	}
+}
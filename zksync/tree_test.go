@@ -0,0 +1,81 @@
+package zksync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTree writes files, by slash separated path, below dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree reads the files below dir back by slash separated path, dirs
+// ending in a slash.
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(p string, fInfo os.FileInfo, err error) error {
+		if err != nil || p == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if fInfo.IsDir() {
+			files[filepath.ToSlash(rel)+"/"] = ""
+			return nil
+		}
+		data, err := ioutil.ReadFile(p)
+		files[filepath.ToSlash(rel)] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// sameTree fails t unless the files below dir are want, and the dirs they
+// are in.
+func sameTree(t *testing.T, dir string, want map[string]string) {
+	t.Helper()
+	withDirs := make(map[string]string)
+	for name, data := range want {
+		withDirs[name] = data
+		for d := filepath.ToSlash(filepath.Dir(name)); d != "."; d = filepath.ToSlash(filepath.Dir(d)) {
+			withDirs[d+"/"] = ""
+		}
+	}
+	if got := readTree(t, dir); !reflect.DeepEqual(got, withDirs) {
+		t.Fatalf("%s holds %q, want %q", dir, got, withDirs)
+	}
+}
+
+// applied returns a func failing t if the run whose results it is given
+// failed, or any of its changes did, for wrapping runs in.
+func applied(t *testing.T) func(*Result, error) *Result {
+	return func(res *Result, err error) *Result {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Failed) > 0 {
+			t.Fatal(res.Failed[0])
+		}
+		return res
+	}
+}
@@ -0,0 +1,218 @@
+package zksync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+func (c *Client) planRemotePath(serverPrefix string) (Plan, error) {
+	if serverPrefix == "/" {
+		return nil, nil
+	}
+
+	dir := path.Dir(serverPrefix)
+	p, err := c.planRemotePath(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(p) == 0 {
+		_, _, err := c.Backend.Get(serverPrefix)
+		if err == nil {
+			c.logger().Debug("Dir already created", "path", serverPrefix)
+			return nil, nil
+		} else if err != ErrNoNode {
+			return nil, fmt.Errorf("checking %s: %w", serverPrefix, err)
+		}
+	}
+	return append(p, Op{Kind: OpCreate, Target: serverPrefix, Dir: true}), nil
+}
+
+// planUpload plans copying the local tree to the server. rel is where
+// localPrefix sits in the tree being synced, for matching opts.Filter. With
+// opts.Clean set the remote tree is assumed to be empty, as it will be after
+// a delete.
+func (c *Client) planUpload(ctx context.Context, serverPrefix, localPrefix, rel string, opts Options) (Plan, error) {
+	// iterate local dir
+	absLocal, err := filepath.Abs(localPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := path.Dir(serverPrefix)
+	p, err := c.planRemotePath(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i := range p {
+		p[i].ACL = opts.ACLs.For("")
+	}
+	// nothing can exist below a dir that is yet to be created, so there is
+	// no need to ask the server about it
+	created := make(map[string]bool)
+	for _, o := range p {
+		created[o.Target] = true
+	}
+
+	ig := ignorerFor(absLocal, rel, opts.Names)
+	var invalid []error
+	// names that map to the same node, which only Options.Names can make
+	walked := make(map[string]string)
+
+	visitFunc := func(visitedPath string, fInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if !fInfo.Mode().IsRegular() && !fInfo.IsDir() {
+			c.logger().Warn("Not a regular file, skipping", "path", visitedPath)
+			return err
+		}
+
+		subPath := opts.Names.RemotePath(filepath.ToSlash(visitedPath[len(absLocal):]))
+		fRel := strings.TrimPrefix(path.Join(rel, subPath), "/")
+		if stripped := opts.Names.stripExt(fRel); stripped != fRel && !fInfo.IsDir() {
+			subPath = strings.TrimSuffix(subPath, fRel[len(stripped):])
+			fRel = stripped
+		}
+		if opts.Filter.Excluded(fRel) {
+			if fInfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if !fInfo.IsDir() && !opts.Filter.Included(fRel) {
+			return nil
+		} else if ignored, err := ig.ignored(fRel, fInfo.IsDir()); err != nil {
+			return err
+		} else if ignored {
+			c.logger().Debug("Ignoring", "path", visitedPath)
+			if fInfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		remotePath := path.Join(serverPrefix, subPath)
+		if other, ok := walked[remotePath]; ok {
+			return fmt.Errorf("%s and %s would both be %s: %w", other, visitedPath, remotePath, ErrNameCollision)
+		}
+		walked[remotePath] = visitedPath
+
+		// upload files
+		var fData []byte
+		if fInfo.IsDir() {
+			fData = []byte{}
+		} else {
+			data, err := ioutil.ReadFile(visitedPath)
+			if err != nil {
+				return err
+			}
+			if opts.Binary != BinaryAllow && opts.Binary != "" && IsBinary(data) {
+				if opts.Binary == BinaryError {
+					return fmt.Errorf("%s: %w", visitedPath, ErrBinary)
+				}
+				c.logger().Warn("Binary file, skipping", "path", visitedPath)
+				return nil
+			}
+			if fData, err = opts.Template.render(visitedPath, data); err != nil {
+				return err
+			}
+			if opts.Vault.onUpload() {
+				if fData, err = opts.Vault.resolve(visitedPath, fData); err != nil {
+					return err
+				}
+			}
+			if fData, err = opts.Normalize.normalize(fRel, visitedPath, fData); err != nil {
+				invalid = append(invalid, err)
+				return nil
+			}
+			if opts.Properties.matches(fRel) {
+				propsPlan, err := c.planUploadProperties(ctx, visitedPath, remotePath, fRel, fData, opts.Clean || created[path.Dir(remotePath)], opts)
+				if err != nil {
+					invalid = append(invalid, err)
+					return nil
+				}
+				p = append(p, propsPlan...)
+				return nil
+			}
+		}
+
+		exists := false
+		var remoteData []byte
+		var fStat *Stat
+		if !opts.Clean && !created[path.Dir(remotePath)] {
+			remoteData, fStat, err = c.getFile(remotePath)
+			if err == nil {
+				exists = true
+			} else if err != ErrNoNode {
+				return fmt.Errorf("checking %s: %w", remotePath, err)
+			}
+			err = nil
+		}
+
+		if !exists && fInfo.IsDir() {
+			p = append(p, Op{Kind: OpCreate, Source: visitedPath, Target: remotePath, Dir: true, Data: fData, ACL: opts.ACLs.For(fRel)})
+			created[remotePath] = true
+		} else if !exists {
+			if err := opts.Validator.check(ctx, fRel, visitedPath, fData); err != nil {
+				invalid = append(invalid, err)
+				return nil
+			}
+			acl := opts.ACLs.For(fRel)
+			if opts.ModeACLs {
+				acl = modeACL(localMode(fInfo))
+			}
+			writePlan, err := c.planWrite(visitedPath, remotePath, fData, nil, acl, opts)
+			if err != nil {
+				return err
+			}
+			p = append(p, writePlan...)
+		} else if fStat.Ephemeral {
+			c.logger().Warn("Remote node is ephemeral, not overwriting", "path", remotePath)
+		} else if fInfo.IsDir() {
+			c.logger().Debug("Dir already there", "path", remotePath)
+		} else if fStat.NumChildren > 0 {
+			return fmt.Errorf("remote path is a dir when a file is expected: %s", remotePath)
+		} else {
+			// files left in plain text that are to be encrypted are
+			// rewritten even if they did not change
+			plain := !fStat.Encrypted && len(fData) > 0 && c.Encryption.encrypts(remotePath)
+			if (!fStat.IsDir() || opts.PlainEmpty) && bytes.Equal(remoteData, fData) && !plain {
+				c.logger().Debug("Files are the same", "path", remotePath)
+			} else if err := opts.Validator.check(ctx, fRel, visitedPath, fData); err != nil {
+				invalid = append(invalid, err)
+				return nil
+			} else {
+				writePlan, err := c.planWrite(visitedPath, remotePath, fData, fStat, nil, opts)
+				if err != nil {
+					return err
+				}
+				p = append(p, writePlan...)
+			}
+			if opts.ModeACLs {
+				aclPlan, err := c.planModeACL(remotePath, localMode(fInfo))
+				if err != nil {
+					return err
+				}
+				p = append(p, aclPlan...)
+			}
+		}
+
+		return err
+	}
+	if err := walkLocal(absLocal, opts.Symlinks, visitFunc); err != nil {
+		return nil, err
+	}
+	if len(invalid) > 0 {
+		return nil, &ValidationError{Errs: invalid}
+	}
+	return p, nil
+}
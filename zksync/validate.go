@@ -0,0 +1,122 @@
+package zksync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Validator checks the files uploads are about to write, so that a
+// malformed config never reaches those reading it. Every file is checked
+// before anything is changed, and one failing stops the upload.
+type Validator struct {
+	// Syntax checks the files whose extension names a format it knows:
+	// .json, .yaml, .yml, .toml and .xml.
+	Syntax   bool
+	commands []validatorCommand
+	schemas  []schemaRule
+}
+
+type validatorCommand struct {
+	pattern pattern
+	command string
+}
+
+// AddCommand has the files matching a pattern checked by a shell command,
+// from a pattern=command spec. Patterns are matched as Filter matches
+// them. The command gets the file, as it would be uploaded, on stdin and
+// its path relative to the tree in CONFIGURATOR_PATH, and fails to reject
+// it.
+func (v *Validator) AddCommand(spec string) error {
+	i := strings.Index(spec, "=")
+	if i <= 0 || i == len(spec)-1 {
+		return fmt.Errorf("bad validator %q, want pattern=command", spec)
+	}
+	patterns, err := compilePatterns([]string{spec[:i]})
+	if err != nil {
+		return err
+	}
+	v.commands = append(v.commands, validatorCommand{pattern: patterns[0], command: spec[i+1:]})
+	return nil
+}
+
+// check checks data, the file at rel read from file.
+func (v *Validator) check(ctx context.Context, rel, file string, data []byte) error {
+	if v == nil {
+		return nil
+	}
+	if v.Syntax {
+		if err := checkSyntax(rel, data); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	if schema := v.schemaFor(rel); schema != nil {
+		if err := checkSchema(rel, data, schema); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	for _, vc := range v.commands {
+		if !vc.pattern.match(rel) {
+			continue
+		}
+		var out bytes.Buffer
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", vc.command)
+		cmd.Stdin = bytes.NewReader(data)
+		cmd.Stdout, cmd.Stderr = &out, &out
+		cmd.Env = append(os.Environ(), "CONFIGURATOR_PATH="+rel)
+		if err := cmd.Run(); err != nil {
+			if msg := strings.TrimSpace(out.String()); msg != "" {
+				err = fmt.Errorf("%w: %s", err, msg)
+			}
+			return fmt.Errorf("%s: %s: %w", file, vc.command, err)
+		}
+	}
+	return nil
+}
+
+// checkSyntax parses data as the format the extension of rel names, if it
+// names one.
+func checkSyntax(rel string, data []byte) error {
+	switch strings.ToLower(path.Ext(rel)) {
+	case ".json":
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("invalid JSON: %w", err)
+		}
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		for {
+			var doc yaml.Node
+			if err := dec.Decode(&doc); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("invalid YAML: %w", err)
+			}
+		}
+	case ".toml":
+		var v map[string]interface{}
+		if err := toml.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("invalid TOML: %w", err)
+		}
+	case ".xml":
+		dec := xml.NewDecoder(bytes.NewReader(data))
+		for {
+			if _, err := dec.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("invalid XML: %w", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package zksync
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Vault resolves references to HashiCorp Vault secrets written in files as
+// vault:path#key, so that secrets never have to be in the tree itself.
+// Paths are those of the Vault API, so a KV version 2 secret is written
+// vault:secret/data/db#password. Every secret is read once per Vault.
+type Vault struct {
+	// Addr is the address of the Vault server, such as
+	// https://vault.example.com:8200.
+	Addr  string
+	Token string
+	// Namespace is the Vault Enterprise namespace, if any.
+	Namespace string
+	// OnDownload leaves references as they are in the files uploaded, and
+	// resolves them in the files downloaded instead, so that secrets never
+	// reach the server either.
+	OnDownload bool
+
+	client  *http.Client
+	mu      sync.Mutex
+	secrets map[string]map[string]interface{}
+}
+
+var vaultRef = regexp.MustCompile(`vault:([A-Za-z0-9_.\-/]+)#([A-Za-z0-9_.\-]+)`)
+
+func (v *Vault) onUpload() bool {
+	return v != nil && !v.OnDownload
+}
+
+func (v *Vault) onDownload() bool {
+	return v != nil && v.OnDownload
+}
+
+// resolve returns data, the contents of the file name, with every reference
+// replaced by the secret it points at.
+func (v *Vault) resolve(name string, data []byte) ([]byte, error) {
+	var failed []string
+	fail := func(msg string) {
+		for _, f := range failed {
+			if f == msg {
+				return
+			}
+		}
+		failed = append(failed, msg)
+	}
+	out := vaultRef.ReplaceAllFunc(data, func(ref []byte) []byte {
+		m := vaultRef.FindSubmatch(ref)
+		secret, err := v.read(string(m[1]))
+		if err != nil {
+			fail(err.Error())
+			return ref
+		}
+		value, ok := secret[string(m[2])]
+		if !ok {
+			fail(fmt.Sprintf("%s has no key %s", m[1], m[2]))
+			return ref
+		}
+		return []byte(fmt.Sprint(value))
+	})
+	if len(failed) > 0 {
+		return nil, fmt.Errorf("resolving secrets in %s: %s", name, strings.Join(failed, ", "))
+	}
+	return out, nil
+}
+
+// read returns the data of the secret at p, reading it from Vault the
+// first time.
+func (v *Vault) read(p string) (map[string]interface{}, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if secret, ok := v.secrets[p]; ok {
+		return secret, nil
+	}
+	if v.client == nil {
+		v.client = &http.Client{Timeout: 30 * time.Second}
+		v.secrets = make(map[string]map[string]interface{})
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(v.Addr, "/")+"/v1/"+strings.TrimPrefix(p, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s from Vault: %w", p, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []string               `json:"errors"`
+	}
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("reading %s from Vault: %w", p, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := resp.Status
+		if len(body.Errors) > 0 {
+			msg += ": " + strings.Join(body.Errors, "; ")
+		}
+		return nil, fmt.Errorf("reading %s from Vault: %s", p, msg)
+	}
+	secret := body.Data
+	// KV version 2 wraps the secret with its metadata
+	if inner, ok := secret["data"].(map[string]interface{}); ok && secret["metadata"] != nil {
+		secret = inner
+	}
+	v.secrets[p] = secret
+	return secret, nil
+}
@@ -0,0 +1,51 @@
+package zksync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+var diffKindNames = [...]string{"local-only", "remote-only", "modified", "type-mismatch"}
+
+func (k DiffKind) String() string {
+	if int(k) < len(diffKindNames) {
+		return diffKindNames[k]
+	}
+	return "unknown"
+}
+
+// Mismatch is a Difference as verify reports it, files being described by
+// size and hash rather than contents.
+type Mismatch struct {
+	Path         string `json:"path" yaml:"path"`
+	Kind         string `json:"kind" yaml:"kind"`
+	LocalSize    int    `json:"local_size,omitempty" yaml:"local_size,omitempty"`
+	RemoteSize   int    `json:"remote_size,omitempty" yaml:"remote_size,omitempty"`
+	LocalSHA256  string `json:"local_sha256,omitempty" yaml:"local_sha256,omitempty"`
+	RemoteSHA256 string `json:"remote_sha256,omitempty" yaml:"remote_sha256,omitempty"`
+}
+
+// VerifyReport says whether two trees are the same, and where not.
+type VerifyReport struct {
+	Identical  bool       `json:"identical" yaml:"identical"`
+	Mismatches []Mismatch `json:"mismatches" yaml:"mismatches"`
+}
+
+// NewVerifyReport sums up diffs, as Diff returns them.
+func NewVerifyReport(diffs []Difference) VerifyReport {
+	r := VerifyReport{Identical: len(diffs) == 0, Mismatches: make([]Mismatch, len(diffs))}
+	for i, d := range diffs {
+		m := Mismatch{Path: d.Path, Kind: d.Kind.String()}
+		if d.Kind == Modified {
+			m.LocalSize, m.RemoteSize = len(d.LocalData), len(d.RemoteData)
+			m.LocalSHA256, m.RemoteSHA256 = sha256Hex(d.LocalData), sha256Hex(d.RemoteData)
+		}
+		r.Mismatches[i] = m
+	}
+	return r
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
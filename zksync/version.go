@@ -0,0 +1,53 @@
+package zksync
+
+import (
+	"errors"
+	"fmt"
+)
+
+// checkVersions reads again every node p writes, returning a ChangedError
+// listing those that are no longer as the plan found them: nodes to be set
+// or deleted at another version or gone, and files to be created that
+// someone else created.
+func (c *Client) checkVersions(p Plan) error {
+	var changed []string
+	for _, o := range p {
+		switch {
+		case o.Kind == OpCreate && !o.Dir:
+			if _, _, err := c.Backend.Get(o.Target); err == nil {
+				changed = append(changed, o.Target)
+			} else if err != ErrNoNode {
+				return fmt.Errorf("checking %s: %w", o.Target, err)
+			}
+		case (o.Kind == OpSet || o.Kind == OpDelete) && o.Version >= 0:
+			_, stat, err := c.Backend.Get(o.Target)
+			if err == ErrNoNode {
+				changed = append(changed, o.Target)
+			} else if err != nil {
+				return fmt.Errorf("checking %s: %w", o.Target, err)
+			} else if stat.Version != o.Version {
+				changed = append(changed, o.Target)
+			}
+		}
+	}
+	if len(changed) > 0 {
+		return &ChangedError{Paths: changed}
+	}
+	return nil
+}
+
+// isConflict reports whether err failed an op because someone else
+// changed its node since it was planned.
+func isConflict(err error) bool {
+	var oe *OpError
+	if !errors.As(err, &oe) || (oe.Op.Kind == OpCreate && oe.Op.Dir) {
+		return false
+	}
+	switch oe.Op.Kind {
+	case OpCreate:
+		return errors.Is(err, ErrNodeExists)
+	case OpSet, OpDelete:
+		return errors.Is(err, ErrBadVersion) || errors.Is(err, ErrNoNode)
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package zksync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// applyWatched applies a plan made while watching, where failures are
+// logged rather than ending the watch.
+func (c *Client) applyWatched(ctx context.Context, p Plan, opts Options) {
+	if opts.Filter.hasIncludes() {
+		p = pruneEmptyDirs(p)
+	}
+	if err := c.checkSizes(p, opts); err != nil {
+		c.logger().Error("Not applying changes", "err", err)
+		reportWatched(opts, nil, []error{err}, 0)
+		return
+	}
+	if opts.DryRun {
+		for _, o := range p {
+			c.logger().Info("Would apply", "op", o.String())
+		}
+		reportWatched(opts, nil, nil, 0)
+		return
+	}
+	start := time.Now()
+	errs := c.apply(ctx, p, opts)
+	took := time.Since(start)
+	for _, err := range errs {
+		c.logger().Error("Change failed", "err", err)
+	}
+
+	failed := make(map[string]bool)
+	for _, err := range errs {
+		var opErr *OpError
+		if errors.As(err, &opErr) {
+			failed[opKey(opErr.Op.Kind, opErr.Op.Target)] = true
+		}
+	}
+	applied := make(Plan, 0, len(p))
+	for _, o := range p {
+		if !failed[opKey(o.Kind, o.Target)] {
+			applied = append(applied, o)
+		}
+	}
+	reportWatched(opts, applied, errs, took)
+}
+
+// reportWatched passes a batch of watched changes on to opts.OnApplied.
+func reportWatched(opts Options, applied Plan, errs []error, took time.Duration) {
+	if opts.OnApplied != nil {
+		opts.OnApplied(applied, errs, took)
+	}
+}
+
+// Watch mirrors every remote change under remotePath to localPath until ctx
+// is done. The watch is set up before the initial download so that nothing
+// changing in between is missed.
+func (c *Client) Watch(ctx context.Context, localPath, remotePath string, opts Options) error {
+	events, err := c.Backend.Watch(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+
+	p, err := c.planDownload(ctx, remotePath, localPath, "", opts)
+	if err != nil {
+		return err
+	}
+	c.applyWatched(ctx, p, opts)
+	c.logger().Info("Watching for changes", "path", remotePath)
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.logger().Info("Stopped watching")
+			return nil
+		case <-opts.Resync:
+			c.logger().Info("Resyncing", "path", remotePath)
+			p, err := c.planDownload(ctx, remotePath, localPath, "", opts)
+			if err != nil {
+				c.logger().Warn("Could not resync", "path", remotePath, "err", err)
+				reportWatched(opts, nil, []error{err}, 0)
+				continue
+			}
+			c.applyWatched(ctx, p, opts)
+		case ev, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					c.logger().Info("Stopped watching")
+					return nil
+				}
+				return ErrNoSession
+			}
+			if ev.Err != nil {
+				return ev.Err
+			}
+
+			p, err := c.planWatchEvent(ctx, ev, remotePath, localPath, opts)
+			if err != nil {
+				c.logger().Warn("Could not mirror", "path", ev.Path, "err", err)
+				reportWatched(opts, nil, []error{err}, 0)
+				continue
+			}
+			c.applyWatched(ctx, p, opts)
+		}
+	}
+}
+
+// planLocalPath plans creating the local dir localDir and the ones it is
+// in that are not there yet, for the node at source.
+func planLocalPath(source, localDir string) (Plan, error) {
+	if _, err := os.Stat(localDir); err == nil {
+		return nil, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	parent := filepath.Dir(localDir)
+	if parent == localDir {
+		return nil, nil
+	}
+	p, err := planLocalPath(source, parent)
+	if err != nil {
+		return nil, err
+	}
+	return append(p, Op{Kind: OpMkdir, Source: source, Target: localDir, Dir: true}), nil
+}
+
+func (c *Client) planWatchEvent(ctx context.Context, ev Event, serverPrefix string, localPrefix string, opts Options) (Plan, error) {
+	if isChunk(path.Base(ev.Path)) {
+		// a piece of a large file, which is read whole once the last piece
+		// is in
+		ev = Event{Type: EventChanged, Path: path.Dir(ev.Path)}
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(ev.Path, serverPrefix), "/")
+	if dir := path.Dir(rel); dir != "." && opts.Properties.matches(dir) {
+		// a key of a properties file, which is written again whole
+		ev, rel = Event{Type: EventChanged, Path: path.Dir(ev.Path)}, dir
+	}
+	// each name on its own, before filepath.Join takes a backslash in one
+	// for a separator
+	for _, name := range strings.Split(opts.Names.LocalPath(rel), "/") {
+		if err := checkLocalName(ev.Path, name); err != nil {
+			return nil, err
+		}
+	}
+	localPath := opts.Names.localChild(filepath.Join(localPrefix, filepath.FromSlash(opts.Names.LocalPath(path.Dir(rel)))), path.Dir(rel), path.Base(rel))
+	if ev.Type != EventDeleted && opts.Names.ext(rel) != "" {
+		// the node is there to say whether it is a file
+		if _, stat, err := c.Backend.Get(ev.Path); err == nil && !stat.IsDir() {
+			localPath = filepath.Join(localPrefix, filepath.FromSlash(opts.Names.LocalFile(rel)))
+		}
+	}
+	if opts.Filter.Excluded(rel) {
+		return nil, nil
+	}
+
+	switch ev.Type {
+	case EventDeleted:
+		fInfo, err := os.Lstat(localPath)
+		if os.IsNotExist(err) || (err == nil && !fInfo.IsDir() && !opts.Filter.Included(rel)) {
+			return nil, nil
+		}
+		return Plan{{Kind: OpRemove, Source: ev.Path, Target: localPath}}, nil
+	case EventCreated:
+		if opts.Names != nil {
+			// the node may be a file already there under another name
+			siblings, _, err := c.Backend.List(path.Dir(ev.Path))
+			if err != nil {
+				return nil, fmt.Errorf("listing %s: %w", path.Dir(ev.Path), err)
+			}
+			if _, err := opts.Names.localNames(path.Dir(ev.Path), path.Dir(rel), siblings, nil); err != nil {
+				return nil, err
+			}
+		}
+		// backends without real dirs can report a node before its parents
+		p, err := planLocalPath(ev.Path, filepath.Dir(localPath))
+		if err != nil {
+			return nil, err
+		}
+		downloadPlan, err := c.planDownload(ctx, ev.Path, localPath, rel, opts)
+		if err != nil || len(downloadPlan) == 0 {
+			return nil, err
+		}
+		return append(p, downloadPlan...), nil
+	}
+
+	// a change event means the data is newer even if the mtimes say
+	// otherwise, so only the contents are compared
+	fData, stat, err := c.getFile(ev.Path)
+	if err == ErrNoNode || errors.Is(err, errPartialChunks) {
+		// the deletion event follows, or the rest of the chunks
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if stat.IsDir() && opts.Properties.matches(rel) && opts.Filter.Included(rel) {
+		return c.planDownloadProperties(ctx, ev.Path, localPath, opts)
+	}
+	if stat.IsDir() || (stat.Ephemeral && !opts.Ephemeral) || !opts.Filter.Included(rel) || rel == DeployNode {
+		return nil, nil
+	}
+	if opts.Vault.onDownload() {
+		if fData, err = opts.Vault.resolve(ev.Path, fData); err != nil {
+			return nil, err
+		}
+	}
+
+	kind := OpOverwrite
+	localData, err := ioutil.ReadFile(localPath)
+	if os.IsNotExist(err) {
+		kind = OpWrite
+	} else if err != nil {
+		return nil, err
+	} else if bytes.Equal(localData, fData) {
+		return nil, nil
+	}
+	return Plan{{Kind: kind, Source: ev.Path, Target: localPath, Data: fData, OldSize: len(localData), Mtime: stat.Mtime}}, nil
+}
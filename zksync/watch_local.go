@@ -0,0 +1,163 @@
+package zksync
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultDebounce is used by WatchLocal when Options.Debounce is not set.
+const DefaultDebounce = 500 * time.Millisecond
+
+// isEditorTemp matches the scratch files editors create while saving.
+func isEditorTemp(name string) bool {
+	return strings.HasSuffix(name, "~") ||
+		strings.HasSuffix(name, ".swp") ||
+		strings.HasSuffix(name, ".swx") ||
+		strings.HasPrefix(name, ".#") ||
+		name == "4913"
+}
+
+// addWatches watches dir and every dir below it, fsnotify not being
+// recursive.
+func addWatches(w *fsnotify.Watcher, dir string, policy SymlinkPolicy) error {
+	return walkLocal(dir, policy, func(visitedPath string, fInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fInfo.IsDir() {
+			return w.Add(visitedPath)
+		}
+		return nil
+	})
+}
+
+// WatchLocal uploads local changes under localPath as they happen until ctx
+// is done. Changes are only acted on once the tree has been quiet for the
+// debounce period, so the rename dance editors do on save ends up as a
+// single upload.
+func (c *Client) WatchLocal(ctx context.Context, localPath, remotePath string, opts Options) error {
+	debounce := opts.Debounce
+	if debounce == 0 {
+		debounce = DefaultDebounce
+	}
+
+	absLocal, err := filepath.Abs(localPath)
+	if err != nil {
+		return err
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := addWatches(w, absLocal, opts.Symlinks); err != nil {
+		return err
+	}
+
+	// watches never wipe the remote tree before uploading
+	opts.Clean = false
+	p, err := c.planUpload(ctx, remotePath, absLocal, "", opts)
+	if err != nil {
+		return err
+	}
+	c.applyWatched(ctx, p, opts)
+	c.logger().Info("Watching for changes", "path", absLocal)
+
+	pending := make(map[string]bool)
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.logger().Info("Stopped watching")
+			return nil
+		case <-opts.Resync:
+			c.logger().Info("Resyncing", "path", absLocal)
+			// dirs made while events were being missed need watches too
+			err := addWatches(w, absLocal, opts.Symlinks)
+			var p Plan
+			if err == nil {
+				p, err = c.planUpload(ctx, remotePath, absLocal, "", opts)
+			}
+			if err != nil {
+				c.logger().Warn("Could not resync", "path", absLocal, "err", err)
+				reportWatched(opts, nil, []error{err}, 0)
+				continue
+			}
+			c.applyWatched(ctx, p, opts)
+		case err := <-w.Errors:
+			return err
+		case ev := <-w.Events:
+			if isEditorTemp(filepath.Base(ev.Name)) || ev.Op == fsnotify.Chmod {
+				continue
+			}
+			pending[ev.Name] = true
+			timer.Reset(debounce)
+		case <-timer.C:
+			changed := make([]string, 0, len(pending))
+			for changedPath := range pending {
+				changed = append(changed, changedPath)
+			}
+			pending = make(map[string]bool)
+			// parents before children
+			sort.Strings(changed)
+
+			for _, changedPath := range changed {
+				p, err := c.planLocalChange(ctx, w, remotePath, absLocal, changedPath, opts)
+				if err != nil {
+					c.logger().Warn("Could not upload", "path", changedPath, "err", err)
+					reportWatched(opts, nil, []error{err}, 0)
+					continue
+				}
+				c.applyWatched(ctx, p, opts)
+			}
+		}
+	}
+}
+
+func (c *Client) planLocalChange(ctx context.Context, w *fsnotify.Watcher, serverPrefix string, absLocal string, localPath string, opts Options) (Plan, error) {
+	rel := opts.Names.RemotePath(strings.TrimPrefix(filepath.ToSlash(strings.TrimPrefix(localPath, absLocal)), "/"))
+	fInfo, err := localStat(localPath, opts.Symlinks)
+	if err != nil || !fInfo.IsDir() {
+		rel = opts.Names.stripExt(rel)
+	}
+	remotePath := path.Join(serverPrefix, rel)
+	if opts.Filter.Excluded(rel) {
+		return nil, nil
+	}
+
+	if ignored, ierr := newIgnorer(absLocal, opts.Names).ignored(rel, err == nil && fInfo.IsDir()); ierr != nil {
+		return nil, ierr
+	} else if ignored {
+		return nil, nil
+	}
+	if os.IsNotExist(err) {
+		if !opts.Filter.Included(rel) {
+			// only a dir can have included files below it
+			_, stat, err := c.Backend.Get(remotePath)
+			if err != nil || stat.DataLength != 0 {
+				return nil, nil
+			}
+		}
+		return c.planDelete(ctx, remotePath)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if fInfo.IsDir() {
+		// watches are per dir, new ones need adding
+		if err := addWatches(w, localPath, opts.Symlinks); err != nil {
+			return nil, err
+		}
+	}
+	return c.planUpload(ctx, remotePath, localPath, rel, opts)
+}
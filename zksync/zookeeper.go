@@ -0,0 +1,722 @@
+package zksync
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+type zkBackend struct {
+	c      *zk.Conn
+	krb    *client.Client // nil without SASL
+	retry  RetryPolicy
+	limit  *limiter
+	chroot string // every path is below, "" for none
+}
+
+// newZKBackend dials the ensemble and waits until a session is established,
+// so that an unreachable ensemble is reported instead of retried forever.
+func newZKBackend(cfg BackendConfig) (*zkBackend, error) {
+	for _, a := range cfg.ExtraAuth {
+		if a.Scheme == "sasl" {
+			cfg.SASL.Enabled = true
+			if a.Credential != "" {
+				cfg.SASL.Principal = a.Credential
+			}
+		}
+	}
+	servers, chroot := cfg.Servers, ""
+	var err error
+	if !fetchesServers(servers) {
+		// URLs have slashes of their own, they cannot have a chroot
+		if servers, chroot, err = splitChroot(servers); err != nil {
+			return nil, err
+		}
+	}
+	lookup := discovery(servers)
+	hosts := strings.Split(servers, ",")
+	hp := zk.HostProvider(&zk.DNSHostProvider{})
+	if lookup != nil {
+		if hosts, err = lookup(); err != nil {
+			return nil, err
+		}
+		hp = newDiscoveryHostProvider(lookup, hosts, cfg.DiscoveryRefresh)
+	}
+	tlsCfg, err := cfg.TLS.config()
+	if err != nil {
+		return nil, err
+	}
+	var krb *client.Client
+	if cfg.SASL.enabled() {
+		if krb, err = cfg.SASL.login(); err != nil {
+			return nil, err
+		}
+	}
+	service := cfg.SASL.Service
+	if service == "" {
+		service = "zookeeper"
+	}
+
+	// the library keeps redialing when authentication fails, remember why
+	// so that it can be reported
+	var saslMu sync.Mutex
+	var saslErr error
+	dialer := func(network, address string, timeout time.Duration) (net.Conn, error) {
+		d := &net.Dialer{Timeout: timeout}
+		var conn net.Conn
+		var err error
+		if tlsCfg != nil {
+			conn, err = tls.DialWithDialer(d, network, address, tlsCfg)
+		} else {
+			conn, err = d.Dial(network, address)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if cfg.ReadOnlySession {
+			conn = &readOnlyConn{Conn: conn}
+		}
+		if krb == nil {
+			return conn, nil
+		}
+
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return &saslConn{Conn: conn, krb: krb, spn: service + "/" + host, onFail: func(err error) {
+			saslMu.Lock()
+			saslErr = err
+			saslMu.Unlock()
+		}}, nil
+	}
+
+	c, events, err := zk.Connect(hosts, 5*time.Second, zk.WithDialer(dialer), zk.WithHostProvider(hp))
+	if err != nil {
+		if krb != nil {
+			krb.Destroy()
+		}
+		return nil, err
+	}
+	b := &zkBackend{c: c, krb: krb, retry: cfg.Retry, limit: newLimiter(cfg.RateLimit), chroot: chroot}
+
+	timeout := time.After(10 * time.Second)
+	for c.State() != zk.StateHasSession {
+		select {
+		case <-events:
+		case <-timeout:
+			b.Close()
+			saslMu.Lock()
+			defer saslMu.Unlock()
+			if saslErr != nil {
+				return nil, saslErr
+			}
+			return nil, ErrNoSession
+		}
+	}
+
+	auth := cfg.ExtraAuth
+	if cfg.Auth != "" {
+		auth = append([]AuthEntry{{Scheme: "digest", Credential: cfg.Auth}}, auth...)
+	}
+	for _, a := range auth {
+		if a.Scheme == "sasl" {
+			continue
+		}
+		// the library sends them all again on every reconnect
+		if err := c.AddAuth(a.Scheme, []byte(a.Credential)); err != nil {
+			b.Close()
+			return nil, fmt.Errorf("%w: %s: %v", ErrNoAuth, a.Scheme, err)
+		}
+	}
+	if chroot != "" {
+		// as in the Java client, the chroot has to be there already
+		if ok, _, err := c.Exists(chroot); err != nil || !ok {
+			b.Close()
+			if err == nil {
+				err = ErrNoNode
+			}
+			return nil, fmt.Errorf("chroot %s: %w", chroot, zkError(err))
+		}
+	}
+	if cfg.OnSessionEvent != nil {
+		// the library closes events once the connection is closed
+		go func() {
+			for ev := range events {
+				if ev.Type == zk.EventSession {
+					cfg.OnSessionEvent(ev.State.String())
+				}
+			}
+		}()
+	}
+	return b, nil
+}
+
+// zkError translates the errors the sync cares about into their backend
+// independent counterparts.
+func zkError(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case zk.ErrNoNode:
+		return ErrNoNode
+	case zk.ErrNodeExists:
+		return ErrNodeExists
+	case zk.ErrBadVersion:
+		return ErrBadVersion
+	case zk.ErrNotEmpty:
+		return ErrNotEmpty
+	case zk.ErrNoAuth, zk.ErrAuthFailed:
+		return fmt.Errorf("%w: %v", ErrNoAuth, err)
+	case zk.ErrNoServer, zk.ErrConnectionClosed, zk.ErrSessionExpired, zk.ErrClosing:
+		return fmt.Errorf("%w: %v", ErrNoSession, err)
+	}
+	return err
+}
+
+func zkStat(stat *zk.Stat) *Stat {
+	return &Stat{
+		Version:     int64(stat.Version),
+		Mtime:       time.Unix(stat.Mtime/1000, 0),
+		DataLength:  int(stat.DataLength),
+		NumChildren: int(stat.NumChildren),
+		Ephemeral:   stat.EphemeralOwner != 0,
+		Czxid:       stat.Czxid,
+		Mzxid:       stat.Mzxid,
+		Ctime:       time.Unix(stat.Ctime/1000, 0),
+	}
+}
+
+// splitChroot splits a connection string such as zk1:2181,zk2:2181/apps/x
+// into the servers and the chroot every path is below, "" if none.
+func splitChroot(servers string) (string, string, error) {
+	i := strings.IndexByte(servers, '/')
+	if i < 0 {
+		return servers, "", nil
+	}
+	chroot := path.Clean(servers[i:])
+	if chroot != servers[i:] && chroot+"/" != servers[i:] {
+		return "", "", fmt.Errorf("bad chroot %q", servers[i:])
+	}
+	if chroot == "/" {
+		chroot = ""
+	}
+	return servers[:i], chroot, nil
+}
+
+// path returns where p is on the ensemble, below the chroot.
+func (b *zkBackend) path(p string) string {
+	switch {
+	case b.chroot == "":
+		return p
+	case p == "/":
+		return b.chroot
+	}
+	return b.chroot + p
+}
+
+// unroot is the reverse of path, for the paths the ensemble reports.
+func (b *zkBackend) unroot(p string) string {
+	if b.chroot == "" {
+		return p
+	}
+	if p = strings.TrimPrefix(p, b.chroot); p == "" {
+		return "/"
+	}
+	return p
+}
+
+// zkTransient reports whether err comes from a lost connection or session,
+// which the library recovers from by itself.
+func zkTransient(err error) bool {
+	switch err {
+	case zk.ErrConnectionClosed, zk.ErrSessionExpired, zk.ErrSessionMoved, zk.ErrNoServer:
+		return true
+	}
+	return false
+}
+
+// do runs f under the retry policy, once the rate limit allows another
+// operation.
+func (b *zkBackend) do(op, p string, f func(retried bool) error) error {
+	b.limit.wait(1, 0)
+	return zkError(b.retry.do(op+" "+p, f, zkTransient))
+}
+
+// hasData tells whether a write that looks like it failed on a retry was
+// actually done by the attempt that lost its connection.
+func (b *zkBackend) hasData(p string, data []byte) bool {
+	have, _, err := b.c.Get(p)
+	return err == nil && bytes.Equal(have, data)
+}
+
+func (b *zkBackend) Get(p string) ([]byte, *Stat, error) {
+	p = b.path(p)
+	var data []byte
+	var stat *zk.Stat
+	err := b.do("get", p, func(bool) (err error) {
+		data, stat, err = b.c.Get(p)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	b.limit.wait(0, len(data))
+	return data, zkStat(stat), nil
+}
+
+func (b *zkBackend) Stat(p string) (*Stat, error) {
+	p = b.path(p)
+	var stat *zk.Stat
+	err := b.do("exists", p, func(bool) (err error) {
+		var ok bool
+		if ok, stat, err = b.c.Exists(p); err == nil && !ok {
+			err = zk.ErrNoNode
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return zkStat(stat), nil
+}
+
+func (b *zkBackend) List(p string) ([]string, *Stat, error) {
+	p = b.path(p)
+	var children []string
+	var stat *zk.Stat
+	err := b.do("list", p, func(bool) (err error) {
+		children, stat, err = b.c.Children(p)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return children, zkStat(stat), nil
+}
+
+func (b *zkBackend) Create(p string, data []byte) error {
+	return b.create(b.path(p), data, zk.AuthACL(zk.PermAll))
+}
+
+func (b *zkBackend) CreateWithACL(p string, data []byte, acl []ACL) error {
+	return b.create(b.path(p), data, toZKACL(acl))
+}
+
+func (b *zkBackend) create(p string, data []byte, acl []zk.ACL) error {
+	b.limit.wait(0, len(data))
+	return b.do("create", p, func(retried bool) error {
+		_, err := b.c.Create(p, data, 0, acl)
+		if err == zk.ErrNodeExists && retried && b.hasData(p, data) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (b *zkBackend) GetACL(p string) ([]ACL, error) {
+	p = b.path(p)
+	var zkACL []zk.ACL
+	err := b.do("getacl", p, func(bool) (err error) {
+		zkACL, _, err = b.c.GetACL(p)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	acl := make([]ACL, len(zkACL))
+	for i, a := range zkACL {
+		acl[i] = ACL{Scheme: a.Scheme, ID: a.ID, Perms: a.Perms}
+	}
+	return acl, nil
+}
+
+func (b *zkBackend) SetACL(p string, acl []ACL) error {
+	p = b.path(p)
+	return b.do("setacl", p, func(bool) error {
+		_, err := b.c.SetACL(p, toZKACL(acl), -1)
+		return err
+	})
+}
+
+func toZKACL(acl []ACL) []zk.ACL {
+	zkACL := make([]zk.ACL, len(acl))
+	for i, a := range acl {
+		zkACL[i] = zk.ACL{Scheme: a.Scheme, ID: a.ID, Perms: a.Perms}
+	}
+	return zkACL
+}
+
+func (b *zkBackend) Set(p string, data []byte, version int64) error {
+	p = b.path(p)
+	b.limit.wait(0, len(data))
+	return b.do("set", p, func(retried bool) error {
+		_, err := b.c.Set(p, data, int32(version))
+		if err == zk.ErrBadVersion && retried && b.hasData(p, data) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (b *zkBackend) Delete(p string, version int64) error {
+	p = b.path(p)
+	return b.do("delete", p, func(retried bool) error {
+		err := b.c.Delete(p, int32(version))
+		if err == zk.ErrNoNode && retried {
+			return nil
+		}
+		return err
+	})
+}
+
+func (b *zkBackend) DeleteMulti(nodes []NodeVersion) error {
+	ops := make([]interface{}, len(nodes))
+	for i, node := range nodes {
+		ops[i] = &zk.DeleteRequest{Path: b.path(node.Path), Version: int32(node.Version)}
+	}
+	b.limit.wait(len(ops)-1, 0)
+	return b.do("delete", nodes[0].Path, func(retried bool) error {
+		failed := b.multi(ops)
+		if failed == zk.ErrNoNode && retried {
+			// the transaction that lost its connection may have gone
+			// through
+			for _, node := range nodes {
+				if ok, _, err := b.c.Exists(b.path(node.Path)); err != nil || ok {
+					return failed
+				}
+			}
+			return nil
+		}
+		return failed
+	})
+}
+
+// multi runs ops in one transaction, returning why it failed if it did.
+func (b *zkBackend) multi(ops []interface{}) error {
+	res, err := b.c.Multi(ops...)
+	if err != nil {
+		return err
+	}
+	// ops rolled back because of another one failing report an unknown
+	// error, the one that caused it has the real reason
+	var failed error
+	for _, r := range res {
+		if r.Error != nil && (failed == nil || failed == zk.ErrUnknown) {
+			failed = r.Error
+		}
+	}
+	return failed
+}
+
+func (b *zkBackend) Transact(p Plan) error {
+	ops := make([]interface{}, len(p))
+	size := 0
+	for i, o := range p {
+		size += len(o.Data)
+		switch o.Kind {
+		case OpCreate:
+			acl := zk.AuthACL(zk.PermAll)
+			if o.ACL != nil {
+				acl = toZKACL(o.ACL)
+			}
+			ops[i] = &zk.CreateRequest{Path: b.path(o.Target), Data: o.Data, Acl: acl}
+		case OpSet:
+			ops[i] = &zk.SetDataRequest{Path: b.path(o.Target), Data: o.Data, Version: int32(o.Version)}
+		case OpDelete:
+			ops[i] = &zk.DeleteRequest{Path: b.path(o.Target), Version: int32(o.Version)}
+		default:
+			return fmt.Errorf("%s in a transaction: %w", o.Kind, ErrUnsupported)
+		}
+	}
+	b.limit.wait(len(ops)-1, size)
+	return b.do("transact", p[0].Target, func(retried bool) error {
+		failed := b.multi(ops)
+		if failed != nil && retried && b.transacted(p) {
+			return nil
+		}
+		return failed
+	})
+}
+
+// transacted tells whether a transaction that looks like it failed on a
+// retry was actually done by the attempt that lost its connection.
+func (b *zkBackend) transacted(p Plan) bool {
+	for _, o := range p {
+		if o.Kind == OpDelete {
+			if ok, _, err := b.c.Exists(b.path(o.Target)); err != nil || ok {
+				return false
+			}
+		} else if !b.hasData(b.path(o.Target), o.Data) {
+			return false
+		}
+	}
+	return true
+}
+
+// MultiLimit stays well under the default 1MB jute.maxbuffer, each op
+// costing its path and data plus a few dozen bytes of framing.
+func (b *zkBackend) MultiLimit() (int, int) {
+	return 0, 512 * 1024
+}
+
+func (b *zkBackend) Watch(ctx context.Context, p string) (<-chan Event, error) {
+	p = b.path(p)
+	events := make(chan Event)
+	if err := b.watchNode(ctx, p, events, func() {}); err != nil {
+		return nil, zkError(err)
+	}
+	return events, nil
+}
+
+// watchNode keeps a data and a children watch on p, watching new children as
+// they appear, until p is deleted or ctx is done, when it calls left.
+// ZooKeeper watches fire once, so they are set again after every event.
+func (b *zkBackend) watchNode(ctx context.Context, p string, events chan<- Event, left func()) error {
+	_, _, dataCh, err := b.c.GetW(p)
+	if err != nil {
+		return err
+	}
+	children, _, childCh, err := b.c.ChildrenW(p)
+	if err != nil {
+		return err
+	}
+
+	// gone names the children no longer watched, which may have been
+	// created again before the children watch was set again
+	gone := make(chan string)
+	done := make(chan struct{})
+	leftChild := func(child string) func() {
+		return func() {
+			select {
+			case gone <- child:
+			case <-done:
+			case <-ctx.Done():
+			}
+		}
+	}
+	known := make(map[string]bool)
+	for _, child := range children {
+		err := b.watchNode(ctx, path.Join(p, child), events, leftChild(child))
+		if err == nil {
+			known[child] = true
+		} else if err != zk.ErrNoNode {
+			close(done)
+			return err
+		}
+	}
+
+	send := func(ev Event) bool {
+		select {
+		case events <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	// watchChild watches the new child child, reporting it unless it is
+	// gone again already.
+	watchChild := func(child string) bool {
+		childPath := path.Join(p, child)
+		err := b.watchNode(ctx, childPath, events, leftChild(child))
+		if err == zk.ErrNoNode {
+			return true
+		} else if err != nil {
+			send(Event{Path: b.unroot(childPath), Err: zkError(err)})
+			return false
+		}
+		known[child] = true
+		return send(Event{Type: EventCreated, Path: b.unroot(childPath)})
+	}
+
+	go func() {
+		defer left()
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-dataCh:
+				switch ev.Type {
+				case zk.EventNodeDeleted:
+					send(Event{Type: EventDeleted, Path: b.unroot(p)})
+					return
+				case zk.EventNotWatching:
+					send(Event{Path: b.unroot(p), Err: zkError(ev.Err)})
+					return
+				case zk.EventNodeDataChanged:
+					if !send(Event{Type: EventChanged, Path: b.unroot(p)}) {
+						return
+					}
+				}
+				if _, _, dataCh, err = b.c.GetW(p); err != nil {
+					if err == zk.ErrNoNode {
+						send(Event{Type: EventDeleted, Path: b.unroot(p)})
+					} else {
+						send(Event{Path: b.unroot(p), Err: zkError(err)})
+					}
+					return
+				}
+			case child := <-gone:
+				delete(known, child)
+				if !watchChild(child) {
+					return
+				}
+			case ev := <-childCh:
+				if ev.Type != zk.EventNodeChildrenChanged {
+					// deletion and lost watches are reported by the data watch
+					continue
+				}
+				if children, _, childCh, err = b.c.ChildrenW(p); err != nil {
+					// the data watch will report the deletion
+					continue
+				}
+				for _, child := range children {
+					if !known[child] && !watchChild(child) {
+						return
+					}
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Lock follows the ZooKeeper lock recipe: every contender creates an
+// ephemeral sequential node under p, and the one with the lowest sequence
+// number holds the lock, each of the others waiting for the node just
+// before its own to go.
+func (b *zkBackend) Lock(ctx context.Context, p, holder string) (func(), error) {
+	var dirs []string
+	for dir := p; dir != "/"; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := b.create(b.path(dirs[i]), nil, zk.AuthACL(zk.PermAll)); err != nil && err != ErrNodeExists {
+			return nil, err
+		}
+	}
+	p = b.path(p)
+	b.limit.wait(1, len(holder))
+	own, err := b.c.CreateProtectedEphemeralSequential(path.Join(p, "lock-"), []byte(holder), zk.AuthACL(zk.PermAll))
+	if err != nil {
+		return nil, zkError(err)
+	}
+	unlock := func() { b.c.Delete(own, -1) }
+
+	for {
+		children, _, err := b.c.Children(p)
+		if err != nil {
+			unlock()
+			return nil, zkError(err)
+		}
+		sort.Slice(children, func(i, j int) bool { return lockSeq(children[i]) < lockSeq(children[j]) })
+		prev, found := "", false
+		for _, child := range children {
+			if child == path.Base(own) {
+				found = true
+				break
+			}
+			prev = child
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: lost lock node %s", ErrNoSession, own)
+		}
+		if prev == "" {
+			return unlock, nil
+		}
+		exists, _, ch, err := b.c.ExistsW(path.Join(p, prev))
+		if err != nil {
+			unlock()
+			return nil, zkError(err)
+		}
+		if !exists {
+			continue
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			unlock()
+			if ctx.Err() == context.Canceled {
+				return nil, ctx.Err()
+			}
+			data, _, _ := b.c.Get(path.Join(p, children[0]))
+			return nil, lockedBy(string(data))
+		}
+	}
+}
+
+// zkQuotaRoot is where ZooKeeper keeps quotas, the limits of a path in a
+// zookeeper_limits node below the path's own, beside zookeeper_stats.
+const zkQuotaRoot = "/zookeeper/quota"
+
+// SetQuota sets the quota of p as zkCli setquota does, or removes it as
+// delquota does. The ensemble counts what the tree holds once the stats
+// node is created, and logs a warning whenever it goes over the limits.
+func (b *zkBackend) SetQuota(p string, count, bytes int64) error {
+	dir := strings.TrimSuffix(zkQuotaRoot+b.path(p), "/")
+	limits, stats := dir+"/zookeeper_limits", dir+"/zookeeper_stats"
+	if count < 0 && bytes < 0 {
+		for _, node := range []string{limits, stats} {
+			err := b.do("delete", node, func(bool) error { return b.c.Delete(node, -1) })
+			if err != nil && err != ErrNoNode {
+				return err
+			}
+		}
+		// and the dirs leading to them, unless other quotas need them
+		for ; dir != zkQuotaRoot; dir = path.Dir(dir) {
+			if err := b.do("delete", dir, func(bool) error { return b.c.Delete(dir, -1) }); err != nil {
+				break
+			}
+		}
+		return nil
+	}
+
+	at := zkQuotaRoot
+	for _, name := range strings.Split(strings.TrimPrefix(dir, zkQuotaRoot+"/"), "/") {
+		at += "/" + name
+		if err := b.create(at, nil, zk.WorldACL(zk.PermAll)); err != nil && err != ErrNodeExists {
+			return err
+		}
+	}
+	data := []byte(fmt.Sprintf("count=%d,bytes=%d", count, bytes))
+	err := b.create(limits, data, zk.WorldACL(zk.PermAll))
+	if err == ErrNodeExists {
+		err = b.do("set", limits, func(bool) error {
+			_, err := b.c.Set(limits, data, -1)
+			return err
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if err := b.create(stats, []byte("count=0,bytes=0"), zk.WorldACL(zk.PermAll)); err != nil && err != ErrNodeExists {
+		return err
+	}
+	return nil
+}
+
+// lockSeq is the sequence number ZooKeeper appended to a lock node name.
+func lockSeq(name string) int {
+	n, _ := strconv.Atoi(name[strings.LastIndex(name, "-")+1:])
+	return n
+}
+
+func (b *zkBackend) Close() {
+	b.c.Close()
+	if b.krb != nil {
+		b.krb.Destroy()
+	}
+}